@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// errPolicyNotAllowed is returned for policies outside POLICY_ALLOWLIST.
+var errPolicyNotAllowed = errors.New("policy is not allowed")
+
+// checkPolicyAllowed enforces POLICY_ALLOWLIST, a comma-separated list of
+// policy names or globs. Globs match per dotted segment, so "auth.*" allows
+// "auth.user" but not "auth.user.admin". An unset allowlist allows everything.
+func checkPolicyAllowed(policyName string) error {
+	raw := strings.TrimSpace(os.Getenv("POLICY_ALLOWLIST"))
+	if raw == "" {
+		return nil
+	}
+
+	name := strings.ReplaceAll(policyName, ".", "/")
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		matched, err := path.Match(strings.ReplaceAll(entry, ".", "/"), name)
+		if err != nil {
+			return fmt.Errorf("invalid POLICY_ALLOWLIST entry %q: %w", entry, err)
+		}
+		if matched {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", errPolicyNotAllowed, policyName)
+}
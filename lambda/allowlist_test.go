@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPolicyAllowed(t *testing.T) {
+	tests := []struct {
+		allowlist string
+		policy    string
+		allowed   bool
+	}{
+		{allowlist: "", policy: "anything", allowed: true},
+		{allowlist: "example", policy: "example", allowed: true},
+		{allowlist: "example", policy: "world", allowed: false},
+		{allowlist: "world, example", policy: "example", allowed: true},
+		{allowlist: "auth.*", policy: "auth.user", allowed: true},
+		{allowlist: "auth.*", policy: "auth.user.admin", allowed: false},
+		{allowlist: "auth.*", policy: "authz", allowed: false},
+		{allowlist: "auth.*.admin", policy: "auth.user.admin", allowed: true},
+	}
+
+	for _, test := range tests {
+		t.Setenv("POLICY_ALLOWLIST", test.allowlist)
+		err := checkPolicyAllowed(test.policy)
+		if test.allowed {
+			require.NoError(t, err, "%s in %q", test.policy, test.allowlist)
+		} else {
+			require.ErrorIs(t, err, errPolicyNotAllowed, "%s in %q", test.policy, test.allowlist)
+		}
+	}
+
+	t.Setenv("POLICY_ALLOWLIST", "auth.[")
+	err := checkPolicyAllowed("auth.user")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, errPolicyNotAllowed)
+}
+
+func TestHandleLambdaAPIGatewayProxyEventPolicyNotAllowed(t *testing.T) {
+	t.Setenv("POLICY_ALLOWLIST", "world")
+
+	event := events.APIGatewayProxyRequest{
+		Resource:       "/opa",
+		Body:           string(buildLambdaEventPayloadBytes(t)),
+		RequestContext: events.APIGatewayProxyRequestContext{Stage: "dev"},
+	}
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+
+	gwResp, ok := resp.(events.APIGatewayProxyResponse)
+	require.True(t, ok)
+	require.Equal(t, http.StatusForbidden, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "policy is not allowed: example")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultMaxBatchItems bounds payloads arrays when MAX_BATCH_ITEMS is unset.
+const defaultMaxBatchItems = 1000
+
+// errBatchTooLarge is returned for payloads arrays longer than MAX_BATCH_ITEMS.
+var errBatchTooLarge = errors.New("batch is too large")
+
+// batchItemResult is one line of an NDJSON batch response.
+type batchItemResult struct {
+	Index int `json:"index"` // The position of the payload in the request's payloads array.
+	LambdaResponse
+}
+
+// batchAggregate is the response to a batch request with aggregate_allow.
+type batchAggregate struct {
+	Allow   bool             `json:"allow"`   // Whether every payload was allowed.
+	Results []LambdaResponse `json:"results"` // The evaluated payloads' results, in request order.
+}
+
+// checkBatchSize enforces MAX_BATCH_ITEMS before any payload is evaluated.
+// Zero disables the limit.
+func checkBatchSize(items int) error {
+	maxItems, err := intFromEnv("MAX_BATCH_ITEMS", defaultMaxBatchItems)
+	if err != nil {
+		return err
+	}
+	if maxItems > 0 && items > maxItems {
+		return fmt.Errorf("%w: %d payloads exceeds MAX_BATCH_ITEMS=%d", errBatchTooLarge, items, maxItems)
+	}
+	return nil
+}
+
+// isDirectBatchEvent recognizes direct invocations carrying a payloads array.
+func isDirectBatchEvent(payload json.RawMessage) bool {
+	var probe struct {
+		Payloads json.RawMessage `json:"payloads"`
+	}
+
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return false
+	}
+
+	return len(probe.Payloads) > 0 && string(probe.Payloads) != "null"
+}
+
+// handleDirectBatchEvent evaluates the policy of a direct invocation against
+// each of its payloads. The results are returned in request order, as a JSON
+// array or, with aggregate_allow, wrapped with the overall decision. Only
+// problems with the request as a whole fail the invocation.
+func handleDirectBatchEvent(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	var req LambdaEvent
+	err := decodeEnvelope(payload, &req)
+	if err != nil {
+		err = invalidPayloadError(fmt.Errorf("unable to parse lambda payload: %w", err))
+	} else if req.Payload != nil {
+		err = invalidRequestError("payload and payloads are mutually exclusive")
+	} else if req.Action != "" {
+		err = invalidRequestError("action and payloads are mutually exclusive")
+	} else {
+		err = checkBatchSize(len(req.Payloads))
+	}
+	if err != nil {
+		log.Error(err)
+		return errorResponse(err), err
+	}
+
+	if req.AggregateAllow {
+		return evaluateBatchAggregate(ctx, req), nil
+	}
+	return evaluateBatch(ctx, req), nil
+}
+
+// evaluateBatchItem evaluates one element of a batch request. Errors are
+// reported on the item so that one bad payload does not abort the batch.
+func evaluateBatchItem(ctx context.Context, req LambdaEvent, payload json.RawMessage) LambdaResponse {
+	item := req
+	item.Payload = &payload
+	item.Payloads = nil
+
+	resp, err := evaluatePolicy(ctx, item)
+	if err != nil {
+		log.Error(err)
+		return errorResponse(err)
+	}
+	return resp
+}
+
+// evaluateBatch evaluates every payload of a batch request in order.
+func evaluateBatch(ctx context.Context, req LambdaEvent) []LambdaResponse {
+	results := make([]LambdaResponse, 0, len(req.Payloads))
+	for _, payload := range req.Payloads {
+		results = append(results, evaluateBatchItem(ctx, req, payload))
+	}
+	return results
+}
+
+// evaluateBatchAggregate evaluates a batch request with aggregate_allow. Unless
+// req.EvaluateAll is set, it stops at the first payload that is not allowed,
+// so Results then ends with that payload and later ones are not evaluated.
+func evaluateBatchAggregate(ctx context.Context, req LambdaEvent) batchAggregate {
+	aggregate := batchAggregate{Allow: true, Results: make([]LambdaResponse, 0, len(req.Payloads))}
+	for _, payload := range req.Payloads {
+		result := evaluateBatchItem(ctx, req, payload)
+		aggregate.Results = append(aggregate.Results, result)
+		if !isAllowed(result) {
+			aggregate.Allow = false
+			if !req.EvaluateAll {
+				break
+			}
+		}
+	}
+	return aggregate
+}
+
+// isAllowed reports whether a result allows its payload: the output is true
+// or an object whose allow field is true. Errors and undefined outputs deny.
+func isAllowed(result LambdaResponse) bool {
+	if result.Error != "" || result.Undefined {
+		return false
+	}
+	if object, ok := result.Output.(map[string]interface{}); ok {
+		return object["allow"] == true
+	}
+	return result.Output == true
+}
+
+// streamBatch evaluates a batch request in the background, writing one
+// NDJSON line per payload as soon as it has been evaluated.
+func streamBatch(ctx context.Context, req LambdaEvent) io.Reader {
+	pr, pw := io.Pipe()
+
+	go func() {
+		encoder := json.NewEncoder(pw)
+		for i, payload := range req.Payloads {
+			line := batchItemResult{Index: i, LambdaResponse: evaluateBatchItem(ctx, req, payload)}
+			if err := encoder.Encode(line); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	return pr
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+const batchRequestBody = `{"payloads":[
+	{"routing":{"policy":"example"},"membership":{"user":{"login":"jane","mail":"jane@example.com"}}},
+	{"routing":{},"membership":{"user":{"login":"joe"}}},
+	{"routing":{"policy":"example"},"membership":{"user":{"login":"joe","mail":"joe@elsewhere.com"}}}
+]}`
+
+func TestHandleLambdaAPIGatewayV2EventBatch(t *testing.T) {
+	t.Setenv("POLICY_SELECTOR_PATH", "$.routing.policy")
+
+	gwResp := invokeAPIGatewayV2(t, nil, batchRequestBody)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, "application/json", gwResp.Headers["Content-Type"])
+
+	var results []LambdaResponse
+	require.NoError(t, json.Unmarshal([]byte(gwResp.Body), &results))
+	require.Len(t, results, 3)
+	require.Equal(t, true, results[0].Output.(map[string]interface{})["allow"])
+	require.Contains(t, results[1].Error, "unable to select policy")
+	require.Equal(t, false, results[2].Output.(map[string]interface{})["allow"])
+}
+
+func TestHandleLambdaDirectEventBatch(t *testing.T) {
+	t.Setenv("POLICY_SELECTOR_PATH", "$.routing.policy")
+
+	resp, err := handleLambda(context.Background(), json.RawMessage(batchRequestBody))
+	require.NoError(t, err)
+
+	results, ok := resp.([]LambdaResponse)
+	require.True(t, ok)
+	require.Len(t, results, 3)
+	require.Equal(t, true, results[0].Output.(map[string]interface{})["allow"])
+	require.Contains(t, results[1].Error, "unable to select policy")
+	require.Equal(t, false, results[2].Output.(map[string]interface{})["allow"])
+
+	resp, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"example","aggregate_allow":true,"payloads":[{},{}]}`))
+	require.NoError(t, err)
+	aggregate, ok := resp.(batchAggregate)
+	require.True(t, ok)
+	require.False(t, aggregate.Allow)
+	require.Len(t, aggregate.Results, 1)
+}
+
+func TestHandleLambdaDirectEventBatchErrors(t *testing.T) {
+	_, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"example","payload":{},"payloads":[{}]}`))
+	require.ErrorContains(t, err, "mutually exclusive")
+
+	t.Setenv("MAX_BATCH_ITEMS", "1")
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"example","payloads":[{},{}]}`))
+	require.ErrorIs(t, err, errBatchTooLarge)
+
+	// An explicit null is not a batch.
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"example","payload":{},"payloads":null}`))
+	require.NoError(t, err)
+	require.IsType(t, LambdaResponse{}, resp)
+}
+
+func TestHandleLambdaAPIGatewayV2EventBatchNDJSON(t *testing.T) {
+	gwResp := invokeAPIGatewayV2(t, map[string]string{"Accept": "application/x-ndjson"}, `{"policy":"example","payloads":[{},{"membership":{"user":{"login":"jane"}}}]}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, "application/x-ndjson", gwResp.Headers["Content-Type"])
+	require.Equal(t, `{"index":0,"output":{"allow":false}}`+"\n"+
+		`{"index":1,"output":{"allow":false,"email":null,"user":"jane"}}`+"\n", withoutDecisionIDs(gwResp.Body))
+}
+
+func TestHandleLambdaAPIGatewayV2EventBatchSSE(t *testing.T) {
+	gwResp := invokeAPIGatewayV2(t, map[string]string{"Accept": "text/event-stream"}, `{"policy":"example","payloads":[{},{"membership":{"user":{"login":"jane"}}}]}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, "text/event-stream", gwResp.Headers["Content-Type"])
+	require.Equal(t, "no-cache", gwResp.Headers["Cache-Control"])
+	require.Equal(t, "id: 0\ndata: {\"index\":0,\"output\":{\"allow\":false}}\n\n"+
+		"id: 1\ndata: {\"index\":1,\"output\":{\"allow\":false,\"email\":null,\"user\":\"jane\"}}\n\n"+
+		"event: done\ndata: {\"count\":2}\n\n", withoutDecisionIDs(gwResp.Body))
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"example","format":"sse","aggregate_allow":true,"payloads":[{}]}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+}
+
+func TestStreamBatchEventsHeartbeat(t *testing.T) {
+	writeTestPolicy(t, "slow", "package slow\n\ntotal := count([x | x := numbers.range(1, 200000)[_]])\n")
+	interval := sseHeartbeatInterval
+	sseHeartbeatInterval = time.Millisecond
+	t.Cleanup(func() { sseHeartbeatInterval = interval })
+
+	body, err := io.ReadAll(streamBatchEvents(context.Background(), LambdaEvent{PolicyName: "slow", Payloads: []json.RawMessage{json.RawMessage(`{}`)}}))
+	require.NoError(t, err)
+	require.Contains(t, string(body), ": heartbeat\n\n")
+	require.Equal(t, "id: 0\ndata: {\"index\":0,\"output\":{\"total\":200000}}\n\nevent: done\ndata: {\"count\":1}\n\n",
+		withoutDecisionIDs(strings.ReplaceAll(string(body), ": heartbeat\n\n", "")))
+}
+
+func TestHandleLambdaAPIGatewayV2EventBatchErrors(t *testing.T) {
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"example","payload":{},"payloads":[{}]}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "mutually exclusive")
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"example","format":"csv","payloads":[{}]}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+
+	gwResp = invokeAPIGatewayV2(t, map[string]string{"Accept": "text/csv"}, `{"policy":"example","payloads":[{}]}`)
+	require.Equal(t, http.StatusNotAcceptable, gwResp.StatusCode)
+}
+
+var functionURLRequestContext = events.LambdaFunctionURLRequestContext{DomainName: "abcdefg.lambda-url.us-east-1.on.aws"}
+
+func TestHandleLambdaFunctionURLStreamingBatch(t *testing.T) {
+	t.Setenv("RESPONSE_STREAMING", "true")
+	t.Setenv("POLICY_SELECTOR_PATH", "$.routing.policy")
+
+	event := events.LambdaFunctionURLRequest{
+		Version:        "2.0",
+		RawPath:        "/",
+		Headers:        map[string]string{"accept": "application/x-ndjson"},
+		Body:           batchRequestBody,
+		RequestContext: functionURLRequestContext,
+	}
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+
+	stream, ok := resp.(*events.LambdaFunctionURLStreamingResponse)
+	require.True(t, ok)
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+	require.Equal(t, "application/x-ndjson", stream.Headers["Content-Type"])
+
+	var lines []batchItemResult
+	scanner := bufio.NewScanner(stream.Body)
+	for scanner.Scan() {
+		var line batchItemResult
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		require.Equal(t, len(lines), line.Index)
+		lines = append(lines, line)
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Len(t, lines, 3)
+	require.Equal(t, true, lines[0].Output.(map[string]interface{})["allow"])
+	require.Contains(t, lines[1].Error, "unable to select policy")
+	require.Equal(t, false, lines[2].Output.(map[string]interface{})["allow"])
+}
+
+func TestHandleLambdaFunctionURLStreamingSingle(t *testing.T) {
+	t.Setenv("RESPONSE_STREAMING", "true")
+
+	event := events.LambdaFunctionURLRequest{
+		Version:        "2.0",
+		RawPath:        "/",
+		Body:           string(buildLambdaEventPayloadBytes(t)),
+		RequestContext: functionURLRequestContext,
+	}
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+
+	stream, ok := resp.(*events.LambdaFunctionURLStreamingResponse)
+	require.True(t, ok)
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+
+	var body LambdaResponse
+	require.NoError(t, json.NewDecoder(stream.Body).Decode(&body))
+	assertExampleOutput(t, body.Output)
+}
+
+func TestHandleLambdaAPIGatewayV2EventStreamingBuffered(t *testing.T) {
+	t.Setenv("RESPONSE_STREAMING", "true")
+
+	gwResp := invokeAPIGatewayV2(t, map[string]string{"Accept": "application/x-ndjson"}, `{"policy":"example","payloads":[{}]}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, "application/x-ndjson", gwResp.Headers["Content-Type"])
+	require.Equal(t, `{"index":0,"output":{"allow":false}}`+"\n", withoutDecisionIDs(gwResp.Body))
+
+	gwResp = invokeAPIGatewayV2(t, nil, string(buildLambdaEventPayloadBytes(t)))
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	assertExampleOutput(t, parseLambdaResponseBody(t, gwResp.Body).Output)
+}
+
+func TestHandleLambdaAPIGatewayV2EventBatchLimit(t *testing.T) {
+	t.Setenv("MAX_BATCH_ITEMS", "2")
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"example","payloads":[{},{}]}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"example","payloads":[{},{},{}]}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "exceeds MAX_BATCH_ITEMS=2")
+
+	t.Setenv("MAX_BATCH_ITEMS", "0")
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"example","payloads":[{},{},{}]}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+}
+
+func TestHandleLambdaAPIGatewayV2EventBatchAggregateAllow(t *testing.T) {
+	const member = `{"membership":{"user":{"login":"jane","mail":"jane@example.com"}}}`
+	const outsider = `{"membership":{"user":{"login":"joe","mail":"joe@elsewhere.com"}}}`
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"example","aggregate_allow":true,"payloads":[`+member+`,`+member+`]}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	var aggregate batchAggregate
+	require.NoError(t, json.Unmarshal([]byte(gwResp.Body), &aggregate))
+	require.True(t, aggregate.Allow)
+	require.Len(t, aggregate.Results, 2)
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"example","aggregate_allow":true,"payloads":[`+member+`,`+outsider+`,`+member+`]}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	aggregate = batchAggregate{}
+	require.NoError(t, json.Unmarshal([]byte(gwResp.Body), &aggregate))
+	require.False(t, aggregate.Allow)
+	require.Len(t, aggregate.Results, 2)
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"example","aggregate_allow":true,"evaluate_all":true,"payloads":[`+member+`,`+outsider+`,`+member+`]}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	aggregate = batchAggregate{}
+	require.NoError(t, json.Unmarshal([]byte(gwResp.Body), &aggregate))
+	require.False(t, aggregate.Allow)
+	require.Len(t, aggregate.Results, 3)
+
+	gwResp = invokeAPIGatewayV2(t, map[string]string{"Accept": "application/x-ndjson"}, `{"policy":"example","aggregate_allow":true,"payloads":[{}]}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+}
+
+func TestIsAllowed(t *testing.T) {
+	require.True(t, isAllowed(LambdaResponse{Output: true}))
+	require.True(t, isAllowed(LambdaResponse{Output: map[string]interface{}{"allow": true}}))
+	require.False(t, isAllowed(LambdaResponse{Output: map[string]interface{}{"allow": "yes"}}))
+	require.False(t, isAllowed(LambdaResponse{Output: map[string]interface{}{}}))
+	require.False(t, isAllowed(LambdaResponse{Error: "boom", Output: true}))
+	require.False(t, isAllowed(LambdaResponse{Undefined: true}))
+}
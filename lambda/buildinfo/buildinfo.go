@@ -0,0 +1,57 @@
+// Package buildinfo reports which build of the function is running.
+package buildinfo
+
+import "runtime/debug"
+
+// version can be injected at build time with
+// -ldflags "-X opa_lambda/buildinfo.version=<version>".
+var version string
+
+// Version returns the injected version, falling back to the module version
+// or VCS revision recorded by the Go toolchain, or "unknown".
+func Version() string {
+	if version != "" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			revision := setting.Value
+			if len(revision) > 12 {
+				revision = revision[:12]
+			}
+			return revision
+		}
+	}
+	return "unknown"
+}
+
+// Fields describes the build for structured logs: the version plus the Go
+// version and VCS details recorded by the toolchain, when available.
+func Fields() map[string]interface{} {
+	fields := map[string]interface{}{"version": Version()}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fields
+	}
+	fields["go_version"] = info.GoVersion
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			fields["vcs_revision"] = setting.Value
+		case "vcs.time":
+			fields["vcs_time"] = setting.Value
+		case "vcs.modified":
+			fields["vcs_modified"] = setting.Value == "true"
+		}
+	}
+	return fields
+}
@@ -0,0 +1,27 @@
+package buildinfo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionInjected(t *testing.T) {
+	version = "1.2.3"
+	t.Cleanup(func() { version = "" })
+
+	assert.Equal(t, "1.2.3", Version())
+}
+
+func TestVersionFallback(t *testing.T) {
+	assert.NotEmpty(t, Version())
+}
+
+func TestFields(t *testing.T) {
+	version = "1.2.3"
+	t.Cleanup(func() { version = "" })
+
+	fields := Fields()
+	assert.Equal(t, "1.2.3", fields["version"])
+	assert.NotEmpty(t, fields["go_version"])
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborMediaType is the Content-Type of request bodies encoded as CBOR.
+const cborMediaType = "application/cbor"
+
+// cborDecMode decodes CBOR into the same Go values json.Unmarshal produces
+// for the equivalent JSON, so that maps must have string keys. Tags that are
+// not understood are dropped and their content kept.
+var cborDecMode = func() cbor.DecMode {
+	mode, err := cbor.DecOptions{
+		DefaultMapType:       reflect.TypeOf(map[string]interface{}(nil)),
+		UnrecognizedTagToAny: cbor.UnrecognizedTagContentToAny,
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}()
+
+// requestBody returns the body of an HTTP request as JSON. Bodies sent with
+// Content-Encoding: gzip are decompressed first. Bodies sent with
+// Content-Type: application/cbor are decoded and converted to the equivalent
+// JSON document, so the handlers only ever deal with JSON.
+func requestBody(req httpRequest) ([]byte, error) {
+	body, err := decodeBody(req.Body, req.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+	if body, err = gunzipBody(body, req.header("Content-Encoding")); err != nil {
+		return nil, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.header("Content-Type"))
+	if mediaType != cborMediaType {
+		return body, nil
+	}
+	return cborToJSON(body)
+}
+
+// cborToJSON converts one CBOR data item to JSON. Byte strings become base64
+// strings, as encoding/json renders []byte, and trailing data is rejected.
+func cborToJSON(body []byte) ([]byte, error) {
+	var value interface{}
+	if err := cborDecMode.Unmarshal(body, &value); err != nil {
+		return nil, fmt.Errorf("unable to parse CBOR body: %w", err)
+	}
+
+	converted, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert CBOR body to JSON: %w", err)
+	}
+	return converted, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleLambdaAPIGatewayV2EventCBOR(t *testing.T) {
+	envelope := map[string]interface{}{
+		"policy": "example",
+		"payload": map[string]interface{}{
+			"membership": map[string]interface{}{
+				"user": map[string]interface{}{"login": "jane", "mail": "jane@example.com"},
+			},
+		},
+	}
+	body, err := cbor.Marshal(envelope)
+	require.NoError(t, err)
+
+	invoke := func(body []byte, contentType string) events.APIGatewayV2HTTPResponse {
+		raw, err := json.Marshal(events.APIGatewayV2HTTPRequest{
+			Version:         "2.0",
+			RawPath:         "/opa",
+			Headers:         map[string]string{"content-type": contentType},
+			Body:            base64.StdEncoding.EncodeToString(body),
+			IsBase64Encoded: true,
+		})
+		require.NoError(t, err)
+		resp, err := handleLambda(context.Background(), raw)
+		require.NoError(t, err)
+		return resp.(events.APIGatewayV2HTTPResponse)
+	}
+
+	gwResp := invoke(body, "application/cbor")
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, "application/json", gwResp.Headers["Content-Type"])
+	assertExampleOutput(t, parseLambdaResponseBody(t, gwResp.Body).Output)
+
+	gwResp = invoke(body[:len(body)-3], "application/cbor; charset=binary")
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "unable to parse CBOR body")
+
+	// JSON stays the default when no CBOR content type is sent.
+	gwResp = invoke(body, "application/json")
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+}
+
+func TestCBORToJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    string
+		wantErr string
+	}{
+		{name: "scalars", value: map[string]interface{}{"n": 1, "f": 1.5, "b": true, "s": "x", "null": nil}, want: `{"b":true,"f":1.5,"n":1,"null":null,"s":"x"}`},
+		{name: "bytes", value: map[string]interface{}{"raw": []byte("hi")}, want: `{"raw":"aGk="}`},
+		{name: "unknown tag", value: cbor.Tag{Number: 40000, Content: "tagged"}, want: `"tagged"`},
+		{name: "integer keys", value: map[int]string{1: "one"}, wantErr: "unable to parse CBOR body"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := cbor.Marshal(tt.value)
+			require.NoError(t, err)
+
+			got, err := cborToJSON(body)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
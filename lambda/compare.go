@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// dataComparison is the output of evaluating one payload against the current
+// and the candidate reference data.
+type dataComparison struct {
+	Current   interface{}    `json:"current"`   // The output with data; null when undefined.
+	Candidate interface{}    `json:"candidate"` // The output with candidate_data; null when undefined.
+	Changed   bool           `json:"changed"`   // Whether the two outputs differ.
+	Diff      []outputChange `json:"diff"`      // The differing values, in path order.
+}
+
+// outputChange is one value that differs between two outputs.
+type outputChange struct {
+	Path      string      `json:"path"`                // Dotted path of the value; empty for the whole output.
+	Current   interface{} `json:"current,omitempty"`   // The value with data, omitted when absent.
+	Candidate interface{} `json:"candidate,omitempty"` // The value with candidate_data, omitted when absent.
+}
+
+// compareDataSnapshots evaluates the payload once with req.Data and once with
+// req.CandidateData and reports both outputs along with their differences, so
+// reference data changes can be vetted before rollout.
+func compareDataSnapshots(ctx context.Context, req LambdaEvent) (LambdaResponse, error) {
+	if len(req.Policies) > 0 {
+		return LambdaResponse{}, invalidRequestError("candidate_data is not supported when evaluating several policies")
+	}
+	if req.Coverage {
+		return LambdaResponse{}, invalidRequestError("coverage is not supported when comparing data")
+	}
+
+	current := req
+	current.CandidateData = nil
+	current.preview = true
+	currentResp, err := evaluatePolicy(ctx, current)
+	if err != nil {
+		return LambdaResponse{}, fmt.Errorf("current data: %w", err)
+	}
+
+	candidate := current
+	candidate.Data = req.CandidateData
+	candidateResp, err := evaluatePolicy(ctx, candidate)
+	if err != nil {
+		return LambdaResponse{}, fmt.Errorf("candidate data: %w", err)
+	}
+
+	comparison := dataComparison{Diff: []outputChange{}}
+	if !currentResp.Undefined {
+		comparison.Current = currentResp.Output
+	}
+	if !candidateResp.Undefined {
+		comparison.Candidate = candidateResp.Output
+	}
+	comparison.Diff = diffValues(comparison.Current, comparison.Candidate, "", comparison.Diff)
+	comparison.Changed = len(comparison.Diff) > 0
+
+	return LambdaResponse{
+		Output:         comparison,
+		Truncated:      currentResp.Truncated || candidateResp.Truncated,
+		TimedOut:       currentResp.TimedOut || candidateResp.TimedOut,
+		NoCache:        req.NoCache,
+		PolicyMetadata: currentResp.PolicyMetadata,
+		Warnings:       currentResp.Warnings,
+	}, nil
+}
+
+// diffValues appends the differences between current and candidate to
+// changes. Objects are compared key by key; anything else, including arrays,
+// is compared as a whole.
+func diffValues(current, candidate interface{}, path string, changes []outputChange) []outputChange {
+	currentObject, currentIsObject := current.(map[string]interface{})
+	candidateObject, candidateIsObject := candidate.(map[string]interface{})
+	if !currentIsObject || !candidateIsObject {
+		if !reflect.DeepEqual(current, candidate) {
+			changes = append(changes, outputChange{Path: path, Current: current, Candidate: candidate})
+		}
+		return changes
+	}
+
+	keys := make([]string, 0, len(currentObject)+len(candidateObject))
+	for key := range currentObject {
+		keys = append(keys, key)
+	}
+	for key := range candidateObject {
+		if _, ok := currentObject[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		changes = diffValues(currentObject[key], candidateObject[key], strings.TrimPrefix(path+"."+key, "."), changes)
+	}
+	return changes
+}
+
+// parseData decodes a request's reference data, which must be a JSON object.
+// A nil document leaves the policy's base data empty.
+func parseData(field string, raw *json.RawMessage) (map[string]interface{}, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(*raw))
+	decoder.UseNumber()
+
+	var data map[string]interface{}
+	if err := decoder.Decode(&data); err != nil || data == nil {
+		return nil, invalidRequestError("%s must be a JSON object", field)
+	}
+	return data, nil
+}
+
+// errMockDataNotAllowed is returned for requests sending mock_data to a
+// deployment that does not accept it.
+var errMockDataNotAllowed = errors.New("mock_data requires ALLOW_MOCK_DATA=true")
+
+// parseMockData decodes a request's mock_data values, keyed by data path.
+// Mocks change what policies decide, so they are only accepted by
+// deployments that opt in with ALLOW_MOCK_DATA, such as those running policy
+// tests.
+func parseMockData(raw map[string]json.RawMessage) (map[string]interface{}, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	allowed, err := boolFromEnv("ALLOW_MOCK_DATA", false)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, errMockDataNotAllowed
+	}
+
+	mocks := make(map[string]interface{}, len(raw))
+	for path, value := range raw {
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		decoder.UseNumber()
+
+		var mock interface{}
+		if err := decoder.Decode(&mock); err != nil {
+			return nil, fmt.Errorf("mock_data %s: %w", path, err)
+		}
+		mocks[path] = mock
+	}
+	return mocks, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"opa_lambda/ratelimit"
+
+	"github.com/stretchr/testify/require"
+)
+
+const referencePolicy = `package reference
+
+default allow = false
+
+allow {
+    data.lists.admins[_] == input.user
+}
+
+limit := data.lists.limits[input.user]
+`
+
+func TestEvaluatePolicyWithData(t *testing.T) {
+	writeTestPolicy(t, "reference", referencePolicy)
+
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{
+		"policy": "reference",
+		"payload": {"user": "jane"},
+		"data": {"lists": {"admins": ["jane"], "limits": {"jane": 5}}}
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"allow": true, "limit": json.Number("5")}, resp.(LambdaResponse).Output)
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy": "reference", "payload": {}, "data": [1]}`))
+	require.ErrorContains(t, err, "data must be a JSON object")
+	require.Equal(t, errorCodeInvalidRequest, classifyError(err).code)
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy": "reference", "payload": {}, "data": {}, "candidate_data": 5}`))
+	require.ErrorContains(t, err, "candidate data: data must be a JSON object")
+	require.Equal(t, errorCodeInvalidRequest, classifyError(err).code)
+}
+
+func TestCompareDataSnapshots(t *testing.T) {
+	writeTestPolicy(t, "reference", referencePolicy)
+
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{
+		"policy": "reference",
+		"payload": {"user": "jane"},
+		"data": {"lists": {"admins": ["jane"], "limits": {"jane": 5}}},
+		"candidate_data": {"lists": {"admins": ["joe"], "limits": {"jane": 5}}}
+	}`))
+	require.NoError(t, err)
+
+	raw, err := json.Marshal(resp)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"output": {
+		"current": {"allow": true, "limit": 5},
+		"candidate": {"allow": false, "limit": 5},
+		"changed": true,
+		"diff": [{"path": "allow", "current": true, "candidate": false}]
+	}}`, withoutDecisionIDs(string(raw)))
+
+	resp, err = handleLambda(context.Background(), json.RawMessage(`{
+		"policy": "reference",
+		"payload": {"user": "joe"},
+		"data": {"lists": {"admins": [], "limits": {}}},
+		"candidate_data": {"lists": {"admins": [], "limits": {"joe": 2}}}
+	}`))
+	require.NoError(t, err)
+
+	comparison := resp.(LambdaResponse).Output.(dataComparison)
+	require.True(t, comparison.Changed)
+	require.Equal(t, []outputChange{{Path: "limit", Candidate: json.Number("2")}}, comparison.Diff)
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policies": ["reference"], "payload": {}, "candidate_data": {}}`))
+	require.ErrorContains(t, err, "not supported when evaluating several policies")
+}
+
+func TestDiffValues(t *testing.T) {
+	current := map[string]interface{}{"a": map[string]interface{}{"b": 1, "c": []interface{}{1}}, "d": "x"}
+	candidate := map[string]interface{}{"a": map[string]interface{}{"b": 1, "c": []interface{}{2}}, "e": "y"}
+
+	require.Equal(t, []outputChange{
+		{Path: "a.c", Current: []interface{}{1}, Candidate: []interface{}{2}},
+		{Path: "d", Current: "x"},
+		{Path: "e", Candidate: "y"},
+	}, diffValues(current, candidate, "", nil))
+
+	require.Empty(t, diffValues(current, current, "", nil))
+	require.Equal(t, []outputChange{{Path: "", Current: true, Candidate: false}}, diffValues(true, false, "", nil))
+}
+
+func TestEvaluatePolicyWithMockData(t *testing.T) {
+	writeTestPolicy(t, "reference", referencePolicy)
+	t.Setenv("ALLOW_MOCK_DATA", "true")
+
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{
+		"policy": "reference",
+		"payload": {"user": "jane"},
+		"data": {"lists": {"admins": ["joe"], "limits": {"jane": 5}}},
+		"mock_data": {"lists.admins": ["jane"]}
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"allow": true, "limit": json.Number("5")}, resp.(LambdaResponse).Output)
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy": "reference", "payload": {}, "mock_data": {"lists[input.user]": 1}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+
+	// The policy's own rules cannot be stubbed.
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy": "reference", "payload": {}, "mock_data": {"reference.allow": true}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, `"reference.allow" would replace data.reference`)
+}
+
+func TestEvaluatePolicyMockDataNotAllowed(t *testing.T) {
+	t.Setenv("ALLOW_MOCK_DATA", "")
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"example","payload":{"membership":{"user":{"login":"joe"}}},"mock_data":{"example.allow":true}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	body := parseLambdaResponseBody(t, gwResp.Body)
+	require.Equal(t, errorCodeInvalidRequest, body.ErrorCode)
+	require.Contains(t, body.Error, "mock_data requires ALLOW_MOCK_DATA=true")
+}
+
+func TestPreviewsDoNotSpendRateLimits(t *testing.T) {
+	t.Setenv("ALLOW_MOCK_DATA", "true")
+	rateLimiterMu.Lock()
+	rateLimiter, rateLimiterInit = ratelimit.NewMemoryLimiter(), true
+	rateLimiterMu.Unlock()
+	t.Cleanup(func() {
+		rateLimiterMu.Lock()
+		rateLimiter, rateLimiterInit = nil, false
+		rateLimiterMu.Unlock()
+	})
+	writeTestPolicy(t, "metered", "package metered\n\nallow = ratelimit.allow(\"metered\", \"1/h\")\n")
+
+	for _, body := range []string{
+		`{"policy":"metered","payload":{},"data":{},"candidate_data":{"x":1}}`,
+		`{"policy":"metered","inputs":{"a":{},"b":{}}}`,
+		`{"policy":"metered","payload":{},"mock_data":{"data.x":1}}`,
+	} {
+		_, err := handleLambda(context.Background(), json.RawMessage(body))
+		require.NoError(t, err, body)
+	}
+
+	// The bucket's only token is still there for a real decision.
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"metered","payload":{}}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"allow": true}, resp.(LambdaResponse).Output)
+	resp, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"metered","payload":{}}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"allow": false}, resp.(LambdaResponse).Output)
+}
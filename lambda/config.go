@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"opa_lambda/policyevaluator"
+)
+
+// defaultMaxResultDepth bounds the nesting of results when MAX_RESULT_DEPTH is
+// unset. Real decisions are far shallower.
+const defaultMaxResultDepth = 1000
+
+// evaluationOptionsFromEnv builds the deployment-wide evaluation options.
+func evaluationOptionsFromEnv() (policyevaluator.EvaluationOptions, error) {
+	var opts policyevaluator.EvaluationOptions
+
+	maxItems, err := intFromEnv("MAX_RESULT_ITEMS", 0)
+	if err != nil {
+		return opts, err
+	}
+	opts.MaxResultItems = maxItems
+
+	if opts.MaxResultDepth, err = intFromEnv("MAX_RESULT_DEPTH", defaultMaxResultDepth); err != nil {
+		return opts, err
+	}
+
+	if opts.DisableUnsafeBuiltins, err = boolFromEnv("DISABLE_UNSAFE_BUILTINS", false); err != nil {
+		return opts, err
+	}
+	if opts.StrictBuiltinErrors, err = boolFromEnv("STRICT_BUILTIN_ERRORS", false); err != nil {
+		return opts, err
+	}
+	if opts.TypeCheckInput, err = boolFromEnv("TYPE_CHECK_INPUT", false); err != nil {
+		return opts, err
+	}
+	if opts.Timeout, err = secondsFromEnv("EVAL_TIMEOUT_SECONDS", 0); err != nil {
+		return opts, err
+	}
+	if opts.LoadTimeout, err = secondsFromEnv("LOAD_TIMEOUT_SECONDS", 0); err != nil {
+		return opts, err
+	}
+
+	maxHeapGrowthMB, err := intFromEnv("EVAL_MAX_HEAP_GROWTH_MB", 0)
+	if err != nil {
+		return opts, err
+	}
+	opts.MaxHeapGrowth = uint64(maxHeapGrowthMB) << 20
+
+	if opts.MaxIterations, err = intFromEnv("EVAL_MAX_ITERATIONS", 0); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// intFromEnv reads a non-negative integer from the environment, falling back
+// to def when the variable is unset.
+func intFromEnv(name string, def int) (int, error) {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def, nil
+	}
+	val, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if val < 0 {
+		return 0, fmt.Errorf("%s must not be negative", name)
+	}
+	return val, nil
+}
+
+// boolFromEnv reads a boolean from the environment, falling back to def when
+// the variable is unset.
+func boolFromEnv(name string, def bool) (bool, error) {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def, nil
+	}
+	val, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return val, nil
+}
+
+// secondsFromEnv reads a non-negative, possibly fractional number of seconds
+// from the environment, falling back to def when the variable is unset.
+func secondsFromEnv(name string, def time.Duration) (time.Duration, error) {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def, nil
+	}
+	val, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if val < 0 {
+		return 0, fmt.Errorf("%s must not be negative", name)
+	}
+	return time.Duration(val * float64(time.Second)), nil
+}
+
+// fractionFromEnv reads a number between 0 and 1 from the environment,
+// falling back to def when the variable is unset.
+func fractionFromEnv(name string, def float64) (float64, error) {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def, nil
+	}
+	val, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if val < 0 || val > 1 {
+		return 0, fmt.Errorf("%s must be between 0 and 1", name)
+	}
+	return val, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Control actions accepted by direct invocations in the action field.
+const (
+	actionParse  = "parse"  // Return the parsed AST of the policy.
+	actionWarmup = "warmup" // Ready the function; detected by handleLambda before the event is parsed.
+)
+
+// handleControlEvent runs a direct invocation's control action in place of a
+// policy evaluation.
+func handleControlEvent(ctx context.Context, req LambdaEvent) (LambdaResponse, error) {
+	switch req.Action {
+	case actionParse:
+		return parsePolicy(ctx, req)
+	default:
+		return LambdaResponse{}, invalidRequestError("unsupported action: %s", req.Action)
+	}
+}
+
+// parsePolicy loads and parses req.PolicyName and returns its AST, including
+// node locations, for editor tooling. The policy is not compiled or evaluated.
+func parsePolicy(ctx context.Context, req LambdaEvent) (LambdaResponse, error) {
+	if req.PolicyName == "" {
+		return LambdaResponse{}, errPolicyRequired
+	}
+	if err := checkPolicyAllowed(req.PolicyName); err != nil {
+		return LambdaResponse{}, err
+	}
+
+	pe, err := sharedEvaluator(ctx)
+	if err != nil {
+		return LambdaResponse{}, err
+	}
+
+	log.Infof("Parsing policy: %s", req.PolicyName)
+
+	module, err := pe.ParsePolicy(ctx, req.PolicyName)
+	if err != nil {
+		return LambdaResponse{}, err
+	}
+	return LambdaResponse{Output: module}, nil
+}
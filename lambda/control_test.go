@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleLambdaParseAction(t *testing.T) {
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"action":"parse","policy":"example"}`))
+	require.NoError(t, err)
+
+	lambdaResp, ok := resp.(LambdaResponse)
+	require.True(t, ok)
+	require.Empty(t, lambdaResp.Error)
+
+	raw, err := json.Marshal(lambdaResp)
+	require.NoError(t, err)
+
+	var decoded struct {
+		Output struct {
+			Package struct {
+				Path []struct {
+					Value interface{} `json:"value"`
+				} `json:"path"`
+			} `json:"package"`
+			Rules []struct {
+				Location struct {
+					File string `json:"file"`
+					Row  int    `json:"row"`
+				} `json:"location"`
+			} `json:"rules"`
+		} `json:"output"`
+	}
+	require.NoError(t, json.Unmarshal(raw, &decoded))
+	require.Len(t, decoded.Output.Package.Path, 2)
+	require.Equal(t, "example", decoded.Output.Package.Path[1].Value)
+	require.Len(t, decoded.Output.Rules, 4)
+	require.Equal(t, "example.rego", decoded.Output.Rules[0].Location.File)
+	require.Equal(t, 3, decoded.Output.Rules[0].Location.Row)
+}
+
+func TestHandleLambdaParseActionErrors(t *testing.T) {
+	_, err := handleLambda(context.Background(), json.RawMessage(`{"action":"parse"}`))
+	require.ErrorContains(t, err, "policy is required")
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"action":"parse","policy":"nosuchpolicy"}`))
+	require.Error(t, err)
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"action":"explode","policy":"example"}`))
+	require.ErrorContains(t, err, "unsupported action: explode")
+	require.Equal(t, errorCodeInvalidRequest, classifyError(err).code)
+
+	t.Setenv("POLICY_ALLOWLIST", "world")
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"action":"parse","policy":"example"}`))
+	require.ErrorIs(t, err, errPolicyNotAllowed)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"opa_lambda/policyloader"
+
+	"github.com/open-policy-agent/opa/ast"
+	log "github.com/sirupsen/logrus"
+)
+
+// dataAPIPrefix is the path of OPA's data API. Requests below it are served
+// in OPA's native request and response shape.
+const dataAPIPrefix = "/v1/data"
+
+// dataAPIRequest is the body of an OPA data API request.
+type dataAPIRequest struct {
+	Input *json.RawMessage `json:"input"`
+}
+
+// dataAPIError is OPA's error response body.
+type dataAPIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// dataAPIPath returns the document path of a data API request, tolerating a
+// stage or base path in front of /v1/data.
+func dataAPIPath(path string) (string, bool) {
+	i := strings.Index(path, dataAPIPrefix)
+	if i < 0 {
+		return "", false
+	}
+
+	rest := path[i+len(dataAPIPrefix):]
+	if rest != "" && !strings.HasPrefix(rest, "/") {
+		return "", false
+	}
+	return strings.Trim(rest, "/"), true
+}
+
+// handleDataAPIRequest evaluates POST /v1/data/<path> with an {"input": ...}
+// body and answers {"result": ...}, omitting result when the document is
+// undefined, as OPA does.
+//
+// The path is mapped onto the longest policy name that can be loaded: for
+// /v1/data/a/b/c the policies a.b.c, a.b and a are tried in turn, and the
+// first one found is queried for data.a.b.c.
+func handleDataAPIRequest(ctx context.Context, req httpRequest, docPath string) httpResponse {
+	segments := strings.Split(docPath, "/")
+	for _, segment := range segments {
+		if segment == "" || strings.Contains(segment, ".") {
+			err := fmt.Errorf("invalid data path: %q", docPath)
+			log.Error(err)
+			return newDataAPIErrorResponse(http.StatusBadRequest, "invalid_parameter", err)
+		}
+	}
+
+	input := json.RawMessage("null")
+	if req.Body != "" {
+		body, err := requestBody(req)
+		if err != nil {
+			log.Error(err)
+			return newDataAPIErrorResponse(http.StatusBadRequest, "invalid_parameter", err)
+		}
+
+		var dataReq dataAPIRequest
+		if err := decodeJSONBody(body, &dataReq); err != nil {
+			err = fmt.Errorf("unable to parse %s body: %w", req.Integration, err)
+			log.Error(err)
+			return newDataAPIErrorResponse(http.StatusBadRequest, "invalid_parameter", err)
+		}
+		if dataReq.Input != nil {
+			input = *dataReq.Input
+		}
+	}
+
+	ref := ast.Ref{ast.DefaultRootDocument}
+	for _, segment := range segments {
+		ref = append(ref, ast.StringTerm(segment))
+	}
+
+	var notAllowed bool
+	var tooLongErr error
+	for n := len(segments); n > 0; n-- {
+		resp, err := evaluatePolicy(ctx, LambdaEvent{
+			PolicyName: strings.Join(segments[:n], "."),
+			Payload:    &input,
+			Query:      ref.String(),
+		})
+
+		var notFound *policyloader.FileNotFoundError
+		var tooLong *policyloader.URLTooLongError
+		switch {
+		case err == nil:
+			body := map[string]interface{}{"decision_id": resp.DecisionID}
+			if !resp.Undefined {
+				body["result"] = resp.Output
+			}
+			httpResp := newJSONResponse(http.StatusOK, body)
+			setDecisionHeaders(httpResp, resp)
+			return httpResp
+		case errors.As(err, &notFound):
+			continue
+		case errors.Is(err, errPolicyNotAllowed):
+			notAllowed = true
+			continue
+		case errors.As(err, &tooLong):
+			// Deep rule paths can overflow the URL of a candidate that is
+			// not a policy at all; a shorter candidate may still be found.
+			if tooLongErr == nil {
+				tooLongErr = err
+			}
+			continue
+		default:
+			log.Error(err)
+			return newDataAPIErrorResponse(http.StatusInternalServerError, "internal_error", err)
+		}
+	}
+
+	if notAllowed {
+		err := fmt.Errorf("%w: %s", errPolicyNotAllowed, docPath)
+		log.Error(err)
+		return newDataAPIErrorResponse(http.StatusForbidden, "unauthorized", err)
+	}
+
+	if tooLongErr != nil {
+		log.Error(tooLongErr)
+		return newDataAPIErrorResponse(http.StatusBadRequest, "invalid_parameter", tooLongErr)
+	}
+
+	err := fmt.Errorf("no policy found for data path: %s", docPath)
+	log.Error(err)
+	return newDataAPIErrorResponse(http.StatusNotFound, "resource_not_found", err)
+}
+
+func newDataAPIErrorResponse(status int, code string, err error) httpResponse {
+	return newJSONResponse(status, dataAPIError{Code: code, Message: err.Error()})
+}
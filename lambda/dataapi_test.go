@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataAPIPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{path: "/v1/data/example/allow", want: "example/allow", ok: true},
+		{path: "/prod/v1/data/example", want: "example", ok: true},
+		{path: "/v1/data", want: "", ok: true},
+		{path: "/v1/dataset/example", ok: false},
+		{path: "/opa", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := dataAPIPath(tt.path)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func invokeDataAPI(t *testing.T, path, body string) (events.APIGatewayV2HTTPResponse, map[string]interface{}) {
+	t.Helper()
+	event := events.APIGatewayV2HTTPRequest{
+		Version: "2.0",
+		RawPath: path,
+		Body:    body,
+	}
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+
+	gwResp, ok := resp.(events.APIGatewayV2HTTPResponse)
+	require.True(t, ok)
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(gwResp.Body), &decoded))
+	if gwResp.StatusCode == http.StatusOK {
+		require.Equal(t, gwResp.Headers["X-Decision-Id"], decoded["decision_id"])
+		delete(decoded, "decision_id")
+	}
+	return gwResp, decoded
+}
+
+func TestHandleDataAPIRule(t *testing.T) {
+	gwResp, body := invokeDataAPI(t, "/v1/data/example/allow", `{"input":{"membership":{"user":{"login":"jane","mail":"jane@example.com"}}}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, map[string]interface{}{"result": true}, body)
+}
+
+func TestHandleDataAPIPackage(t *testing.T) {
+	gwResp, body := invokeDataAPI(t, "/v1/data/example", `{"input":{"membership":{"user":{"login":"jane"}}}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+
+	result, ok := body["result"].(map[string]interface{})
+	require.True(t, ok)
+	require.Equal(t, false, result["allow"])
+	require.Equal(t, "jane", result["user"])
+}
+
+func TestHandleDataAPIUndefined(t *testing.T) {
+	gwResp, body := invokeDataAPI(t, "/v1/data/example/missing", `{"input":{}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Empty(t, body)
+
+	gwResp, body = invokeDataAPI(t, "/v1/data/example/user", "")
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Empty(t, body)
+}
+
+func TestHandleDataAPIErrors(t *testing.T) {
+	gwResp, body := invokeDataAPI(t, "/v1/data/nosuchpolicy/allow", `{"input":{}}`)
+	require.Equal(t, http.StatusNotFound, gwResp.StatusCode)
+	require.Equal(t, "resource_not_found", body["code"])
+
+	gwResp, body = invokeDataAPI(t, "/v1/data/example/allow", `{"input":`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Equal(t, "invalid_parameter", body["code"])
+
+	gwResp, body = invokeDataAPI(t, "/v1/data/example.allow", `{"input":{}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Equal(t, "invalid_parameter", body["code"])
+
+	t.Setenv("POLICY_ALLOWLIST", "world")
+	gwResp, body = invokeDataAPI(t, "/v1/data/example/allow", `{"input":{}}`)
+	require.Equal(t, http.StatusForbidden, gwResp.StatusCode)
+	require.Equal(t, "unauthorized", body["code"])
+}
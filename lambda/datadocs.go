@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"opa_lambda/policyloader"
+)
+
+// dataDocumentSuffix names the data document stored next to a policy, as in
+// policies/auth/user.data.json for auth.user.
+const dataDocumentSuffix = ".data.json"
+
+// errReservedData is returned for request data setting a top-level document
+// the deployment supplies, such as one of a policy's data document.
+var errReservedData = errors.New("reserved data")
+
+// policyData returns the data document stored next to the policy when
+// POLICY_DATA_DOCUMENTS is enabled, and nil otherwise or when the policy has
+// none, along with the version of the document.
+func policyData(ctx context.Context, policyName string) (map[string]interface{}, string, error) {
+	enabled, err := boolFromEnv("POLICY_DATA_DOCUMENTS", false)
+	if err != nil || !enabled {
+		return nil, "", err
+	}
+	return loadPolicyData(ctx, policyName)
+}
+
+// checkRequestData checks the data a request supplied against the data the
+// deployment supplies for the policy. Policies trust the deployment's data,
+// so a request may add top-level documents but not replace or extend any of
+// the deployment's; a request that tries is rejected with errReservedData.
+func checkRequestData(data, requested map[string]interface{}) error {
+	keys := make([]string, 0, len(requested))
+	for key := range requested {
+		if _, ok := data[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		return fmt.Errorf("%w: data.%s is supplied by the deployment and cannot be set by the request", errReservedData, strings.Join(keys, ", data."))
+	}
+	return nil
+}
+
+// loadPolicyData loads and parses the data document of a policy, and
+// fingerprints it as its version. It returns nil when the policy has none.
+func loadPolicyData(ctx context.Context, policyName string) (map[string]interface{}, string, error) {
+	pl, err := sharedPolicyLoader(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	documents, ok := pl.(policyloader.DocumentLoader)
+	if !ok {
+		return nil, "", errors.New("POLICY_DATA_DOCUMENTS is not supported by the policy backend")
+	}
+
+	raw, err := documents.LoadDocument(ctx, policyName, dataDocumentSuffix)
+	var notFound *policyloader.FileNotFoundError
+	if errors.As(err, &notFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	decoder.UseNumber()
+
+	var data map[string]interface{}
+	if err := decoder.Decode(&data); err != nil || data == nil {
+		return nil, "", fmt.Errorf("invalid data document for %s: must be a JSON object", policyName)
+	}
+	return data, fmt.Sprintf("%x", sha256.Sum256([]byte(raw))), nil
+}
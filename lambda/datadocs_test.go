@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestDataDocument(t *testing.T, name, document string) {
+	t.Helper()
+	path := filepath.Join(testPolicyDir(t), name+dataDocumentSuffix)
+	require.NoError(t, os.WriteFile(path, []byte(document), 0o600))
+}
+
+func TestEvaluatePolicyWithDataDocument(t *testing.T) {
+	writeTestPolicy(t, "access", "package access\n\nallow { data.roles[input.user][_] == \"admin\" }\n\nregion = data.config.region\n\nstage = data.config.stage\n")
+	writeTestDataDocument(t, "access", `{"roles": {"jane": ["admin"]}, "config": {"region": "eu-west-1", "stage": "prod"}}`)
+	payload := `{"policy": "access", "payload": {"user": "jane"}}`
+
+	// Data documents are opt-in.
+	resp, err := handleLambda(context.Background(), json.RawMessage(payload))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{}, resp.(LambdaResponse).Output)
+
+	t.Setenv("POLICY_DATA_DOCUMENTS", "true")
+	resp, err = handleLambda(context.Background(), json.RawMessage(payload))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"allow": true, "region": "eu-west-1", "stage": "prod"}, resp.(LambdaResponse).Output)
+
+	// Request data can add documents but not override the document's.
+	resp, err = handleLambda(context.Background(), json.RawMessage(`{"policy": "access", "payload": {"user": "jane"}, "data": {"teams": {}}}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"allow": true, "region": "eu-west-1", "stage": "prod"}, resp.(LambdaResponse).Output)
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy": "access", "payload": {"user": "jane"}, "data": {"roles": {"jane": []}, "config": {"stage": "canary"}}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	body := parseLambdaResponseBody(t, gwResp.Body)
+	require.Equal(t, errorCodeInvalidRequest, body.ErrorCode)
+	require.Contains(t, body.Error, "data.config, data.roles is supplied by the deployment")
+
+	writeTestDataDocument(t, "access", `["admin"]`)
+	_, err = handleLambda(context.Background(), json.RawMessage(payload))
+	require.ErrorContains(t, err, "invalid data document for access")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"opa_lambda/decisionlog"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// decisionIDHeader is the HTTP response header echoing a decision's ID.
+const decisionIDHeader = "X-Decision-Id"
+
+type decisionIDKey struct{}
+
+// withDecisionID returns a context carrying the ID of the decision being
+// evaluated, generating a new UUID unless ctx already carries one. Requests
+// evaluating several policies, such as merges and comparisons, therefore log
+// every policy under the ID of the decision they are part of.
+func withDecisionID(ctx context.Context) (context.Context, string) {
+	if id := decisionID(ctx); id != "" {
+		return ctx, id
+	}
+	id := uuid.NewString()
+	return context.WithValue(ctx, decisionIDKey{}, id), id
+}
+
+// decisionID returns the ID of the decision being evaluated under ctx, or an
+// empty string outside of an evaluation.
+func decisionID(ctx context.Context) string {
+	id, _ := ctx.Value(decisionIDKey{}).(string)
+	return id
+}
+
+// decisionLog returns a logger tagging entries with the decision ID and, when
+// the invocation is traced, the X-Ray trace ID.
+func decisionLog(ctx context.Context) *log.Entry {
+	entry := log.WithField("decision_id", decisionID(ctx))
+	if id := traceID(ctx); id != "" {
+		entry = entry.WithField("trace_id", id)
+	}
+	return entry
+}
+
+var (
+	decisionLoggerMu   sync.Mutex
+	decisionLogger     decisionlog.DecisionLogger
+	decisionLoggerInit bool
+)
+
+// sharedDecisionLogger returns the logger recording every decision, reused
+// across warm invocations so its buffer outlives a request. It is nil unless
+// POLICY_DECISION_LOG_URL is set.
+func sharedDecisionLogger() (decisionlog.DecisionLogger, error) {
+	decisionLoggerMu.Lock()
+	defer decisionLoggerMu.Unlock()
+
+	if decisionLoggerInit {
+		return decisionLogger, nil
+	}
+
+	logger, err := decisionlog.NewLoggerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	decisionLogger, decisionLoggerInit = logger, true
+	return decisionLogger, nil
+}
+
+// recordDecision queues a record of a decision with the decision logger.
+// Unlike logDecision, it records every decision, whatever LOG_SAMPLE_RATE.
+func recordDecision(ctx context.Context, logger decisionlog.DecisionLogger, req LambdaEvent, resp LambdaResponse, err error, start time.Time) {
+	record := decisionlog.Record{
+		DecisionID: decisionID(ctx),
+		Policy:     decisionPolicy(req),
+		Input:      decisionInput(req),
+		Result:     resp.Output,
+		Timestamp:  start.UTC(),
+		LatencyMS:  milliseconds(time.Since(start)),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	logger.Log(record)
+}
+
+// decisionInput returns what a decision was evaluated against: the payload,
+// or the named inputs. Each payload of a batch is a decision of its own.
+func decisionInput(req LambdaEvent) json.RawMessage {
+	if req.Payload != nil {
+		return *req.Payload
+	}
+	if req.Inputs == nil {
+		return nil
+	}
+	raw, err := json.Marshal(req.Inputs)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// flushInvocationDecisionLogs sends the records of a decision logger that
+// holds them in the container, before the invocation returns. A failure is
+// logged rather than failing the request.
+func flushInvocationDecisionLogs(ctx context.Context) {
+	decisionLoggerMu.Lock()
+	flusher, ok := decisionLogger.(decisionlog.InvocationFlusher)
+	decisionLoggerMu.Unlock()
+	if !ok {
+		return
+	}
+
+	if err := flusher.FlushInvocation(ctx); err != nil {
+		log.WithError(err).Error("Unable to flush decision logs")
+	}
+}
+
+// flushDecisionLogs waits briefly for queued decision records to be sent,
+// before the function shuts down.
+func flushDecisionLogs() {
+	decisionLoggerMu.Lock()
+	logger := decisionLogger
+	decisionLoggerMu.Unlock()
+	if logger == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	if err := logger.Flush(ctx); err != nil {
+		log.WithError(err).Warn("Unable to flush decision logs")
+	}
+}
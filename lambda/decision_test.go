@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"opa_lambda/decisionlog"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// decisionIDField matches the decision_id field of a JSON response.
+var decisionIDField = regexp.MustCompile(`,?"decision_id":"[0-9a-f-]{36}"`)
+
+// withoutDecisionIDs removes the random decision IDs from JSON responses so
+// that they can be compared verbatim.
+func withoutDecisionIDs(body string) string {
+	return decisionIDField.ReplaceAllString(body, "")
+}
+
+func TestEvaluatePolicyDecisionID(t *testing.T) {
+	payload := buildLambdaEventPayload(t)
+
+	first, err := handleLambda(context.Background(), payload)
+	require.NoError(t, err)
+	second, err := handleLambda(context.Background(), payload)
+	require.NoError(t, err)
+
+	id := first.(LambdaResponse).DecisionID
+	_, err = uuid.Parse(id)
+	require.NoError(t, err)
+	require.NotEqual(t, id, second.(LambdaResponse).DecisionID)
+
+	// Policies evaluated as part of one decision share its ID.
+	ctx, id := withDecisionID(context.Background())
+	resp, err := evaluatePolicy(ctx, LambdaEvent{Policies: []string{"example", "example"}, MergeOutputs: true, Payload: &json.RawMessage{'{', '}'}})
+	require.NoError(t, err)
+	require.Equal(t, id, resp.DecisionID)
+
+	// Failed evaluations have no decision.
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"example"}`))
+	require.Error(t, err)
+}
+
+func TestHandleLambdaAPIGatewayV2EventDecisionID(t *testing.T) {
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"example","payload":{}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.NotEmpty(t, gwResp.Headers["X-Decision-Id"])
+	require.Equal(t, gwResp.Headers["X-Decision-Id"], parseLambdaResponseBody(t, gwResp.Body).DecisionID)
+
+	csvResp := newCSVResponse(LambdaResponse{Output: []interface{}{}, DecisionID: "d-1"})
+	require.Equal(t, "d-1", csvResp.Headers["X-Decision-Id"])
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"payload":{}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.NotContains(t, gwResp.Headers, "X-Decision-Id")
+}
+
+// useDecisionLogger points the decision logger at a sink collecting the
+// records posted to it.
+func useDecisionLogger(t *testing.T) func() []decisionlog.Record {
+	var mu sync.Mutex
+	var records []decisionlog.Record
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var record decisionlog.Record
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&record))
+		mu.Lock()
+		defer mu.Unlock()
+		records = append(records, record)
+	}))
+	t.Setenv("POLICY_DECISION_LOG_URL", sink.URL)
+
+	reset := func() {
+		decisionLoggerMu.Lock()
+		decisionLogger, decisionLoggerInit = nil, false
+		decisionLoggerMu.Unlock()
+	}
+	reset()
+	t.Cleanup(func() {
+		sink.Close()
+		reset()
+	})
+
+	return func() []decisionlog.Record {
+		flushDecisionLogs()
+		mu.Lock()
+		defer mu.Unlock()
+		sent := records
+		records = nil
+		return sent
+	}
+}
+
+func TestHandleLambdaDirectEventDecisionLogger(t *testing.T) {
+	records := useDecisionLogger(t)
+	t.Setenv("LOG_SAMPLE_RATE", "0")
+	writeTestPolicy(t, "recorded", "package recorded\n\nallow = input.user == \"jane\"\n")
+
+	// Every decision is recorded, whatever the sample rate.
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"recorded","payload":{"user":"jane"}}`))
+	require.NoError(t, err)
+	sent := records()
+	require.Len(t, sent, 1)
+	require.Equal(t, resp.(LambdaResponse).DecisionID, sent[0].DecisionID)
+	require.Equal(t, "recorded", sent[0].Policy)
+	require.JSONEq(t, `{"user":"jane"}`, string(sent[0].Input))
+	require.Equal(t, map[string]interface{}{"allow": true}, sent[0].Result)
+	require.WithinDuration(t, time.Now(), sent[0].Timestamp, time.Minute)
+	require.Positive(t, sent[0].LatencyMS)
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"recorded","payloads":[{"user":"jane"},{"user":"joe"}]}`))
+	require.NoError(t, err)
+	// Each payload of a batch is a decision of its own.
+	sent = records()
+	require.Len(t, sent, 2)
+	require.ElementsMatch(t, []string{`{"user":"jane"}`, `{"user":"joe"}`}, []string{string(sent[0].Input), string(sent[1].Input)})
+
+	// Policies evaluated as part of a decision are not decisions of their own.
+	resp, err = handleLambda(context.Background(), json.RawMessage(`{"policies":["recorded","recorded"],"merge_outputs":true,"payload":{"user":"jane"}}`))
+	require.NoError(t, err)
+	sent = records()
+	require.Len(t, sent, 1)
+	require.Equal(t, resp.(LambdaResponse).DecisionID, sent[0].DecisionID)
+	require.Equal(t, "recorded,recorded", sent[0].Policy)
+	require.Equal(t, map[string]interface{}{"allow": true}, sent[0].Result)
+
+	resp, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"recorded","payload":{"user":"jane"},"data":{},"candidate_data":{"x":1}}`))
+	require.NoError(t, err)
+	sent = records()
+	require.Len(t, sent, 1)
+	require.Equal(t, resp.(LambdaResponse).DecisionID, sent[0].DecisionID)
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"missing","payload":{}}`))
+	require.Error(t, err)
+	sent = records()
+	require.Len(t, sent, 1)
+	require.Contains(t, sent[0].Error, "missing")
+	require.Nil(t, sent[0].Result)
+}
+
+// useS3DecisionLogger points the decision logger at a fake S3 bucket.
+func useS3DecisionLogger(t *testing.T) *fakeS3 {
+	client, fake := newFakeS3Client(t, map[string]string{})
+	decisionLoggerMu.Lock()
+	decisionLogger, decisionLoggerInit = decisionlog.NewS3DecisionLoggerWithClient(client, "audit", "decisions"), true
+	decisionLoggerMu.Unlock()
+	t.Cleanup(func() {
+		decisionLoggerMu.Lock()
+		decisionLogger, decisionLoggerInit = nil, false
+		decisionLoggerMu.Unlock()
+	})
+	return fake
+}
+
+func TestHandleLambdaDirectEventS3DecisionLogger(t *testing.T) {
+	fake := useS3DecisionLogger(t)
+	writeTestPolicy(t, "recorded", "package recorded\n\nallow = input.user == \"jane\"\n")
+
+	// Records are written before the invocation returns.
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"recorded","payloads":[{"user":"jane"},{"user":"joe"}]}`))
+	require.NoError(t, err)
+	require.Len(t, resp.([]LambdaResponse), 2)
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	require.Len(t, fake.objects, 1)
+	for key, body := range fake.objects {
+		require.Regexp(t, `^/audit/decisions/\d{4}/\d{2}/\d{2}/`, key)
+		require.Len(t, strings.Split(strings.TrimSpace(body), "\n"), 2)
+	}
+}
+
+func TestHandleLambdaFunctionURLStreamingS3DecisionLogger(t *testing.T) {
+	t.Setenv("RESPONSE_STREAMING", "true")
+	fake := useS3DecisionLogger(t)
+	writeTestPolicy(t, "recorded", "package recorded\n\nallow = input.user == \"jane\"\n")
+
+	raw, err := json.Marshal(events.LambdaFunctionURLRequest{
+		Version:        "2.0",
+		RawPath:        "/",
+		Headers:        map[string]string{"accept": "application/x-ndjson"},
+		Body:           `{"policy":"recorded","payloads":[{"user":"jane"},{"user":"joe"},{"user":"ann"}]}`,
+		RequestContext: functionURLRequestContext,
+	})
+	require.NoError(t, err)
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+
+	// Records are written once the stream has been sent, not when the
+	// handler returns.
+	stream := resp.(*events.LambdaFunctionURLStreamingResponse)
+	body, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+	require.Len(t, strings.Split(strings.TrimSpace(string(body)), "\n"), 3)
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	require.Len(t, fake.objects, 1)
+	for _, object := range fake.objects {
+		require.Len(t, strings.Split(strings.TrimSpace(object), "\n"), 3)
+	}
+}
@@ -0,0 +1,110 @@
+// Package decisionlog records every decision the function makes to an
+// external sink, for audits that need more than sampled application logs.
+package decisionlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is one decision as sent to a sink.
+type Record struct {
+	DecisionID string          `json:"decision_id"`
+	Policy     string          `json:"policy"`
+	Input      json.RawMessage `json:"input,omitempty"`
+	Result     interface{}     `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Timestamp  time.Time       `json:"timestamp"`
+	LatencyMS  float64         `json:"latency_ms"`
+}
+
+// DecisionLogger sends decision records to a sink.
+type DecisionLogger interface {
+	// Log queues a record to be sent. It does not wait for the sink, so it
+	// never delays a decision; records it cannot queue are dropped.
+	Log(record Record)
+
+	// Flush waits until the records queued so far have been sent, or until
+	// ctx is done.
+	Flush(ctx context.Context) error
+}
+
+// InvocationFlusher is implemented by loggers holding records in the
+// container until they are flushed. Lambda may freeze the container as soon
+// as an invocation returns, so their records are flushed before it does.
+type InvocationFlusher interface {
+	// FlushInvocation sends the records logged during the invocation.
+	FlushInvocation(ctx context.Context) error
+}
+
+// defaultBufferSize is how many records wait to be sent before new ones are
+// dropped.
+const defaultBufferSize = 1000
+
+// NewLoggerFromEnv creates the logger selected by the environment: one
+// posting records to POLICY_DECISION_LOG_URL, or one writing them to the S3
+// bucket DECISION_LOG_S3_BUCKET under DECISION_LOG_S3_PREFIX. It returns nil
+// when neither is set.
+func NewLoggerFromEnv() (DecisionLogger, error) {
+	url := strings.TrimSpace(os.Getenv("POLICY_DECISION_LOG_URL"))
+	bucket := strings.TrimSpace(os.Getenv("DECISION_LOG_S3_BUCKET"))
+	switch {
+	case url != "" && bucket != "":
+		return nil, fmt.Errorf("POLICY_DECISION_LOG_URL and DECISION_LOG_S3_BUCKET are mutually exclusive")
+	case url != "":
+		return newHTTPLoggerFromEnv(url)
+	case bucket != "":
+		return newS3DecisionLoggerFromEnv(bucket)
+	}
+	return nil, nil
+}
+
+// newHTTPLoggerFromEnv creates the logger posting records to url,
+// authenticated with POLICY_DECISION_LOG_TOKEN as a bearer token when it is
+// set, and queueing up to POLICY_DECISION_LOG_BUFFER records.
+func newHTTPLoggerFromEnv(url string) (DecisionLogger, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("invalid POLICY_DECISION_LOG_URL %q: expected an http(s):// URL", url)
+	}
+
+	size, err := positiveIntFromEnv("POLICY_DECISION_LOG_BUFFER", defaultBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	return NewHTTPLogger(url, os.Getenv("POLICY_DECISION_LOG_TOKEN"), size), nil
+}
+
+// newS3DecisionLoggerFromEnv creates the logger writing records to bucket
+// under DECISION_LOG_S3_PREFIX, in batches of up to
+// DECISION_LOG_S3_MAX_BATCH_BYTES.
+func newS3DecisionLoggerFromEnv(bucket string) (DecisionLogger, error) {
+	maxBytes, err := positiveIntFromEnv("DECISION_LOG_S3_MAX_BATCH_BYTES", defaultMaxBatchBytes)
+	if err != nil {
+		return nil, err
+	}
+	logger, err := NewS3DecisionLogger(bucket, strings.Trim(os.Getenv("DECISION_LOG_S3_PREFIX"), "/"))
+	if err != nil {
+		return nil, err
+	}
+	logger.MaxBatchBytes = maxBytes
+	return logger, nil
+}
+
+// positiveIntFromEnv reads a positive integer from the environment variable
+// name, returning fallback when it is unset.
+func positiveIntFromEnv(name string, fallback int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive integer", name, value)
+	}
+	return n, nil
+}
@@ -0,0 +1,101 @@
+package decisionlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HTTPLogger posts each record as a JSON object to an endpoint. Records are
+// queued in a bounded buffer and posted in the background, one at a time.
+type HTTPLogger struct {
+	Client *http.Client
+
+	url     string
+	token   string
+	records chan Record
+	pending sync.WaitGroup // Records queued but not yet posted.
+}
+
+// NewHTTPLogger creates a logger posting to url, with token as a bearer token
+// unless it is empty, and queueing up to size records.
+func NewHTTPLogger(url, token string, size int) *HTTPLogger {
+	l := &HTTPLogger{
+		Client:  &http.Client{Timeout: 5 * time.Second},
+		url:     url,
+		token:   token,
+		records: make(chan Record, size),
+	}
+	go l.run()
+	return l
+}
+
+// Log queues record, dropping it when the buffer is full.
+func (l *HTTPLogger) Log(record Record) {
+	l.pending.Add(1)
+	select {
+	case l.records <- record:
+	default:
+		l.pending.Done()
+		log.WithField("decision_id", record.DecisionID).Warn("Decision log buffer full, dropping record")
+	}
+}
+
+// Flush waits until every queued record has been posted, or until ctx is done.
+func (l *HTTPLogger) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		l.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("decision log records left unsent: %w", ctx.Err())
+	}
+}
+
+func (l *HTTPLogger) run() {
+	for record := range l.records {
+		if err := l.post(record); err != nil {
+			log.WithField("decision_id", record.DecisionID).WithError(err).Error("Unable to send decision log record")
+		}
+		l.pending.Done()
+	}
+}
+
+// post sends one record. Failed records are not retried, so that a sink
+// outage cannot back the buffer up.
+func (l *HTTPLogger) post(record Record) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, l.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.token != "" {
+		req.Header.Set("Authorization", "Bearer "+l.token)
+	}
+
+	resp, err := l.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
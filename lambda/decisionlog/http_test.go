@@ -0,0 +1,115 @@
+package decisionlog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sink collects the records posted to it, holding requests until released.
+type sink struct {
+	mu      sync.Mutex
+	records []Record
+	headers []http.Header
+	arrived chan struct{}
+	release chan struct{}
+}
+
+func (s *sink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.arrived <- struct{}{}
+	<-s.release
+	var record Record
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	s.headers = append(s.headers, r.Header)
+}
+
+func TestHTTPLogger(t *testing.T) {
+	s := &sink{arrived: make(chan struct{}, 10), release: make(chan struct{})}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	logger := NewHTTPLogger(server.URL, "secret", 1)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	record := func(id string) Record {
+		return Record{DecisionID: id, Policy: "auth", Input: json.RawMessage(`{"user":"jane"}`), Result: true, Timestamp: now, LatencyMS: 1.5}
+	}
+	logger.Log(record("a"))
+	<-s.arrived
+	for _, id := range []string{"b", "c"} {
+		logger.Log(record(id))
+	}
+
+	// Records are posted in the background, so Log does not wait for the
+	// sink, and neither does Flush beyond its context.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, logger.Flush(ctx), context.DeadlineExceeded)
+
+	close(s.release)
+	assert.NoError(t, logger.Flush(context.Background()))
+
+	// While a was being posted, b filled the buffer and c was dropped.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(t, []Record{record("a"), record("b")}, s.records)
+	assert.Equal(t, "Bearer secret", s.headers[0].Get("Authorization"))
+	assert.Equal(t, "application/json", s.headers[0].Get("Content-Type"))
+}
+
+func TestHTTPLoggerSinkFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"))
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	// Failed records are not retried.
+	logger := NewHTTPLogger(server.URL, "", 10)
+	logger.Log(Record{DecisionID: "a"})
+	assert.NoError(t, logger.Flush(context.Background()))
+}
+
+func TestNewLoggerFromEnv(t *testing.T) {
+	t.Setenv("POLICY_DECISION_LOG_URL", "")
+	logger, err := NewLoggerFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, logger)
+
+	t.Setenv("POLICY_DECISION_LOG_URL", "https://audit.example.com/decisions")
+	logger, err = NewLoggerFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, defaultBufferSize, cap(logger.(*HTTPLogger).records))
+
+	t.Setenv("POLICY_DECISION_LOG_BUFFER", "0")
+	_, err = NewLoggerFromEnv()
+	assert.ErrorContains(t, err, "POLICY_DECISION_LOG_BUFFER")
+
+	t.Setenv("POLICY_DECISION_LOG_URL", "audit.example.com")
+	_, err = NewLoggerFromEnv()
+	assert.ErrorContains(t, err, "expected an http(s):// URL")
+
+	t.Setenv("DECISION_LOG_S3_BUCKET", "audit")
+	_, err = NewLoggerFromEnv()
+	assert.ErrorContains(t, err, "mutually exclusive")
+
+	t.Setenv("POLICY_DECISION_LOG_URL", "")
+	t.Setenv("DECISION_LOG_S3_PREFIX", "/decisions/")
+	t.Setenv("DECISION_LOG_S3_MAX_BATCH_BYTES", "4096")
+	logger, err = NewLoggerFromEnv()
+	assert.NoError(t, err)
+	s3Logger := logger.(*S3DecisionLogger)
+	assert.Equal(t, "decisions", s3Logger.prefix)
+	assert.Equal(t, 4096, s3Logger.MaxBatchBytes)
+}
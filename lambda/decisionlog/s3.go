@@ -0,0 +1,139 @@
+package decisionlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultMaxBatchBytes is how large a batch of records grows before it is
+// written without waiting for the invocation to end.
+const defaultMaxBatchBytes = 1 << 20
+
+// S3DecisionLogger writes records in batches to S3, as objects of
+// newline-delimited JSON under prefix/YYYY/MM/DD/. Records are buffered in
+// memory until the batch reaches MaxBatchBytes or Flush is called; a batch
+// that cannot be written is logged and dropped.
+type S3DecisionLogger struct {
+	MaxBatchBytes int
+
+	client s3iface.S3API
+	bucket string
+	prefix string
+
+	mu      sync.Mutex
+	batch   bytes.Buffer
+	started time.Time      // When the first record of the batch was logged.
+	writes  sync.WaitGroup // Full batches being written in the background.
+}
+
+// NewS3DecisionLogger creates a logger writing to bucket under prefix.
+func NewS3DecisionLogger(bucket, prefix string) (*S3DecisionLogger, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))})
+	if err != nil {
+		return nil, err
+	}
+	return NewS3DecisionLoggerWithClient(s3.New(sess), bucket, prefix), nil
+}
+
+// NewS3DecisionLoggerWithClient creates a logger writing with client.
+func NewS3DecisionLoggerWithClient(client s3iface.S3API, bucket, prefix string) *S3DecisionLogger {
+	return &S3DecisionLogger{MaxBatchBytes: defaultMaxBatchBytes, client: client, bucket: bucket, prefix: prefix}
+}
+
+// Log adds record to the batch. A batch that reaches MaxBatchBytes is written
+// in the background.
+func (l *S3DecisionLogger) Log(record Record) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.WithField("decision_id", record.DecisionID).WithError(err).Error("Unable to encode decision log record")
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.batch.Len() == 0 {
+		l.started = record.Timestamp
+	}
+	l.batch.Write(line)
+	l.batch.WriteByte('\n')
+	if l.batch.Len() < l.MaxBatchBytes {
+		return
+	}
+
+	body, started := l.take()
+	l.writes.Add(1)
+	go func() {
+		defer l.writes.Done()
+		if err := l.write(context.Background(), body, started); err != nil {
+			log.WithError(err).Error("Unable to write decision log batch")
+		}
+	}()
+}
+
+// Flush writes the records logged so far, waiting for batches already being
+// written, or until ctx is done.
+func (l *S3DecisionLogger) Flush(ctx context.Context) error {
+	l.mu.Lock()
+	body, started := l.take()
+	l.mu.Unlock()
+
+	var err error
+	if len(body) > 0 {
+		err = l.write(ctx, body, started)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		l.writes.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("decision log batches left unwritten: %w", ctx.Err())
+	}
+	return err
+}
+
+// FlushInvocation writes the records logged so far, since Lambda may freeze
+// the container as soon as the invocation returns.
+func (l *S3DecisionLogger) FlushInvocation(ctx context.Context) error {
+	return l.Flush(ctx)
+}
+
+// take empties the batch, returning its contents. l.mu must be held.
+func (l *S3DecisionLogger) take() ([]byte, time.Time) {
+	body := bytes.Clone(l.batch.Bytes())
+	l.batch.Reset()
+	return body, l.started
+}
+
+// write writes a batch as an object keyed by the date its first record was
+// logged.
+func (l *S3DecisionLogger) write(ctx context.Context, body []byte, started time.Time) error {
+	started = started.UTC()
+	key := path.Join(l.prefix, started.Format("2006/01/02"), started.Format("20060102T150405Z")+"-"+uuid.NewString()+".jsonl")
+	_, err := l.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(l.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write decision log batch to s3://%s/%s: %w", l.bucket, key, err)
+	}
+	return nil
+}
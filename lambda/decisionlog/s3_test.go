@@ -0,0 +1,116 @@
+package decisionlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 keeps the objects put to it by key.
+type fakeS3 struct {
+	s3iface.S3API
+
+	mu      sync.Mutex
+	objects map[string][]string // Lines of each object.
+	err     error
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	body, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	f.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)] = lines
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+var batchKey = regexp.MustCompile(`^audit/decisions/2024/05/01/20240501T235959Z-[0-9a-f-]{36}\.jsonl$`)
+
+func TestS3DecisionLogger(t *testing.T) {
+	client := &fakeS3{objects: make(map[string][]string)}
+	logger := NewS3DecisionLoggerWithClient(client, "audit", "decisions")
+	at := time.Date(2024, 5, 1, 23, 59, 59, 0, time.UTC)
+
+	// Records wait for a flush.
+	logger.Log(Record{DecisionID: "a", Policy: "auth", Result: true, Timestamp: at})
+	logger.Log(Record{DecisionID: "b", Policy: "auth", Result: false, Timestamp: at.Add(time.Second)})
+	assert.Empty(t, client.keys())
+
+	// A batch is keyed by the date of its first record.
+	assert.NoError(t, logger.FlushInvocation(context.Background()))
+	keys := client.keys()
+	assert.Len(t, keys, 1)
+	assert.Regexp(t, batchKey, keys[0])
+	assert.Equal(t, []string{
+		`{"decision_id":"a","policy":"auth","result":true,"timestamp":"2024-05-01T23:59:59Z","latency_ms":0}`,
+		`{"decision_id":"b","policy":"auth","result":false,"timestamp":"2024-05-02T00:00:00Z","latency_ms":0}`,
+	}, client.objects[keys[0]])
+
+	// Flushing nothing writes nothing.
+	assert.NoError(t, logger.Flush(context.Background()))
+	assert.Len(t, client.keys(), 1)
+}
+
+func TestS3DecisionLoggerMaxBatchBytes(t *testing.T) {
+	client := &fakeS3{objects: make(map[string][]string)}
+	logger := NewS3DecisionLoggerWithClient(client, "audit", "")
+	logger.MaxBatchBytes = 200
+
+	for i := 0; i < 5; i++ {
+		logger.Log(Record{DecisionID: "decision", Policy: "auth", Timestamp: time.Now()})
+	}
+	// Full batches are written without waiting for a flush; Flush waits for
+	// them and writes the rest.
+	assert.NoError(t, logger.Flush(context.Background()))
+	lines := 0
+	for _, key := range client.keys() {
+		lines += len(client.objects[key])
+	}
+	assert.Equal(t, 5, lines)
+	assert.Greater(t, len(client.keys()), 1)
+}
+
+func TestS3DecisionLoggerWriteFailure(t *testing.T) {
+	client := &fakeS3{objects: make(map[string][]string), err: errors.New("access denied")}
+	logger := NewS3DecisionLoggerWithClient(client, "audit", "decisions")
+
+	logger.Log(Record{DecisionID: "a", Timestamp: time.Now()})
+	assert.ErrorContains(t, logger.Flush(context.Background()), "access denied")
+
+	// The failed batch is dropped rather than retried.
+	client.err = nil
+	assert.NoError(t, logger.Flush(context.Background()))
+	assert.Empty(t, client.keys())
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// deriveFunctions lists the functions of the derive transform operation,
+// each computing an object or array of fields from a string.
+var deriveFunctions = map[string]func(value, separator string) (interface{}, error){
+	"arn":   deriveARN,
+	"email": deriveEmail,
+	"url":   deriveURL,
+	"split": deriveSplit,
+}
+
+// deriveValue computes the fields function derives from value.
+func deriveValue(value interface{}, function, separator string) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot derive %s fields from %T", function, value)
+	}
+	return deriveFunctions[function](s, separator)
+}
+
+// deriveARN splits an ARN such as arn:aws:s3:::bucket/key into its parts.
+// The resource is further split into resource_type and resource_id when it
+// has the form type/id or type:id.
+func deriveARN(value, _ string) (interface{}, error) {
+	parts := strings.SplitN(value, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[1] == "" || parts[2] == "" || parts[5] == "" {
+		return nil, fmt.Errorf("%q is not an ARN", value)
+	}
+	fields := map[string]interface{}{
+		"partition": parts[1],
+		"service":   parts[2],
+		"region":    parts[3],
+		"account":   parts[4],
+		"resource":  parts[5],
+	}
+	if i := strings.IndexAny(parts[5], "/:"); i > 0 {
+		fields["resource_type"] = parts[5][:i]
+		fields["resource_id"] = parts[5][i+1:]
+	}
+	return fields, nil
+}
+
+// deriveEmail splits an email address into its local part and its domain,
+// lowercased since domains are case-insensitive.
+func deriveEmail(value, _ string) (interface{}, error) {
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Name != "" {
+		return nil, fmt.Errorf("%q is not an email address", value)
+	}
+	local, domain, _ := strings.Cut(address.Address, "@")
+	return map[string]interface{}{"local": local, "domain": strings.ToLower(domain)}, nil
+}
+
+// deriveURL splits an absolute URL into its scheme, host, port, path and
+// query parameters, taking the first value of repeated parameters.
+func deriveURL(value, _ string) (interface{}, error) {
+	u, err := url.Parse(value)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an absolute URL", value)
+	}
+	query := make(map[string]interface{})
+	for name, values := range u.Query() {
+		query[name] = values[0]
+	}
+	return map[string]interface{}{
+		"scheme": u.Scheme,
+		"host":   strings.ToLower(u.Hostname()),
+		"port":   u.Port(),
+		"path":   u.Path,
+		"query":  query,
+	}, nil
+}
+
+// deriveSplit splits value around every instance of separator.
+func deriveSplit(value, separator string) (interface{}, error) {
+	if separator == "" {
+		return nil, errors.New("separator is required for split")
+	}
+	parts := strings.Split(value, separator)
+	array := make([]interface{}, len(parts))
+	for i, part := range parts {
+		array[i] = part
+	}
+	return array, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInputTransformApplyDerive(t *testing.T) {
+	transform := inputTransform{Operations: []transformOperation{
+		{Op: "derive", From: "resource", Path: "derived.resource", Function: "arn"},
+		{Op: "derive", From: "role", Path: "derived.role", Function: "arn"},
+		{Op: "derive", From: "user", Path: "derived.user", Function: "email"},
+		{Op: "derive", From: "referer", Path: "derived.referer", Function: "url"},
+		{Op: "derive", From: "scope", Path: "derived.scopes", Function: "split", Separator: " "},
+		{Op: "derive", From: "absent", Path: "derived.absent", Function: "arn"},
+	}}
+
+	out, err := transform.apply(json.RawMessage(`{
+		"resource": "arn:aws:s3:::reports/2024/q1.csv",
+		"role": "arn:aws:iam::123456789012:role/admin",
+		"user": "Jane.Doe@Example.COM",
+		"referer": "https://App.example.com:8443/orders?id=7&id=8",
+		"scope": "read write"
+	}`))
+	require.NoError(t, err)
+
+	var input map[string]interface{}
+	require.NoError(t, json.Unmarshal(out, &input))
+	require.Equal(t, map[string]interface{}{
+		"resource": map[string]interface{}{
+			"partition": "aws", "service": "s3", "region": "", "account": "",
+			"resource": "reports/2024/q1.csv", "resource_type": "reports", "resource_id": "2024/q1.csv",
+		},
+		"role": map[string]interface{}{
+			"partition": "aws", "service": "iam", "region": "", "account": "123456789012",
+			"resource": "role/admin", "resource_type": "role", "resource_id": "admin",
+		},
+		"user": map[string]interface{}{"local": "Jane.Doe", "domain": "example.com"},
+		"referer": map[string]interface{}{
+			"scheme": "https", "host": "app.example.com", "port": "8443", "path": "/orders",
+			"query": map[string]interface{}{"id": "7"},
+		},
+		"scopes": []interface{}{"read", "write"},
+	}, input["derived"])
+	// The source fields are kept.
+	require.Equal(t, "read write", input["scope"])
+}
+
+func TestInputTransformApplyDeriveErrors(t *testing.T) {
+	for function, value := range map[string]string{
+		"arn":   `"arn:aws:s3"`,
+		"email": `"Jane <jane@example.com>"`,
+		"url":   `"/relative/path"`,
+	} {
+		transform := inputTransform{Operations: []transformOperation{{Op: "derive", From: "value", Path: "derived", Function: function}}}
+		_, err := transform.apply(json.RawMessage(`{"value": ` + value + `}`))
+		require.ErrorIs(t, err, errInputTransform, function)
+	}
+
+	transform := inputTransform{Operations: []transformOperation{{Op: "derive", From: "value", Path: "derived", Function: "arn"}}}
+	_, err := transform.apply(json.RawMessage(`{"value": 42}`))
+	require.ErrorContains(t, err, "cannot derive arn fields from json.Number")
+}
+
+func TestTransformOperationValidateDerive(t *testing.T) {
+	require.NoError(t, transformOperation{Op: "derive", From: "a", Path: "b", Function: "arn"}.validate())
+	require.ErrorContains(t, transformOperation{Op: "derive", Path: "b", Function: "arn"}.validate(), "from is required")
+	require.ErrorContains(t, transformOperation{Op: "derive", From: "a", Path: "b", Function: "jwt"}.validate(), `unsupported derive function: "jwt"`)
+	require.ErrorContains(t, transformOperation{Op: "derive", From: "a", Path: "b", Function: "split"}.validate(), "separator is required")
+}
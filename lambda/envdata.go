@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// environmentPlaceholder is replaced with ENV in POLICY_DATA_OVERLAY_URI, as
+// in s3://reference-data/overlays/{env}.json.
+const environmentPlaceholder = "{env}"
+
+// defaultEnvironmentDataRefresh is how long a base or overlay document is
+// used before it is read again.
+const defaultEnvironmentDataRefresh = time.Minute
+
+// environmentDataDocument is a base or overlay document as last read.
+type environmentDataDocument struct {
+	data    map[string]interface{}
+	fetched time.Time
+	version uint64 // Numbers the documents read, so that no two share one.
+}
+
+// mergedEnvironmentData is the reference data as last merged, along with
+// the documents it was merged from.
+type mergedEnvironmentData struct {
+	base, overlay *environmentDataDocument
+	data          map[string]interface{}
+}
+
+var (
+	environmentDataMu     sync.Mutex
+	environmentDataCache  = make(map[string]*environmentDataDocument) // Keyed by URI.
+	environmentDataReads  uint64
+	environmentDataMerged *mergedEnvironmentData
+)
+
+// environmentDataClient reads documents from http(s):// URIs.
+var environmentDataClient = &http.Client{Timeout: 15 * time.Second}
+
+// newEnvironmentDataS3Client creates the S3 client used to read documents
+// from s3:// URIs.
+var newEnvironmentDataS3Client = newBatchS3Client
+
+// environmentData returns the deployment's reference data: the base document
+// at POLICY_BASE_DATA_URI with the overlay at POLICY_DATA_OVERLAY_URI, for
+// the environment named by ENV, deep-merged over it. The documents are only
+// merged again once either is read again, and the version names the pair
+// merged. It returns nil when neither variable is set.
+func environmentData(ctx context.Context) (map[string]interface{}, string, error) {
+	baseURI := strings.TrimSpace(os.Getenv("POLICY_BASE_DATA_URI"))
+	overlayURI, err := environmentOverlayURI()
+	if err != nil || (baseURI == "" && overlayURI == "") {
+		return nil, "", err
+	}
+
+	refresh, err := secondsFromEnv("POLICY_DATA_REFRESH_SECONDS", defaultEnvironmentDataRefresh)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var documents [2]*environmentDataDocument
+	for i, uri := range []string{baseURI, overlayURI} {
+		if uri == "" {
+			continue
+		}
+		if documents[i], err = cachedEnvironmentData(ctx, uri, refresh); err != nil {
+			return nil, "", err
+		}
+	}
+	base, overlay := documents[0], documents[1]
+	version := fmt.Sprintf("%d.%d", base.versionNumber(), overlay.versionNumber())
+
+	environmentDataMu.Lock()
+	defer environmentDataMu.Unlock()
+
+	if cached := environmentDataMerged; cached != nil && cached.base == base && cached.overlay == overlay {
+		return cached.data, version, nil
+	}
+	merged := make(map[string]interface{})
+	for _, document := range documents {
+		if document == nil {
+			continue
+		}
+		if merged, err = mergeObjects(merged, document.data, mergeOverride, ""); err != nil {
+			return nil, "", err
+		}
+	}
+	environmentDataMerged = &mergedEnvironmentData{base: base, overlay: overlay, data: merged}
+	return merged, version, nil
+}
+
+// versionNumber returns the document's version, or zero for no document.
+func (d *environmentDataDocument) versionNumber() uint64 {
+	if d == nil {
+		return 0
+	}
+	return d.version
+}
+
+// environmentOverlayURI returns POLICY_DATA_OVERLAY_URI with ENV in place of
+// its {env} placeholder.
+func environmentOverlayURI() (string, error) {
+	uri := strings.TrimSpace(os.Getenv("POLICY_DATA_OVERLAY_URI"))
+	if !strings.Contains(uri, environmentPlaceholder) {
+		return uri, nil
+	}
+
+	environment := strings.TrimSpace(os.Getenv("ENV"))
+	if environment == "" {
+		return "", fmt.Errorf("POLICY_DATA_OVERLAY_URI contains %s but ENV is not set", environmentPlaceholder)
+	}
+	return strings.ReplaceAll(uri, environmentPlaceholder, url.PathEscape(environment)), nil
+}
+
+// cachedEnvironmentData returns the document at uri, read again once it is
+// older than refresh. Each document is cached on its own, so the base and
+// overlay are refreshed independently. When a refresh fails, the copy read
+// last keeps being used.
+func cachedEnvironmentData(ctx context.Context, uri string, refresh time.Duration) (*environmentDataDocument, error) {
+	environmentDataMu.Lock()
+	defer environmentDataMu.Unlock()
+
+	cached := environmentDataCache[uri]
+	if cached != nil && time.Since(cached.fetched) < refresh {
+		return cached, nil
+	}
+
+	data, err := fetchEnvironmentData(ctx, uri)
+	if err != nil {
+		if cached == nil {
+			return nil, err
+		}
+		log.Warnf("using data document read %s ago: %v", time.Since(cached.fetched).Round(time.Second), err)
+		return cached, nil
+	}
+
+	environmentDataReads++
+	document := &environmentDataDocument{data: data, fetched: time.Now(), version: environmentDataReads}
+	environmentDataCache[uri] = document
+	return document, nil
+}
+
+// fetchEnvironmentData reads the document at an s3://bucket/key or
+// http(s):// URI.
+func fetchEnvironmentData(ctx context.Context, uri string) (map[string]interface{}, error) {
+	var body []byte
+	var err error
+	switch {
+	case strings.HasPrefix(uri, "s3://"):
+		body, err = fetchEnvironmentDataS3(ctx, uri)
+	case strings.HasPrefix(uri, "http://"), strings.HasPrefix(uri, "https://"):
+		body, err = fetchEnvironmentDataHTTP(ctx, uri)
+	default:
+		return nil, fmt.Errorf("invalid data document URI %q: expected an s3:// or https:// URI", uri)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read data document from %s: %w", uri, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var data map[string]interface{}
+	if err := decoder.Decode(&data); err != nil || data == nil {
+		return nil, fmt.Errorf("data document in %s must be a JSON object", uri)
+	}
+	return data, nil
+}
+
+// fetchEnvironmentDataS3 reads the object at an s3://bucket/key URI.
+func fetchEnvironmentDataS3(ctx context.Context, uri string) ([]byte, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(uri, "s3://"), "/")
+	if !ok || bucket == "" || key == "" {
+		return nil, errors.New("expected s3://bucket/key")
+	}
+
+	client, err := newEnvironmentDataS3Client()
+	if err != nil {
+		return nil, err
+	}
+	object, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Body.Close()
+	return io.ReadAll(object.Body)
+}
+
+// fetchEnvironmentDataHTTP reads the document at an http(s):// URL.
+func fetchEnvironmentDataHTTP(ctx context.Context, uri string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := environmentDataClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// withEnvironmentData deep-merges the data stored next to a policy over the
+// deployment's reference data, so that the policy's own data takes
+// precedence over both the base and the overlay. Data is returned unchanged
+// when no reference data is configured. It returns the version of the
+// reference data along with the data.
+func withEnvironmentData(ctx context.Context, data map[string]interface{}) (map[string]interface{}, string, error) {
+	environment, version, err := environmentData(ctx)
+	if err != nil || environment == nil {
+		return data, "", err
+	}
+	if len(data) == 0 {
+		return environment, version, nil
+	}
+	merged, err := mergeObjects(environment, data, mergeOverride, "")
+	return merged, version, err
+}
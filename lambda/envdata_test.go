@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/require"
+)
+
+const quotaPolicy = `package quota
+
+result = {"max": data.limits.max, "min": data.limits.min, "regions": data.regions, "tier": data.tier}
+`
+
+// useEnvironmentData serves base documents from a fake S3 bucket and overlays
+// from an HTTP server, and forgets the documents cached by earlier tests.
+func useEnvironmentData(t *testing.T, objects, overlays map[string]string) (*fakeS3, *overlayServer) {
+	client, fake := newFakeS3Client(t, objects)
+	newClient := newEnvironmentDataS3Client
+	newEnvironmentDataS3Client = func() (s3iface.S3API, error) { return client, nil }
+
+	overlay := &overlayServer{documents: overlays}
+	server := httptest.NewServer(overlay)
+	overlay.url = server.URL
+
+	resetCache := func() {
+		environmentDataMu.Lock()
+		environmentDataCache = make(map[string]*environmentDataDocument)
+		environmentDataMu.Unlock()
+	}
+	resetCache()
+	t.Cleanup(func() {
+		server.Close()
+		newEnvironmentDataS3Client = newClient
+		resetCache()
+	})
+	return fake, overlay
+}
+
+// overlayServer serves overlay documents by path.
+type overlayServer struct {
+	mu        sync.Mutex
+	url       string
+	documents map[string]string
+}
+
+func (s *overlayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	document, ok := s.documents[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	_, _ = w.Write([]byte(document))
+}
+
+func (s *overlayServer) set(path, document string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.documents[path] = document
+}
+
+func evaluateQuota(t *testing.T, event string) (map[string]interface{}, error) {
+	resp, err := handleLambda(context.Background(), json.RawMessage(event))
+	if err != nil {
+		return nil, err
+	}
+	return resp.(LambdaResponse).Output.(map[string]interface{})["result"].(map[string]interface{}), nil
+}
+
+func TestEnvironmentDataOverlay(t *testing.T) {
+	writeTestPolicy(t, "quota", quotaPolicy)
+	_, overlays := useEnvironmentData(t,
+		map[string]string{"/reference/base.json": `{"limits": {"max": 10, "min": 1}, "regions": ["us", "eu"], "tier": "basic"}`},
+		map[string]string{
+			"/overlays/prod.json": `{"limits": {"max": 1000}, "regions": ["us"]}`,
+			"/overlays/dev.json":  `{"tier": "dev"}`,
+		})
+	t.Setenv("POLICY_BASE_DATA_URI", "s3://reference/base.json")
+	t.Setenv("POLICY_DATA_OVERLAY_URI", overlays.url+"/overlays/{env}.json")
+
+	// Objects are merged key by key; arrays and other values are replaced.
+	t.Setenv("ENV", "prod")
+	result, err := evaluateQuota(t, `{"policy":"quota","payload":{}}`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"max": json.Number("1000"), "min": json.Number("1"), "regions": []interface{}{"us"}, "tier": "basic"}, result)
+
+	t.Setenv("ENV", "dev")
+	result, err = evaluateQuota(t, `{"policy":"quota","payload":{}}`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"max": json.Number("10"), "min": json.Number("1"), "regions": []interface{}{"us", "eu"}, "tier": "dev"}, result)
+
+	// Request data cannot override either.
+	_, err = evaluateQuota(t, `{"policy":"quota","payload":{},"data":{"limits":{"min":5}}}`)
+	require.ErrorIs(t, err, errReservedData)
+	require.ErrorContains(t, err, "data.limits is supplied by the deployment")
+
+	t.Setenv("ENV", "")
+	_, err = evaluateQuota(t, `{"policy":"quota","payload":{}}`)
+	require.ErrorContains(t, err, "ENV is not set")
+
+	t.Setenv("ENV", "stage")
+	_, err = evaluateQuota(t, `{"policy":"quota","payload":{}}`)
+	require.ErrorContains(t, err, "unable to read data document from "+overlays.url+"/overlays/stage.json")
+}
+
+func TestEnvironmentDataCaching(t *testing.T) {
+	writeTestPolicy(t, "quota", quotaPolicy)
+	base, overlays := useEnvironmentData(t,
+		map[string]string{"/reference/base.json": `{"limits": {"max": 10, "min": 1}, "regions": [], "tier": "basic"}`},
+		map[string]string{"/overlays/prod.json": `{"limits": {"max": 1000}}`})
+	t.Setenv("POLICY_BASE_DATA_URI", "s3://reference/base.json")
+	t.Setenv("POLICY_DATA_OVERLAY_URI", overlays.url+"/overlays/prod.json")
+
+	result, err := evaluateQuota(t, `{"policy":"quota","payload":{}}`)
+	require.NoError(t, err)
+	require.Equal(t, json.Number("1000"), result["max"])
+
+	// Documents are cached until they are due for a refresh.
+	overlays.set("/overlays/prod.json", `{"limits": {"max": 2000}}`)
+	result, err = evaluateQuota(t, `{"policy":"quota","payload":{}}`)
+	require.NoError(t, err)
+	require.Equal(t, json.Number("1000"), result["max"])
+
+	// So is the merged document, and the version the evaluator keeps the
+	// store built from it by.
+	merged, version, err := environmentData(context.Background())
+	require.NoError(t, err)
+	again, againVersion, err := environmentData(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, version, againVersion)
+	require.Equal(t, reflect.ValueOf(merged).Pointer(), reflect.ValueOf(again).Pointer())
+
+	// Each document is refreshed on its own: the base keeps its last copy
+	// when it cannot be read, while the overlay picks up its change.
+	t.Setenv("POLICY_DATA_REFRESH_SECONDS", "0")
+	base.mu.Lock()
+	delete(base.objects, "/reference/base.json")
+	base.mu.Unlock()
+	result, err = evaluateQuota(t, `{"policy":"quota","payload":{}}`)
+	require.NoError(t, err)
+	require.Equal(t, json.Number("2000"), result["max"])
+	require.Equal(t, json.Number("1"), result["min"])
+	_, refreshed, err := environmentData(context.Background())
+	require.NoError(t, err)
+	require.NotEqual(t, version, refreshed)
+
+	// Without a copy read before, a failed read fails the evaluation.
+	t.Setenv("POLICY_BASE_DATA_URI", "s3://reference/missing.json")
+	_, err = evaluateQuota(t, `{"policy":"quota","payload":{}}`)
+	require.ErrorContains(t, err, "unable to read data document from s3://reference/missing.json")
+
+	t.Setenv("POLICY_BASE_DATA_URI", "reference/base.json")
+	_, err = evaluateQuota(t, `{"policy":"quota","payload":{}}`)
+	require.ErrorContains(t, err, "expected an s3:// or https:// URI")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// errUnknownFields is returned under STRICT_ENVELOPE for envelopes with
+// top-level fields LambdaEvent does not define.
+var errUnknownFields = errors.New("unknown envelope fields")
+
+// decodeEnvelope decodes a request envelope that must hold exactly one JSON
+// document. Unknown top-level fields are ignored unless STRICT_ENVELOPE is
+// set, which rejects them so that typos such as "polciy" are reported as
+// such instead of as a missing policy.
+func decodeEnvelope(body []byte, req *LambdaEvent) error {
+	strict, err := boolFromEnv("STRICT_ENVELOPE", false)
+	if err != nil {
+		return err
+	}
+	if !strict {
+		return decodeJSONBody(body, req)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decodeJSON(decoder, req); err != nil {
+		// The decoder stops at the first unknown field; name them all.
+		if fields := unknownEnvelopeFields(body); len(fields) > 0 {
+			return fmt.Errorf("%w: %s", errUnknownFields, strings.Join(fields, ", "))
+		}
+		return err
+	}
+	return nil
+}
+
+// unknownEnvelopeFields returns the sorted top-level fields of body that do
+// not name a LambdaEvent field. Like encoding/json, names match regardless of
+// case.
+func unknownEnvelopeFields(body []byte) []string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	envelope := reflect.TypeOf(LambdaEvent{})
+	for i := 0; i < envelope.NumField(); i++ {
+		name, _, _ := strings.Cut(envelope.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			known[strings.ToLower(name)] = true
+		}
+	}
+
+	var unknown []string
+	for name := range fields {
+		if !known[strings.ToLower(name)] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
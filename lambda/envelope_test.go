@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleLambdaDirectEventStrictEnvelope(t *testing.T) {
+	event := json.RawMessage(`{"polciy":"example","Payload":{},"metadata":{"client":"web"}}`)
+
+	// Unknown fields are ignored by default, leaving the policy unset.
+	_, err := handleLambda(context.Background(), event)
+	require.ErrorContains(t, err, "policy is required")
+
+	t.Setenv("STRICT_ENVELOPE", "true")
+	_, err = handleLambda(context.Background(), event)
+	require.ErrorIs(t, err, errUnknownFields)
+	require.ErrorContains(t, err, "unknown envelope fields: metadata, polciy")
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"polciy":"example","payloads":[{}]}`))
+	require.ErrorIs(t, err, errUnknownFields)
+
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"example","payload":{"membership":{"user":{"login":"jane","mail":"jane@example.com"}}}}`))
+	require.NoError(t, err)
+	assertExampleOutput(t, resp.(LambdaResponse).Output)
+}
+
+func TestHandleLambdaAPIGatewayV2EventStrictEnvelope(t *testing.T) {
+	t.Setenv("STRICT_ENVELOPE", "true")
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"example","payload":{},"metadata":{}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "unknown envelope fields: metadata")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"opa_lambda/policyevaluator"
+	"opa_lambda/policyloader"
+)
+
+// Error codes returned as error_code next to the message of a failed request,
+// so that clients can branch on the kind of failure rather than on its text.
+const (
+	errorCodePolicyRequired    = "POLICY_REQUIRED"     // The request names no policy.
+	errorCodePolicyNotFound    = "POLICY_NOT_FOUND"    // The policy does not exist in the backend.
+	errorCodePolicyNotAllowed  = "POLICY_NOT_ALLOWED"  // The policy is outside POLICY_ALLOWLIST.
+	errorCodeInvalidPayload    = "INVALID_PAYLOAD"     // The body or payload cannot be decoded or is rejected.
+	errorCodeInvalidRequest    = "INVALID_REQUEST"     // The request's fields or options are invalid together.
+	errorCodeNotAcceptable     = "NOT_ACCEPTABLE"      // No supported response format is acceptable.
+	errorCodeCompileError      = "COMPILE_ERROR"       // The policy fails to parse or compile.
+	errorCodeOutputConflict    = "OUTPUT_CONFLICT"     // Merged policy outputs conflict or are not objects.
+	errorCodeLoadTimeout       = "POLICY_LOAD_TIMEOUT" // Loading the policy timed out.
+	errorCodeEvaluationTimeout = "EVALUATION_TIMEOUT"  // Evaluating the policy timed out.
+	errorCodeEvaluationError   = "EVALUATION_ERROR"    // Any other failure.
+)
+
+var (
+	// errPolicyRequired is returned for requests that name no policy.
+	errPolicyRequired = errors.New("policy is required")
+	// errPayloadRequired is returned for requests without a payload.
+	errPayloadRequired = errors.New("payload is required")
+)
+
+// requestError is an error classified with the code returned to clients and
+// the HTTP status the request is answered with.
+type requestError struct {
+	code   string
+	status int
+	err    error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// invalidPayloadError classifies err, a failure to decode a request, as
+// INVALID_PAYLOAD.
+func invalidPayloadError(err error) error {
+	return &requestError{code: errorCodeInvalidPayload, status: http.StatusBadRequest, err: err}
+}
+
+// invalidRequestError returns an INVALID_REQUEST error with the formatted
+// message.
+func invalidRequestError(format string, args ...interface{}) error {
+	return &requestError{code: errorCodeInvalidRequest, status: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+// classifyError returns the code and HTTP status of err: those it was
+// classified with or, for the errors of the loaders, the evaluator and the
+// request checks, those they map to. Anything else is an EVALUATION_ERROR
+// answered with 500.
+func classifyError(err error) *requestError {
+	var classified *requestError
+	if errors.As(err, &classified) {
+		return classified
+	}
+
+	code, status := errorCodeEvaluationError, http.StatusInternalServerError
+	var tooLong *policyloader.URLTooLongError
+	var compileErr *policyevaluator.CompileError
+	switch {
+	case errors.Is(err, errPolicyRequired):
+		code, status = errorCodePolicyRequired, http.StatusBadRequest
+	case errors.Is(err, policyloader.ErrPolicyNotFound):
+		code, status = errorCodePolicyNotFound, http.StatusNotFound
+	case errors.Is(err, errPolicyNotAllowed):
+		code, status = errorCodePolicyNotAllowed, http.StatusForbidden
+	case errors.Is(err, errPayloadRequired), errors.Is(err, errInvalidPolicySelection),
+		errors.Is(err, policyevaluator.ErrInputSchemaMismatch), errors.Is(err, errInputTransform),
+		errors.Is(err, errEmptyPayload), errors.Is(err, errInputTooComplex), errors.Is(err, errPreviousDecision):
+		code, status = errorCodeInvalidPayload, http.StatusBadRequest
+	case errors.Is(err, errBatchTooLarge), errors.Is(err, policyevaluator.ErrInvalidMockData),
+		errors.Is(err, errRouteConflict), errors.Is(err, errInvalidQuery), errors.Is(err, errSeedNotAllowed),
+		errors.Is(err, errMockDataNotAllowed), errors.Is(err, errReservedData), errors.Is(err, policyevaluator.ErrReservedData),
+		errors.As(err, &tooLong):
+		code, status = errorCodeInvalidRequest, http.StatusBadRequest
+	case errors.Is(err, errNotAcceptable), errors.Is(err, errNotTabular):
+		code, status = errorCodeNotAcceptable, http.StatusNotAcceptable
+	case errors.As(err, &compileErr):
+		code, status = errorCodeCompileError, http.StatusUnprocessableEntity
+	case errors.Is(err, errMergeConflict), errors.Is(err, errOutputNotObject):
+		code, status = errorCodeOutputConflict, http.StatusConflict
+	case errors.Is(err, policyevaluator.ErrEvaluationTimeout):
+		code, status = errorCodeEvaluationTimeout, http.StatusGatewayTimeout
+	case errors.Is(err, policyevaluator.ErrLoadTimeout):
+		code, status = errorCodeLoadTimeout, http.StatusServiceUnavailable
+	}
+	return &requestError{code: code, status: status, err: err}
+}
+
+// errorResponse reports err in a response, with its message and code.
+func errorResponse(err error) LambdaResponse {
+	return LambdaResponse{Error: err.Error(), ErrorCode: classifyError(err).code}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"opa_lambda/policyevaluator"
+	"opa_lambda/policyloader"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		code   string
+		status int
+	}{
+		{errPolicyRequired, errorCodePolicyRequired, http.StatusBadRequest},
+		{&policyloader.FileNotFoundError{Key: "missing"}, errorCodePolicyNotFound, http.StatusNotFound},
+		{fmt.Errorf("%w: admin", errPolicyNotAllowed), errorCodePolicyNotAllowed, http.StatusForbidden},
+		{errPayloadRequired, errorCodeInvalidPayload, http.StatusBadRequest},
+		{invalidPayloadError(errors.New("unable to parse")), errorCodeInvalidPayload, http.StatusBadRequest},
+		{invalidRequestError("%s and %s are mutually exclusive", "a", "b"), errorCodeInvalidRequest, http.StatusBadRequest},
+		{fmt.Errorf("%w: supported media types are text/csv", errNotAcceptable), errorCodeNotAcceptable, http.StatusNotAcceptable},
+		{fmt.Errorf("policy a: %w", &policyevaluator.CompileError{Policy: "a"}), errorCodeCompileError, http.StatusUnprocessableEntity},
+		{errMergeConflict, errorCodeOutputConflict, http.StatusConflict},
+		{fmt.Errorf("policy a: %w", errOutputNotObject), errorCodeOutputConflict, http.StatusConflict},
+		{policyevaluator.ErrLoadTimeout, errorCodeLoadTimeout, http.StatusServiceUnavailable},
+		{policyevaluator.ErrEvaluationTimeout, errorCodeEvaluationTimeout, http.StatusGatewayTimeout},
+		{errors.New("eval_conflict_error"), errorCodeEvaluationError, http.StatusInternalServerError},
+	} {
+		classified := classifyError(tc.err)
+		require.Equal(t, tc.code, classified.code, tc.err.Error())
+		require.Equal(t, tc.status, classified.status, tc.err.Error())
+	}
+
+	// Classified errors keep their message and what they wrap.
+	err := fmt.Errorf("batch item: %w", invalidPayloadError(errEmptyPayload))
+	require.Equal(t, errorCodeInvalidPayload, classifyError(err).code)
+	require.ErrorIs(t, err, errEmptyPayload)
+	require.Equal(t, "batch item: payload must not be an empty object", err.Error())
+}
+
+func TestHandleLambdaAPIGatewayV2EventErrorCodes(t *testing.T) {
+	writeTestPolicy(t, "conflicting", "package conflicting\n\nallow = true { true }\nallow = false { true }\n")
+	writeTestPolicy(t, "broken", "package broken\n\nallow {\n    input.user ==\n}\n")
+
+	for _, tc := range []struct {
+		body   string
+		code   string
+		status int
+	}{
+		{`{"payload":{}}`, errorCodePolicyRequired, http.StatusBadRequest},
+		{`{"policy":"missing","payload":{}}`, errorCodePolicyNotFound, http.StatusNotFound},
+		{`{"policy":"example"`, errorCodeInvalidPayload, http.StatusBadRequest},
+		{`{"policy":"example","payload":{},"format":"xml"}`, errorCodeInvalidRequest, http.StatusBadRequest},
+		{`{"policy":"conflicting","payload":{}}`, errorCodeEvaluationError, http.StatusInternalServerError},
+		{`{"policy":"broken","payload":{}}`, errorCodeCompileError, http.StatusUnprocessableEntity},
+	} {
+		gwResp := invokeAPIGatewayV2(t, nil, tc.body)
+		require.Equal(t, tc.status, gwResp.StatusCode, tc.body)
+		resp := parseLambdaResponseBody(t, gwResp.Body)
+		require.Equal(t, tc.code, resp.ErrorCode, tc.body)
+		require.NotEmpty(t, resp.Error, tc.body)
+	}
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"broken","payload":{}}`)
+	require.Equal(t, "policy broken failed to compile: broken.rego:5: rego_parse_error: unexpected } token", parseLambdaResponseBody(t, gwResp.Body).Error)
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"example","payload":{}}`)
+	require.NotContains(t, gwResp.Body, "error_code")
+}
+
+func TestHandleLambdaDirectEventClientErrorCodes(t *testing.T) {
+	writeTestPolicy(t, "mergebase", "package mergebase\n\nallow = false\n")
+	writeTestPolicy(t, "mergeoverride", "package mergeoverride\n\nallow = true\n")
+	writeTestPolicy(t, "mergescalar", "package unrelated\n\nallow = true\n")
+
+	for _, tc := range []struct {
+		body   string
+		code   string
+		status int
+	}{
+		{`{"payload":{}}`, errorCodePolicyRequired, http.StatusBadRequest},
+		{`{"policy":"missing","payload":{}}`, errorCodePolicyNotFound, http.StatusNotFound},
+		{`{"policy":"example"}`, errorCodeInvalidPayload, http.StatusBadRequest},
+		{`{"action":"explode","policy":"example"}`, errorCodeInvalidRequest, http.StatusBadRequest},
+		{`{"policy":"example","payload":{"a":1},"data":5}`, errorCodeInvalidRequest, http.StatusBadRequest},
+		{`{"policy":"example","payload":{"a":1},"data":{},"candidate_data":[1]}`, errorCodeInvalidRequest, http.StatusBadRequest},
+		{`{"policy":"example","policies":["mergebase"],"payload":{"a":1}}`, errorCodeInvalidRequest, http.StatusBadRequest},
+		{`{"policies":["mergebase","mergeoverride"],"merge_outputs":true,"merge_conflict":"newest","payload":{"a":1}}`, errorCodeInvalidRequest, http.StatusBadRequest},
+		{`{"policies":["mergebase","mergeoverride"],"merge_outputs":true,"merge_conflict":"error","payload":{"a":1}}`, errorCodeOutputConflict, http.StatusConflict},
+		{`{"policies":["mergebase","mergescalar"],"merge_outputs":true,"data":{"mergescalar":1},"payload":{"a":1}}`, errorCodeOutputConflict, http.StatusConflict},
+		{`{"policy":"example","query":"data.other.allow","payload":{"a":1}}`, errorCodeInvalidRequest, http.StatusBadRequest},
+		{`{"policy":"example","payload":{"a":1},"mock_data":{"data.roles":[]}}`, errorCodeInvalidRequest, http.StatusBadRequest},
+		{`{"policy":"example","payload":{"a":1},"seed":1}`, errorCodeInvalidRequest, http.StatusBadRequest},
+		{`{"policy":"example","payload":{"a":1},"redact":["allow"]}`, errorCodeInvalidRequest, http.StatusBadRequest},
+	} {
+		resp, err := handleLambda(context.Background(), json.RawMessage(tc.body))
+		require.Error(t, err, tc.body)
+		require.Equal(t, tc.code, resp.(LambdaResponse).ErrorCode, tc.body)
+		require.Equal(t, tc.status, classifyError(err).status, tc.body)
+	}
+}
+
+func TestHandleLambdaDirectEventErrorCode(t *testing.T) {
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"missing","payload":{}}`))
+	require.Error(t, err)
+	require.Equal(t, errorCodePolicyNotFound, resp.(LambdaResponse).ErrorCode)
+	require.Equal(t, "unable to locate policy file: missing", resp.(LambdaResponse).Error)
+}
+
+func TestHandleLambdaHTTPEventsPolicyNotFound(t *testing.T) {
+	body := `{"policy":"missing","payload":{"user":"jane"}}`
+
+	raw, err := json.Marshal(events.APIGatewayProxyRequest{Resource: "/opa", Path: "/opa", Body: body})
+	require.NoError(t, err)
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+	proxyResp := resp.(events.APIGatewayProxyResponse)
+	require.Equal(t, http.StatusNotFound, proxyResp.StatusCode)
+	require.Equal(t, "unable to locate policy file: missing", parseLambdaResponseBody(t, proxyResp.Body).Error)
+
+	raw, err = json.Marshal(events.ALBTargetGroupRequest{
+		Path:           "/opa",
+		Body:           body,
+		RequestContext: events.ALBTargetGroupRequestContext{ELB: events.ELBContext{TargetGroupArn: "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/opa/abc"}},
+	})
+	require.NoError(t, err)
+	resp, err = handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+	albResp := resp.(events.ALBTargetGroupResponse)
+	require.Equal(t, http.StatusNotFound, albResp.StatusCode)
+	require.Equal(t, "404 Not Found", albResp.StatusDescription)
+
+	require.Equal(t, http.StatusNotFound, invokeAPIGatewayV2(t, nil, body).StatusCode)
+}
+
+// httpEventResponse is the status and body an HTTP event was answered with.
+type httpEventResponse struct {
+	event  string
+	status int
+	body   string
+}
+
+// invokeHTTPEvents sends body in an ALB, an API Gateway proxy and an API
+// Gateway v2 event and returns their responses.
+func invokeHTTPEvents(t *testing.T, body string) []httpEventResponse {
+	t.Helper()
+
+	raw, err := json.Marshal(events.ALBTargetGroupRequest{
+		Path:           "/opa",
+		Body:           body,
+		RequestContext: events.ALBTargetGroupRequestContext{ELB: events.ELBContext{TargetGroupArn: "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/opa/abc"}},
+	})
+	require.NoError(t, err)
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+	albResp := resp.(events.ALBTargetGroupResponse)
+
+	raw, err = json.Marshal(events.APIGatewayProxyRequest{Resource: "/opa", Path: "/opa", Body: body})
+	require.NoError(t, err)
+	resp, err = handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+	proxyResp := resp.(events.APIGatewayProxyResponse)
+
+	gwResp := invokeAPIGatewayV2(t, nil, body)
+	return []httpEventResponse{
+		{"alb", albResp.StatusCode, albResp.Body},
+		{"apigw-proxy", proxyResp.StatusCode, proxyResp.Body},
+		{"apigw-v2", gwResp.StatusCode, gwResp.Body},
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// flagsDataKey is the reserved key of data under which policies see the
+// feature flags.
+const flagsDataKey = "flags"
+
+// defaultFlagsRefresh is how long flags read from S3 are used before they
+// are read again.
+const defaultFlagsRefresh = time.Minute
+
+var (
+	flagsMu      sync.Mutex
+	flagsSource  string // The POLICY_FLAGS_S3_URI the cached flags were read from.
+	flagsCache   map[string]interface{}
+	flagsFetched time.Time
+	flagsReads   uint64 // Numbers the flags read from S3, for their versions.
+)
+
+// featureFlags returns the deployment's feature flags: the JSON object in
+// POLICY_FLAGS, or the one stored at POLICY_FLAGS_S3_URI, read again once it
+// is older than POLICY_FLAGS_REFRESH_SECONDS. When a refresh fails, the flags
+// read last keep being used. It returns nil when neither variable is set.
+// The version changes whenever the flags may have.
+func featureFlags(ctx context.Context) (map[string]interface{}, string, error) {
+	inline := strings.TrimSpace(os.Getenv("POLICY_FLAGS"))
+	uri := strings.TrimSpace(os.Getenv("POLICY_FLAGS_S3_URI"))
+	switch {
+	case inline != "" && uri != "":
+		return nil, "", errors.New("POLICY_FLAGS and POLICY_FLAGS_S3_URI are mutually exclusive")
+	case inline != "":
+		flags, err := decodeFlags("POLICY_FLAGS", []byte(inline))
+		return flags, fmt.Sprintf("inline:%x", sha256.Sum256([]byte(inline))), err
+	case uri == "":
+		return nil, "", nil
+	}
+
+	refresh, err := secondsFromEnv("POLICY_FLAGS_REFRESH_SECONDS", defaultFlagsRefresh)
+	if err != nil {
+		return nil, "", err
+	}
+
+	flagsMu.Lock()
+	defer flagsMu.Unlock()
+
+	if flagsSource == uri && time.Since(flagsFetched) < refresh {
+		return flagsCache, fmt.Sprintf("s3:%d", flagsReads), nil
+	}
+
+	flags, err := fetchFlags(ctx, uri)
+	if err != nil {
+		if flagsSource != uri {
+			return nil, "", err
+		}
+		log.Warnf("using feature flags read %s ago: %v", time.Since(flagsFetched).Round(time.Second), err)
+		return flagsCache, fmt.Sprintf("s3:%d", flagsReads), nil
+	}
+
+	flagsReads++
+	flagsSource, flagsCache, flagsFetched = uri, flags, time.Now()
+	return flags, fmt.Sprintf("s3:%d", flagsReads), nil
+}
+
+// fetchFlags reads the flags object stored at an s3://bucket/key URI.
+func fetchFlags(ctx context.Context, uri string) (map[string]interface{}, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(uri, "s3://"), "/")
+	if !strings.HasPrefix(uri, "s3://") || !ok || bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid POLICY_FLAGS_S3_URI %q: expected s3://bucket/key", uri)
+	}
+
+	client, err := newFlagsS3Client()
+	if err != nil {
+		return nil, err
+	}
+	object, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read feature flags from %s: %w", uri, err)
+	}
+	defer object.Body.Close()
+
+	body, err := io.ReadAll(object.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read feature flags from %s: %w", uri, err)
+	}
+	return decodeFlags(uri, body)
+}
+
+// newFlagsS3Client creates the S3 client used to read feature flags.
+var newFlagsS3Client = newBatchS3Client
+
+// decodeFlags decodes a flags document, which must be a JSON object.
+func decodeFlags(source string, body []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var flags map[string]interface{}
+	if err := decoder.Decode(&flags); err != nil || flags == nil {
+		return nil, fmt.Errorf("feature flags in %s must be a JSON object", source)
+	}
+	return flags, nil
+}
+
+// withFeatureFlags adds the feature flags to the data a policy is evaluated
+// against, under data.flags. Requests may not supply data.flags themselves,
+// through data or mock_data, so that a policy cannot be talked out of a flag.
+// It returns the version of the flags along with the data.
+func withFeatureFlags(ctx context.Context, data, requested map[string]interface{}) (map[string]interface{}, string, error) {
+	flags, version, err := featureFlags(ctx)
+	if err != nil || flags == nil {
+		return data, "", err
+	}
+	for _, document := range []map[string]interface{}{data, requested} {
+		if _, ok := document[flagsDataKey]; ok {
+			return nil, "", fmt.Errorf("%w: data.%s is reserved for feature flags", errReservedData, flagsDataKey)
+		}
+	}
+
+	withFlags := make(map[string]interface{}, len(data)+1)
+	for key, value := range data {
+		withFlags[key] = value
+	}
+	withFlags[flagsDataKey] = flags
+	return withFlags, version, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/require"
+)
+
+const flagsTestPolicy = "package flagged\n\ndefault checkout = \"old\"\n\ncheckout = \"new\" { data.flags.new_checkout }\n"
+
+// useFlagsS3 serves feature flags from a fake S3 bucket and forgets the
+// flags cached by earlier tests.
+func useFlagsS3(t *testing.T, objects map[string]string) *fakeS3 {
+	client, fake := newFakeS3Client(t, objects)
+	newClient := newFlagsS3Client
+	newFlagsS3Client = func() (s3iface.S3API, error) { return client, nil }
+	flagsSource = ""
+	t.Cleanup(func() {
+		newFlagsS3Client = newClient
+		flagsSource = ""
+	})
+	return fake
+}
+
+func evaluateFlagged(t *testing.T, event string) (interface{}, error) {
+	resp, err := handleLambda(context.Background(), json.RawMessage(event))
+	if err != nil {
+		return nil, err
+	}
+	return resp.(LambdaResponse).Output.(map[string]interface{})["checkout"], nil
+}
+
+func TestFeatureFlagsInline(t *testing.T) {
+	writeTestPolicy(t, "flagged", flagsTestPolicy)
+
+	t.Setenv("POLICY_FLAGS", "")
+	checkout, err := evaluateFlagged(t, `{"policy":"flagged","payload":{}}`)
+	require.NoError(t, err)
+	require.Equal(t, "old", checkout)
+
+	t.Setenv("POLICY_FLAGS", `{"new_checkout": true}`)
+	checkout, err = evaluateFlagged(t, `{"policy":"flagged","payload":{}}`)
+	require.NoError(t, err)
+	require.Equal(t, "new", checkout)
+
+	// Flags can be neither stubbed nor supplied as data.
+	t.Setenv("ALLOW_MOCK_DATA", "true")
+	_, err = evaluateFlagged(t, `{"policy":"flagged","payload":{},"mock_data":{"flags.new_checkout":false}}`)
+	require.ErrorContains(t, err, `"flags.new_checkout" would replace data.flags`)
+	_, err = evaluateFlagged(t, `{"policy":"flagged","payload":{},"data":{"flags":{}}}`)
+	require.ErrorContains(t, err, "data.flags is reserved for feature flags")
+
+	t.Setenv("POLICY_FLAGS", `[true]`)
+	_, err = evaluateFlagged(t, `{"policy":"flagged","payload":{}}`)
+	require.ErrorContains(t, err, "feature flags in POLICY_FLAGS must be a JSON object")
+
+	t.Setenv("POLICY_FLAGS", `{}`)
+	t.Setenv("POLICY_FLAGS_S3_URI", "s3://flags/flags.json")
+	_, err = evaluateFlagged(t, `{"policy":"flagged","payload":{}}`)
+	require.ErrorContains(t, err, "mutually exclusive")
+}
+
+func TestFeatureFlagsS3(t *testing.T) {
+	writeTestPolicy(t, "flagged", flagsTestPolicy)
+	fake := useFlagsS3(t, map[string]string{"/flags/prod/flags.json": `{"new_checkout": true}`})
+	t.Setenv("POLICY_FLAGS_S3_URI", "s3://flags/prod/flags.json")
+
+	checkout, err := evaluateFlagged(t, `{"policy":"flagged","payload":{}}`)
+	require.NoError(t, err)
+	require.Equal(t, "new", checkout)
+
+	// Flags are cached until they are due for a refresh.
+	fake.objects["/flags/prod/flags.json"] = `{"new_checkout": false}`
+	checkout, err = evaluateFlagged(t, `{"policy":"flagged","payload":{}}`)
+	require.NoError(t, err)
+	require.Equal(t, "new", checkout)
+
+	t.Setenv("POLICY_FLAGS_REFRESH_SECONDS", "0")
+	checkout, err = evaluateFlagged(t, `{"policy":"flagged","payload":{}}`)
+	require.NoError(t, err)
+	require.Equal(t, "old", checkout)
+
+	// A failed refresh keeps the flags read last.
+	delete(fake.objects, "/flags/prod/flags.json")
+	checkout, err = evaluateFlagged(t, `{"policy":"flagged","payload":{}}`)
+	require.NoError(t, err)
+	require.Equal(t, "old", checkout)
+
+	// Without flags read before, it fails the evaluation.
+	t.Setenv("POLICY_FLAGS_S3_URI", "s3://flags/missing.json")
+	_, err = evaluateFlagged(t, `{"policy":"flagged","payload":{}}`)
+	require.ErrorContains(t, err, "unable to read feature flags from s3://flags/missing.json")
+
+	t.Setenv("POLICY_FLAGS_S3_URI", "flags/prod/flags.json")
+	_, err = evaluateFlagged(t, `{"policy":"flagged","payload":{}}`)
+	require.ErrorContains(t, err, "expected s3://bucket/key")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+	formatSSE    = "sse"
+)
+
+// formatMediaTypes maps the response formats to their media types.
+var formatMediaTypes = map[string]string{
+	formatJSON:   "application/json",
+	formatCSV:    "text/csv",
+	formatNDJSON: "application/x-ndjson",
+	formatSSE:    "text/event-stream",
+}
+
+// errNotAcceptable is returned when the Accept header rules out every
+// supported response format.
+var errNotAcceptable = errors.New("not acceptable")
+
+// errNotTabular is returned when a CSV response is requested for a result
+// that is not an array of objects.
+var errNotTabular = errors.New("policy result is not tabular: CSV output requires an array of objects")
+
+// negotiateFormat picks the format whose media type the Accept header rates
+// highest, using the most specific matching media range for each format.
+// Ties go to the earlier format, and an empty header accepts the first one.
+func negotiateFormat(accept string, formats ...string) (string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return formats[0], nil
+	}
+
+	best, bestQuality := "", 0.0
+	for _, format := range formats {
+		mediaType := mediaType(format)
+		mainType, _, _ := strings.Cut(mediaType, "/")
+
+		quality, specificity := 0.0, 0
+		for _, mediaRange := range strings.Split(accept, ",") {
+			params := strings.Split(mediaRange, ";")
+			candidate := strings.ToLower(strings.TrimSpace(params[0]))
+
+			var rank int
+			switch candidate {
+			case mediaType:
+				rank = 3
+			case mainType + "/*":
+				rank = 2
+			case "*/*":
+				rank = 1
+			default:
+				continue
+			}
+			if rank <= specificity {
+				continue
+			}
+
+			q, ok := mediaRangeQuality(params[1:])
+			if !ok {
+				continue
+			}
+			quality, specificity = q, rank
+		}
+
+		if quality > bestQuality {
+			best, bestQuality = format, quality
+		}
+	}
+
+	if best == "" {
+		mediaTypes := make([]string, 0, len(formats))
+		for _, format := range formats {
+			mediaTypes = append(mediaTypes, mediaType(format))
+		}
+		return "", fmt.Errorf("%w: supported media types are %s", errNotAcceptable, strings.Join(mediaTypes, ", "))
+	}
+	return best, nil
+}
+
+// mediaRangeQuality returns the q parameter of a media range, defaulting to 1.
+func mediaRangeQuality(params []string) (float64, bool) {
+	for _, param := range params {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0, false
+		}
+		return q, true
+	}
+	return 1, true
+}
+
+// renderCSV flattens an array of objects into CSV. The header row is the
+// sorted union of all object keys; missing keys and nulls produce empty
+// cells, and nested objects or arrays are JSON-encoded into their cell.
+func renderCSV(output interface{}) ([]byte, error) {
+	rows, ok := output.([]interface{})
+	if !ok {
+		return nil, errNotTabular
+	}
+
+	records := make([]map[string]interface{}, 0, len(rows))
+	columnSet := make(map[string]struct{})
+	for _, row := range rows {
+		record, ok := row.(map[string]interface{})
+		if !ok {
+			return nil, errNotTabular
+		}
+		for key := range record {
+			columnSet[key] = struct{}{}
+		}
+		records = append(records, record)
+	}
+
+	columns := make([]string, 0, len(columnSet))
+	for key := range columnSet {
+		columns = append(columns, key)
+	}
+	sort.Strings(columns)
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if len(columns) > 0 {
+		if err := writer.Write(columns); err != nil {
+			return nil, err
+		}
+	}
+	for _, record := range records {
+		line := make([]string, len(columns))
+		for i, column := range columns {
+			cell, err := csvCell(record[column])
+			if err != nil {
+				return nil, err
+			}
+			line[i] = cell
+		}
+		if err := writer.Write(line); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+
+	return buf.Bytes(), writer.Error()
+}
+
+func csvCell(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, json.Number, float64:
+		return fmt.Sprint(v), nil
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderCSV(t *testing.T) {
+	output := []interface{}{
+		map[string]interface{}{"user": "jane", "allowed": true, "score": json.Number("3")},
+		map[string]interface{}{"user": "joe, jr", "roles": []interface{}{"admin", "dev"}, "meta": map[string]interface{}{"team": "a"}},
+		map[string]interface{}{"user": nil},
+	}
+
+	csv, err := renderCSV(output)
+	require.NoError(t, err)
+	require.Equal(t, "allowed,meta,roles,score,user\n"+
+		"true,,,3,jane\n"+
+		`,"{""team"":""a""}","[""admin"",""dev""]",,"joe, jr"`+"\n"+
+		",,,,\n", string(csv))
+}
+
+func TestRenderCSVEmpty(t *testing.T) {
+	csv, err := renderCSV([]interface{}{})
+	require.NoError(t, err)
+	require.Empty(t, csv)
+}
+
+func TestRenderCSVNotTabular(t *testing.T) {
+	for _, output := range []interface{}{
+		map[string]interface{}{"allow": true},
+		[]interface{}{"a", "b"},
+		true,
+	} {
+		_, err := renderCSV(output)
+		require.ErrorIs(t, err, errNotTabular)
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept  string
+		want    string
+		wantErr bool
+	}{
+		{accept: "", want: formatJSON},
+		{accept: "*/*", want: formatJSON},
+		{accept: "application/json", want: formatJSON},
+		{accept: "text/csv", want: formatCSV},
+		{accept: "text/*", want: formatCSV},
+		{accept: "Text/CSV; charset=utf-8", want: formatCSV},
+		{accept: "text/csv;q=0.5, application/json", want: formatJSON},
+		{accept: "text/csv, application/json;q=0.5", want: formatCSV},
+		{accept: "application/xml, */*;q=0.1", want: formatJSON},
+		{accept: "*/*, application/json;q=0", want: formatCSV},
+		{accept: "application/xml", wantErr: true},
+		{accept: "application/json;q=0, text/csv;q=0", wantErr: true},
+		{accept: "application/json;q=abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			got, err := negotiateFormat(tt.accept, formatJSON, formatCSV)
+			if tt.wantErr {
+				require.ErrorIs(t, err, errNotAcceptable)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
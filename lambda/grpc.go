@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// evaluatorService is the handler type of evaluatorServiceDesc.
+type evaluatorService interface {
+	evaluateStream(stream grpc.ServerStream) error
+}
+
+// evaluatorServiceDesc describes the service in proto/evaluator.proto. It is
+// written by hand because its messages are google.protobuf.Struct values.
+var evaluatorServiceDesc = grpc.ServiceDesc{
+	ServiceName: "opalambda.Evaluator",
+	HandlerType: (*evaluatorService)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "EvaluateStream",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(evaluatorService).evaluateStream(stream)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/evaluator.proto",
+}
+
+type evaluatorServer struct{}
+
+// newGRPCServer returns a server exposing the evaluator service.
+func newGRPCServer() *grpc.Server {
+	server := grpc.NewServer()
+	server.RegisterService(&evaluatorServiceDesc, evaluatorServer{})
+	return server
+}
+
+// serveGRPC serves the evaluator service on addr until the listener fails.
+func serveGRPC(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Infof("Serving gRPC on %s", listener.Addr())
+	return newGRPCServer().Serve(listener)
+}
+
+// evaluateStream answers every request on the stream in order, as soon as it
+// has been evaluated. Errors in one request are reported on its response and
+// do not end the stream.
+func (evaluatorServer) evaluateStream(stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	for index := 0; ; index++ {
+		var msg structpb.Struct
+		if err := stream.RecvMsg(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		result := batchItemResult{Index: index, LambdaResponse: evaluateStreamMessage(ctx, &msg)}
+		reply, err := toStruct(result)
+		if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// evaluateStreamMessage evaluates one stream request, which has the shape of
+// a direct invocation event.
+func evaluateStreamMessage(ctx context.Context, msg *structpb.Struct) LambdaResponse {
+	raw, err := protojson.Marshal(msg)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	var req LambdaEvent
+	if err := decodeEnvelope(raw, &req); err != nil {
+		err = invalidPayloadError(fmt.Errorf("unable to parse gRPC request: %w", err))
+		log.Error(err)
+		return errorResponse(err)
+	}
+
+	resp, err := evaluatePolicy(ctx, req)
+	if err != nil {
+		log.Error(err)
+		return errorResponse(err)
+	}
+	return resp
+}
+
+// toStruct converts a JSON-serializable value into a Struct.
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out structpb.Struct
+	if err := protojson.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestEvaluateStream(t *testing.T) {
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer()
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	stream, err := conn.NewStream(context.Background(), &evaluatorServiceDesc.Streams[0], "/opalambda.Evaluator/EvaluateStream")
+	require.NoError(t, err)
+
+	requests := []map[string]interface{}{
+		{"policy": "example", "payload": map[string]interface{}{"membership": map[string]interface{}{"user": map[string]interface{}{"login": "jane", "mail": "jane@example.com"}}}},
+		{"policy": "nosuchpolicy", "payload": map[string]interface{}{}},
+		{"policy": "example", "payload": map[string]interface{}{"membership": map[string]interface{}{"user": map[string]interface{}{"login": "joe"}}}},
+	}
+	for _, req := range requests {
+		msg, err := structpb.NewStruct(req)
+		require.NoError(t, err)
+		require.NoError(t, stream.SendMsg(msg))
+	}
+	require.NoError(t, stream.CloseSend())
+
+	var replies []map[string]interface{}
+	for range requests {
+		var reply structpb.Struct
+		require.NoError(t, stream.RecvMsg(&reply))
+		replies = append(replies, reply.AsMap())
+	}
+
+	require.Equal(t, float64(0), replies[0]["index"])
+	assertExampleOutput(t, replies[0]["output"])
+	require.Equal(t, float64(1), replies[1]["index"])
+	require.Contains(t, replies[1]["error"], "unable to locate policy file")
+	require.Equal(t, float64(2), replies[2]["index"])
+	require.Equal(t, false, replies[2]["output"].(map[string]interface{})["allow"])
+}
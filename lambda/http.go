@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"opa_lambda/buildinfo"
+
+	"github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxDecisionTTL caps the ttl_seconds a policy may request.
+const maxDecisionTTL = 24 * 60 * 60
+
+// maxRetryAfter caps the retry_after a rate-limited decision may request.
+const maxRetryAfter = 24 * 60 * 60
+
+// maxGzipBodyBytes caps the size of a gzipped request body once decompressed,
+// so a small body cannot expand beyond the function's memory.
+const maxGzipBodyBytes = 64 << 20
+
+// httpRequest is the part of an ALB or API Gateway request the handlers act on.
+type httpRequest struct {
+	Integration     string            // Human-readable integration name used in error messages.
+	Path            string            // The request path.
+	Body            string            // The raw request body.
+	IsBase64Encoded bool              // Whether Body is base64 encoded.
+	Headers         map[string]string // The request headers.
+}
+
+// header returns the value of the named header, ignoring case.
+func (r httpRequest) header(name string) string {
+	for key, value := range r.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// httpResponse is rendered into the integration-specific response type.
+type httpResponse struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       string
+	Stream     io.Reader // When set, produces the body incrementally instead of Body.
+}
+
+// buffered reads a streamed body into Body for integrations that cannot
+// stream their responses.
+func (r httpResponse) buffered() httpResponse {
+	if r.Stream == nil {
+		return r
+	}
+
+	body, err := io.ReadAll(r.Stream)
+	if err != nil {
+		log.Error(err)
+		return newHTTPErrorResponse(err)
+	}
+	r.Body = string(body)
+	r.Stream = nil
+	return r
+}
+
+// withBuildVersion adds the X-Build-Version header when BUILD_VERSION_HEADER
+// is enabled, so responses can be traced to the artifact that served them.
+func (r httpResponse) withBuildVersion() httpResponse {
+	enabled, err := boolFromEnv("BUILD_VERSION_HEADER", false)
+	if err != nil {
+		log.Error(err)
+		return r
+	}
+	if !enabled {
+		return r
+	}
+
+	headers := make(map[string]string, len(r.Headers)+1)
+	for key, value := range r.Headers {
+		headers[key] = value
+	}
+	headers["X-Build-Version"] = buildinfo.Version()
+	r.Headers = headers
+	return r
+}
+
+// handleHTTPRequest evaluates the LambdaEvent carried in the body of an HTTP
+// integration request.
+func handleHTTPRequest(ctx context.Context, req httpRequest) httpResponse {
+	if docPath, ok := dataAPIPath(req.Path); ok {
+		return handleDataAPIRequest(ctx, req, docPath)
+	}
+
+	body, err := requestBody(req)
+	if err != nil {
+		log.Error(err)
+		return newHTTPErrorResponse(invalidPayloadError(err))
+	}
+
+	var lambdaReq LambdaEvent
+	if err := decodeEnvelope(body, &lambdaReq); err != nil {
+		err = invalidPayloadError(fmt.Errorf("unable to parse %s body: %w", req.Integration, err))
+		log.Error(err)
+		return newHTTPErrorResponse(err)
+	}
+	if err := applyPolicyRoute(req.Path, &lambdaReq); err != nil {
+		log.Error(err)
+		return newHTTPErrorResponse(err)
+	}
+
+	if lambdaReq.Payloads != nil {
+		return handleHTTPBatchRequest(ctx, req, lambdaReq)
+	}
+
+	format, err := responseFormat(req, lambdaReq, formatJSON, formatCSV)
+	if err != nil {
+		log.Error(err)
+		return newHTTPErrorResponse(err)
+	}
+
+	resp, err := evaluatePolicy(ctx, lambdaReq)
+	if err != nil {
+		log.Error(err)
+		return newHTTPErrorResponse(err)
+	}
+
+	if limited, ok := rateLimitedResponse(resp); ok {
+		return limited
+	}
+	if reauth, ok := reauthResponse(resp); ok {
+		return reauth
+	}
+	if denied, ok := deniedResponse(resp); ok {
+		return denied
+	}
+	if format == formatCSV {
+		return newCSVResponse(resp)
+	}
+	return newHTTPResponse(http.StatusOK, resp)
+}
+
+// handleHTTPBatchRequest evaluates every element of a payloads array. The
+// results are returned as a JSON array in request order or, when NDJSON or
+// Server-Sent Events are requested, as one line or event per payload written
+// as soon as it is evaluated. With
+// aggregate_allow they are wrapped in an object with the overall decision.
+func handleHTTPBatchRequest(ctx context.Context, req httpRequest, lambdaReq LambdaEvent) httpResponse {
+	if lambdaReq.Payload != nil {
+		err := invalidRequestError("payload and payloads are mutually exclusive")
+		log.Error(err)
+		return newHTTPErrorResponse(err)
+	}
+	if err := checkBatchSize(len(lambdaReq.Payloads)); err != nil {
+		log.Error(err)
+		return newHTTPErrorResponse(err)
+	}
+
+	format, err := responseFormat(req, lambdaReq, formatJSON, formatNDJSON, formatSSE)
+	if err != nil {
+		log.Error(err)
+		return newHTTPErrorResponse(err)
+	}
+
+	if lambdaReq.AggregateAllow {
+		if format != formatJSON {
+			err := invalidRequestError("aggregate_allow is not supported with %s responses", format)
+			log.Error(err)
+			return newHTTPErrorResponse(err)
+		}
+		return newJSONResponse(http.StatusOK, evaluateBatchAggregate(ctx, lambdaReq))
+	}
+	if format == formatNDJSON {
+		return httpResponse{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"Content-Type": formatMediaTypes[formatNDJSON]},
+			Stream:     streamBatch(ctx, lambdaReq),
+		}
+	}
+	if format == formatSSE {
+		return httpResponse{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"Content-Type": formatMediaTypes[formatSSE], "Cache-Control": "no-cache"},
+			Stream:     streamBatchEvents(ctx, lambdaReq),
+		}
+	}
+	return newJSONResponse(http.StatusOK, evaluateBatch(ctx, lambdaReq))
+}
+
+// responseFormat picks the response body format from the request's format
+// field, falling back to negotiating it from the Accept header. The first of
+// the supported formats is the default.
+func responseFormat(req httpRequest, lambdaReq LambdaEvent, formats ...string) (string, error) {
+	if lambdaReq.Format == "" {
+		return negotiateFormat(req.header("Accept"), formats...)
+	}
+
+	for _, format := range formats {
+		if strings.EqualFold(lambdaReq.Format, format) {
+			return format, nil
+		}
+	}
+	return "", invalidRequestError("unsupported format: %s", lambdaReq.Format)
+}
+
+// errTrailingData is returned for bodies holding more than one JSON document.
+var errTrailingData = errors.New("unexpected data after the JSON document; send one request object per call, or use payloads for several payloads")
+
+// decodeJSONBody decodes a body that must hold exactly one JSON document.
+// Trailing data, such as a second object appended by a client that meant to
+// send NDJSON, is rejected rather than dropped.
+func decodeJSONBody(body []byte, v interface{}) error {
+	return decodeJSON(json.NewDecoder(bytes.NewReader(body)), v)
+}
+
+// decodeJSON decodes the only JSON document read by decoder.
+func decodeJSON(decoder *json.Decoder, v interface{}) error {
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	offset := decoder.InputOffset()
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("%w (offset %d)", errTrailingData, offset)
+	}
+	return nil
+}
+
+func newCSVResponse(resp LambdaResponse) httpResponse {
+	body, err := renderCSV(resp.Output)
+	if err != nil {
+		log.Error(err)
+		return newHTTPErrorResponse(err)
+	}
+
+	httpResp := httpResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "text/csv; charset=utf-8"},
+		Body:       string(body),
+	}
+	setDecisionHeaders(httpResp, resp)
+	return httpResp
+}
+
+func decodeBody(body string, isBase64Encoded bool) ([]byte, error) {
+	if body == "" {
+		return nil, errors.New("request body is required")
+	}
+
+	if isBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err == nil {
+			return decoded, nil
+		}
+		// Some upstreams flag bodies as base64-encoded that they sent as is.
+		lenient, lenientErr := boolFromEnv("LENIENT_BASE64_BODY", false)
+		if lenientErr != nil {
+			return nil, lenientErr
+		}
+		if !lenient {
+			return nil, fmt.Errorf("invalid base64 body: %w", err)
+		}
+		log.Warnf("Request body flagged as base64-encoded is not valid base64, using it as is: %v", err)
+	}
+
+	return []byte(body), nil
+}
+
+// gunzipBody decompresses a request body sent with Content-Encoding: gzip.
+// Bodies without a content encoding, or with another one, are returned as is.
+func gunzipBody(body []byte, contentEncoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "gzip", "x-gzip":
+	default:
+		return body, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip body: %w", err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(reader, maxGzipBodyBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip body: %w", err)
+	}
+	if len(decompressed) > maxGzipBodyBytes {
+		return nil, fmt.Errorf("gzip body exceeds %d bytes once decompressed", maxGzipBodyBytes)
+	}
+	return decompressed, nil
+}
+
+// newHTTPErrorResponse reports err with the HTTP status it is classified with.
+func newHTTPErrorResponse(err error) httpResponse {
+	return newHTTPResponse(classifyError(err).status, errorResponse(err))
+}
+
+// newHTTPResponse renders a response in the envelope selected by
+// RESPONSE_FORMAT, the LambdaResponse itself by default.
+func newHTTPResponse(status int, body LambdaResponse) httpResponse {
+	resp, err := renderEnvelope(status, body)
+	if err != nil {
+		log.Error(err)
+		return newJSONResponse(http.StatusInternalServerError, errorResponse(err))
+	}
+	setDecisionHeaders(resp, body)
+
+	if body.NoCache {
+		resp.Headers["Cache-Control"] = "no-store"
+	} else if resp.StatusCode == http.StatusOK {
+		if maxAge, ok := decisionTTL(body.Output); ok {
+			resp.Headers["Cache-Control"] = fmt.Sprintf("max-age=%d", maxAge)
+		}
+	}
+
+	return resp
+}
+
+// renderEnvelope encodes a response in the envelope selected by
+// RESPONSE_FORMAT.
+func renderEnvelope(status int, body LambdaResponse) (httpResponse, error) {
+	envelope, err := responseEnvelope()
+	if err != nil || envelope != envelopeJSONAPI {
+		return newJSONResponse(status, body), err
+	}
+
+	doc, err := newJSONAPIDocument(status, body)
+	if err != nil {
+		return httpResponse{}, err
+	}
+	resp := newJSONResponse(status, doc)
+	resp.Headers["Content-Type"] = jsonAPIMediaType
+	return resp, nil
+}
+
+func newJSONResponse(status int, body interface{}) httpResponse {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		log.Errorf("unable to marshal response: %v", err)
+		status = http.StatusInternalServerError
+		payload = []byte(fmt.Sprintf(`{"error":"%s"}`, http.StatusText(status)))
+	}
+
+	return httpResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}
+}
+
+// setDecisionHeaders echoes a decision's ID in the X-Decision-Id header and
+// its trace ID, when returned, in X-Amzn-Trace-Id. Empty IDs, as for requests
+// that failed before being evaluated, are left out.
+func setDecisionHeaders(resp httpResponse, body LambdaResponse) {
+	if body.DecisionID != "" {
+		resp.Headers[decisionIDHeader] = body.DecisionID
+	}
+	if body.TraceID != "" {
+		resp.Headers["X-Amzn-Trace-Id"] = "Root=" + body.TraceID
+	}
+}
+
+// decisionTTL extracts the ttl_seconds a policy attached to its output. The
+// value must be a whole number of seconds between 0 and maxDecisionTTL;
+// anything else is logged and ignored so a bad hint never breaks a decision.
+func decisionTTL(output interface{}) (int64, bool) {
+	return outputSeconds(output, "ttl_seconds", maxDecisionTTL)
+}
+
+// rateLimitedResponse answers 429 Too Many Requests when a policy's output is
+// an object with rate_limited set to true, passing its retry_after on as a
+// Retry-After header. It reports false for every other output.
+func rateLimitedResponse(resp LambdaResponse) (httpResponse, bool) {
+	result, ok := resp.Output.(map[string]interface{})
+	if !ok || result["rate_limited"] != true {
+		return httpResponse{}, false
+	}
+
+	httpResp := newHTTPResponse(http.StatusTooManyRequests, resp)
+	if retryAfter, ok := outputSeconds(result, "retry_after", maxRetryAfter); ok {
+		httpResp.Headers["Retry-After"] = strconv.FormatInt(retryAfter, 10)
+	}
+	return httpResp, true
+}
+
+// outputSeconds extracts a whole number of seconds between 0 and max from a
+// field of a policy's output object. Invalid values are logged and ignored.
+func outputSeconds(output interface{}, field string, max int64) (int64, bool) {
+	result, ok := output.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	raw, ok := result[field]
+	if !ok {
+		return 0, false
+	}
+
+	var seconds float64
+	switch v := raw.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			log.Warnf("ignoring invalid %s %v: %v", field, raw, err)
+			return 0, false
+		}
+		seconds = f
+	case float64:
+		seconds = v
+	default:
+		log.Warnf("ignoring non-numeric %s %v", field, raw)
+		return 0, false
+	}
+
+	if seconds != math.Trunc(seconds) || seconds < 0 || seconds > float64(max) {
+		log.Warnf("ignoring %s %v: must be a whole number between 0 and %d", field, raw, max)
+		return 0, false
+	}
+
+	return int64(seconds), true
+}
+
+// firstHeaderValues flattens multi-value headers, keeping the first value.
+func firstHeaderValues(multi map[string][]string) map[string]string {
+	headers := make(map[string]string, len(multi))
+	for key, values := range multi {
+		if len(values) > 0 {
+			headers[key] = values[0]
+		}
+	}
+	return headers
+}
+
+func newALBResponse(resp httpResponse) events.ALBTargetGroupResponse {
+	resp = resp.buffered().withBuildVersion()
+	return events.ALBTargetGroupResponse{
+		StatusCode:        resp.StatusCode,
+		StatusDescription: fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+		Headers:           resp.Headers,
+		Body:              resp.Body,
+		IsBase64Encoded:   false,
+	}
+}
+
+func newAPIGatewayProxyResponse(resp httpResponse) events.APIGatewayProxyResponse {
+	resp = resp.buffered().withBuildVersion()
+	return events.APIGatewayProxyResponse{
+		StatusCode:      resp.StatusCode,
+		Headers:         resp.Headers,
+		Body:            resp.Body,
+		IsBase64Encoded: false,
+	}
+}
+
+func newAPIGatewayV2Response(resp httpResponse) events.APIGatewayV2HTTPResponse {
+	resp = resp.buffered().withBuildVersion()
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode:      resp.StatusCode,
+		Headers:         resp.Headers,
+		Body:            resp.Body,
+		IsBase64Encoded: false,
+	}
+}
+
+func newFunctionURLResponse(resp httpResponse) events.LambdaFunctionURLResponse {
+	resp = resp.buffered().withBuildVersion()
+	return events.LambdaFunctionURLResponse{
+		StatusCode:      resp.StatusCode,
+		Headers:         resp.Headers,
+		Body:            resp.Body,
+		IsBase64Encoded: false,
+	}
+}
+
+func newFunctionURLStreamingResponse(resp httpResponse) *events.LambdaFunctionURLStreamingResponse {
+	resp = resp.withBuildVersion()
+	body := resp.Stream
+	if body == nil {
+		body = strings.NewReader(resp.Body)
+	}
+
+	return &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Headers,
+		Body:       body,
+	}
+}
+
+// invocationStream is the body of a streamed response. It ends the
+// invocation, flushing its traces and decision logs, once the body has been
+// read to the end or closed by the runtime, rather than when the handler
+// returns while payloads are still being evaluated.
+type invocationStream struct {
+	io.Reader
+	once   sync.Once
+	finish func()
+}
+
+func (s *invocationStream) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	if err != nil {
+		s.once.Do(s.finish)
+	}
+	return n, err
+}
+
+// Close closes the body, stopping evaluations the client no longer waits
+// for, and ends the invocation.
+func (s *invocationStream) Close() error {
+	var err error
+	if closer, ok := s.Reader.(io.Closer); ok {
+		err = closer.Close()
+	}
+	s.once.Do(s.finish)
+	return err
+}
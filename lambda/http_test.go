@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"opa_lambda/buildinfo"
+	"opa_lambda/policyloader"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecisionTTL(t *testing.T) {
+	tests := []struct {
+		name   string
+		output interface{}
+		ttl    int64
+		ok     bool
+	}{
+		{name: "json number", output: map[string]interface{}{"ttl_seconds": json.Number("300")}, ttl: 300, ok: true},
+		{name: "float", output: map[string]interface{}{"ttl_seconds": float64(60)}, ttl: 60, ok: true},
+		{name: "zero", output: map[string]interface{}{"ttl_seconds": json.Number("0")}, ttl: 0, ok: true},
+		{name: "missing", output: map[string]interface{}{"allow": true}},
+		{name: "not an object", output: true},
+		{name: "negative", output: map[string]interface{}{"ttl_seconds": json.Number("-1")}},
+		{name: "fractional", output: map[string]interface{}{"ttl_seconds": json.Number("1.5")}},
+		{name: "too large", output: map[string]interface{}{"ttl_seconds": json.Number("86401")}},
+		{name: "string", output: map[string]interface{}{"ttl_seconds": "300"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ttl, ok := decisionTTL(test.output)
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.ttl, ttl)
+		})
+	}
+}
+
+func TestHandleLambdaAPIGatewayV2EventCacheControl(t *testing.T) {
+	writeTestPolicy(t, "cached", "package cached\n\nallow = true\n\nttl_seconds = 300\n")
+
+	event := events.APIGatewayV2HTTPRequest{
+		Version: "2.0",
+		RawPath: "/opa",
+		Body:    `{"policy":"cached","payload":{}}`,
+	}
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+
+	gwResp, ok := resp.(events.APIGatewayV2HTTPResponse)
+	require.True(t, ok)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, "max-age=300", gwResp.Headers["Cache-Control"])
+}
+
+func TestHandleLambdaAPIGatewayV2EventNoCache(t *testing.T) {
+	writeTestPolicy(t, "cached", "package cached\n\nallow = true\n\nttl_seconds = 300\n")
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"cached","payload":{},"no_cache":true}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, "no-store", gwResp.Headers["Cache-Control"])
+	require.True(t, parseLambdaResponseBody(t, gwResp.Body).NoCache)
+}
+
+func TestHandleLambdaALBEventWithoutTTL(t *testing.T) {
+	event := events.ALBTargetGroupRequest{
+		RequestContext: events.ALBTargetGroupRequestContext{
+			ELB: events.ELBContext{TargetGroupArn: "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/opa/test"},
+		},
+		Body: string(buildLambdaEventPayloadBytes(t)),
+	}
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+
+	albResp, ok := resp.(events.ALBTargetGroupResponse)
+	require.True(t, ok)
+	require.Equal(t, http.StatusOK, albResp.StatusCode)
+	require.NotContains(t, albResp.Headers, "Cache-Control")
+}
+
+// testPolicyDirs holds the policy directory of each test that writes
+// fixtures.
+var testPolicyDirs = map[*testing.T]string{}
+
+// testPolicyDir points the shared loader at a temporary copy of the current
+// policy directory for the duration of the test, so fixtures never touch the
+// checked-in policies.
+func testPolicyDir(t *testing.T) string {
+	t.Helper()
+	if dir, ok := testPolicyDirs[t]; ok {
+		return dir
+	}
+
+	source := os.Getenv("POLICY_DIR")
+	if source == "" {
+		source = "policies"
+	}
+	dir := t.TempDir()
+	entries, err := os.ReadDir(source)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(source, entry.Name()))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, entry.Name()), content, 0o600))
+	}
+	t.Setenv("POLICY_DIR", dir)
+
+	loaderMu.Lock()
+	previousLoader, previousEvaluator := loader, evaluator
+	loader, evaluator = nil, nil
+	loaderMu.Unlock()
+	testPolicyDirs[t] = dir
+	t.Cleanup(func() {
+		delete(testPolicyDirs, t)
+		loaderMu.Lock()
+		loader, evaluator = previousLoader, previousEvaluator
+		loaderMu.Unlock()
+	})
+	return dir
+}
+
+// writeTestPolicy installs a policy in the test's policy directory.
+func writeTestPolicy(t *testing.T, name, module string) {
+	t.Helper()
+	path := filepath.Join(testPolicyDir(t), name+".rego")
+	require.NoError(t, os.WriteFile(path, []byte(module), 0o600))
+}
+
+func TestHandleLambdaAPIGatewayV2EventCSVNotTabular(t *testing.T) {
+	gwResp := invokeAPIGatewayV2(t, map[string]string{"accept": "text/csv;q=0.9, application/json;q=0.5"}, string(buildLambdaEventPayloadBytes(t)))
+	require.Equal(t, http.StatusNotAcceptable, gwResp.StatusCode)
+	require.Equal(t, "application/json", gwResp.Headers["Content-Type"])
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "not tabular")
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"example","format":"csv","payload":{}}`)
+	require.Equal(t, http.StatusNotAcceptable, gwResp.StatusCode)
+}
+
+func TestHandleLambdaAPIGatewayV2EventUnsupportedAccept(t *testing.T) {
+	gwResp := invokeAPIGatewayV2(t, map[string]string{"Accept": "application/xml"}, string(buildLambdaEventPayloadBytes(t)))
+	require.Equal(t, http.StatusNotAcceptable, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "not acceptable")
+
+	gwResp = invokeAPIGatewayV2(t, map[string]string{"Accept": "application/xml, */*;q=0.1"}, string(buildLambdaEventPayloadBytes(t)))
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, "application/json", gwResp.Headers["Content-Type"])
+}
+
+func TestHandleLambdaAPIGatewayV2EventUnsupportedFormat(t *testing.T) {
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"example","format":"xml","payload":{}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+}
+
+func invokeAPIGatewayV2(t *testing.T, headers map[string]string, body string) events.APIGatewayV2HTTPResponse {
+	t.Helper()
+	event := events.APIGatewayV2HTTPRequest{
+		Version: "2.0",
+		RawPath: "/opa",
+		Headers: headers,
+		Body:    body,
+	}
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+
+	gwResp, ok := resp.(events.APIGatewayV2HTTPResponse)
+	require.True(t, ok)
+	return gwResp
+}
+
+func TestHandleLambdaAPIGatewayV2EventInputSchemaMismatch(t *testing.T) {
+	t.Setenv("TYPE_CHECK_INPUT", "true")
+	writeTestPolicy(t, "typed", "package typed\n\n# METADATA\n# schemas:\n#   - input: {\"type\": \"object\", \"required\": [\"user\"]}\nallow {\n    input.user == \"alice\"\n}\n")
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"typed","payload":{"user":"alice"}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"typed","payload":{}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "user is required")
+}
+
+func TestStatusForErrorURLTooLong(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", &policyloader.URLTooLongError{Key: "example", Length: 3000, Max: 2048})
+	require.Equal(t, http.StatusBadRequest, classifyError(err).status)
+}
+
+func TestDecodeJSONBody(t *testing.T) {
+	var req LambdaEvent
+	require.NoError(t, decodeJSONBody([]byte(`{"policy":"example"}`+"\n"), &req))
+	require.Equal(t, "example", req.PolicyName)
+
+	err := decodeJSONBody([]byte(`{"policy":"a"}`+"\n"+`{"policy":"b"}`), &req)
+	require.ErrorIs(t, err, errTrailingData)
+	require.ErrorContains(t, err, "offset 14")
+
+	require.ErrorIs(t, decodeJSONBody([]byte(`{"policy":"a"} trailing`), &req), errTrailingData)
+	require.Error(t, decodeJSONBody([]byte(`{"policy":`), &req))
+}
+
+func TestHandleLambdaAPIGatewayV2EventTrailingData(t *testing.T) {
+	body := string(buildLambdaEventPayloadBytes(t))
+	gwResp := invokeAPIGatewayV2(t, nil, body+"\n"+body)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "unexpected data after the JSON document")
+}
+
+func TestHandleLambdaAPIGatewayV2EventGzipBody(t *testing.T) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write(buildLambdaEventPayloadBytes(t))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	invoke := func(body []byte, headers map[string]string) events.APIGatewayV2HTTPResponse {
+		raw, err := json.Marshal(events.APIGatewayV2HTTPRequest{
+			Version:         "2.0",
+			RawPath:         "/opa",
+			Headers:         headers,
+			Body:            base64.StdEncoding.EncodeToString(body),
+			IsBase64Encoded: true,
+		})
+		require.NoError(t, err)
+		resp, err := handleLambda(context.Background(), raw)
+		require.NoError(t, err)
+		return resp.(events.APIGatewayV2HTTPResponse)
+	}
+
+	gwResp := invoke(compressed.Bytes(), map[string]string{"content-encoding": "gzip"})
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	assertExampleOutput(t, parseLambdaResponseBody(t, gwResp.Body).Output)
+
+	// A corrupt body is rejected rather than parsed as is.
+	gwResp = invoke(compressed.Bytes()[:compressed.Len()-8], map[string]string{"Content-Encoding": "gzip"})
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "invalid gzip body")
+
+	// Without the header the body is not decompressed.
+	gwResp = invoke(compressed.Bytes(), nil)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+}
+
+func TestGunzipBodyTooLarge(t *testing.T) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write(make([]byte, maxGzipBodyBytes+1))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	_, err = gunzipBody(compressed.Bytes(), "gzip")
+	require.ErrorContains(t, err, "once decompressed")
+
+	body, err := gunzipBody([]byte(`{}`), "identity")
+	require.NoError(t, err)
+	require.Equal(t, `{}`, string(body))
+}
+
+func TestHandleLambdaAPIGatewayV2EventBuildVersion(t *testing.T) {
+	body := string(buildLambdaEventPayloadBytes(t))
+
+	gwResp := invokeAPIGatewayV2(t, nil, body)
+	require.NotContains(t, gwResp.Headers, "X-Build-Version")
+
+	t.Setenv("BUILD_VERSION_HEADER", "true")
+	gwResp = invokeAPIGatewayV2(t, nil, body)
+	require.Equal(t, buildinfo.Version(), gwResp.Headers["X-Build-Version"])
+	require.Empty(t, parseLambdaResponseBody(t, gwResp.Body).BuildVersion)
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"example","payload":{},"include_build_version":true}`)
+	require.Equal(t, buildinfo.Version(), parseLambdaResponseBody(t, gwResp.Body).BuildVersion)
+}
+
+func TestHandleLambdaAPIGatewayV2EventRateLimited(t *testing.T) {
+	writeTestPolicy(t, "limited", "package limited\n\nallow = false\n\nrate_limited = true\n\nretry_after = input.retry_after\n")
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"limited","payload":{"retry_after":30}}`)
+	require.Equal(t, http.StatusTooManyRequests, gwResp.StatusCode)
+	require.Equal(t, "30", gwResp.Headers["Retry-After"])
+	require.Empty(t, gwResp.Headers["Cache-Control"])
+	require.Equal(t, true, parseLambdaResponseBody(t, gwResp.Body).Output.(map[string]interface{})["rate_limited"])
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"limited","format":"csv","payload":{"retry_after":-1}}`)
+	require.Equal(t, http.StatusTooManyRequests, gwResp.StatusCode)
+	require.NotContains(t, gwResp.Headers, "Retry-After")
+
+	writeTestPolicy(t, "limited", "package limited\n\nallow = true\n\nrate_limited = false\n")
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"limited","payload":{}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+}
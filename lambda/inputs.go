@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"opa_lambda/policyevaluator"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// evaluateInputs evaluates req.PolicyName once per named input of req.Inputs,
+// compiling the policy only once. The output maps each input name to the
+// policy's output for it, or null when the output is undefined, so policy
+// authors can see a matrix of decisions in one call.
+func evaluateInputs(ctx context.Context, req LambdaEvent) (LambdaResponse, error) {
+	if req.Payload != nil {
+		return LambdaResponse{}, invalidRequestError("payload and inputs are mutually exclusive")
+	}
+	if req.Coverage {
+		return LambdaResponse{}, invalidRequestError("coverage is not supported when evaluating several inputs")
+	}
+	if req.PolicyName == "" {
+		return LambdaResponse{}, errPolicyRequired
+	}
+	if err := checkPolicyAllowed(req.PolicyName); err != nil {
+		return LambdaResponse{}, err
+	}
+	if err := checkQuery(req.PolicyName, req.Query); err != nil {
+		return LambdaResponse{}, err
+	}
+
+	pe, opts, err := newRequestEvaluator(ctx, req)
+	if err != nil {
+		return LambdaResponse{}, err
+	}
+
+	raws := make(map[string][]byte, len(req.Inputs))
+	for name, raw := range req.Inputs {
+		if raws[name], err = prepareInput(ctx, req, raw); err != nil {
+			return LambdaResponse{}, fmt.Errorf("input %s: %w", name, err)
+		}
+	}
+
+	decisionLog(ctx).Debugf("Evaluating policy: %s against %d inputs", req.PolicyName, len(raws))
+
+	var results map[string]*policyevaluator.EvaluationResult
+	err = traceSubsegment(ctx, "evaluate_policy", func(ctx context.Context) error {
+		results, err = pe.EvaluatePolicyInputs(ctx, req.PolicyName, raws, opts)
+		return err
+	}, attribute.String("policy.name", req.PolicyName))
+	if err != nil {
+		return LambdaResponse{}, err
+	}
+
+	outputs := make(map[string]interface{}, len(results))
+	resp := LambdaResponse{Output: outputs, NoCache: req.NoCache}
+	for name, result := range results {
+		outputs[name] = nil
+		if !result.Undefined {
+			outputs[name] = result.Value
+		}
+		resp.Truncated = resp.Truncated || result.Truncated
+		resp.PolicyMetadata = result.Metadata
+		resp.Warnings = result.Warnings
+	}
+	logWarnings(ctx, resp.Warnings)
+	return resp, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateInputs(t *testing.T) {
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{
+		"policy": "example",
+		"inputs": {
+			"member": {"membership": {"user": {"login": "jane", "mail": "jane@example.com"}}},
+			"outsider": {"membership": {"user": {"login": "joe", "mail": "joe@elsewhere.com"}}}
+		}
+	}`))
+	require.NoError(t, err)
+
+	outputs, ok := resp.(LambdaResponse).Output.(map[string]interface{})
+	require.True(t, ok)
+	require.Len(t, outputs, 2)
+	require.Equal(t, true, outputs["member"].(map[string]interface{})["allow"])
+	require.Equal(t, false, outputs["outsider"].(map[string]interface{})["allow"])
+}
+
+func TestEvaluateInputsErrors(t *testing.T) {
+	_, err := handleLambda(context.Background(), json.RawMessage(`{"policy": "example", "payload": {}, "inputs": {"a": {}}}`))
+	require.ErrorContains(t, err, "payload and inputs are mutually exclusive")
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"inputs": {"a": {}}}`))
+	require.ErrorContains(t, err, "policy is required")
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy": "example", "coverage": true, "inputs": {"a": {}}}`))
+	require.ErrorContains(t, err, "coverage is not supported")
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policies": ["example"], "inputs": {"a": {}}}`))
+	require.ErrorContains(t, err, "inputs is not supported")
+}
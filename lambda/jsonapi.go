@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Response envelopes selected by RESPONSE_FORMAT.
+const (
+	envelopeDefault = "default"
+	envelopeJSONAPI = "jsonapi"
+)
+
+// jsonAPIMediaType is the media type of JSON:API documents.
+const jsonAPIMediaType = "application/vnd.api+json"
+
+// responseEnvelope returns the envelope HTTP responses are wrapped in, from
+// RESPONSE_FORMAT.
+func responseEnvelope() (string, error) {
+	switch envelope := strings.ToLower(strings.TrimSpace(os.Getenv("RESPONSE_FORMAT"))); envelope {
+	case "", envelopeDefault:
+		return envelopeDefault, nil
+	case envelopeJSONAPI:
+		return envelope, nil
+	default:
+		return "", fmt.Errorf("invalid RESPONSE_FORMAT %q: expected default or jsonapi", envelope)
+	}
+}
+
+// mediaType returns the media type of a response format. JSON responses are
+// JSON:API documents under RESPONSE_FORMAT=jsonapi.
+func mediaType(format string) string {
+	if envelope, _ := responseEnvelope(); format == formatJSON && envelope == envelopeJSONAPI {
+		return jsonAPIMediaType
+	}
+	return formatMediaTypes[format]
+}
+
+// jsonAPIDocument is a JSON:API top-level document, holding either a decision
+// resource or errors.
+type jsonAPIDocument struct {
+	Data   *jsonAPIResource `json:"data,omitempty"`
+	Errors []jsonAPIError   `json:"errors,omitempty"`
+}
+
+// jsonAPIResource is a decision as a JSON:API resource object.
+type jsonAPIResource struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// jsonAPIError is a JSON:API error object.
+type jsonAPIError struct {
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Code   string `json:"code,omitempty"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+}
+
+// newJSONAPIDocument wraps a response in a JSON:API document: a decision
+// resource identified by the decision ID, whose attributes are the fields of
+// the default envelope, or an errors array for failed requests.
+func newJSONAPIDocument(status int, body LambdaResponse) (jsonAPIDocument, error) {
+	if body.Error != "" {
+		return jsonAPIDocument{Errors: []jsonAPIError{{
+			ID:     body.DecisionID,
+			Status: strconv.Itoa(status),
+			Code:   body.ErrorCode,
+			Title:  http.StatusText(status),
+			Detail: body.Error,
+		}}}, nil
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return jsonAPIDocument{}, err
+	}
+	var attributes map[string]interface{}
+	if err := json.Unmarshal(raw, &attributes); err != nil {
+		return jsonAPIDocument{}, err
+	}
+	delete(attributes, "decision_id")
+	return jsonAPIDocument{Data: &jsonAPIResource{Type: "decision", ID: body.DecisionID, Attributes: attributes}}, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleLambdaAPIGatewayV2EventJSONAPI(t *testing.T) {
+	t.Setenv("RESPONSE_FORMAT", "jsonapi")
+	writeTestPolicy(t, "obligations", obligationsPolicy)
+
+	gwResp := invokeAPIGatewayV2(t, map[string]string{"accept": "application/vnd.api+json"}, `{"policy":"obligations","payload":{"user":"jane"}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, "application/vnd.api+json", gwResp.Headers["Content-Type"])
+
+	var doc jsonAPIDocument
+	require.NoError(t, json.Unmarshal([]byte(gwResp.Body), &doc))
+	require.Equal(t, "decision", doc.Data.Type)
+	require.Equal(t, gwResp.Headers[decisionIDHeader], doc.Data.ID)
+	require.Equal(t, true, doc.Data.Attributes["output"].(map[string]interface{})["allow"])
+	require.NotContains(t, doc.Data.Attributes, "decision_id")
+	require.NotContains(t, gwResp.Body, `"errors"`)
+
+	// Denials are decisions too.
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"obligations","payload":{"user":"joe"}}`)
+	require.Equal(t, http.StatusForbidden, gwResp.StatusCode)
+	require.NoError(t, json.Unmarshal([]byte(gwResp.Body), &doc))
+	require.Equal(t, "decision", doc.Data.Type)
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"payload":{}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.JSONEq(t, `{"errors":[{"status":"400","code":"POLICY_REQUIRED","title":"Bad Request","detail":"policy is required"}]}`, gwResp.Body)
+
+	gwResp = invokeAPIGatewayV2(t, map[string]string{"accept": "application/json"}, `{"policy":"example","payload":{}}`)
+	require.Equal(t, http.StatusNotAcceptable, gwResp.StatusCode)
+}
+
+func TestResponseEnvelopeInvalid(t *testing.T) {
+	t.Setenv("RESPONSE_FORMAT", "xml")
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"example","payload":{}}`)
+	require.Equal(t, http.StatusInternalServerError, gwResp.StatusCode)
+	require.Contains(t, gwResp.Body, "invalid RESPONSE_FORMAT")
+}
@@ -7,258 +7,560 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"opa_lambda/buildinfo"
 	"opa_lambda/policyevaluator"
 	"opa_lambda/policyloader"
+	"opa_lambda/ratelimit"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/open-policy-agent/opa/cover"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // A LambdaRequest is the event used to invoke the Lambda function.
 type LambdaEvent struct {
-	PolicyName string           `json:"policy"`  // The name of the OPA policy to check.
-	Payload    *json.RawMessage `json:"payload"` // The payload to evaluate the policy against.
+	PolicyName          string                     `json:"policy"`                          // The name of the OPA policy to check.
+	Payload             *json.RawMessage           `json:"payload"`                         // The payload to evaluate the policy against.
+	Coverage            bool                       `json:"coverage,omitempty"`              // Whether to return a line coverage report.
+	Format              string                     `json:"format,omitempty"`                // The HTTP response body format: "json" (default), "csv", or "ndjson" or "sse" for batches.
+	Payloads            []json.RawMessage          `json:"payloads,omitempty"`              // Payloads to evaluate the policy against one by one, instead of payload.
+	Policies            []string                   `json:"policies,omitempty"`              // Policies to evaluate against the payload, instead of policy.
+	MergeOutputs        bool                       `json:"merge_outputs,omitempty"`         // Whether to deep-merge the outputs of policies into one object.
+	FirstMatch          bool                       `json:"first_match,omitempty"`           // Whether to return the output of the first of policies whose decision matches.
+	MergeConflict       string                     `json:"merge_conflict,omitempty"`        // How merged outputs resolve conflicts: "override" (default), "keep", or "error".
+	Data                *json.RawMessage           `json:"data,omitempty"`                  // Reference data for the policy, available under data.
+	CandidateData       *json.RawMessage           `json:"candidate_data,omitempty"`        // Proposed reference data to compare against data.
+	Action              string                     `json:"action,omitempty"`                // A control action to run instead of an evaluation, such as "parse".
+	NoCache             bool                       `json:"no_cache,omitempty"`              // Whether to reload the policy from its source instead of a cached copy.
+	IncludeBuildVersion bool                       `json:"include_build_version,omitempty"` // Whether to return the version of the build that served the request.
+	IncludeMetadata     bool                       `json:"include_metadata,omitempty"`      // Whether to return the policy's METADATA annotation.
+	Inputs              map[string]json.RawMessage `json:"inputs,omitempty"`                // Named payloads to evaluate the policy against, instead of payload.
+	AggregateAllow      bool                       `json:"aggregate_allow,omitempty"`       // Whether a batch also returns whether every payload was allowed.
+	EvaluateAll         bool                       `json:"evaluate_all,omitempty"`          // Whether aggregate_allow evaluates every payload instead of stopping at the first deny.
+	MockData            map[string]json.RawMessage `json:"mock_data,omitempty"`             // Values replacing subtrees of data, keyed by data path, for testing.
+	PreviousDecision    *json.RawMessage           `json:"previous_decision,omitempty"`     // The decision the client tracked before, available to the policy as input.previous.
+	Query               string                     `json:"query,omitempty"`                 // A query within the policy's package replacing the default data.<policy>, such as data.authz.deny_reasons.
+	Seed                *int64                     `json:"seed,omitempty"`                  // Seeds rand.intn and other random built-ins for reproducible tests; requires ALLOW_EVALUATION_SEED.
+	Redact              []string                   `json:"redact,omitempty"`                // Output paths to mask in the returned output, in addition to the policy's redaction rules.
+
+	preview bool // Whether the request previews decisions, like the data snapshots of a comparison, rather than making them.
 }
 
 type LambdaResponse struct {
-	Output interface{} `json:"output,omitempty"` // The output of the policy evaluation.
-	Error  string      `json:"error,omitempty"`  // The error, if any, that occurred during policy evaluation.
+	Output         interface{}                     `json:"output,omitempty"`          // The output of the policy evaluation.
+	Error          string                          `json:"error,omitempty"`           // The error, if any, that occurred during policy evaluation.
+	ErrorCode      string                          `json:"error_code,omitempty"`      // The machine-readable code of the error, such as POLICY_NOT_FOUND.
+	Truncated      bool                            `json:"truncated,omitempty"`       // Whether arrays in the output were truncated to MAX_RESULT_ITEMS.
+	Coverage       *cover.Report                   `json:"coverage,omitempty"`        // The line coverage report, when requested.
+	TimedOut       bool                            `json:"timed_out,omitempty"`       // Whether the output is the TIMEOUT_DECISION default after an evaluation timeout.
+	NoCache        bool                            `json:"no_cache,omitempty"`        // Whether the policy was reloaded from its source for this request.
+	PolicyMetadata *policyevaluator.PolicyMetadata `json:"policy_metadata,omitempty"` // The policy's METADATA annotation, when requested.
+	BuildVersion   string                          `json:"build_version,omitempty"`   // The version of the build that served the request, when requested.
+	MatchedPolicy  string                          `json:"matched_policy,omitempty"`  // The policy whose output was returned under first_match.
+	DecisionID     string                          `json:"decision_id,omitempty"`     // The unique ID of the decision, also logged with its evaluation.
+	TraceID        string                          `json:"trace_id,omitempty"`        // The X-Ray trace ID of the invocation, with INCLUDE_TRACE_ID.
+	Warnings       []string                        `json:"warnings,omitempty"`        // Non-fatal problems with the policies evaluated, with INCLUDE_WARNINGS.
+	Obligations    []interface{}                   `json:"obligations,omitempty"`     // Actions the enforcement point must carry out, from an output of {allow, obligations}.
+	Undefined      bool                            `json:"-"`                         // Whether the policy produced no result.
 }
 
 // Handle requests for policy evaluation when running on AWS Lambda.
 func handleLambda(ctx context.Context, payload json.RawMessage) (interface{}, error) {
 	log.SetFormatter(&log.JSONFormatter{})
+	eventCtx, span := startInvocationSpan(ctx, payload)
+	finish := func() {
+		span.End()
+		flushTraces(ctx)
+		flushInvocationDecisionLogs(ctx)
+	}
+
+	// A streamed body ends the invocation itself, once it has been sent.
+	var stream *events.LambdaFunctionURLStreamingResponse
+	defer func() {
+		if stream == nil {
+			finish()
+		}
+	}()
 
+	resp, err := routeLambdaEvent(eventCtx, payload)
+	if streaming, ok := resp.(*events.LambdaFunctionURLStreamingResponse); ok && streaming != nil {
+		stream = streaming
+		stream.Body = &invocationStream{Reader: stream.Body, finish: finish}
+	}
+	return resp, err
+}
+
+// routeLambdaEvent handles an event with the handler for its type.
+func routeLambdaEvent(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	if isWarmupEvent(payload) {
+		return handleWarmup(ctx), nil
+	}
 	if isALBEvent(payload) {
 		return handleALBRequest(ctx, payload)
 	}
+	// Function URL events are API Gateway v2 events too, so they are
+	// recognized first.
+	if isFunctionURLEvent(payload) {
+		streaming, err := boolFromEnv("RESPONSE_STREAMING", false)
+		if err != nil {
+			return nil, err
+		}
+		if streaming {
+			return handleFunctionURLStreamingRequest(ctx, payload)
+		}
+		return handleFunctionURLRequest(ctx, payload)
+	}
+	// API Gateway cannot stream responses, so RESPONSE_STREAMING does not
+	// apply to its events.
 	if isAPIGatewayV2Event(payload) {
 		return handleAPIGatewayV2Request(ctx, payload)
 	}
 	if isAPIGatewayProxyEvent(payload) {
 		return handleAPIGatewayProxyRequest(ctx, payload)
 	}
+	if isSQSEvent(payload) {
+		return handleSQSEvent(ctx, payload)
+	}
+	if isS3BatchEvent(payload) {
+		return handleS3BatchEvent(ctx, payload)
+	}
+
+	if isDirectBatchEvent(payload) {
+		return handleDirectBatchEvent(ctx, payload)
+	}
 
 	return handleDirectLambdaEvent(ctx, payload)
 }
 
 func handleDirectLambdaEvent(ctx context.Context, payload json.RawMessage) (LambdaResponse, error) {
 	var req LambdaEvent
-	if err := json.Unmarshal(payload, &req); err != nil {
-		err = fmt.Errorf("unable to parse lambda payload: %w", err)
+	if err := decodeEnvelope(payload, &req); err != nil {
+		err = invalidPayloadError(fmt.Errorf("unable to parse lambda payload: %w", err))
 		log.Error(err)
-		return LambdaResponse{Error: err.Error()}, err
+		return errorResponse(err), err
+	}
+
+	if req.Action != "" {
+		resp, err := handleControlEvent(ctx, req)
+		if err != nil {
+			log.Error(err)
+			return errorResponse(err), err
+		}
+		return resp, nil
 	}
 
-	value, err := evaluatePolicy(ctx, req)
+	resp, err := evaluatePolicy(ctx, req)
 	if err != nil {
 		log.Error(err)
-		return LambdaResponse{Error: err.Error()}, err
+		return errorResponse(err), err
 	}
 
-	return LambdaResponse{Output: value}, nil
+	return resp, nil
 }
 
 func handleALBRequest(ctx context.Context, payload json.RawMessage) (events.ALBTargetGroupResponse, error) {
 	var req events.ALBTargetGroupRequest
 	if err := json.Unmarshal(payload, &req); err != nil {
-		err = fmt.Errorf("unable to parse ALB payload: %w", err)
+		err = invalidPayloadError(fmt.Errorf("unable to parse ALB payload: %w", err))
 		log.Error(err)
-		return newALBErrorResponse(http.StatusBadRequest, err), nil
+		return newALBResponse(newHTTPErrorResponse(err)), nil
 	}
 
-	body, err := decodeBody(req.Body, req.IsBase64Encoded)
-	if err != nil {
-		log.Error(err)
-		return newALBErrorResponse(http.StatusBadRequest, err), nil
+	headers := req.Headers
+	if len(headers) == 0 {
+		headers = firstHeaderValues(req.MultiValueHeaders)
 	}
 
-	var lambdaReq LambdaEvent
-	if err := json.Unmarshal(body, &lambdaReq); err != nil {
-		err = fmt.Errorf("unable to parse ALB body: %w", err)
-		log.Error(err)
-		return newALBErrorResponse(http.StatusBadRequest, err), nil
-	}
-
-	value, err := evaluatePolicy(ctx, lambdaReq)
-	if err != nil {
-		log.Error(err)
-		return newALBErrorResponse(http.StatusInternalServerError, err), nil
-	}
-
-	return newALBResponse(http.StatusOK, LambdaResponse{Output: value}), nil
+	resp := handleHTTPRequest(ctx, httpRequest{
+		Integration:     "ALB",
+		Path:            req.Path,
+		Body:            req.Body,
+		IsBase64Encoded: req.IsBase64Encoded,
+		Headers:         headers,
+	})
+	return newALBResponse(resp), nil
 }
 
 func handleAPIGatewayProxyRequest(ctx context.Context, payload json.RawMessage) (events.APIGatewayProxyResponse, error) {
 	var req events.APIGatewayProxyRequest
 	if err := json.Unmarshal(payload, &req); err != nil {
-		err = fmt.Errorf("unable to parse API Gateway proxy payload: %w", err)
+		err = invalidPayloadError(fmt.Errorf("unable to parse API Gateway proxy payload: %w", err))
 		log.Error(err)
-		return newAPIGatewayProxyErrorResponse(http.StatusBadRequest, err), nil
+		return newAPIGatewayProxyResponse(newHTTPErrorResponse(err)), nil
 	}
 
-	body, err := decodeBody(req.Body, req.IsBase64Encoded)
-	if err != nil {
-		log.Error(err)
-		return newAPIGatewayProxyErrorResponse(http.StatusBadRequest, err), nil
-	}
+	resp := handleHTTPRequest(ctx, httpRequest{
+		Integration:     "API Gateway",
+		Path:            req.Path,
+		Body:            req.Body,
+		IsBase64Encoded: req.IsBase64Encoded,
+		Headers:         req.Headers,
+	})
+	return newAPIGatewayProxyResponse(resp), nil
+}
 
-	var lambdaReq LambdaEvent
-	if err := json.Unmarshal(body, &lambdaReq); err != nil {
-		err = fmt.Errorf("unable to parse API Gateway body: %w", err)
+func handleAPIGatewayV2Request(ctx context.Context, payload json.RawMessage) (events.APIGatewayV2HTTPResponse, error) {
+	var req events.APIGatewayV2HTTPRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		err = invalidPayloadError(fmt.Errorf("unable to parse API Gateway v2 payload: %w", err))
 		log.Error(err)
-		return newAPIGatewayProxyErrorResponse(http.StatusBadRequest, err), nil
+		return newAPIGatewayV2Response(newHTTPErrorResponse(err)), nil
 	}
 
-	value, err := evaluatePolicy(ctx, lambdaReq)
-	if err != nil {
+	resp := handleHTTPRequest(ctx, httpRequest{
+		Integration:     "API Gateway v2",
+		Path:            req.RawPath,
+		Body:            req.Body,
+		IsBase64Encoded: req.IsBase64Encoded,
+		Headers:         req.Headers,
+	})
+	return newAPIGatewayV2Response(resp), nil
+}
+
+// handleFunctionURLRequest serves a Lambda Function URL whose InvokeMode is
+// BUFFERED.
+func handleFunctionURLRequest(ctx context.Context, payload json.RawMessage) (events.LambdaFunctionURLResponse, error) {
+	var req events.LambdaFunctionURLRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		err = invalidPayloadError(fmt.Errorf("unable to parse Function URL payload: %w", err))
 		log.Error(err)
-		return newAPIGatewayProxyErrorResponse(http.StatusInternalServerError, err), nil
+		return newFunctionURLResponse(newHTTPErrorResponse(err)), nil
 	}
 
-	return newAPIGatewayProxyResponse(http.StatusOK, LambdaResponse{Output: value}), nil
+	resp := handleHTTPRequest(ctx, functionURLHTTPRequest(req))
+	return newFunctionURLResponse(resp), nil
 }
 
-func handleAPIGatewayV2Request(ctx context.Context, payload json.RawMessage) (events.APIGatewayV2HTTPResponse, error) {
-	var req events.APIGatewayV2HTTPRequest
+// handleFunctionURLStreamingRequest serves a Lambda Function URL whose
+// InvokeMode is RESPONSE_STREAM, so NDJSON batch results reach the client as
+// they are evaluated.
+func handleFunctionURLStreamingRequest(ctx context.Context, payload json.RawMessage) (*events.LambdaFunctionURLStreamingResponse, error) {
+	var req events.LambdaFunctionURLRequest
 	if err := json.Unmarshal(payload, &req); err != nil {
-		err = fmt.Errorf("unable to parse API Gateway v2 payload: %w", err)
+		err = invalidPayloadError(fmt.Errorf("unable to parse Function URL payload: %w", err))
 		log.Error(err)
-		return newAPIGatewayV2ErrorResponse(http.StatusBadRequest, err), nil
+		return newFunctionURLStreamingResponse(newHTTPErrorResponse(err)), nil
 	}
 
-	body, err := decodeBody(req.Body, req.IsBase64Encoded)
-	if err != nil {
-		log.Error(err)
-		return newAPIGatewayV2ErrorResponse(http.StatusBadRequest, err), nil
+	resp := handleHTTPRequest(ctx, functionURLHTTPRequest(req))
+	return newFunctionURLStreamingResponse(resp), nil
+}
+
+// functionURLHTTPRequest adapts a Function URL request. Function URLs move the
+// Cookie header into a separate cookies list, which is joined back into the
+// header so that handlers see the request as the client sent it.
+func functionURLHTTPRequest(req events.LambdaFunctionURLRequest) httpRequest {
+	headers := req.Headers
+	if len(req.Cookies) > 0 {
+		headers = make(map[string]string, len(req.Headers)+1)
+		for key, value := range req.Headers {
+			headers[key] = value
+		}
+		headers["cookie"] = strings.Join(req.Cookies, "; ")
 	}
 
-	var lambdaReq LambdaEvent
-	if err := json.Unmarshal(body, &lambdaReq); err != nil {
-		err = fmt.Errorf("unable to parse API Gateway v2 body: %w", err)
-		log.Error(err)
-		return newAPIGatewayV2ErrorResponse(http.StatusBadRequest, err), nil
+	return httpRequest{
+		Integration:     "Function URL",
+		Path:            req.RawPath,
+		Body:            req.Body,
+		IsBase64Encoded: req.IsBase64Encoded,
+		Headers:         headers,
 	}
+}
 
-	value, err := evaluatePolicy(ctx, lambdaReq)
+// evaluatePolicy evaluates a request under a decision ID, stamping the
+// response with it, with the X-Ray trace ID under INCLUDE_TRACE_ID and, when
+// asked to, with the build version. Policy warnings are always logged but
+// only returned under INCLUDE_WARNINGS. The decision's outcome is logged,
+// subject to LOG_SAMPLE_RATE, and the decision logger records it once, with
+// the result of the whole request rather than of each policy it evaluates.
+func evaluatePolicy(ctx context.Context, req LambdaEvent) (LambdaResponse, error) {
+	includeTraceID, err := boolFromEnv("INCLUDE_TRACE_ID", false)
 	if err != nil {
-		log.Error(err)
-		return newAPIGatewayV2ErrorResponse(http.StatusInternalServerError, err), nil
+		return LambdaResponse{}, err
 	}
-
-	return newAPIGatewayV2Response(http.StatusOK, LambdaResponse{Output: value}), nil
+	includeWarnings, err := boolFromEnv("INCLUDE_WARNINGS", false)
+	if err != nil {
+		return LambdaResponse{}, err
+	}
+	sampleRate, err := fractionFromEnv("LOG_SAMPLE_RATE", 1)
+	if err != nil {
+		return LambdaResponse{}, err
+	}
+	logTimings, err := boolFromEnv("LOG_TIMINGS", false)
+	if err != nil {
+		return LambdaResponse{}, err
+	}
+	recorder, err := sharedDecisionLogger()
+	if err != nil {
+		return LambdaResponse{}, err
+	}
+	redaction, err := requestedRedaction(req)
+	if err != nil {
+		return LambdaResponse{}, err
+	}
+
+	// Policies evaluated as part of a larger decision are redacted once, as
+	// the decision they belong to is returned.
+	nested := decisionID(ctx) != ""
+	ctx, id := withDecisionID(ctx)
+	if logTimings {
+		ctx = withDecisionTimings(ctx)
+	}
+	start := time.Now()
+	req, err = selectRequestPolicy(req)
+	var resp LambdaResponse
+	if err == nil {
+		resp, err = evaluateRequest(ctx, req)
+	}
+	elapsed := time.Since(start)
+	logDecision(ctx, req, resp, err, sampleRate, elapsed)
+	if !nested {
+		recordDecisionMetrics(req, resp, err, elapsed)
+		if recorder != nil {
+			recordDecision(ctx, recorder, req, resp, err, start)
+		}
+	}
+	if err != nil {
+		return resp, err
+	}
+	if !nested {
+		if resp, err = redactResponse(ctx, req, resp, redaction); err != nil {
+			return resp, err
+		}
+	}
+	resp.DecisionID = id
+	if !includeWarnings {
+		resp.Warnings = nil
+	}
+	if includeTraceID {
+		resp.TraceID = traceID(ctx)
+	}
+	if req.IncludeBuildVersion {
+		resp.BuildVersion = buildinfo.Version()
+	}
+	return resp, nil
 }
 
-func decodeBody(body string, isBase64Encoded bool) ([]byte, error) {
-	if body == "" {
-		return nil, errors.New("request body is required")
+func evaluateRequest(ctx context.Context, req LambdaEvent) (LambdaResponse, error) {
+	if req.NoCache {
+		ctx = policyloader.WithRevalidation(ctx)
+	}
+	if req.CandidateData != nil {
+		return compareDataSnapshots(ctx, req)
+	}
+	if len(req.Policies) > 0 {
+		return evaluatePolicies(ctx, req)
+	}
+	if req.Inputs != nil {
+		return evaluateInputs(ctx, req)
+	}
+	if req.PolicyName == "" {
+		return LambdaResponse{}, errPolicyRequired
+	}
+	if req.Payload == nil {
+		return LambdaResponse{}, errPayloadRequired
+	}
+	if err := checkPolicyAllowed(req.PolicyName); err != nil {
+		return LambdaResponse{}, err
+	}
+	if err := checkQuery(req.PolicyName, req.Query); err != nil {
+		return LambdaResponse{}, err
 	}
 
-	if isBase64Encoded {
-		decoded, err := base64.StdEncoding.DecodeString(body)
-		if err != nil {
-			return nil, fmt.Errorf("invalid base64 body: %w", err)
-		}
-		return decoded, nil
+	pe, opts, err := newRequestEvaluator(ctx, req)
+	if err != nil {
+		return LambdaResponse{}, err
+	}
+	payload, err := prepareInput(ctx, req, *req.Payload)
+	if err != nil {
+		return LambdaResponse{}, err
 	}
 
-	return []byte(body), nil
+	decisionLog(ctx).Debugf("Evaluating policy: %s", req.PolicyName)
+
+	var result *policyevaluator.EvaluationResult
+	err = traceSubsegment(ctx, "evaluate_policy", func(ctx context.Context) error {
+		result, err = pe.EvaluatePolicyWithOptions(ctx, req.PolicyName, payload, opts)
+		return err
+	}, attribute.String("policy.name", req.PolicyName))
+	if errors.Is(err, policyevaluator.ErrEvaluationTimeout) {
+		resp, err := timeoutResponse(req.PolicyName, err)
+		resp.NoCache = req.NoCache
+		return resp, err
+	}
+	if err != nil {
+		return LambdaResponse{}, err
+	}
+	logWarnings(ctx, result.Warnings)
+	obligations, _ := decisionObligations(result.Value)
+
+	return LambdaResponse{
+		Output:         result.Value,
+		Truncated:      result.Truncated,
+		Coverage:       result.Coverage,
+		Undefined:      result.Undefined,
+		NoCache:        req.NoCache,
+		PolicyMetadata: result.Metadata,
+		Warnings:       result.Warnings,
+		Obligations:    obligations,
+	}, nil
 }
 
-func newALBErrorResponse(status int, err error) events.ALBTargetGroupResponse {
-	return newALBResponse(status, LambdaResponse{Error: err.Error()})
+// logWarnings logs the warnings of a policy under the decision being made.
+func logWarnings(ctx context.Context, warnings []string) {
+	for _, warning := range warnings {
+		decisionLog(ctx).Warnf("Policy warning: %s", warning)
+	}
 }
 
-func newALBResponse(status int, body LambdaResponse) events.ALBTargetGroupResponse {
-	payload, err := json.Marshal(body)
+// newEvaluator returns an evaluator backed by the shared policy loader along
+// with the deployment-wide evaluation options.
+func newEvaluator(ctx context.Context) (*policyevaluator.PolicyEvaluator, policyevaluator.EvaluationOptions, error) {
+	opts, err := evaluationOptionsFromEnv()
 	if err != nil {
-		log.Errorf("unable to marshal ALB response: %v", err)
-		status = http.StatusInternalServerError
-		payload = []byte(fmt.Sprintf(`{"error":"%s"}`, http.StatusText(status)))
+		return nil, opts, err
 	}
 
-	return events.ALBTargetGroupResponse{
-		StatusCode:        status,
-		StatusDescription: fmt.Sprintf("%d %s", status, http.StatusText(status)),
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
-		Body:            string(payload),
-		IsBase64Encoded: false,
+	pe, err := sharedEvaluator(ctx)
+	if err != nil {
+		return nil, opts, err
 	}
-}
 
-func newAPIGatewayProxyErrorResponse(status int, err error) events.APIGatewayProxyResponse {
-	return newAPIGatewayProxyResponse(status, LambdaResponse{Error: err.Error()})
+	if opts.RateLimiter, err = sharedRateLimiter(); err != nil {
+		return nil, opts, err
+	}
+	opts.Metrics = decisionTimings(ctx)
+
+	return pe, opts, nil
 }
 
-func newAPIGatewayProxyResponse(status int, body LambdaResponse) events.APIGatewayProxyResponse {
-	payload, err := json.Marshal(body)
+// newRequestEvaluator returns an evaluator along with the options a request
+// is evaluated with: the deployment-wide options, the request's own, and the
+// data it is evaluated against.
+func newRequestEvaluator(ctx context.Context, req LambdaEvent) (*policyevaluator.PolicyEvaluator, policyevaluator.EvaluationOptions, error) {
+	pe, opts, err := newEvaluator(ctx)
 	if err != nil {
-		log.Errorf("unable to marshal API Gateway response: %v", err)
-		status = http.StatusInternalServerError
-		payload = []byte(fmt.Sprintf(`{"error":"%s"}`, http.StatusText(status)))
+		return nil, opts, err
 	}
-
-	return events.APIGatewayProxyResponse{
-		StatusCode:      status,
-		Headers:         map[string]string{"Content-Type": "application/json"},
-		Body:            string(payload),
-		IsBase64Encoded: false,
+	opts.Coverage = req.Coverage
+	opts.IncludeMetadata = req.IncludeMetadata
+	opts.Query = req.Query
+	if opts.Seed, err = requestSeed(req.Seed); err != nil {
+		return nil, opts, err
+	}
+	requested, err := parseData("data", req.Data)
+	if err != nil {
+		return nil, opts, err
+	}
+	// The deployment's data is versioned by each of its sources, so that
+	// the evaluator builds its store once per version. The request's data is
+	// layered over it.
+	var versions [3]string
+	if opts.Data, versions[0], err = policyData(ctx, req.PolicyName); err != nil {
+		return nil, opts, err
 	}
+	if opts.Data, versions[1], err = withEnvironmentData(ctx, opts.Data); err != nil {
+		return nil, opts, err
+	}
+	if err = checkRequestData(opts.Data, requested); err != nil {
+		return nil, opts, err
+	}
+	if opts.Data, versions[2], err = withFeatureFlags(ctx, opts.Data, requested); err != nil {
+		return nil, opts, err
+	}
+	opts.DataVersion = strings.Join(versions[:], "/")
+	opts.RequestData = requested
+	if opts.MockData, err = parseMockData(req.MockData); err != nil {
+		return nil, opts, err
+	}
+	opts.ReservedData = []string{flagsDataKey}
+	// Previews, what-if matrices and tests against mocks must not spend the
+	// tokens of the decisions clients actually make.
+	if opts.RateLimiter != nil && (req.preview || req.Inputs != nil || opts.MockData != nil) {
+		opts.RateLimiter = ratelimit.DryRun
+	}
+	return pe, opts, nil
 }
 
-func newAPIGatewayV2ErrorResponse(status int, err error) events.APIGatewayV2HTTPResponse {
-	return newAPIGatewayV2Response(status, LambdaResponse{Error: err.Error()})
-}
+var (
+	loaderMu  sync.Mutex
+	loader    policyloader.PolicyLoader
+	evaluator *policyevaluator.PolicyEvaluator
+)
 
-func newAPIGatewayV2Response(status int, body LambdaResponse) events.APIGatewayV2HTTPResponse {
-	payload, err := json.Marshal(body)
+// sharedEvaluator returns the evaluator reused across warm invocations, so
+// the queries it compiled survive between requests.
+func sharedEvaluator(ctx context.Context) (*policyevaluator.PolicyEvaluator, error) {
+	pl, err := sharedPolicyLoader(ctx)
 	if err != nil {
-		log.Errorf("unable to marshal API Gateway v2 response: %v", err)
-		status = http.StatusInternalServerError
-		payload = []byte(fmt.Sprintf(`{"error":"%s"}`, http.StatusText(status)))
+		return nil, err
 	}
 
-	return events.APIGatewayV2HTTPResponse{
-		StatusCode:      status,
-		Headers:         map[string]string{"Content-Type": "application/json"},
-		Body:            string(payload),
-		IsBase64Encoded: false,
+	loaderMu.Lock()
+	defer loaderMu.Unlock()
+	if evaluator == nil {
+		verification, err := policyloader.BundleVerificationFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		evaluator = policyevaluator.NewPolicyEvaluatorWithBundleVerification(tracedPolicyLoader{pl}, verification)
 	}
+	return evaluator, nil
 }
 
-func evaluatePolicy(ctx context.Context, req LambdaEvent) (interface{}, error) {
-	if req.PolicyName == "" {
-		return nil, errors.New("policy is required")
-	}
-	if req.Payload == nil {
-		return nil, errors.New("payload is required")
-	}
+// sharedPolicyLoader returns the loader reused across warm invocations, so its
+// in-memory caches and poll windows survive between requests.
+func sharedPolicyLoader(ctx context.Context) (policyloader.PolicyLoader, error) {
+	loaderMu.Lock()
+	defer loaderMu.Unlock()
 
-	log.Infof("Evaluating policy: %s", req.PolicyName)
+	if loader != nil {
+		return loader, nil
+	}
 
 	pl, err := policyloader.NewPolicyLoader(ctx)
 	if err != nil {
 		return nil, err
 	}
+	loader = pl
+	return loader, nil
+}
 
-	pe := policyevaluator.NewPolicyEvaluator(pl)
-	result, err := pe.EvaluatePolicy(ctx, req.PolicyName, *req.Payload)
+var (
+	rateLimiterMu   sync.Mutex
+	rateLimiter     ratelimit.Limiter
+	rateLimiterInit bool
+)
+
+// sharedRateLimiter returns the limiter behind ratelimit.allow, reused across
+// warm invocations so in-memory buckets persist. It is nil unless
+// RATE_LIMIT_BACKEND is set.
+func sharedRateLimiter() (ratelimit.Limiter, error) {
+	rateLimiterMu.Lock()
+	defer rateLimiterMu.Unlock()
+
+	if rateLimiterInit {
+		return rateLimiter, nil
+	}
+
+	limiter, err := ratelimit.NewLimiterFromEnv()
 	if err != nil {
 		return nil, err
 	}
-
-	return result.Value, nil
+	rateLimiter, rateLimiterInit = limiter, true
+	return rateLimiter, nil
 }
 
 func isALBEvent(payload json.RawMessage) bool {
@@ -298,10 +600,27 @@ func isAPIGatewayProxyEvent(payload json.RawMessage) bool {
 	return probe.Resource != "" || probe.RequestContext.ApiID != "" || probe.RequestContext.Stage != ""
 }
 
-func isAPIGatewayV2Event(payload json.RawMessage) bool {
+// isFunctionURLEvent recognizes Lambda Function URL requests. They use the API
+// Gateway v2 payload format, but are served from a lambda-url domain.
+func isFunctionURLEvent(payload json.RawMessage) bool {
 	var probe struct {
 		Version        string `json:"version"`
-		RawPath        string `json:"rawPath"`
+		RequestContext struct {
+			DomainName string `json:"domainName"`
+		} `json:"requestContext"`
+	}
+
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return false
+	}
+
+	return probe.Version == "2.0" && strings.Contains(probe.RequestContext.DomainName, ".lambda-url.")
+}
+
+func isAPIGatewayV2Event(payload json.RawMessage) bool {
+	var probe struct {
+		Version string `json:"version"`
+		RawPath string `json:"rawPath"`
 	}
 
 	if err := json.Unmarshal(payload, &probe); err != nil {
@@ -324,12 +643,12 @@ func handleLocal() {
 	payload := json.RawMessage(input)
 	req := LambdaEvent{PolicyName: os.Args[1], Payload: &payload}
 
-	value, err := evaluatePolicy(ctx, req)
+	resp, err := evaluatePolicy(ctx, req)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	output, err := json.Marshal(value)
+	output, err := json.Marshal(resp.Output)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -337,10 +656,35 @@ func handleLocal() {
 	log.Println(string(output))
 }
 
+// initRuntime sets up tracing and builds the policy loader before the first
+// request, so that a misconfigured backend fails the function at startup
+// rather than every request.
+func initRuntime() {
+	ctx := context.Background()
+	if err := initTracerProvider(ctx); err != nil {
+		log.WithError(err).Fatal("Unable to export traces")
+	}
+	if _, err := sharedPolicyLoader(ctx); err != nil {
+		log.WithError(err).Fatal("Invalid policy loader configuration")
+	}
+}
+
 func main() {
 	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
 		// Lambda Environment
-		lambda.Start(handleLambda)
+		log.SetFormatter(&log.JSONFormatter{})
+		log.WithFields(buildinfo.Fields()).Info("Starting opa-lambda")
+		initRuntime()
+		lambda.StartWithOptions(handleLambda, lambda.WithEnableSIGTERM(flushDecisionLogs, shutdownTraces))
+	} else if addr := os.Getenv("GRPC_LISTEN_ADDR"); addr != "" {
+		// Long-running server
+		log.SetFormatter(&log.JSONFormatter{})
+		log.WithFields(buildinfo.Fields()).Info("Starting opa-lambda")
+		initRuntime()
+		if metricsAddr := os.Getenv("METRICS_LISTEN_ADDR"); metricsAddr != "" {
+			go func() { log.Fatal(serveMetrics(metricsAddr)) }()
+		}
+		log.Fatal(serveGRPC(addr))
 	} else {
 		// Local development
 		handleLocal()
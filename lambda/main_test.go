@@ -5,8 +5,12 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"net/http"
+	"os"
+	"strings"
 	"testing"
 
+	"opa_lambda/policyevaluator"
+
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/stretchr/testify/require"
 )
@@ -107,6 +111,89 @@ func TestHandleLambdaAPIGatewayV2EventBase64(t *testing.T) {
 	assertExampleOutput(t, lr.Output)
 }
 
+func TestHandleLambdaAPIGatewayV2EventLenientBase64(t *testing.T) {
+	// The body is flagged as base64-encoded but sent as is.
+	raw, err := json.Marshal(events.APIGatewayV2HTTPRequest{
+		Version:         "2.0",
+		RawPath:         "/opa",
+		Body:            string(buildLambdaEventPayloadBytes(t)),
+		IsBase64Encoded: true,
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			APIID: "def456",
+		},
+	})
+	require.NoError(t, err)
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+	gwResp := resp.(events.APIGatewayV2HTTPResponse)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "invalid base64 body")
+
+	t.Setenv("LENIENT_BASE64_BODY", "true")
+	resp, err = handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+	gwResp = resp.(events.APIGatewayV2HTTPResponse)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	assertExampleOutput(t, parseLambdaResponseBody(t, gwResp.Body).Output)
+
+	// Valid base64 is still decoded.
+	body, err := decodeBody(base64.StdEncoding.EncodeToString([]byte(`{"a":1}`)), true)
+	require.NoError(t, err)
+	require.Equal(t, `{"a":1}`, string(body))
+
+	t.Setenv("LENIENT_BASE64_BODY", "maybe")
+	_, err = decodeBody("not base64!", true)
+	require.ErrorContains(t, err, "LENIENT_BASE64_BODY")
+}
+
+func TestHandleLambdaFunctionURLEvent(t *testing.T) {
+	raw, err := os.ReadFile("inputs/function-url-event.json")
+	require.NoError(t, err)
+	require.True(t, isFunctionURLEvent(raw))
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+
+	urlResp, ok := resp.(events.LambdaFunctionURLResponse)
+	require.True(t, ok)
+	require.Equal(t, http.StatusOK, urlResp.StatusCode)
+	require.Equal(t, true, parseLambdaResponseBody(t, urlResp.Body).Output.(map[string]interface{})["allow"])
+
+	var event events.LambdaFunctionURLRequest
+	require.NoError(t, json.Unmarshal(raw, &event))
+	require.Equal(t, "session=abc123; theme=dark", functionURLHTTPRequest(event).header("Cookie"))
+	require.Empty(t, event.Headers["cookie"])
+}
+
+func TestIsFunctionURLEvent(t *testing.T) {
+	for _, name := range []string{"apigw-v2-event.json", "apigw-proxy-event.json", "alb-event.json", "lambda-event.json"} {
+		raw, err := os.ReadFile("inputs/" + name)
+		require.NoError(t, err)
+		require.False(t, isFunctionURLEvent(raw), name)
+	}
+}
+
+func TestHandleLambdaDirectEventCoverage(t *testing.T) {
+	ctx := context.Background()
+
+	var event map[string]interface{}
+	require.NoError(t, json.Unmarshal(buildLambdaEventPayloadBytes(t), &event))
+	event["coverage"] = true
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	resp, err := handleLambda(ctx, raw)
+	require.NoError(t, err)
+
+	lambdaResp, ok := resp.(LambdaResponse)
+	require.True(t, ok)
+	assertExampleOutput(t, lambdaResp.Output)
+	require.NotNil(t, lambdaResp.Coverage)
+	require.Contains(t, lambdaResp.Coverage.Files, "example.rego")
+	require.Positive(t, lambdaResp.Coverage.CoveredLines)
+}
+
 func buildLambdaEventPayload(t *testing.T) json.RawMessage {
 	t.Helper()
 	body := buildLambdaEventPayloadBytes(t)
@@ -148,3 +235,85 @@ func assertExampleOutput(t *testing.T, output interface{}) {
 	require.Equal(t, "jane", result["user"])
 	require.Equal(t, "jane@example.com", result["email"])
 }
+
+func TestEvaluatePolicyPackageQuery(t *testing.T) {
+	ctx := context.Background()
+	pe, _, err := newEvaluator(ctx)
+	require.NoError(t, err)
+	payload := []byte(`{"membership":{"user":{"login":"jane","mail":"jane@example.com"}}}`)
+
+	// The default query is the package, so every rule is returned as one object.
+	result, err := pe.EvaluatePolicy(ctx, "example", payload)
+	require.NoError(t, err)
+	assertExampleOutput(t, result.Value)
+	require.Len(t, result.Value, 3)
+
+	// A rule query collapses the result to that rule's value.
+	result, err = pe.EvaluatePolicyWithOptions(ctx, "example", payload, policyevaluator.EvaluationOptions{Query: "data.example.allow"})
+	require.NoError(t, err)
+	require.Equal(t, true, result.Value)
+}
+
+func TestHandleLambdaDirectEventIncludeMetadata(t *testing.T) {
+	writeTestPolicy(t, "annotated", "# METADATA\n# title: Annotated\n# custom:\n#   owner: team-identity\npackage annotated\n\nallow = true\n")
+
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"annotated","payload":{},"include_metadata":true}`))
+	require.NoError(t, err)
+
+	raw, err := json.Marshal(resp)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"output":{"allow":true},"policy_metadata":{"title":"Annotated","custom":{"owner":"team-identity"}}}`, withoutDecisionIDs(string(raw)))
+}
+
+func TestHandleLambdaDirectEventWarnings(t *testing.T) {
+	writeTestPolicy(t, "legacy", "package legacy\n\nallow {\n    re_match(\"^a\", input.user)\n}\n")
+	event := json.RawMessage(`{"policy":"legacy","payload":{"user":"alice"}}`)
+
+	// Deprecated built-ins do not stop the evaluation, and are only reported
+	// when asked to.
+	resp, err := handleLambda(context.Background(), event)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"allow": true}, resp.(LambdaResponse).Output)
+	require.Empty(t, resp.(LambdaResponse).Warnings)
+
+	t.Setenv("INCLUDE_WARNINGS", "true")
+	resp, err = handleLambda(context.Background(), event)
+	require.NoError(t, err)
+	require.Equal(t, []string{"legacy.rego:4: re_match is deprecated"}, resp.(LambdaResponse).Warnings)
+
+	resp, err = handleLambda(context.Background(), json.RawMessage(`{"policies":["legacy","example"],"payload":{"user":"alice"}}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"legacy.rego:4: re_match is deprecated"}, resp.(LambdaResponse).Warnings)
+}
+
+func TestHandleLambdaDirectEventDivisionByZero(t *testing.T) {
+	// Rego numbers are arbitrary-precision and division by zero is a built-in
+	// error, so ratios never reach the response as NaN or Inf.
+	writeTestPolicy(t, "ratio", "package ratio\n\nratio = input.hits / input.total\n\nhuge = 1e400 * 1e400\n")
+
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"ratio","payload":{"hits":0,"total":0}}`))
+	require.NoError(t, err)
+	raw, err := json.Marshal(resp)
+	require.NoError(t, err)
+	require.NotContains(t, string(raw), "ratio")
+	require.Contains(t, string(raw), `"huge":1000000000000000000`)
+
+	t.Setenv("STRICT_BUILTIN_ERRORS", "true")
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"ratio","payload":{"hits":0,"total":0}}`))
+	require.ErrorContains(t, err, "divide by zero")
+}
+
+func TestHandleLambdaDirectEventResultTooDeep(t *testing.T) {
+	writeTestPolicy(t, "echo", "package echo\n\nresult := input\n")
+	deep := strings.Repeat(`{"a":`, 1500) + "1" + strings.Repeat("}", 1500)
+	event := json.RawMessage(`{"policy":"echo","payload":` + deep + `}`)
+
+	_, err := handleLambda(context.Background(), event)
+	require.ErrorIs(t, err, policyevaluator.ErrResultTooDeep)
+
+	t.Setenv("MAX_RESULT_DEPTH", "0")
+	resp, err := handleLambda(context.Background(), event)
+	require.NoError(t, err)
+	_, err = json.Marshal(resp)
+	require.NoError(t, err)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Conflict strategies for merging the outputs of several policies. A
+// conflict is a key present in two outputs whose values differ and are not
+// both objects; objects are always merged key by key.
+const (
+	mergeOverride = "override" // The later policy's value wins (default).
+	mergeKeep     = "keep"     // The earlier policy's value wins.
+	mergeError    = "error"    // The request fails.
+)
+
+var (
+	// errMergeConflict is returned for conflicting outputs under the "error" strategy.
+	errMergeConflict = errors.New("conflicting policy outputs")
+	// errOutputNotObject is returned when an output to be merged is not an object.
+	errOutputNotObject = errors.New("only object outputs can be merged")
+)
+
+// evaluatePolicies evaluates each of req.Policies against the payload. The
+// output maps each policy name to its output or, with MergeOutputs, is the
+// deep merge of all object outputs in the order the policies are listed.
+// Undefined outputs are skipped when merging.
+func evaluatePolicies(ctx context.Context, req LambdaEvent) (LambdaResponse, error) {
+	if req.PolicyName != "" {
+		return LambdaResponse{}, invalidRequestError("policy and policies are mutually exclusive")
+	}
+	if req.Coverage {
+		return LambdaResponse{}, invalidRequestError("coverage is not supported when evaluating several policies")
+	}
+	if req.IncludeMetadata {
+		return LambdaResponse{}, invalidRequestError("include_metadata is not supported when evaluating several policies")
+	}
+	if req.Inputs != nil {
+		return LambdaResponse{}, invalidRequestError("inputs is not supported when evaluating several policies")
+	}
+	if req.Query != "" {
+		return LambdaResponse{}, invalidRequestError("query is not supported when evaluating several policies")
+	}
+
+	if req.FirstMatch {
+		if req.MergeOutputs {
+			return LambdaResponse{}, invalidRequestError("first_match and merge_outputs are mutually exclusive")
+		}
+		return evaluateFirstMatch(ctx, req)
+	}
+
+	strategy := strings.ToLower(req.MergeConflict)
+	switch strategy {
+	case "":
+		strategy = mergeOverride
+	case mergeOverride, mergeKeep, mergeError:
+	default:
+		return LambdaResponse{}, invalidRequestError("unsupported merge_conflict: %s", req.MergeConflict)
+	}
+
+	outputs := make(map[string]interface{}, len(req.Policies))
+	merged := make(map[string]interface{})
+	resp := LambdaResponse{NoCache: req.NoCache}
+
+	for _, name := range req.Policies {
+		single := req
+		single.PolicyName = name
+		single.Policies = nil
+
+		result, err := evaluatePolicy(ctx, single)
+		if err != nil {
+			return LambdaResponse{}, fmt.Errorf("policy %s: %w", name, err)
+		}
+		resp.Truncated = resp.Truncated || result.Truncated
+		resp.TimedOut = resp.TimedOut || result.TimedOut
+		resp.Warnings = append(resp.Warnings, result.Warnings...)
+
+		if !req.MergeOutputs {
+			outputs[name] = result.Output
+			continue
+		}
+		if result.Undefined {
+			continue
+		}
+
+		object, ok := result.Output.(map[string]interface{})
+		if !ok {
+			return LambdaResponse{}, fmt.Errorf("policy %s: %w", name, errOutputNotObject)
+		}
+		if merged, err = mergeObjects(merged, object, strategy, ""); err != nil {
+			return LambdaResponse{}, fmt.Errorf("policy %s: %w", name, err)
+		}
+	}
+
+	resp.Output = outputs
+	if req.MergeOutputs {
+		resp.Output = merged
+	}
+	return resp, nil
+}
+
+// evaluateFirstMatch evaluates req.Policies in order and returns the output of
+// the first one whose decision matches, naming it in MatchedPolicy. Later
+// policies are not evaluated. When no policy matches, the output is undefined.
+func evaluateFirstMatch(ctx context.Context, req LambdaEvent) (LambdaResponse, error) {
+	resp := LambdaResponse{NoCache: req.NoCache, Undefined: true}
+	for _, name := range req.Policies {
+		single := req
+		single.PolicyName = name
+		single.Policies = nil
+
+		result, err := evaluatePolicy(ctx, single)
+		if err != nil {
+			return LambdaResponse{}, fmt.Errorf("policy %s: %w", name, err)
+		}
+		resp.TimedOut = resp.TimedOut || result.TimedOut
+		resp.Warnings = append(resp.Warnings, result.Warnings...)
+
+		if !result.Undefined && decisionMatches(result.Output) {
+			resp.Output = result.Output
+			resp.Truncated = result.Truncated
+			resp.Undefined = false
+			resp.MatchedPolicy = name
+			return resp, nil
+		}
+	}
+	return resp, nil
+}
+
+// decisionMatches reports whether a defined output counts as a match under
+// first_match: false and empty objects do not match, objects with an allow
+// field match when allow is true, and any other value matches.
+func decisionMatches(output interface{}) bool {
+	switch value := output.(type) {
+	case bool:
+		return value
+	case map[string]interface{}:
+		if allow, ok := value["allow"]; ok {
+			return allow == true
+		}
+		return len(value) > 0
+	default:
+		return true
+	}
+}
+
+// mergeObjects deep-merges src into a copy of dst.
+func mergeObjects(dst, src map[string]interface{}, strategy, path string) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(dst)+len(src))
+	for key, value := range dst {
+		out[key] = value
+	}
+
+	keys := make([]string, 0, len(src))
+	for key := range src {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := src[key]
+		existing, ok := out[key]
+		if !ok {
+			out[key] = value
+			continue
+		}
+
+		existingObject, existingIsObject := existing.(map[string]interface{})
+		valueObject, valueIsObject := value.(map[string]interface{})
+		if existingIsObject && valueIsObject {
+			nested, err := mergeObjects(existingObject, valueObject, strategy, path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = nested
+			continue
+		}
+		if reflect.DeepEqual(existing, value) {
+			continue
+		}
+
+		switch strategy {
+		case mergeKeep:
+		case mergeError:
+			return nil, fmt.Errorf("%w at %s", errMergeConflict, strings.TrimPrefix(path+"."+key, "."))
+		default:
+			out[key] = value
+		}
+	}
+
+	return out, nil
+}
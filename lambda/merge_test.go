@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeObjects(t *testing.T) {
+	base := map[string]interface{}{
+		"allow":  false,
+		"limits": map[string]interface{}{"rate": json.Number("10"), "burst": json.Number("20")},
+		"tags":   []interface{}{"base"},
+	}
+	override := map[string]interface{}{
+		"allow":  true,
+		"limits": map[string]interface{}{"rate": json.Number("50")},
+		"tags":   []interface{}{"base"},
+		"reason": "override",
+	}
+
+	merged, err := mergeObjects(base, override, mergeOverride, "")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"allow":  true,
+		"limits": map[string]interface{}{"rate": json.Number("50"), "burst": json.Number("20")},
+		"tags":   []interface{}{"base"},
+		"reason": "override",
+	}, merged)
+	require.Equal(t, false, base["allow"], "inputs must not be modified")
+
+	merged, err = mergeObjects(base, override, mergeKeep, "")
+	require.NoError(t, err)
+	require.Equal(t, false, merged["allow"])
+	require.Equal(t, json.Number("10"), merged["limits"].(map[string]interface{})["rate"])
+	require.Equal(t, "override", merged["reason"])
+
+	_, err = mergeObjects(base, override, mergeError, "")
+	require.ErrorIs(t, err, errMergeConflict)
+	require.ErrorContains(t, err, "at allow")
+
+	_, err = mergeObjects(base, map[string]interface{}{"limits": map[string]interface{}{"rate": json.Number("1")}}, mergeError, "")
+	require.ErrorContains(t, err, "at limits.rate")
+}
+
+func TestHandleLambdaDirectEventPolicies(t *testing.T) {
+	writeTestPolicy(t, "mergebase", "package mergebase\n\nallow = false\nlimits := {\"rate\": 10, \"burst\": 20}\n")
+	writeTestPolicy(t, "mergeoverride", "package mergeoverride\n\nallow = input.admin\nlimits := {\"rate\": 50}\n")
+
+	resp, err := handleDirectLambdaEvent(context.Background(), json.RawMessage(`{"policies":["mergebase","mergeoverride"],"payload":{"admin":true}}`))
+	require.NoError(t, err)
+	outputs := resp.Output.(map[string]interface{})
+	require.Equal(t, false, outputs["mergebase"].(map[string]interface{})["allow"])
+	require.Equal(t, true, outputs["mergeoverride"].(map[string]interface{})["allow"])
+
+	resp, err = handleDirectLambdaEvent(context.Background(), json.RawMessage(`{"policies":["mergebase","mergeoverride"],"merge_outputs":true,"payload":{"admin":true}}`))
+	require.NoError(t, err)
+	merged := resp.Output.(map[string]interface{})
+	require.Equal(t, true, merged["allow"])
+	require.Equal(t, json.Number("50"), merged["limits"].(map[string]interface{})["rate"])
+	require.Equal(t, json.Number("20"), merged["limits"].(map[string]interface{})["burst"])
+
+	_, err = handleDirectLambdaEvent(context.Background(), json.RawMessage(`{"policy":"example","policies":["mergebase"],"payload":{}}`))
+	require.ErrorContains(t, err, "mutually exclusive")
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policies":["mergebase","mergeoverride"],"merge_outputs":true,"merge_conflict":"error","payload":{"admin":true}}`)
+	require.Equal(t, http.StatusConflict, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "conflicting policy outputs at allow")
+}
+
+func TestHandleLambdaHTTPEventsMergeErrors(t *testing.T) {
+	writeTestPolicy(t, "mergebase", "package mergebase\n\nallow = false\nlimits := {\"rate\": 10, \"burst\": 20}\n")
+	writeTestPolicy(t, "mergeoverride", "package mergeoverride\n\nallow = input.admin\nlimits := {\"rate\": 50}\n")
+	// The policy's package is elsewhere, so data.mergescalar is the request's data.
+	writeTestPolicy(t, "mergescalar", "package unrelated\n\nallow = true\n")
+
+	for _, tc := range []struct {
+		body   string
+		code   string
+		status int
+		err    string
+	}{
+		{`{"policies":["mergebase","mergeoverride"],"merge_outputs":true,"merge_conflict":"newest","payload":{}}`,
+			errorCodeInvalidRequest, http.StatusBadRequest, "unsupported merge_conflict: newest"},
+		{`{"policies":["mergebase","mergeoverride"],"merge_outputs":true,"merge_conflict":"error","payload":{"admin":true}}`,
+			errorCodeOutputConflict, http.StatusConflict, "conflicting policy outputs at allow"},
+		{`{"policies":["mergebase","mergescalar"],"merge_outputs":true,"data":{"mergescalar":"yes"},"payload":{}}`,
+			errorCodeOutputConflict, http.StatusConflict, "policy mergescalar: only object outputs can be merged"},
+	} {
+		for _, resp := range invokeHTTPEvents(t, tc.body) {
+			require.Equal(t, tc.status, resp.status, resp.event+" "+tc.body)
+			body := parseLambdaResponseBody(t, resp.body)
+			require.Equal(t, tc.code, body.ErrorCode, resp.event+" "+tc.body)
+			require.Contains(t, body.Error, tc.err, resp.event+" "+tc.body)
+		}
+	}
+}
+
+func TestDecisionMatches(t *testing.T) {
+	require.True(t, decisionMatches(true))
+	require.False(t, decisionMatches(false))
+	require.True(t, decisionMatches(map[string]interface{}{"allow": true}))
+	require.False(t, decisionMatches(map[string]interface{}{"allow": false, "reason": "x"}))
+	require.False(t, decisionMatches(map[string]interface{}{}))
+	require.True(t, decisionMatches(map[string]interface{}{"route": "team-a"}))
+	require.True(t, decisionMatches("team-a"))
+}
+
+func TestHandleLambdaDirectEventFirstMatch(t *testing.T) {
+	writeTestPolicy(t, "tierteam", "package tierteam\n\ndefault allow = false\n\nallow { input.team == \"a\" }\n")
+	writeTestPolicy(t, "tierorg", "package tierorg\n\nallow { input.org == \"x\" }\n")
+	writeTestPolicy(t, "tierdefault", "package tierdefault\n\nallow = true\nreason := \"default\"\n")
+
+	resp, err := handleDirectLambdaEvent(context.Background(), json.RawMessage(`{"policies":["tierteam","tierorg","tierdefault"],"first_match":true,"payload":{"org":"x"}}`))
+	require.NoError(t, err)
+	require.Equal(t, "tierorg", resp.MatchedPolicy)
+	require.Equal(t, map[string]interface{}{"allow": true}, resp.Output)
+
+	resp, err = handleDirectLambdaEvent(context.Background(), json.RawMessage(`{"policies":["tierteam","tierorg","tierdefault"],"first_match":true,"payload":{"team":"a"}}`))
+	require.NoError(t, err)
+	require.Equal(t, "tierteam", resp.MatchedPolicy)
+
+	resp, err = handleDirectLambdaEvent(context.Background(), json.RawMessage(`{"policies":["tierteam","tierorg"],"first_match":true,"payload":{}}`))
+	require.NoError(t, err)
+	require.Empty(t, resp.MatchedPolicy)
+	require.Nil(t, resp.Output)
+	require.True(t, resp.Undefined)
+
+	_, err = handleDirectLambdaEvent(context.Background(), json.RawMessage(`{"policies":["tierteam"],"first_match":true,"merge_outputs":true,"payload":{}}`))
+	require.ErrorContains(t, err, "mutually exclusive")
+}
@@ -0,0 +1,31 @@
+package main
+
+import "net/http"
+
+// decisionObligations extracts the obligations of a policy following the
+// obligations contract: an output object with a boolean allow and an
+// obligations array, listing actions the enforcement point must carry out
+// along with the decision, such as logging access or notifying an owner. It
+// reports false for every other output.
+func decisionObligations(output interface{}) ([]interface{}, bool) {
+	result, ok := output.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	if _, ok := result["allow"].(bool); !ok {
+		return nil, false
+	}
+	obligations, ok := result["obligations"].([]interface{})
+	return obligations, ok
+}
+
+// deniedResponse answers 403 Forbidden when a policy following the
+// obligations contract denies the request, still returning its obligations.
+// It reports false for allowed requests and other outputs, which are answered
+// with 200 as before.
+func deniedResponse(resp LambdaResponse) (httpResponse, bool) {
+	if _, ok := decisionObligations(resp.Output); !ok || isAllowed(resp) {
+		return httpResponse{}, false
+	}
+	return newHTTPResponse(http.StatusForbidden, resp), true
+}
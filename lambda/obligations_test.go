@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const obligationsPolicy = `package obligations
+
+allow = input.user == "jane"
+
+obligations[{"type": "log", "level": "info"}] { allow }
+
+obligations[{"type": "notify", "to": "security"}] { not allow }
+`
+
+func TestDecisionObligations(t *testing.T) {
+	tests := []struct {
+		name   string
+		output interface{}
+		want   []interface{}
+		wantOK bool
+	}{
+		{name: "contract", output: map[string]interface{}{"allow": false, "obligations": []interface{}{"audit"}}, want: []interface{}{"audit"}, wantOK: true},
+		{name: "empty", output: map[string]interface{}{"allow": true, "obligations": []interface{}{}}, want: []interface{}{}, wantOK: true},
+		{name: "no obligations", output: map[string]interface{}{"allow": false}},
+		{name: "no allow", output: map[string]interface{}{"obligations": []interface{}{"audit"}}},
+		{name: "not an array", output: map[string]interface{}{"allow": true, "obligations": "audit"}},
+		{name: "boolean", output: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decisionObligations(tt.output)
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHandleLambdaDirectEventObligations(t *testing.T) {
+	writeTestPolicy(t, "obligations", obligationsPolicy)
+
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"obligations","payload":{"user":"joe"}}`))
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{map[string]interface{}{"type": "notify", "to": "security"}}, resp.(LambdaResponse).Obligations)
+	require.Equal(t, false, resp.(LambdaResponse).Output.(map[string]interface{})["allow"])
+}
+
+func TestHandleLambdaAPIGatewayV2EventObligations(t *testing.T) {
+	writeTestPolicy(t, "obligations", obligationsPolicy)
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"obligations","payload":{"user":"jane"}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, []interface{}{map[string]interface{}{"level": "info", "type": "log"}}, parseLambdaResponseBody(t, gwResp.Body).Obligations)
+
+	// Denials still carry the obligations the enforcement point must fulfil.
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"obligations","payload":{"user":"joe"}}`)
+	require.Equal(t, http.StatusForbidden, gwResp.StatusCode)
+	require.Equal(t, []interface{}{map[string]interface{}{"to": "security", "type": "notify"}}, parseLambdaResponseBody(t, gwResp.Body).Obligations)
+
+	// Outputs without obligations keep answering 200 whatever they decide.
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"example","payload":{}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+}
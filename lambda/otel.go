@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the function's OpenTelemetry spans. Until
+// initTracerProvider installs a provider, its spans are no-ops.
+var tracer = otel.Tracer("opa_lambda")
+
+// tracerProvider exports spans once initTracerProvider has run, and is nil
+// otherwise.
+var tracerProvider *sdktrace.TracerProvider
+
+// initTracerProvider exports spans over OTLP/HTTP when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set. The exporter reads the endpoint and the
+// other standard OTEL_EXPORTER_OTLP_* variables itself, and the service is
+// named by OTEL_SERVICE_NAME. Without an endpoint nothing is installed, so
+// spans cost nothing.
+func initTracerProvider(ctx context.Context) error {
+	if strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")) == "" {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return err
+	}
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return nil
+}
+
+// flushTraces exports the spans ended so far. Lambda may freeze the container
+// as soon as an invocation returns, so spans are flushed before it does.
+func flushTraces(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+	defer cancel()
+	if err := tracerProvider.ForceFlush(ctx); err != nil {
+		log.WithError(err).Warn("Unable to export traces")
+	}
+}
+
+// shutdownTraces exports the remaining spans before the function shuts down.
+func shutdownTraces() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		log.WithError(err).Warn("Unable to export traces")
+	}
+}
+
+// startInvocationSpan starts the span of an invocation, as a child of the
+// trace context an HTTP event carries in its traceparent header, so that the
+// decision joins the caller's trace.
+func startInvocationSpan(ctx context.Context, payload json.RawMessage) (context.Context, trace.Span) {
+	if tracerProvider != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, eventHeaders(payload))
+	}
+	return tracer.Start(ctx, "invoke", trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// eventHeaders returns the headers of an API Gateway, ALB or function URL
+// event, keyed by lowercase name, or an empty carrier for other events.
+func eventHeaders(payload json.RawMessage) propagation.MapCarrier {
+	var probe struct {
+		Headers           map[string]string   `json:"headers"`
+		MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+	}
+	carrier := propagation.MapCarrier{}
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return carrier
+	}
+	for name, values := range probe.MultiValueHeaders {
+		if len(values) > 0 {
+			carrier[strings.ToLower(name)] = values[0]
+		}
+	}
+	for name, value := range probe.Headers {
+		carrier[strings.ToLower(name)] = value
+	}
+	return carrier
+}
+
+// traceSpan runs fn in an OpenTelemetry span named name, recording its error.
+func traceSpan(ctx context.Context, name string, fn func(context.Context) error, attrs ...attribute.KeyValue) error {
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	defer span.End()
+
+	err := fn(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
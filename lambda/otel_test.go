@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"opa_lambda/policyloader"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans exports the spans of the test to the returned recorder.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previousTracer, previousPropagator := tracer, otel.GetTextMapPropagator()
+	tracer, tracerProvider = provider.Tracer("opa_lambda"), provider
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		tracer, tracerProvider = previousTracer, nil
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return recorder
+}
+
+// spanAttribute returns the value of the attribute key of span.
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestHandleLambdaSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	writeTestPolicy(t, "traced", "package traced\n\nallow = input.user == \"jane\"\n")
+
+	gwResp := invokeAPIGatewayV2(t, map[string]string{
+		"Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}, `{"policy":"traced","payload":{"user":"jane"}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "invoke")
+	require.Contains(t, spans, "evaluate_policy")
+	require.Contains(t, spans, "load_policy")
+
+	// The invocation joins the caller's trace.
+	invoke := spans["invoke"]
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", invoke.SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", invoke.Parent().SpanID().String())
+
+	evaluate := spans["evaluate_policy"]
+	require.Equal(t, invoke.SpanContext().SpanID(), evaluate.Parent().SpanID())
+	require.Equal(t, "traced", spanAttribute(evaluate, "policy.name").AsString())
+
+	load := spans["load_policy"]
+	require.Equal(t, evaluate.SpanContext().SpanID(), load.Parent().SpanID())
+	require.Equal(t, "traced", spanAttribute(load, "policy.name").AsString())
+}
+
+func TestHandleLambdaSpansError(t *testing.T) {
+	recorder := recordSpans(t)
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"missing","payload":{}}`)
+	require.NotEqual(t, http.StatusOK, gwResp.StatusCode)
+
+	var found bool
+	for _, span := range recorder.Ended() {
+		if span.Name() == "evaluate_policy" {
+			found = true
+			require.Equal(t, codes.Error, span.Status().Code)
+			require.NotEmpty(t, span.Events())
+		}
+	}
+	require.True(t, found)
+}
+
+func TestPolicyCacheSpanAttributes(t *testing.T) {
+	recorder := recordSpans(t)
+
+	ctx, span := tracer.Start(context.Background(), "load_policy")
+	policyloader.CacheObserver(ctx, "s3", true)
+	span.End()
+
+	ended := recorder.Ended()
+	require.Len(t, ended, 1)
+	require.True(t, spanAttribute(ended[0], "policy.cache_hit").AsBool())
+	require.Equal(t, "s3", spanAttribute(ended[0], "policy.backend").AsString())
+}
+
+func TestEventHeaders(t *testing.T) {
+	carrier := eventHeaders([]byte(`{"headers":{"Traceparent":"a"},"multiValueHeaders":{"TraceState":["b","c"]}}`))
+	require.Equal(t, "a", carrier.Get("traceparent"))
+	require.Equal(t, "b", carrier.Get("tracestate"))
+
+	require.Empty(t, eventHeaders([]byte(`{"policy":"example"}`)))
+	require.Empty(t, eventHeaders([]byte(`[]`)))
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// errEmptyPayload is returned for empty-object payloads under
+// REQUIRE_NONEMPTY_PAYLOAD.
+var errEmptyPayload = errors.New("payload must not be an empty object")
+
+// errInputTooComplex is returned for payloads with more elements than
+// MAX_INPUT_ELEMENTS.
+var errInputTooComplex = errors.New("input is too complex")
+
+// errPreviousDecision is returned for payloads that cannot carry the previous
+// decision.
+var errPreviousDecision = errors.New("previous_decision requires an object payload without a previous field")
+
+// prepareInput checks a payload of req and turns it into the input the policy
+// is evaluated against: transformed, with the previous decision and the
+// deployment context added, and canonical when CANONICAL_INPUT is enabled.
+func prepareInput(ctx context.Context, req LambdaEvent, payload json.RawMessage) ([]byte, error) {
+	if err := checkPayloadNotEmpty(payload); err != nil {
+		return nil, err
+	}
+	if err := checkInputElements(payload); err != nil {
+		return nil, err
+	}
+
+	input, err := transformPayload(ctx, req.PolicyName, payload)
+	if err != nil {
+		return nil, err
+	}
+	if input, err = withPreviousDecision(input, req.PreviousDecision); err != nil {
+		return nil, err
+	}
+	if input, err = withInputContext(input); err != nil {
+		return nil, err
+	}
+	return canonicalPayload(input)
+}
+
+// checkPayloadNotEmpty enforces REQUIRE_NONEMPTY_PAYLOAD, which rejects a
+// payload of {} for deployments where an empty input is always a client that
+// forgot to fill it in. Other payloads, including null and [], are left to
+// the policy.
+func checkPayloadNotEmpty(payload json.RawMessage) error {
+	required, err := boolFromEnv("REQUIRE_NONEMPTY_PAYLOAD", false)
+	if err != nil || !required {
+		return err
+	}
+
+	if !bytes.HasPrefix(bytes.TrimSpace(payload), []byte("{")) {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err == nil && len(fields) == 0 {
+		return errEmptyPayload
+	}
+	return nil
+}
+
+// checkInputElements enforces MAX_INPUT_ELEMENTS, the most array elements and
+// object keys, summed at every level, that a payload may have. Policies that
+// iterate over input collections can take time in proportion to them, however
+// shallow the input is. The payload is streamed and counting stops at the
+// limit, so an oversized payload is rejected without decoding it. The limit is
+// off by default.
+func checkInputElements(payload json.RawMessage) error {
+	maxElements, err := intFromEnv("MAX_INPUT_ELEMENTS", 0)
+	if err != nil || maxElements == 0 {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	// Each open container records whether it is an object and, if so,
+	// whether its next token is a key. Keys and array elements are counted.
+	type container struct{ object, expectKey bool }
+	var stack []container
+	elements := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			// The end of the payload, or a malformed payload, which the
+			// evaluator reports.
+			return nil
+		}
+		delim, isDelim := token.(json.Delim)
+		if isDelim && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		if len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			counted := true
+			if top.object {
+				counted = top.expectKey
+				top.expectKey = !top.expectKey
+			}
+			if counted {
+				if elements++; elements > maxElements {
+					return fmt.Errorf("%w: more than MAX_INPUT_ELEMENTS=%d array elements and object keys", errInputTooComplex, maxElements)
+				}
+			}
+		}
+		if isDelim {
+			stack = append(stack, container{object: delim == '{', expectKey: delim == '{'})
+		}
+	}
+}
+
+// withPreviousDecision adds a request's previous_decision to its payload as
+// input.previous, so that a policy can decide relative to the decision the
+// client made before. The payload is returned unchanged without one.
+func withPreviousDecision(payload []byte, previous *json.RawMessage) ([]byte, error) {
+	if previous == nil {
+		return payload, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil || fields == nil {
+		return nil, errPreviousDecision
+	}
+	if _, ok := fields["previous"]; ok {
+		return nil, fmt.Errorf("%w: the payload already has one", errPreviousDecision)
+	}
+	fields["previous"] = *previous
+	return json.Marshal(fields)
+}
+
+// inputContextKey is the reserved input field INPUT_CONTEXT is added under.
+const inputContextKey = "context"
+
+// withInputContext adds the deployment's constant context, the JSON object in
+// INPUT_CONTEXT, to an object payload as input.context, so that clients need
+// not send values such as the cluster or stage with every request. A context
+// sent with the request takes precedence: objects are deep-merged with its
+// values winning, and any other value replaces the configured context. Other
+// payloads, and every payload when the variable is unset, are returned
+// unchanged.
+func withInputContext(payload []byte) ([]byte, error) {
+	raw := strings.TrimSpace(os.Getenv("INPUT_CONTEXT"))
+	if raw == "" {
+		return payload, nil
+	}
+	configured, ok := decodeObject([]byte(raw))
+	if !ok {
+		return nil, errors.New("INPUT_CONTEXT must be a JSON object")
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil || fields == nil {
+		return payload, nil
+	}
+	context := configured
+	if requested, ok := fields[inputContextKey]; ok {
+		object, ok := decodeObject(requested)
+		if !ok {
+			return payload, nil
+		}
+		var err error
+		if context, err = mergeObjects(configured, object, mergeOverride, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	encoded, err := json.Marshal(context)
+	if err != nil {
+		return nil, err
+	}
+	fields[inputContextKey] = encoded
+	return json.Marshal(fields)
+}
+
+// decodeObject decodes a JSON object, keeping its numbers as written. It
+// reports false for anything else.
+func decodeObject(raw []byte) (map[string]interface{}, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil || object == nil {
+		return nil, false
+	}
+	return object, true
+}
+
+// canonicalPayload re-marshals a payload canonically under CANONICAL_INPUT:
+// object keys sorted, duplicate keys resolved to their last value, and every
+// number in its shortest form, as the float64 the evaluator sees, with -0 as
+// 0. Policies then observe the same input, down to json.marshal(input),
+// however the client serialized it. The payload is returned unchanged when
+// the variable is off.
+func canonicalPayload(payload []byte) ([]byte, error) {
+	canonical, err := boolFromEnv("CANONICAL_INPUT", false)
+	if err != nil || !canonical {
+		return payload, err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(canonicalValue(value)); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// canonicalValue replaces negative zeros in a decoded JSON value, the only
+// numbers encoding/json would otherwise spell two ways. Maps are marshaled
+// with sorted keys already.
+func canonicalValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == 0 {
+			return float64(0)
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = canonicalValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = canonicalValue(item)
+		}
+	}
+	return value
+}
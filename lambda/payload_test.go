@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPayloadNotEmpty(t *testing.T) {
+	tests := []struct {
+		payload string
+		empty   bool
+	}{
+		{payload: `{}`, empty: true},
+		{payload: " { \n } ", empty: true},
+		{payload: `{"user":"jane"}`},
+		{payload: `null`},
+		{payload: `[]`},
+		{payload: `""`},
+	}
+
+	t.Setenv("REQUIRE_NONEMPTY_PAYLOAD", "")
+	require.NoError(t, checkPayloadNotEmpty(json.RawMessage(`{}`)))
+
+	t.Setenv("REQUIRE_NONEMPTY_PAYLOAD", "true")
+	for _, test := range tests {
+		err := checkPayloadNotEmpty(json.RawMessage(test.payload))
+		if test.empty {
+			require.ErrorIs(t, err, errEmptyPayload, test.payload)
+		} else {
+			require.NoError(t, err, test.payload)
+		}
+	}
+
+	t.Setenv("REQUIRE_NONEMPTY_PAYLOAD", "sometimes")
+	require.Error(t, checkPayloadNotEmpty(json.RawMessage(`{"user":"jane"}`)))
+}
+
+func TestHandleLambdaEmptyPayloadRequired(t *testing.T) {
+	t.Setenv("REQUIRE_NONEMPTY_PAYLOAD", "true")
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"example","payload":{}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "payload must not be an empty object")
+
+	_, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"example","inputs":{"jane":{"user":"jane"},"blank":{}}}`))
+	require.ErrorIs(t, err, errEmptyPayload)
+	require.ErrorContains(t, err, "input blank")
+
+	resp, err := handleLambda(context.Background(), buildLambdaEventPayload(t))
+	require.NoError(t, err)
+	assertExampleOutput(t, resp.(LambdaResponse).Output)
+}
+
+// wideObject returns a JSON object with keys key0 through key<n-1>.
+func wideObject(n int) string {
+	fields := make([]string, n)
+	for i := range fields {
+		fields[i] = fmt.Sprintf(`"key%d":%d`, i, i)
+	}
+	return "{" + strings.Join(fields, ",") + "}"
+}
+
+func TestCheckInputElements(t *testing.T) {
+	tests := []struct {
+		payload  string
+		elements int
+	}{
+		{payload: `"scalar"`, elements: 0},
+		{payload: `{}`, elements: 0},
+		{payload: `[1,2,3]`, elements: 3},
+		{payload: `{"a":1,"b":{"c":[1,2]},"d":[]}`, elements: 6},
+		{payload: `[{"a":1},{"b":2}]`, elements: 4},
+		{payload: `[[[[]]]]`, elements: 3},
+		{payload: wideObject(100), elements: 100},
+	}
+
+	t.Setenv("MAX_INPUT_ELEMENTS", "")
+	require.NoError(t, checkInputElements(json.RawMessage(wideObject(10000))))
+
+	for _, test := range tests {
+		t.Setenv("MAX_INPUT_ELEMENTS", strconv.Itoa(max(test.elements, 1)))
+		require.NoError(t, checkInputElements(json.RawMessage(test.payload)), test.payload)
+		// 0 disables the limit, so only payloads with two or more
+		// elements can exceed one.
+		if test.elements > 1 {
+			t.Setenv("MAX_INPUT_ELEMENTS", strconv.Itoa(test.elements-1))
+			require.ErrorIs(t, checkInputElements(json.RawMessage(test.payload)), errInputTooComplex, test.payload)
+		}
+	}
+
+	t.Setenv("MAX_INPUT_ELEMENTS", "many")
+	require.Error(t, checkInputElements(json.RawMessage(`{}`)))
+}
+
+func TestHandleLambdaInputElementsLimit(t *testing.T) {
+	t.Setenv("MAX_INPUT_ELEMENTS", "50")
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"example","payload":`+wideObject(51)+`}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "input is too complex")
+
+	_, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"example","inputs":{"narrow":{"user":"jane"},"wide":`+wideObject(51)+`}}`))
+	require.ErrorIs(t, err, errInputTooComplex)
+	require.ErrorContains(t, err, "input wide")
+
+	resp, err := handleLambda(context.Background(), buildLambdaEventPayload(t))
+	require.NoError(t, err)
+	assertExampleOutput(t, resp.(LambdaResponse).Output)
+}
+
+func TestHandleLambdaPreviousDecision(t *testing.T) {
+	writeTestPolicy(t, "approval", "package approval\n\ndefault stage = \"review\"\n\nstage = \"approved\" { input.previous.stage == \"review\"; input.approver != input.previous.requester }\n")
+
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{
+		"policy": "approval",
+		"payload": {"approver": "joe"},
+		"previous_decision": {"stage": "review", "requester": "jane"}
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"stage": "approved"}, resp.(LambdaResponse).Output)
+
+	// Without a previous decision the policy sees no input.previous.
+	resp, err = handleLambda(context.Background(), json.RawMessage(`{"policy": "approval", "payload": {"approver": "joe"}}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"stage": "review"}, resp.(LambdaResponse).Output)
+
+	for _, payload := range []string{`["joe"]`, `{"previous": {}}`} {
+		gwResp := invokeAPIGatewayV2(t, nil, `{"policy": "approval", "payload": `+payload+`, "previous_decision": {}}`)
+		require.Equal(t, http.StatusBadRequest, gwResp.StatusCode, payload)
+		require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "previous_decision requires an object payload", payload)
+	}
+}
+
+func TestWithInputContext(t *testing.T) {
+	payload := []byte(`{"user": "jane"}`)
+
+	t.Setenv("INPUT_CONTEXT", "")
+	unchanged, err := withInputContext(payload)
+	require.NoError(t, err)
+	require.Equal(t, payload, unchanged)
+
+	t.Setenv("INPUT_CONTEXT", `{"cluster": "prod-1", "region": "eu-west-1", "limits": {"max": 12345678901234567890, "min": 1}}`)
+	for _, test := range []struct {
+		payload, expected string
+	}{
+		{`{"user": "jane"}`, `{"context":{"cluster":"prod-1","limits":{"max":12345678901234567890,"min":1},"region":"eu-west-1"},"user":"jane"}`},
+		// Values sent with the request win, key by key.
+		{`{"context": {"region": "us-east-1", "limits": {"min": 2}}}`, `{"context":{"cluster":"prod-1","limits":{"max":12345678901234567890,"min":2},"region":"us-east-1"}}`},
+		// A context that is not an object replaces the configured one.
+		{`{"context": "local"}`, `{"context": "local"}`},
+		// Payloads that are not objects cannot carry it.
+		{`["jane"]`, `["jane"]`},
+		{`null`, `null`},
+	} {
+		withContext, err := withInputContext([]byte(test.payload))
+		require.NoError(t, err, test.payload)
+		require.Equal(t, test.expected, string(withContext), test.payload)
+	}
+
+	t.Setenv("INPUT_CONTEXT", `["prod-1"]`)
+	_, err = withInputContext(payload)
+	require.EqualError(t, err, "INPUT_CONTEXT must be a JSON object")
+}
+
+func TestHandleLambdaInputContext(t *testing.T) {
+	writeTestPolicy(t, "deployment", "package deployment\n\nstage := input.context.stage\n\nregion := input.context.region\n")
+	t.Setenv("INPUT_CONTEXT", `{"stage": "prod", "region": "eu-west-1"}`)
+
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy": "deployment", "payload": {}}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"stage": "prod", "region": "eu-west-1"}, resp.(LambdaResponse).Output)
+
+	resp, err = handleLambda(context.Background(), json.RawMessage(`{"policy": "deployment", "payload": {"context": {"stage": "canary"}}}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"stage": "canary", "region": "eu-west-1"}, resp.(LambdaResponse).Output)
+}
+
+func TestCanonicalPayload(t *testing.T) {
+	payload := []byte(`{"b": 1.0, "a": [1e2, 0.50, -0, "<&>"], "b": 10E-1}`)
+
+	t.Setenv("CANONICAL_INPUT", "")
+	unchanged, err := canonicalPayload(payload)
+	require.NoError(t, err)
+	require.Equal(t, payload, unchanged)
+
+	t.Setenv("CANONICAL_INPUT", "true")
+	canonical, err := canonicalPayload(payload)
+	require.NoError(t, err)
+	require.Equal(t, `{"a":[100,0.5,0,"<&>"],"b":1}`, string(canonical))
+
+	_, err = canonicalPayload([]byte(`{"a":`))
+	require.Error(t, err)
+}
+
+func TestHandleLambdaCanonicalInput(t *testing.T) {
+	writeTestPolicy(t, "signed", "package signed\n\ndigest := crypto.sha256(json.marshal(input))\n")
+	t.Setenv("CANONICAL_INPUT", "true")
+
+	// Clients serializing the same input differently get the same decision.
+	var digests []interface{}
+	for _, payload := range []string{`{"amount": -0, "to": "jane"}`, `{"to": "jane", "amount": 0.0}`} {
+		resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy": "signed", "payload": `+payload+`}`))
+		require.NoError(t, err)
+		digests = append(digests, resp.(LambdaResponse).Output.(map[string]interface{})["digest"])
+	}
+	require.Equal(t, digests[0], digests[1])
+}
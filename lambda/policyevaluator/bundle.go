@@ -0,0 +1,91 @@
+package policyevaluator
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"opa_lambda/policyloader"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// policyModule is one Rego module of a policy, with the filename errors,
+// warnings and coverage reports refer to it by.
+type policyModule struct {
+	filename string
+	source   string
+}
+
+// policySource is a loaded policy: a single module, or the modules and data
+// of an OPA bundle.
+type policySource struct {
+	modules  []policyModule
+	main     int                    // The module declaring the policy's package.
+	data     map[string]interface{} // The bundle's data; nil for single modules.
+	packages []ast.Ref              // The packages the modules declare.
+}
+
+// readPolicy interprets the text a loader returned for a policy. Bundles
+// must contain a module declaring the policy's package, which serves as the
+// policy's main module for metadata and input schemas. Whichever loader
+// returned it, a bundle must stay within its manifest's roots and, with
+// verification, be signed with the configured key.
+func readPolicy(policyName, text string, verification *policyloader.BundleVerificationConfig) (*policySource, error) {
+	if !policyloader.IsBundle(text) {
+		return &policySource{
+			modules:  []policyModule{{filename: policyName + ".rego", source: text}},
+			packages: []ast.Ref{ast.MustParseRef("data." + policyName)},
+		}, nil
+	}
+
+	b, err := policyloader.ReadBundle(policyName, []byte(text), verification)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(b.Modules, func(i, j int) bool { return b.Modules[i].Path < b.Modules[j].Path })
+	pkg := ast.MustParseRef("data." + policyName)
+	source := &policySource{main: -1, data: b.Data}
+	for _, file := range b.Modules {
+		if file.Parsed != nil {
+			if file.Parsed.Package.Path.Equal(pkg) && source.main < 0 {
+				source.main = len(source.modules)
+			}
+			source.packages = append(source.packages, file.Parsed.Package.Path)
+		}
+		source.modules = append(source.modules, policyModule{filename: strings.TrimPrefix(file.Path, "/"), source: string(file.Raw)})
+	}
+	if source.main < 0 {
+		return nil, fmt.Errorf("invalid bundle for policy %s: no module declares package %s", policyName, pkg)
+	}
+	return source, nil
+}
+
+// ErrReservedData is returned for evaluation data setting a top-level
+// document the policy's bundle supplies.
+var ErrReservedData = errors.New("reserved data")
+
+// checkBundleData checks evaluation data against the data of a policy's
+// bundle. The bundle's data comes with the policy, and is signed with it, so
+// evaluation data may add top-level documents but not replace or extend any
+// of the bundle's.
+func checkBundleData(bundled map[string]interface{}, data ...map[string]interface{}) error {
+	if len(bundled) == 0 {
+		return nil
+	}
+	var keys []string
+	for _, document := range data {
+		for key := range document {
+			if _, ok := bundled[key]; ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		return fmt.Errorf("%w: data.%s is supplied by the policy's bundle", ErrReservedData, strings.Join(keys, ", data."))
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package policyevaluator
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"opa_lambda/policyloader"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildBundle returns a bundle tarball holding files, keyed by path.
+func buildBundle(t *testing.T, files map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for path, content := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: path, Mode: 0o600, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.String()
+}
+
+func TestPolicyEvaluator_Bundle(t *testing.T) {
+	loader := &mutablePolicyLoader{module: buildBundle(t, map[string]string{
+		"/.manifest":             `{"revision": "42", "roots": ["authz"]}`,
+		"/authz/policy.rego":     "# METADATA\n# title: Bundled\npackage authz\n\nimport data.authz.roles\nimport data.authz.helpers\n\nallow { helpers.is_admin(roles[input.user]) }\n",
+		"/authz/helpers.rego":    "package authz.helpers\n\nis_admin(user_roles) { user_roles[_] == \"admin\" }\n",
+		"/authz/roles/data.json": `{"jane": ["admin"], "joe": ["viewer"]}`,
+	})}
+	eval := NewPolicyEvaluator(loader)
+
+	result, err := eval.EvaluatePolicyWithOptions(context.Background(), "authz", json.RawMessage(`{"user": "jane"}`), EvaluationOptions{Query: "data.authz.allow", IncludeMetadata: true, Coverage: true})
+	assert.NoError(t, err)
+	assert.Equal(t, true, result.Value)
+	assert.Equal(t, "Bundled", result.Metadata.Title)
+	assert.Contains(t, result.Coverage.Files, "authz/policy.rego")
+	assert.Contains(t, result.Coverage.Files, "authz/helpers.rego")
+
+	// Data can be added next to the bundle's, but cannot replace it.
+	opts := EvaluationOptions{Query: "data.authz.allow", Data: map[string]interface{}{"teams": map[string]interface{}{}}}
+	result, err = eval.EvaluatePolicyWithOptions(context.Background(), "authz", json.RawMessage(`{"user": "joe"}`), opts)
+	assert.NoError(t, err)
+	assert.True(t, result.Undefined)
+	opts.Data = map[string]interface{}{"authz": map[string]interface{}{"roles": map[string]interface{}{"joe": []interface{}{"admin"}}}}
+	_, err = eval.EvaluatePolicyWithOptions(context.Background(), "authz", json.RawMessage(`{"user": "joe"}`), opts)
+	assert.ErrorIs(t, err, ErrReservedData)
+	assert.ErrorContains(t, err, "data.authz is supplied by the policy's bundle")
+
+	module, err := eval.ParsePolicy(context.Background(), "authz")
+	assert.NoError(t, err)
+	assert.Equal(t, "data.authz", module.Package.Path.String())
+}
+
+func TestPolicyEvaluator_InvalidBundle(t *testing.T) {
+	tests := map[string]map[string]string{
+		"outside roots":  {"/.manifest": `{"roots": ["authz"]}`, "/authz/policy.rego": "package authz\n\nallow = true", "/other/policy.rego": "package other"},
+		"missing module": {"/other/policy.rego": "package other\n\nallow = true"},
+	}
+
+	for name, files := range tests {
+		t.Run(name, func(t *testing.T) {
+			eval := NewPolicyEvaluator(&mutablePolicyLoader{module: buildBundle(t, files)})
+			_, err := eval.EvaluatePolicy(context.Background(), "authz", json.RawMessage(`{}`))
+			assert.ErrorContains(t, err, "invalid bundle for policy authz")
+		})
+	}
+}
+
+func TestPolicyEvaluator_BundleVerification(t *testing.T) {
+	loader := &mutablePolicyLoader{module: buildBundle(t, map[string]string{"/authz/policy.rego": "package authz\n\nallow = true"})}
+	verification := &policyloader.BundleVerificationConfig{PublicKey: "secret", KeyID: "policy-key", Algorithm: "HS256"}
+
+	// Unsigned bundles are refused whichever loader returns them.
+	_, err := NewPolicyEvaluatorWithBundleVerification(loader, verification).EvaluatePolicy(context.Background(), "authz", json.RawMessage(`{}`))
+	assert.ErrorIs(t, err, policyloader.ErrBundleVerification)
+
+	result, err := NewPolicyEvaluator(loader).EvaluatePolicy(context.Background(), "authz", json.RawMessage(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"allow": true}, result.Value)
+}
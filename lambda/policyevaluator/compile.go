@@ -0,0 +1,60 @@
+package policyevaluator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// CompileError is returned when a policy fails to parse or compile, as
+// opposed to failing while it is evaluated. Errors holds OPA's diagnostics,
+// each with the file and line it refers to.
+type CompileError struct {
+	Policy string
+	Errors ast.Errors
+}
+
+// Error lists every diagnostic on one line, as in "policy example failed to
+// compile: policies/example.rego:3: rego_parse_error: unexpected } token".
+func (e *CompileError) Error() string {
+	diagnostics := make([]string, 0, len(e.Errors))
+	for _, diagnostic := range e.Errors {
+		// OPA's own Error appends the offending source on further lines.
+		message := fmt.Sprintf("%s: %s", diagnostic.Code, diagnostic.Message)
+		if diagnostic.Location != nil {
+			message = fmt.Sprintf("%s:%d: %s", diagnostic.Location.File, diagnostic.Location.Row, message)
+		}
+		diagnostics = append(diagnostics, message)
+	}
+	return fmt.Sprintf("policy %s failed to compile: %s", e.Policy, strings.Join(diagnostics, "; "))
+}
+
+// Unwrap returns OPA's diagnostics.
+func (e *CompileError) Unwrap() error {
+	return e.Errors
+}
+
+// compileError wraps the parse and compile diagnostics of policyName in a
+// CompileError. The compiler reports them as ast.Errors, while rego reports
+// parse errors as rego.Errors holding *ast.Error values. Other errors are
+// returned unchanged.
+func compileError(policyName string, err error) error {
+	var diagnostics ast.Errors
+	var regoErrs rego.Errors
+	if !errors.As(err, &diagnostics) && errors.As(err, &regoErrs) {
+		for _, regoErr := range regoErrs {
+			var diagnostic *ast.Error
+			if !errors.As(regoErr, &diagnostic) {
+				return err
+			}
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+	if len(diagnostics) == 0 {
+		return err
+	}
+	return &CompileError{Policy: policyName, Errors: diagnostics}
+}
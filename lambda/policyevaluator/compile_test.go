@@ -0,0 +1,39 @@
+package policyevaluator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyEvaluator_CompileError(t *testing.T) {
+	loader := &mutablePolicyLoader{module: "package broken\n\nallow {\n    input.user ==\n}\n"}
+	eval := NewPolicyEvaluator(loader)
+
+	_, err := eval.EvaluatePolicy(context.Background(), "broken", json.RawMessage(`{}`))
+	var compileErr *CompileError
+	if assert.True(t, errors.As(err, &compileErr), "expected CompileError, got %v", err) {
+		assert.Equal(t, "broken", compileErr.Policy)
+		assert.NotEmpty(t, compileErr.Errors)
+		assert.Equal(t, 5, compileErr.Errors[0].Location.Row)
+		assert.Equal(t, "rego_parse_error", compileErr.Errors[0].Code)
+		assert.Equal(t, "policy broken failed to compile: broken.rego:5: rego_parse_error: unexpected } token", err.Error())
+	}
+
+	// Modules that parse can still fail to compile.
+	loader.module = "package broken\n\nallow {\n    x == 1\n}\n"
+	_, err = eval.EvaluatePolicy(context.Background(), "broken", json.RawMessage(`{}`))
+	if assert.True(t, errors.As(err, &compileErr), "expected CompileError, got %v", err) {
+		assert.Equal(t, "rego_unsafe_var_error", compileErr.Errors[0].Code)
+		assert.Equal(t, 4, compileErr.Errors[0].Location.Row)
+	}
+
+	// Failures while evaluating are not compile errors.
+	loader.module = "package broken\n\nallow = true { true }\nallow = false { true }\n"
+	_, err = eval.EvaluatePolicy(context.Background(), "broken", json.RawMessage(`{}`))
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &compileErr), "expected an evaluation error, got %v", err)
+}
@@ -0,0 +1,58 @@
+package policyevaluator
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// deprecationCache holds the deprecation warnings of every module text seen
+// so far, so a policy is only scanned once per version.
+var deprecationCache sync.Map // [sha256.Size]byte -> []string
+
+// deprecationWarnings returns a warning for every call module makes to a
+// deprecated built-in, such as "auth.rego:7: re_match is deprecated", in
+// source order. OPA only rejects such calls in strict mode and otherwise
+// accepts them silently, so the compiler cannot be asked for them.
+func deprecationWarnings(filename, module string) ([]string, error) {
+	key := sha256.Sum256([]byte(module))
+	if cached, ok := deprecationCache.Load(key); ok {
+		return cached.([]string), nil
+	}
+
+	parsed, err := ast.ParseModule(filename, module)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calls in expressions, such as re_match(p, x), are expressions of their
+	// own; calls nested in terms, such as y := any(xs), are Call values.
+	var calls []*ast.Term
+	ast.WalkExprs(parsed, func(expr *ast.Expr) bool {
+		if expr.IsCall() {
+			calls = append(calls, expr.OperatorTerm())
+		}
+		return false
+	})
+	ast.WalkTerms(parsed, func(term *ast.Term) bool {
+		if call, ok := term.Value.(ast.Call); ok && len(call) > 0 {
+			calls = append(calls, call[0])
+		}
+		return false
+	})
+	sort.SliceStable(calls, func(i, j int) bool { return calls[i].Location.Compare(calls[j].Location) < 0 })
+
+	var warnings []string
+	for _, operator := range calls {
+		name := operator.Value.String()
+		if builtin, ok := ast.BuiltinMap[name]; ok && builtin.IsDeprecated() {
+			warnings = append(warnings, fmt.Sprintf("%s:%d: %s is deprecated", filename, operator.Location.Row, name))
+		}
+	}
+
+	deprecationCache.Store(key, warnings)
+	return warnings, nil
+}
@@ -0,0 +1,45 @@
+package policyevaluator
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrResultTooDeep is returned for results nested more deeply than
+// MaxResultDepth.
+var ErrResultTooDeep = errors.New("policy result is nested too deeply")
+
+// checkResultDepth returns ErrResultTooDeep when value holds arrays or objects
+// nested more than max levels deep. Scalars are at depth 0, so {"a": [1]} is
+// two levels deep. The walk stops max levels down, so its own stack stays
+// bounded however deep the value is.
+func checkResultDepth(value interface{}, max int) error {
+	if exceedsDepth(value, max) {
+		return fmt.Errorf("%w: more than %d levels", ErrResultTooDeep, max)
+	}
+	return nil
+}
+
+func exceedsDepth(value interface{}, remaining int) bool {
+	switch v := value.(type) {
+	case []interface{}:
+		if remaining == 0 {
+			return true
+		}
+		for _, item := range v {
+			if exceedsDepth(item, remaining-1) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		if remaining == 0 {
+			return true
+		}
+		for _, member := range v {
+			if exceedsDepth(member, remaining-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
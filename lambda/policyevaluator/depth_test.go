@@ -0,0 +1,39 @@
+package policyevaluator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckResultDepth(t *testing.T) {
+	nested := interface{}("leaf")
+	for i := 0; i < 100; i++ {
+		nested = map[string]interface{}{"next": []interface{}{nested}}
+	}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		max   int
+		ok    bool
+	}{
+		{name: "scalar", value: true, max: 0, ok: true},
+		{name: "empty object at limit", value: map[string]interface{}{}, max: 1, ok: true},
+		{name: "object over limit", value: map[string]interface{}{"a": []interface{}{1}}, max: 1},
+		{name: "object at limit", value: map[string]interface{}{"a": []interface{}{1}}, max: 2, ok: true},
+		{name: "deep", value: nested, max: 200, ok: true},
+		{name: "too deep", value: nested, max: 199},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkResultDepth(tt.value, tt.max)
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrResultTooDeep)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package policyevaluator
+
+import (
+	"context"
+	"errors"
+
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+// ErrIterationLimitExceeded is returned when an evaluation takes more than
+// MaxIterations steps.
+var ErrIterationLimitExceeded = errors.New("policy evaluation exceeded its iteration limit")
+
+// iterationBudget is a query tracer counting the steps of an evaluation, that
+// is every time an expression is evaluated or, while iterating, evaluated
+// again for the next binding, and canceling the evaluation once it has used
+// up its budget. Unlike a timeout, the count does not depend on how busy the
+// container is.
+type iterationBudget struct {
+	limit  int
+	count  int
+	cancel context.CancelCauseFunc
+}
+
+// guardIterations returns a context that is canceled with
+// ErrIterationLimitExceeded once the returned tracer has seen more than limit
+// steps. OPA checks for cancellation asynchronously, so a few more steps may
+// be taken before the evaluation stops. stop must be called once the
+// evaluation is over.
+func guardIterations(ctx context.Context, limit int) (guarded context.Context, tracer topdown.QueryTracer, stop func()) {
+	guarded, cancel := context.WithCancelCause(ctx)
+	return guarded, &iterationBudget{limit: limit, cancel: cancel}, func() { cancel(nil) }
+}
+
+func (b *iterationBudget) Enabled() bool {
+	return true
+}
+
+func (b *iterationBudget) Config() topdown.TraceConfig {
+	return topdown.TraceConfig{}
+}
+
+func (b *iterationBudget) TraceEvent(event topdown.Event) {
+	if event.Op != topdown.EvalOp && event.Op != topdown.RedoOp {
+		return
+	}
+	b.count++
+	if b.count == b.limit+1 {
+		b.cancel(ErrIterationLimitExceeded)
+	}
+}
@@ -0,0 +1,91 @@
+package policyevaluator
+
+import (
+	"sort"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/types"
+)
+
+// encodeKeysFunction names the built-in the query result is passed through so
+// that objects with non-string keys survive conversion to JSON.
+var encodeKeysFunction = ast.MustParseRef("opa_lambda.encode_keys")
+
+// encodeKeysBuiltin registers encodeKeysFunction with the evaluator.
+var encodeKeysBuiltin = rego.Function1(
+	&rego.Function{
+		Name: encodeKeysFunction.String(),
+		Decl: types.NewFunction(types.Args(types.A), types.A),
+	},
+	func(_ rego.BuiltinContext, term *ast.Term) (*ast.Term, error) {
+		return encodeKeys(term), nil
+	},
+)
+
+// encodeKeysQuery wraps a query consisting of a single term, such as
+// data.<policy>, in a call to encodeKeysFunction. Other queries are returned
+// unchanged and keep OPA's default of stringifying non-string keys.
+func encodeKeysQuery(query ast.Body) ast.Body {
+	if len(query) != 1 || query[0].Negated || len(query[0].With) > 0 {
+		return query
+	}
+	term, ok := query[0].Terms.(*ast.Term)
+	if !ok {
+		return query
+	}
+	call := ast.CallTerm(ast.NewTerm(encodeKeysFunction), term)
+	return ast.NewBody(ast.NewExpr(call))
+}
+
+// encodeKeys rewrites every object with a non-string key into an array of
+// {"key": k, "value": v} objects sorted by key, so that keys such as 1 and
+// "1" stay distinct in JSON. Objects with only string keys are left as
+// objects.
+func encodeKeys(term *ast.Term) *ast.Term {
+	switch value := term.Value.(type) {
+	case ast.Object:
+		keys := value.Keys()
+		stringKeys := true
+		for _, key := range keys {
+			if _, ok := key.Value.(ast.String); !ok {
+				stringKeys = false
+				break
+			}
+		}
+
+		if stringKeys {
+			pairs := make([][2]*ast.Term, 0, len(keys))
+			for _, key := range keys {
+				pairs = append(pairs, [2]*ast.Term{key, encodeKeys(value.Get(key))})
+			}
+			return ast.ObjectTerm(pairs...)
+		}
+
+		sorted := append([]*ast.Term(nil), keys...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Value.Compare(sorted[j].Value) < 0 })
+
+		entries := make([]*ast.Term, 0, len(sorted))
+		for _, key := range sorted {
+			entries = append(entries, ast.ObjectTerm(
+				ast.Item(ast.StringTerm("key"), encodeKeys(key)),
+				ast.Item(ast.StringTerm("value"), encodeKeys(value.Get(key))),
+			))
+		}
+		return ast.ArrayTerm(entries...)
+	case *ast.Array:
+		elems := make([]*ast.Term, 0, value.Len())
+		value.Foreach(func(elem *ast.Term) {
+			elems = append(elems, encodeKeys(elem))
+		})
+		return ast.ArrayTerm(elems...)
+	case ast.Set:
+		elems := make([]*ast.Term, 0, value.Len())
+		value.Foreach(func(elem *ast.Term) {
+			elems = append(elems, encodeKeys(elem))
+		})
+		return ast.SetTerm(elems...)
+	default:
+		return term
+	}
+}
@@ -0,0 +1,54 @@
+package policyevaluator
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"time"
+)
+
+// ErrMemoryLimitExceeded is returned when the heap grows by more than
+// MaxHeapGrowth during an evaluation.
+var ErrMemoryLimitExceeded = errors.New("policy evaluation exceeded its memory limit")
+
+// memoryGuardInterval is how often the heap is sampled during an evaluation.
+const memoryGuardInterval = 10 * time.Millisecond
+
+// guardMemory returns a context that is canceled with ErrMemoryLimitExceeded
+// once the heap has grown by more than limit bytes since the call. Go cannot
+// attribute memory to a goroutine, so the heap of the whole process is
+// sampled: allocations by concurrent evaluations count too, and a burst
+// between samples may exceed the limit before it is noticed. stop must be
+// called once the evaluation is over.
+func guardMemory(ctx context.Context, limit uint64) (guarded context.Context, stop func()) {
+	guarded, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+
+	go func() {
+		ticker := time.NewTicker(memoryGuardInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-guarded.Done():
+				return
+			case <-ticker.C:
+				runtime.ReadMemStats(&stats)
+				if stats.HeapAlloc > baseline && stats.HeapAlloc-baseline > limit {
+					cancel(ErrMemoryLimitExceeded)
+					return
+				}
+			}
+		}
+	}()
+
+	return guarded, func() {
+		close(done)
+		cancel(nil)
+	}
+}
@@ -0,0 +1,52 @@
+package policyevaluator
+
+import (
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// PolicyMetadata is the package-scoped METADATA annotation of a policy.
+type PolicyMetadata struct {
+	Title            string                           `json:"title,omitempty"`
+	Description      string                           `json:"description,omitempty"`
+	Authors          []*ast.AuthorAnnotation          `json:"authors,omitempty"`
+	Organizations    []string                         `json:"organizations,omitempty"`
+	RelatedResources []*ast.RelatedResourceAnnotation `json:"related_resources,omitempty"`
+	Custom           map[string]interface{}           `json:"custom,omitempty"` // Free-form fields such as owner.
+}
+
+// metadata returns the package-scoped annotation of the policy's main
+// module. It is parsed on first use and kept with the compiled query, so it
+// is parsed once per version of the policy and dropped along with it.
+func (p *preparedPolicy) metadata() (*PolicyMetadata, error) {
+	p.metadataOnce.Do(func() {
+		p.metadataValue, p.metadataErr = policyMetadata(p.filename, p.module)
+	})
+	return p.metadataValue, p.metadataErr
+}
+
+// policyMetadata returns the package-scoped annotation of module, or nil when
+// it has none.
+func policyMetadata(filename, module string) (*PolicyMetadata, error) {
+	parsed, err := ast.ParseModuleWithOpts(filename, module, ast.ParserOptions{ProcessAnnotation: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata *PolicyMetadata
+	for _, annotations := range parsed.Annotations {
+		if annotations.Scope != "package" && annotations.Scope != "subpackages" {
+			continue
+		}
+		metadata = &PolicyMetadata{
+			Title:            annotations.Title,
+			Description:      annotations.Description,
+			Authors:          annotations.Authors,
+			Organizations:    annotations.Organizations,
+			RelatedResources: annotations.RelatedResources,
+			Custom:           annotations.Custom,
+		}
+		break
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,74 @@
+package policyevaluator
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// ErrInvalidMockData is returned for MockData paths that are not references
+// into data, or that would replace the policy's rules or reserved data.
+var ErrInvalidMockData = errors.New("invalid mock data")
+
+// mock replaces the subtree at each of mocks' paths, as a "with
+// data.<path> as <value>" modifier would for the whole evaluation. Mocks may
+// not replace, or lie within, any of the reserved paths.
+func (o *dataOverlay) mock(mocks map[string]interface{}, reserved []ast.Ref) error {
+	paths := make([]string, 0, len(mocks))
+	for path := range mocks {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		target, err := mockDataRef(path)
+		if err != nil {
+			return err
+		}
+		for _, ref := range reserved {
+			if target.HasPrefix(ref) || ref.HasPrefix(target) {
+				return fmt.Errorf("%w: %q would replace %s", ErrInvalidMockData, path, ref)
+			}
+		}
+
+		keys := make([]string, 0, len(target)-1)
+		for _, term := range target[1:] {
+			keys = append(keys, string(term.Value.(ast.String)))
+		}
+		o.replace(keys, mocks[path])
+	}
+	return nil
+}
+
+// copyObject makes a shallow copy of object, which may be nil.
+func copyObject(object map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(object))
+	for key, value := range object {
+		copied[key] = value
+	}
+	return copied
+}
+
+// mockDataRef parses a dotted data path such as "users" or "data.users.admins"
+// into a reference under data made of string keys only.
+func mockDataRef(path string) (ast.Ref, error) {
+	text := strings.TrimSpace(path)
+	if !strings.HasPrefix(text, "data.") && !strings.HasPrefix(text, "data[") {
+		text = "data." + text
+	}
+
+	invalid := fmt.Errorf("%w: %q is not a path under data", ErrInvalidMockData, path)
+	ref, err := ast.ParseRef(text)
+	if err != nil || len(ref) < 2 || !ref.HasPrefix(ast.DefaultRootRef) {
+		return nil, invalid
+	}
+	for _, term := range ref[1:] {
+		if _, ok := term.Value.(ast.String); !ok {
+			return nil, invalid
+		}
+	}
+	return ref, nil
+}
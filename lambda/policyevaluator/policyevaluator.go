@@ -3,56 +3,498 @@ package policyevaluator
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
 
 	"opa_lambda/policyloader"
+	"opa_lambda/ratelimit"
 
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/cover"
+	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/topdown"
+	astJSON "github.com/open-policy-agent/opa/v1/ast/json"
+	log "github.com/sirupsen/logrus"
 )
 
 // EvaluationResult is the result of evaluating a policy.
 type EvaluationResult struct {
-	Value interface{} `json:"result"` // The OPA result
+	Value     interface{}     `json:"result"`              // The OPA result
+	Truncated bool            `json:"truncated,omitempty"` // Whether arrays in the result were truncated
+	Coverage  *cover.Report   `json:"coverage,omitempty"`  // Line coverage, when requested
+	Metadata  *PolicyMetadata `json:"metadata,omitempty"`  // The policy's package annotation, when requested
+	Warnings  []string        `json:"warnings,omitempty"`  // Non-fatal problems with the policy, such as calls to deprecated built-ins
+	Undefined bool            `json:"-"`                   // Whether the query produced no result
 }
 
-// PolicyEvaluator evaluates policies.
+// EvaluationOptions tunes a single policy evaluation. The zero value evaluates
+// the policy without any post-processing.
+type EvaluationOptions struct {
+	// MaxResultItems caps the length of top-level arrays in the result. Zero
+	// disables truncation.
+	MaxResultItems int
+
+	// MaxResultDepth rejects results with arrays or objects nested more than
+	// this many levels deep with ErrResultTooDeep, before anything tries to
+	// serialize them. Zero disables the check.
+	MaxResultDepth int
+
+	// DisableUnsafeBuiltins rejects policies calling built-ins that can reach
+	// the container filesystem, environment, or network (see SandboxedBuiltins).
+	DisableUnsafeBuiltins bool
+
+	// StrictBuiltinErrors turns built-in errors (such as json.unmarshal on
+	// malformed text) into evaluation errors instead of undefined results.
+	StrictBuiltinErrors bool
+
+	// Coverage records which lines of the policy were exercised by the input
+	// and attaches the report to the result. It adds tracing overhead.
+	Coverage bool
+
+	// IncludeMetadata attaches the policy's package-scoped METADATA
+	// annotation to the result.
+	IncludeMetadata bool
+
+	// Query replaces the default "data.<policy>" query, for example to select
+	// a single rule of the policy package. Empty evaluates the whole package.
+	Query string
+
+	// Data is the base document the policy sees under data, such as the
+	// deployment's reference data. Nil evaluates against an empty store.
+	Data map[string]interface{}
+
+	// DataVersion identifies the contents of Data. Calls with the same
+	// non-empty DataVersion share the store built from Data, so Data must not
+	// change without its version changing. Empty builds a store for the call.
+	DataVersion string
+
+	// RequestData adds top-level documents to Data for the call only, such as
+	// data supplied with the request. They are read in place rather than
+	// copied into a store, and take precedence over Data's.
+	RequestData map[string]interface{}
+
+	// MockData replaces subtrees of data for the evaluation, keyed by dotted
+	// path such as "users" or "data.users.admins", as a "with" modifier on the
+	// query would. Paths within the packages of the policy's modules, or
+	// within ReservedData, are rejected with ErrInvalidMockData.
+	MockData map[string]interface{}
+
+	// ReservedData lists data paths, such as "flags", that MockData may not
+	// replace, in addition to the policy's own package.
+	ReservedData []string
+
+	// TypeCheckInput processes METADATA annotations so that the compiler type
+	// checks the policy against its declared input schemas, and validates the
+	// input against inline schemas declared for the whole input. A mismatching
+	// input returns ErrInputSchemaMismatch.
+	TypeCheckInput bool
+
+	// RateLimiter backs the ratelimit.allow built-in. Nil leaves the built-in
+	// undefined, so policies calling it fail to compile.
+	RateLimiter ratelimit.Limiter
+
+	// Seed, when set, seeds the randomness of built-ins such as rand.intn and
+	// uuid.rfc4122, so that evaluations with the same seed return the same
+	// results. It is meant for testing policies; without it, every evaluation
+	// draws from a cryptographically secure source.
+	Seed *int64
+
+	// Metrics, when set, records how long each phase of the call takes: the
+	// evaluator's own timers, such as TimerLoad, and those OPA records while
+	// evaluating the query.
+	Metrics metrics.Metrics
+
+	// Timeout bounds the evaluation of the query, excluding policy loading.
+	// Exceeding it returns ErrEvaluationTimeout. Zero disables the timeout.
+	Timeout time.Duration
+
+	// LoadTimeout bounds loading the policy from its backend, cached or not.
+	// Exceeding it returns ErrLoadTimeout. Zero leaves loading bounded by ctx
+	// only.
+	LoadTimeout time.Duration
+
+	// MaxHeapGrowth aborts an evaluation during which the process heap grows
+	// by more than this many bytes, returning ErrMemoryLimitExceeded. It is a
+	// best-effort guard; see guardMemory. Zero disables it.
+	MaxHeapGrowth uint64
+
+	// MaxIterations aborts an evaluation that takes more than this many steps,
+	// counting every evaluation of an expression and every re-evaluation for
+	// the next binding while iterating, and returns
+	// ErrIterationLimitExceeded. Unlike Timeout it does not depend on CPU
+	// contention. It adds tracing overhead. Zero disables it.
+	MaxIterations int
+}
+
+// ErrEvaluationTimeout is returned when an evaluation exceeds its Timeout.
+var ErrEvaluationTimeout = errors.New("policy evaluation timed out")
+
+// ErrLoadTimeout is returned when loading a policy exceeds its LoadTimeout.
+var ErrLoadTimeout = errors.New("policy loading timed out")
+
+// SandboxedBuiltins lists the built-ins rejected when DisableUnsafeBuiltins is
+// set. http.send can read TLS material from arbitrary files and environment
+// variables as well as reach the network; net.lookup_ip_addr performs DNS
+// lookups. opa.runtime is not listed because the evaluator never supplies a
+// runtime document, so it always returns an empty object.
+var SandboxedBuiltins = []string{"http.send", "net.lookup_ip_addr"}
+
+// PolicyEvaluator evaluates policies. It caches the queries compiled for the
+// most recently used policies, so reusing an evaluator skips compiling a
+// policy again until its module text or the settings it was compiled with
+// change. Data is supplied
+// when a query is evaluated, so calls with different data share the query,
+// and the store built from each version of Data is shared by its calls.
 type PolicyEvaluator struct {
-	loader policyloader.PolicyLoader
+	loader       policyloader.PolicyLoader
+	verification *policyloader.BundleVerificationConfig
+
+	mu      sync.Mutex
+	queries map[string]*cachedPolicy // Keyed by policy name.
+
+	storesMu sync.Mutex
+	stores   map[string]*sharedStore // Keyed by EvaluationOptions.DataVersion.
 }
 
 // NewPolicyEvaluator creates a new PolicyEvaluator.
 func NewPolicyEvaluator(loader policyloader.PolicyLoader) *PolicyEvaluator {
-	return &PolicyEvaluator{loader: loader}
+	return NewPolicyEvaluatorWithBundleVerification(loader, nil)
+}
+
+// NewPolicyEvaluatorWithBundleVerification creates a PolicyEvaluator that
+// only evaluates bundles signed with verification's key, whichever loader
+// returned them. Nil accepts unsigned bundles.
+func NewPolicyEvaluatorWithBundleVerification(loader policyloader.PolicyLoader, verification *policyloader.BundleVerificationConfig) *PolicyEvaluator {
+	return &PolicyEvaluator{loader: loader, verification: verification, queries: make(map[string]*cachedPolicy), stores: make(map[string]*sharedStore)}
 }
 
 // EvaluatePolicy evaluates a policy.
 func (pe *PolicyEvaluator) EvaluatePolicy(ctx context.Context, policyName string, raw []byte) (*EvaluationResult, error) {
+	return pe.EvaluatePolicyWithOptions(ctx, policyName, raw, EvaluationOptions{})
+}
+
+// EvaluatePolicyWithOptions evaluates a policy using the supplied options.
+func (pe *PolicyEvaluator) EvaluatePolicyWithOptions(ctx context.Context, policyName string, raw []byte, opts EvaluationOptions) (*EvaluationResult, error) {
 	var input interface{}
-	if err := json.Unmarshal(raw, &input); err != nil {
+	stop := startTimer(opts.Metrics, TimerInputDecode)
+	err := json.Unmarshal(raw, &input)
+	stop()
+	if err != nil {
 		return nil, err
 	}
 
-	module, err := pe.loader.LoadPolicy(ctx, policyName)
+	prepared, err := pe.prepare(ctx, policyName, opts)
+	if err != nil {
+		return nil, err
+	}
+	data, err := pe.callData(prepared, opts)
+	if err != nil {
+		return nil, err
+	}
+	return prepared.eval(ctx, data, input, opts)
+}
+
+// EvaluatePolicyInputs evaluates a policy once for each of several named
+// inputs, compiling it only once, and returns the results by input name.
+// The first input that fails to evaluate fails the whole call.
+func (pe *PolicyEvaluator) EvaluatePolicyInputs(ctx context.Context, policyName string, raws map[string][]byte, opts EvaluationOptions) (map[string]*EvaluationResult, error) {
+	names := make([]string, 0, len(raws))
+	inputs := make(map[string]interface{}, len(raws))
+	stop := startTimer(opts.Metrics, TimerInputDecode)
+	for name, raw := range raws {
+		var input interface{}
+		if err := json.Unmarshal(raw, &input); err != nil {
+			stop()
+			return nil, fmt.Errorf("input %s: %w", name, err)
+		}
+		names = append(names, name)
+		inputs[name] = input
+	}
+	stop()
+	sort.Strings(names)
+
+	prepared, err := pe.prepare(ctx, policyName, opts)
+	if err != nil {
+		return nil, err
+	}
+	data, err := pe.callData(prepared, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]*EvaluationResult, len(names))
+	for _, name := range names {
+		result, err := prepared.eval(ctx, data, inputs[name], opts)
+		if err != nil {
+			return nil, fmt.Errorf("input %s: %w", name, err)
+		}
+		results[name] = result
+	}
+	return results, nil
+}
+
+// preparedPolicy is a policy compiled for evaluation, ready to be evaluated
+// against any number of inputs and data.
+type preparedPolicy struct {
+	query    rego.PreparedEvalQuery
+	filename string // The main module's; see readPolicy.
+	module   string
+	modules  []policyModule
+	parsed   *ast.Module // The main module parsed with annotations; set only with TypeCheckInput.
+	warnings []string
+	data     map[string]interface{} // The bundle's data.
+	packages []ast.Ref              // The packages of the policy's modules, which MockData may not replace.
+
+	key     [sha256.Size]byte // See queryKey.
+	limiter ratelimit.Limiter // The limiter the query's ratelimit.allow calls.
+
+	metadataOnce  sync.Once
+	metadataValue *PolicyMetadata // See metadata.
+	metadataErr   error
+}
+
+// prepare loads a policy and compiles it for the query and options, unless
+// the same module text was already compiled for the query with the same
+// settings.
+func (pe *PolicyEvaluator) prepare(ctx context.Context, policyName string, opts EvaluationOptions) (*preparedPolicy, error) {
+	stop := startTimer(opts.Metrics, TimerLoad)
+	module, err := pe.load(ctx, policyName, opts.LoadTimeout)
+	stop()
 	if err != nil {
 		return nil, err
 	}
 
-	query, err := rego.New(
-		rego.Query("data."+policyName),
-		rego.Module(policyName+".rego", module),
-	).PrepareForEval(ctx)
+	queryText := opts.Query
+	if queryText == "" {
+		queryText = "data." + policyName
+	}
+	key := queryKey(module, opts)
+	if cached := pe.cachedQuery(policyName, queryText, key, opts.RateLimiter); cached != nil {
+		log.Debugf("Compiled query cache hit: %s", policyName)
+		return cached, nil
+	}
+	log.Infof("Compiled query cache miss: %s", policyName)
+	defer startTimer(opts.Metrics, TimerPrepare)()
+
+	source, err := readPolicy(policyName, module, pe.verification)
+	if err != nil {
+		return nil, err
+	}
+	main := source.modules[source.main]
+	prepared := &preparedPolicy{
+		filename: main.filename, module: main.source, modules: source.modules,
+		data: source.data, packages: source.packages, key: key, limiter: opts.RateLimiter,
+	}
+	parsedQuery, err := ast.ParseBody(queryText)
 	if err != nil {
 		return nil, err
 	}
+	parsedQuery = encodeKeysQuery(parsedQuery)
+	regoOpts := []func(*rego.Rego){rego.ParsedQuery(parsedQuery), rego.Store(newRequestStore()), encodeKeysBuiltin}
+	for i, m := range source.modules {
+		if !opts.TypeCheckInput {
+			regoOpts = append(regoOpts, rego.Module(m.filename, m.source))
+			continue
+		}
+		parsed, err := ast.ParseModuleWithOpts(m.filename, m.source, ast.ParserOptions{ProcessAnnotation: true})
+		if err != nil {
+			return nil, compileError(policyName, err)
+		}
+		if i == source.main {
+			prepared.parsed = parsed
+		}
+		regoOpts = append(regoOpts, rego.ParsedModule(parsed))
+	}
+	if opts.DisableUnsafeBuiltins {
+		unsafe := make(map[string]struct{}, len(SandboxedBuiltins))
+		for _, name := range SandboxedBuiltins {
+			unsafe[name] = struct{}{}
+		}
+		regoOpts = append(regoOpts, rego.UnsafeBuiltins(unsafe))
+	}
+	if opts.StrictBuiltinErrors {
+		regoOpts = append(regoOpts, rego.StrictBuiltinErrors(true))
+	}
+	if opts.RateLimiter != nil {
+		regoOpts = append(regoOpts, rateLimitBuiltin(opts.RateLimiter))
+	}
+
+	if prepared.query, err = rego.New(regoOpts...).PrepareForEval(ctx); err != nil {
+		return nil, compileError(policyName, err)
+	}
+	for _, m := range source.modules {
+		warnings, err := deprecationWarnings(m.filename, m.source)
+		if err != nil {
+			return nil, err
+		}
+		prepared.warnings = append(prepared.warnings, warnings...)
+	}
+	pe.storeQuery(policyName, queryText, prepared)
+	return prepared, nil
+}
+
+// load loads a policy, giving up with ErrLoadTimeout after timeout unless it
+// is zero. Loaders do not all wrap context errors, so the timeout is detected
+// from the context rather than from the error.
+func (pe *PolicyEvaluator) load(ctx context.Context, policyName string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return pe.loader.LoadPolicy(ctx, policyName)
+	}
+
+	loadCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	module, err := pe.loader.LoadPolicy(loadCtx, policyName)
+	if err != nil && errors.Is(loadCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return "", fmt.Errorf("%w after %s: %v", ErrLoadTimeout, timeout, err)
+	}
+	return module, err
+}
+
+// eval evaluates the prepared policy against one input and data, which may
+// be nil.
+func (p *preparedPolicy) eval(ctx context.Context, data *callData, input interface{}, opts EvaluationOptions) (*EvaluationResult, error) {
+	if p.parsed != nil {
+		if err := validateInputSchemas(ctx, p.parsed, input); err != nil {
+			return nil, err
+		}
+	}
+
+	evalOpts := []rego.EvalOption{rego.EvalInput(input)}
+	if data != nil {
+		txn, err := data.store.NewTransaction(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer data.store.Abort(ctx, txn)
+		evalOpts = append(evalOpts, rego.EvalTransaction(requestTxn{Transaction: txn, store: data.store, overlay: data.overlay}))
+	}
+	var cov *cover.Cover
+	if opts.Coverage {
+		cov = cover.New()
+		evalOpts = append(evalOpts, rego.EvalQueryTracer(cov))
+	}
+	if opts.Metrics != nil {
+		evalOpts = append(evalOpts, rego.EvalMetrics(opts.Metrics))
+	}
+	if opts.Seed != nil {
+		evalOpts = append(evalOpts, rego.EvalSeed(rand.New(rand.NewSource(*opts.Seed))))
+	}
 
-	result, err := query.Eval(ctx, rego.EvalInput(input))
+	evalCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		evalCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	if opts.MaxHeapGrowth > 0 {
+		var stop func()
+		evalCtx, stop = guardMemory(evalCtx, opts.MaxHeapGrowth)
+		defer stop()
+	}
+	if opts.MaxIterations > 0 {
+		var tracer topdown.QueryTracer
+		var stop func()
+		evalCtx, tracer, stop = guardIterations(evalCtx, opts.MaxIterations)
+		defer stop()
+		evalOpts = append(evalOpts, rego.EvalQueryTracer(tracer))
+	}
+
+	result, err := p.query.Eval(evalCtx, evalOpts...)
 	if err != nil {
+		if errors.Is(context.Cause(evalCtx), ErrMemoryLimitExceeded) {
+			return nil, fmt.Errorf("%w: heap grew by more than %d bytes: %v", ErrMemoryLimitExceeded, opts.MaxHeapGrowth, err)
+		}
+		if errors.Is(context.Cause(evalCtx), ErrIterationLimitExceeded) {
+			return nil, fmt.Errorf("%w: more than %d steps: %v", ErrIterationLimitExceeded, opts.MaxIterations, err)
+		}
+		if opts.Timeout > 0 && errors.Is(evalCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			return nil, fmt.Errorf("%w after %s: %v", ErrEvaluationTimeout, opts.Timeout, err)
+		}
 		return nil, err
 	}
 
-	if len(result) == 0 {
-		return &EvaluationResult{Value: result}, nil
+	defer startTimer(opts.Metrics, TimerResult)()
+	evalResult := &EvaluationResult{Value: result, Undefined: len(result) == 0, Warnings: p.warnings}
+	if len(result) > 0 && opts.MaxResultDepth > 0 {
+		if err := checkResultDepth(result[0].Expressions[0].Value, opts.MaxResultDepth); err != nil {
+			return nil, err
+		}
+	}
+	if len(result) > 0 {
+		evalResult.Value, evalResult.Truncated = truncateResult(result[0].Expressions[0].Value, opts.MaxResultItems)
+	}
+
+	if opts.IncludeMetadata {
+		if evalResult.Metadata, err = p.metadata(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cov != nil {
+		report, err := coverageReport(cov, p.modules)
+		if err != nil {
+			return nil, err
+		}
+		evalResult.Coverage = report
+	}
+
+	return evalResult, nil
+}
+
+// ParsePolicy loads a policy and parses it as the compiler would before
+// evaluation, without compiling or evaluating it. The returned module
+// marshals to JSON with the source location of every node.
+func (pe *PolicyEvaluator) ParsePolicy(ctx context.Context, policyName string) (*ast.Module, error) {
+	module, err := pe.loader.LoadPolicy(ctx, policyName)
+	if err != nil {
+		return nil, err
+	}
+
+	includeLocations.Do(func() {
+		opts := astJSON.GetOptions()
+		opts.MarshalOptions.IncludeLocation = astJSON.NodeToggle{
+			Term: true, Package: true, Comment: true, Import: true, Rule: true, Head: true,
+			Expr: true, SomeDecl: true, Every: true, With: true, Annotations: true, AnnotationsRef: true,
+		}
+		astJSON.SetOptions(opts)
+	})
+
+	source, err := readPolicy(policyName, module, pe.verification)
+	if err != nil {
+		return nil, err
+	}
+	main := source.modules[source.main]
+	parsed, err := ast.ParseModule(main.filename, main.source)
+	if err != nil {
+		return nil, compileError(policyName, err)
+	}
+	return parsed, nil
+}
+
+// includeLocations switches AST JSON marshalling to include locations. OPA
+// only offers this as a process-wide setting; nothing else in the function
+// marshals AST nodes, so it is enabled on the first parse and left on.
+var includeLocations sync.Once
+
+// coverageReport builds the coverage report for the evaluated modules. The
+// modules are parsed again under the same filenames so that the locations
+// recorded by the tracer line up with the report's file entries.
+func coverageReport(cov *cover.Cover, modules []policyModule) (*cover.Report, error) {
+	parsed := make(map[string]*ast.Module, len(modules))
+	for _, m := range modules {
+		var err error
+		if parsed[m.filename], err = ast.ParseModule(m.filename, m.source); err != nil {
+			return nil, err
+		}
 	}
 
-	return &EvaluationResult{Value: result[0].Expressions[0].Value}, nil
+	report := cov.Report(parsed)
+	return &report, nil
 }
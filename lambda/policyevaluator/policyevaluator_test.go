@@ -4,7 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
+
+	"opa_lambda/ratelimit"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -27,6 +31,101 @@ allow {
     input.action == "read"
 }`
 
+const httpSendRegoPolicy = `package fetch
+
+default allow = false
+
+allow {
+    resp := http.send({"method": "get", "url": "http://127.0.0.1:0", "tls_ca_cert_file": "/etc/passwd"})
+    resp.status_code == 200
+}`
+
+const runtimeRegoPolicy = `package runtime
+
+env := opa.runtime()`
+
+const builtinErrorRegoPolicy = `package decode
+
+parsed := json.unmarshal(input.raw)`
+
+const slowRegoPolicy = `package slow
+
+never {
+    r := numbers.range(1, 10000)
+    some i, j
+    r[i] * r[j] == -1
+}`
+
+const typedRegoPolicy = `package typed
+
+default allow = false
+
+# METADATA
+# schemas:
+#   - input: {"type": "object", "properties": {"user": {"type": "string"}}, "required": ["user"]}
+allow {
+    input.user == "alice"
+}`
+
+const mistypedRegoPolicy = `package mistyped
+
+# METADATA
+# schemas:
+#   - input: {"type": "object", "properties": {"user": {"type": "string"}}, "additionalProperties": false}
+allow {
+    input.usr == "alice"
+}`
+
+const nonStringKeysRegoPolicy = `package keyed
+
+counts := {1: "one", "1": "string one", [2, 3]: "pair"}
+
+names := {"alice": {true: "enabled"}}`
+
+const annotatedRegoPolicy = `# METADATA
+# title: Annotated policy
+# description: Grants access to alice.
+# custom:
+#   owner: team-identity
+package annotated
+
+allow {
+    input.user == "alice"
+}`
+
+const rateLimitedRegoPolicy = `package limited
+
+default allow = false
+
+allow {
+    ratelimit.allow(input.user, "2/m")
+}`
+
+const hungryRegoPolicy = `package hungry
+
+strings := [sprintf("item-%d", [x]) | x := numbers.range(1, 2000000)[_]]
+
+count_strings := count(strings)`
+
+const directoryRegoPolicy = `package directory
+
+default allow = false
+
+allow {
+    data.users[input.user].role == "admin"
+}
+
+team := data.teams[input.team]`
+
+const deprecatedRegoPolicy = `package deprecated
+
+default allow = false
+
+allow {
+    re_match("^a", input.user)
+    any([input.user == "bob", true])
+}`
+
 type mockPolicyLoader struct{}
 
 func (m *mockPolicyLoader) LoadPolicy(ctx context.Context, policyID string) (string, error) {
@@ -39,6 +138,42 @@ func (m *mockPolicyLoader) LoadPolicy(ctx context.Context, policyID string) (str
 	if policyID == "empty" {
 		return emptyRegoPolicy, nil
 	}
+	if policyID == "fetch" {
+		return httpSendRegoPolicy, nil
+	}
+	if policyID == "runtime" {
+		return runtimeRegoPolicy, nil
+	}
+	if policyID == "decode" {
+		return builtinErrorRegoPolicy, nil
+	}
+	if policyID == "slow" {
+		return slowRegoPolicy, nil
+	}
+	if policyID == "annotated" {
+		return annotatedRegoPolicy, nil
+	}
+	if policyID == "keyed" {
+		return nonStringKeysRegoPolicy, nil
+	}
+	if policyID == "typed" {
+		return typedRegoPolicy, nil
+	}
+	if policyID == "mistyped" {
+		return mistypedRegoPolicy, nil
+	}
+	if policyID == "directory" {
+		return directoryRegoPolicy, nil
+	}
+	if policyID == "hungry" {
+		return hungryRegoPolicy, nil
+	}
+	if policyID == "limited" {
+		return rateLimitedRegoPolicy, nil
+	}
+	if policyID == "deprecated" {
+		return deprecatedRegoPolicy, nil
+	}
 	return "", errors.New("policy not found")
 }
 
@@ -107,3 +242,390 @@ func TestPolicyEvaluator_EmptyPolicy(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, map[string]interface{}(map[string]interface{}{}), result.Value.(map[string]interface{}))
 }
+
+func TestPolicyEvaluator_DisableUnsafeBuiltins(t *testing.T) {
+	mockLoader := &mockPolicyLoader{}
+	eval := NewPolicyEvaluator(mockLoader)
+
+	payload := json.RawMessage(`{}`)
+	_, err := eval.EvaluatePolicyWithOptions(context.Background(), "fetch", payload, EvaluationOptions{DisableUnsafeBuiltins: true})
+	assert.ErrorContains(t, err, "http.send")
+
+	_, err = eval.EvaluatePolicyWithOptions(context.Background(), "valid", payload, EvaluationOptions{DisableUnsafeBuiltins: true})
+	assert.NoError(t, err)
+}
+
+func TestPolicyEvaluator_RuntimeDoesNotExposeEnvironment(t *testing.T) {
+	t.Setenv("OPA_LAMBDA_SECRET", "do-not-leak")
+
+	mockLoader := &mockPolicyLoader{}
+	eval := NewPolicyEvaluator(mockLoader)
+
+	result, err := eval.EvaluatePolicy(context.Background(), "runtime", json.RawMessage(`{}`))
+	assert.NoError(t, err)
+	value, ok := result.Value.(map[string]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{}, value["env"])
+}
+
+func TestPolicyEvaluator_DeprecationWarnings(t *testing.T) {
+	mockLoader := &mockPolicyLoader{}
+	eval := NewPolicyEvaluator(mockLoader)
+
+	result, err := eval.EvaluatePolicy(context.Background(), "deprecated", json.RawMessage(`{"user": "alice"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"allow": true}, result.Value)
+	assert.Equal(t, []string{
+		"deprecated.rego:6: re_match is deprecated",
+		"deprecated.rego:7: any is deprecated",
+	}, result.Warnings)
+
+	result, err = eval.EvaluatePolicy(context.Background(), "valid", json.RawMessage(`{}`))
+	assert.NoError(t, err)
+	assert.Empty(t, result.Warnings)
+}
+
+func TestPolicyEvaluator_Coverage(t *testing.T) {
+	mockLoader := &mockPolicyLoader{}
+	eval := NewPolicyEvaluator(mockLoader)
+
+	payload := json.RawMessage(`{"user": "alice", "action": "read"}`)
+	result, err := eval.EvaluatePolicyWithOptions(context.Background(), "valid", payload, EvaluationOptions{Coverage: true})
+	assert.NoError(t, err)
+	if assert.NotNil(t, result.Coverage) {
+		file, ok := result.Coverage.Files["valid.rego"]
+		if assert.True(t, ok) {
+			assert.True(t, file.IsCovered(6), "allow conditions should be covered")
+			assert.True(t, file.IsCovered(7), "allow conditions should be covered")
+		}
+	}
+
+	payload = json.RawMessage(`{"user": "bob", "action": "read"}`)
+	result, err = eval.EvaluatePolicyWithOptions(context.Background(), "valid", payload, EvaluationOptions{Coverage: true})
+	assert.NoError(t, err)
+	if assert.NotNil(t, result.Coverage) {
+		file, ok := result.Coverage.Files["valid.rego"]
+		if assert.True(t, ok) {
+			assert.True(t, file.IsCovered(3), "default rule should be covered")
+			assert.True(t, file.IsNotCovered(6), "allow rule should not be reached for bob")
+		}
+	}
+
+	result, err = eval.EvaluatePolicy(context.Background(), "valid", payload)
+	assert.NoError(t, err)
+	assert.Nil(t, result.Coverage)
+}
+
+func TestPolicyEvaluator_StrictBuiltinErrors(t *testing.T) {
+	mockLoader := &mockPolicyLoader{}
+	eval := NewPolicyEvaluator(mockLoader)
+
+	payload := json.RawMessage(`{"raw": "{not json"}`)
+	result, err := eval.EvaluatePolicy(context.Background(), "decode", payload)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{}, result.Value)
+
+	_, err = eval.EvaluatePolicyWithOptions(context.Background(), "decode", payload, EvaluationOptions{StrictBuiltinErrors: true})
+	assert.ErrorContains(t, err, "json.unmarshal")
+}
+
+func TestPolicyEvaluator_Query(t *testing.T) {
+	mockLoader := &mockPolicyLoader{}
+	eval := NewPolicyEvaluator(mockLoader)
+
+	payload := json.RawMessage(`{"user": "alice", "action": "read"}`)
+	result, err := eval.EvaluatePolicyWithOptions(context.Background(), "valid", payload, EvaluationOptions{Query: "data.valid.allow"})
+	assert.NoError(t, err)
+	assert.Equal(t, true, result.Value)
+	assert.False(t, result.Undefined)
+
+	result, err = eval.EvaluatePolicyWithOptions(context.Background(), "valid", payload, EvaluationOptions{Query: "data.valid.missing"})
+	assert.NoError(t, err)
+	assert.True(t, result.Undefined)
+}
+
+func TestPolicyEvaluator_Timeout(t *testing.T) {
+	mockLoader := &mockPolicyLoader{}
+	eval := NewPolicyEvaluator(mockLoader)
+
+	payload := json.RawMessage(`{}`)
+	_, err := eval.EvaluatePolicyWithOptions(context.Background(), "slow", payload, EvaluationOptions{Timeout: 20 * time.Millisecond})
+	assert.ErrorIs(t, err, ErrEvaluationTimeout)
+
+	result, err := eval.EvaluatePolicyWithOptions(context.Background(), "valid", payload, EvaluationOptions{Timeout: time.Second})
+	assert.NoError(t, err)
+	assert.Equal(t, false, result.Value.(map[string]interface{})["allow"])
+}
+
+// slowPolicyLoader loads the valid policy after delay, or fails once ctx is done.
+type slowPolicyLoader struct {
+	delay time.Duration
+}
+
+func (l slowPolicyLoader) LoadPolicy(ctx context.Context, policyID string) (string, error) {
+	select {
+	case <-time.After(l.delay):
+		return (&mockPolicyLoader{}).LoadPolicy(ctx, "valid")
+	case <-ctx.Done():
+		return "", errors.New("failed to get policy")
+	}
+}
+
+func TestPolicyEvaluator_LoadTimeout(t *testing.T) {
+	eval := NewPolicyEvaluator(slowPolicyLoader{delay: time.Second})
+
+	payload := json.RawMessage(`{}`)
+	_, err := eval.EvaluatePolicyWithOptions(context.Background(), "valid", payload, EvaluationOptions{LoadTimeout: 20 * time.Millisecond, Timeout: time.Second})
+	assert.ErrorIs(t, err, ErrLoadTimeout)
+	assert.NotErrorIs(t, err, ErrEvaluationTimeout)
+
+	// A canceled request is not a load timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = eval.EvaluatePolicyWithOptions(ctx, "valid", payload, EvaluationOptions{LoadTimeout: time.Second})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrLoadTimeout)
+
+	eval = NewPolicyEvaluator(slowPolicyLoader{delay: time.Millisecond})
+	result, err := eval.EvaluatePolicyWithOptions(context.Background(), "valid", payload, EvaluationOptions{LoadTimeout: time.Second})
+	assert.NoError(t, err)
+	assert.Equal(t, false, result.Value.(map[string]interface{})["allow"])
+}
+
+func TestPolicyEvaluator_ParsePolicy(t *testing.T) {
+	eval := NewPolicyEvaluator(&mockPolicyLoader{})
+
+	module, err := eval.ParsePolicy(context.Background(), "valid")
+	assert.NoError(t, err)
+	assert.Equal(t, "data.valid", module.Package.Path.String())
+	assert.Len(t, module.Rules, 2)
+
+	raw, err := json.Marshal(module)
+	assert.NoError(t, err)
+	assert.Contains(t, string(raw), `"file":"valid.rego"`)
+
+	_, err = eval.ParsePolicy(context.Background(), "malformed")
+	assert.Error(t, err)
+}
+
+func TestPolicyEvaluator_TypeCheckInput(t *testing.T) {
+	eval := NewPolicyEvaluator(&mockPolicyLoader{})
+	opts := EvaluationOptions{TypeCheckInput: true}
+
+	result, err := eval.EvaluatePolicyWithOptions(context.Background(), "typed", json.RawMessage(`{"user": "alice"}`), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, true, result.Value.(map[string]interface{})["allow"])
+
+	_, err = eval.EvaluatePolicyWithOptions(context.Background(), "typed", json.RawMessage(`{"user": 42}`), opts)
+	assert.ErrorIs(t, err, ErrInputSchemaMismatch)
+	assert.ErrorContains(t, err, "user: Invalid type")
+
+	_, err = eval.EvaluatePolicyWithOptions(context.Background(), "typed", json.RawMessage(`{}`), opts)
+	assert.ErrorIs(t, err, ErrInputSchemaMismatch)
+
+	// Without the option, annotations are ignored.
+	result, err = eval.EvaluatePolicyWithOptions(context.Background(), "typed", json.RawMessage(`{"user": 42}`), EvaluationOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, false, result.Value.(map[string]interface{})["allow"])
+
+	_, err = eval.EvaluatePolicyWithOptions(context.Background(), "mistyped", json.RawMessage(`{"user": "alice"}`), opts)
+	assert.ErrorContains(t, err, "undefined ref: input.usr")
+	assert.NotErrorIs(t, err, ErrInputSchemaMismatch)
+
+	_, err = eval.EvaluatePolicyWithOptions(context.Background(), "mistyped", json.RawMessage(`{"user": "alice"}`), EvaluationOptions{})
+	assert.NoError(t, err)
+}
+
+func TestPolicyEvaluator_Data(t *testing.T) {
+	eval := NewPolicyEvaluator(&mockPolicyLoader{})
+
+	result, err := eval.EvaluatePolicyWithOptions(context.Background(), "valid", json.RawMessage(`{}`), EvaluationOptions{
+		Query: "data.reference.users",
+		Data:  map[string]interface{}{"reference": map[string]interface{}{"users": []interface{}{"alice"}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"alice"}, result.Value)
+}
+
+func TestPolicyEvaluator_NonStringKeys(t *testing.T) {
+	eval := NewPolicyEvaluator(&mockPolicyLoader{})
+
+	result, err := eval.EvaluatePolicy(context.Background(), "keyed", json.RawMessage(`{}`))
+	assert.NoError(t, err)
+
+	raw, err := json.Marshal(result.Value)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"counts": [
+			{"key": 1, "value": "one"},
+			{"key": "1", "value": "string one"},
+			{"key": [2, 3], "value": "pair"}
+		],
+		"names": {"alice": [{"key": true, "value": "enabled"}]}
+	}`, string(raw))
+}
+
+func TestPolicyEvaluator_IncludeMetadata(t *testing.T) {
+	eval := NewPolicyEvaluator(&mockPolicyLoader{})
+	payload := json.RawMessage(`{"user": "alice"}`)
+
+	result, err := eval.EvaluatePolicyWithOptions(context.Background(), "annotated", payload, EvaluationOptions{IncludeMetadata: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "Annotated policy", result.Metadata.Title)
+	assert.Equal(t, "Grants access to alice.", result.Metadata.Description)
+	assert.Equal(t, "team-identity", result.Metadata.Custom["owner"])
+
+	// A second evaluation reuses the metadata kept with the compiled query.
+	cached, err := eval.EvaluatePolicyWithOptions(context.Background(), "annotated", payload, EvaluationOptions{IncludeMetadata: true})
+	assert.NoError(t, err)
+	assert.Same(t, result.Metadata, cached.Metadata)
+
+	result, err = eval.EvaluatePolicyWithOptions(context.Background(), "annotated", payload, EvaluationOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, result.Metadata)
+
+	result, err = eval.EvaluatePolicyWithOptions(context.Background(), "valid", payload, EvaluationOptions{IncludeMetadata: true})
+	assert.NoError(t, err)
+	assert.Nil(t, result.Metadata)
+}
+
+type countingPolicyLoader struct {
+	mockPolicyLoader
+	loads int
+}
+
+func (c *countingPolicyLoader) LoadPolicy(ctx context.Context, policyID string) (string, error) {
+	c.loads++
+	return c.mockPolicyLoader.LoadPolicy(ctx, policyID)
+}
+
+func TestPolicyEvaluator_EvaluatePolicyInputs(t *testing.T) {
+	loader := &countingPolicyLoader{}
+	eval := NewPolicyEvaluator(loader)
+
+	results, err := eval.EvaluatePolicyInputs(context.Background(), "valid", map[string][]byte{
+		"reader": []byte(`{"user": "alice", "action": "read"}`),
+		"writer": []byte(`{"user": "alice", "action": "write"}`),
+	}, EvaluationOptions{Query: "data.valid.allow"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loader.loads)
+	assert.Len(t, results, 2)
+	assert.Equal(t, true, results["reader"].Value)
+	assert.Equal(t, false, results["writer"].Value)
+
+	_, err = eval.EvaluatePolicyInputs(context.Background(), "valid", map[string][]byte{"broken": []byte(`{`)}, EvaluationOptions{})
+	assert.ErrorContains(t, err, "input broken")
+
+	_, err = eval.EvaluatePolicyInputs(context.Background(), "typed", map[string][]byte{
+		"ok":    []byte(`{"user": "alice"}`),
+		"wrong": []byte(`{"user": 1}`),
+	}, EvaluationOptions{TypeCheckInput: true})
+	assert.ErrorIs(t, err, ErrInputSchemaMismatch)
+	assert.ErrorContains(t, err, "input wrong")
+}
+
+func TestPolicyEvaluator_RateLimiter(t *testing.T) {
+	eval := NewPolicyEvaluator(&mockPolicyLoader{})
+	payload := json.RawMessage(`{"user": "alice"}`)
+
+	_, err := eval.EvaluatePolicy(context.Background(), "limited", payload)
+	assert.ErrorContains(t, err, "ratelimit.allow")
+
+	opts := EvaluationOptions{Query: "data.limited.allow", RateLimiter: ratelimit.NewMemoryLimiter()}
+	var decisions []interface{}
+	for i := 0; i < 3; i++ {
+		result, err := eval.EvaluatePolicyWithOptions(context.Background(), "limited", payload, opts)
+		assert.NoError(t, err)
+		decisions = append(decisions, result.Value)
+	}
+	assert.Equal(t, []interface{}{true, true, false}, decisions)
+}
+
+func TestPolicyEvaluator_MaxHeapGrowth(t *testing.T) {
+	eval := NewPolicyEvaluator(&mockPolicyLoader{})
+	payload := json.RawMessage(`{}`)
+
+	_, err := eval.EvaluatePolicyWithOptions(context.Background(), "hungry", payload, EvaluationOptions{
+		Query:         "data.hungry.count_strings",
+		MaxHeapGrowth: 1 << 20,
+	})
+	assert.ErrorIs(t, err, ErrMemoryLimitExceeded)
+
+	result, err := eval.EvaluatePolicyWithOptions(context.Background(), "valid", payload, EvaluationOptions{MaxHeapGrowth: 1 << 30})
+	assert.NoError(t, err)
+	assert.False(t, result.Undefined)
+}
+
+func TestPolicyEvaluator_MaxIterations(t *testing.T) {
+	eval := NewPolicyEvaluator(&mockPolicyLoader{})
+	payload := json.RawMessage(`{}`)
+
+	_, err := eval.EvaluatePolicyWithOptions(context.Background(), "valid", payload, EvaluationOptions{
+		Query:         "count([x | x := numbers.range(1, 100000)[_]])",
+		MaxIterations: 10000,
+	})
+	assert.ErrorIs(t, err, ErrIterationLimitExceeded)
+
+	result, err := eval.EvaluatePolicyWithOptions(context.Background(), "valid", payload, EvaluationOptions{MaxIterations: 10000})
+	assert.NoError(t, err)
+	assert.False(t, result.Undefined)
+}
+
+func TestPolicyEvaluator_MockData(t *testing.T) {
+	eval := NewPolicyEvaluator(&mockPolicyLoader{})
+	payload := json.RawMessage(`{"user": "alice", "team": "core"}`)
+
+	result, err := eval.EvaluatePolicyWithOptions(context.Background(), "directory", payload, EvaluationOptions{
+		MockData: map[string]interface{}{
+			"users":           map[string]interface{}{"alice": map[string]interface{}{"role": "admin"}},
+			"data.teams.core": []interface{}{"alice"},
+		},
+		Data: map[string]interface{}{"teams": map[string]interface{}{"core": []interface{}{"bob"}, "ops": []interface{}{"carol"}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"allow": true, "team": []interface{}{"alice"}}, result.Value)
+
+	// The policy's own rules, and reserved data, cannot be replaced.
+	for _, path := range []string{"users[input.user]", "", "users[0]", "directory.allow", "directory", "data.flags.beta", "flags"} {
+		_, err = eval.EvaluatePolicyWithOptions(context.Background(), "directory", payload, EvaluationOptions{
+			Query:        "data.directory.allow",
+			MockData:     map[string]interface{}{path: true},
+			ReservedData: []string{"flags"},
+		})
+		assert.ErrorIs(t, err, ErrInvalidMockData, path)
+	}
+}
+
+// BenchmarkPolicyEvaluator_Data measures an evaluation against data large
+// enough for building the store to matter: once with a store built for every
+// call, and once with the store shared by the calls with the same data
+// version, with the request's own data layered over it.
+func BenchmarkPolicyEvaluator_Data(b *testing.B) {
+	users := make(map[string]interface{}, 10000)
+	for i := 0; i < 10000; i++ {
+		users[fmt.Sprintf("user-%d", i)] = map[string]interface{}{"role": "viewer"}
+	}
+	users["alice"] = map[string]interface{}{"role": "admin"}
+	payload := json.RawMessage(`{"user": "alice", "team": "core"}`)
+
+	for name, opts := range map[string]EvaluationOptions{
+		"PerCall": {Query: "data.directory", Data: map[string]interface{}{"users": users}},
+		"Shared": {
+			Query:       "data.directory",
+			Data:        map[string]interface{}{"users": users},
+			DataVersion: "v1",
+			RequestData: map[string]interface{}{"teams": map[string]interface{}{"core": []interface{}{"alice"}}},
+		},
+	} {
+		b.Run(name, func(b *testing.B) {
+			eval := NewPolicyEvaluator(&mockPolicyLoader{})
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				result, err := eval.EvaluatePolicyWithOptions(context.Background(), "directory", payload, opts)
+				if err != nil || result.Value.(map[string]interface{})["allow"] != true {
+					b.Fatalf("unexpected result %v: %v", result, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package policyevaluator
+
+import (
+	"crypto/sha256"
+	"time"
+
+	"opa_lambda/ratelimit"
+)
+
+const (
+	// maxCachedQueries bounds the number of queries cached per policy, so
+	// that requests selecting many different rules cannot grow the cache
+	// without limit.
+	maxCachedQueries = 8
+	// maxCachedPolicies bounds the number of policies whose queries are
+	// cached, so that requests naming many policies cannot grow the cache
+	// without limit. The least recently used policy is evicted first.
+	maxCachedPolicies = 256
+)
+
+// cachedPolicy holds the queries compiled for one policy.
+type cachedPolicy struct {
+	queries    map[string]*preparedPolicy // Keyed by query.
+	lastAccess time.Time
+}
+
+// queryKey fingerprints the module text and the settings a query is compiled
+// with, so that a compiled query is only reused for the same policy version.
+// Data, mocks and metrics are supplied when the query is evaluated, so they
+// are not part of it.
+func queryKey(module string, opts EvaluationOptions) [sha256.Size]byte {
+	hash := sha256.New()
+	hash.Write([]byte(module))
+	for _, flag := range []bool{opts.DisableUnsafeBuiltins, opts.StrictBuiltinErrors, opts.TypeCheckInput} {
+		if flag {
+			hash.Write([]byte{1})
+		} else {
+			hash.Write([]byte{0})
+		}
+	}
+	var key [sha256.Size]byte
+	copy(key[:], hash.Sum(nil))
+	return key
+}
+
+// cachedQuery returns the policy's compiled query when it was compiled from
+// key and for the same rate limiter, which is compared by identity.
+func (pe *PolicyEvaluator) cachedQuery(policyName, query string, key [sha256.Size]byte, limiter ratelimit.Limiter) *preparedPolicy {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	policy := pe.queries[policyName]
+	if policy == nil {
+		return nil
+	}
+	policy.lastAccess = time.Now()
+	cached := policy.queries[query]
+	if cached == nil || cached.key != key || cached.limiter != limiter {
+		return nil
+	}
+	return cached
+}
+
+// storeQuery caches a policy's compiled query, replacing the one compiled for
+// the same query from an earlier version of the policy or other settings.
+// Queries compiled from other versions are dropped, and once a policy has
+// maxCachedQueries queries an arbitrary one makes room for the new one.
+func (pe *PolicyEvaluator) storeQuery(policyName, query string, prepared *preparedPolicy) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	policy := pe.queries[policyName]
+	if policy == nil {
+		policy = &cachedPolicy{queries: make(map[string]*preparedPolicy)}
+		pe.queries[policyName] = policy
+	}
+	policy.lastAccess = time.Now()
+	pe.evictPoliciesLocked()
+
+	queries := policy.queries
+	for other, cached := range queries {
+		if cached.key != prepared.key {
+			delete(queries, other)
+		}
+	}
+	if _, ok := queries[query]; !ok && len(queries) >= maxCachedQueries {
+		for other := range queries {
+			delete(queries, other)
+			break
+		}
+	}
+	queries[query] = prepared
+}
+
+// evictPoliciesLocked drops the queries of the least recently used policies
+// beyond maxCachedPolicies. The caller holds mu.
+func (pe *PolicyEvaluator) evictPoliciesLocked() {
+	for len(pe.queries) > maxCachedPolicies {
+		var oldestName string
+		var oldest time.Time
+		for name, policy := range pe.queries {
+			if oldestName == "" || policy.lastAccess.Before(oldest) {
+				oldestName, oldest = name, policy.lastAccess
+			}
+		}
+		delete(pe.queries, oldestName)
+	}
+}
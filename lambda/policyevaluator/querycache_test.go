@@ -0,0 +1,109 @@
+package policyevaluator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"opa_lambda/ratelimit"
+
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// mutablePolicyLoader serves whatever module it currently holds.
+type mutablePolicyLoader struct {
+	module string
+}
+
+func (l *mutablePolicyLoader) LoadPolicy(ctx context.Context, policyID string) (string, error) {
+	return l.module, nil
+}
+
+func TestPolicyEvaluator_QueryCache(t *testing.T) {
+	loader := &mutablePolicyLoader{module: "package cached\n\nallow { data.users[_] == input.user }"}
+	eval := NewPolicyEvaluator(loader)
+	ctx := context.Background()
+
+	first, err := eval.prepare(ctx, "cached", EvaluationOptions{})
+	assert.NoError(t, err)
+
+	// Data, mocks and metrics are supplied at evaluation, so they share the
+	// compiled query.
+	for _, opts := range []EvaluationOptions{
+		{},
+		{Data: map[string]interface{}{"users": []interface{}{"alice"}}},
+		{MockData: map[string]interface{}{"users": []interface{}{"bob"}}},
+		{Metrics: metrics.New()},
+	} {
+		prepared, err := eval.prepare(ctx, "cached", opts)
+		assert.NoError(t, err)
+		assert.Same(t, first, prepared)
+	}
+	for user, opts := range map[string]EvaluationOptions{
+		"alice": {Data: map[string]interface{}{"users": []interface{}{"alice"}}},
+		"bob":   {Data: map[string]interface{}{"users": []interface{}{"alice"}}, MockData: map[string]interface{}{"users": []interface{}{"bob"}}},
+	} {
+		result, err := eval.EvaluatePolicyWithOptions(ctx, "cached", json.RawMessage(`{"user": "`+user+`"}`), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"allow": true}, result.Value, user)
+	}
+
+	// Settings the query is compiled with are part of the key.
+	for _, opts := range []EvaluationOptions{
+		{StrictBuiltinErrors: true},
+		{RateLimiter: ratelimit.NewMemoryLimiter()},
+	} {
+		prepared, err := eval.prepare(ctx, "cached", opts)
+		assert.NoError(t, err)
+		assert.NotSame(t, first, prepared)
+	}
+
+	// Other queries are cached next to the policy's, up to maxCachedQueries.
+	first, err = eval.prepare(ctx, "cached", EvaluationOptions{})
+	assert.NoError(t, err)
+	rule, err := eval.prepare(ctx, "cached", EvaluationOptions{Query: "data.cached.allow"})
+	assert.NoError(t, err)
+	assert.NotSame(t, first, rule)
+	prepared, err := eval.prepare(ctx, "cached", EvaluationOptions{})
+	assert.NoError(t, err)
+	assert.Same(t, first, prepared)
+	for i := 0; i < 2*maxCachedQueries; i++ {
+		_, err := eval.prepare(ctx, "cached", EvaluationOptions{Query: fmt.Sprintf("data.cached.rule%d", i)})
+		assert.NoError(t, err)
+	}
+	assert.Len(t, eval.queries["cached"].queries, maxCachedQueries)
+
+	// A new version of the policy replaces the cached queries.
+	loader.module = "package cached\n\nallow = false"
+	result, err := eval.EvaluatePolicy(ctx, "cached", json.RawMessage(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"allow": false}, result.Value)
+	assert.Len(t, eval.queries, 1)
+	assert.Len(t, eval.queries["cached"].queries, 1)
+}
+
+func TestPolicyEvaluator_QueryCachePolicyEviction(t *testing.T) {
+	eval := NewPolicyEvaluator(&mutablePolicyLoader{module: "package cached\n\nallow = true"})
+	ctx := context.Background()
+
+	first, err := eval.prepare(ctx, "policy0", EvaluationOptions{})
+	assert.NoError(t, err)
+	for i := 1; i <= maxCachedPolicies; i++ {
+		// Using the first policy keeps it cached.
+		if i == maxCachedPolicies/2 {
+			_, err := eval.prepare(ctx, "policy0", EvaluationOptions{})
+			assert.NoError(t, err)
+		}
+		_, err := eval.prepare(ctx, fmt.Sprintf("policy%d", i), EvaluationOptions{})
+		assert.NoError(t, err)
+	}
+
+	assert.Len(t, eval.queries, maxCachedPolicies)
+	assert.Contains(t, eval.queries, "policy0")
+	assert.NotContains(t, eval.queries, "policy1")
+	prepared, err := eval.prepare(ctx, "policy0", EvaluationOptions{})
+	assert.NoError(t, err)
+	assert.Same(t, first, prepared)
+}
@@ -0,0 +1,58 @@
+package policyevaluator
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"opa_lambda/ratelimit"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/types"
+)
+
+// rateLimitFunction is the built-in policies call to take a token from a rate
+// limit bucket: ratelimit.allow(key, rate). The rate is a string such as
+// "100/m" or a number of requests per second.
+const rateLimitFunction = "ratelimit.allow"
+
+// rateLimitBuiltin registers rateLimitFunction backed by limiter. The
+// built-in changes state on every call, so it is never memoized.
+func rateLimitBuiltin(limiter ratelimit.Limiter) func(*rego.Rego) {
+	return rego.Function2(
+		&rego.Function{
+			Name:             rateLimitFunction,
+			Decl:             types.NewFunction(types.Args(types.S, types.NewAny(types.S, types.N)), types.B),
+			Nondeterministic: true,
+		},
+		func(bctx rego.BuiltinContext, keyTerm, rateTerm *ast.Term) (*ast.Term, error) {
+			key, ok := keyTerm.Value.(ast.String)
+			if !ok {
+				return nil, fmt.Errorf("%s: key must be a string", rateLimitFunction)
+			}
+
+			rate, err := rateFromTerm(rateTerm)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", rateLimitFunction, err)
+			}
+
+			allowed, err := limiter.Allow(bctx.Context, string(key), rate)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", rateLimitFunction, err)
+			}
+			return ast.BooleanTerm(allowed), nil
+		},
+	)
+}
+
+// rateFromTerm reads a rate given as a string or as a number per second.
+func rateFromTerm(term *ast.Term) (ratelimit.Rate, error) {
+	switch value := term.Value.(type) {
+	case ast.String:
+		return ratelimit.ParseRate(string(value))
+	case ast.Number:
+		return ratelimit.ParseRate(json.Number(value).String())
+	default:
+		return ratelimit.Rate{}, fmt.Errorf("rate must be a string or a number")
+	}
+}
@@ -0,0 +1,82 @@
+package policyevaluator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// ErrInputSchemaMismatch is returned when TypeCheckInput is set and the input
+// does not match an input schema declared by the policy.
+var ErrInputSchemaMismatch = errors.New("input does not match schema")
+
+// matchSchemaQuery validates a document against a JSON schema with OPA's own
+// JSON Schema implementation, so the result agrees with the compiler's.
+const matchSchemaQuery = "json.match_schema(input.document, input.schema)"
+
+// validateInputSchemas checks input against every inline schema the module
+// declares for the whole input. Schemas for parts of the input, and schemas
+// referenced by name, are left to the compiler's type checker.
+func validateInputSchemas(ctx context.Context, module *ast.Module, input interface{}) error {
+	for _, annotations := range module.Annotations {
+		for _, schema := range annotations.Schemas {
+			if schema.Definition == nil || !schema.Path.Equal(ast.InputRootRef) {
+				continue
+			}
+			if err := matchSchema(ctx, *schema.Definition, input); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	matchSchemaOnce     sync.Once
+	matchSchemaPrepared rego.PreparedEvalQuery
+	matchSchemaErr      error
+)
+
+// preparedMatchSchema returns matchSchemaQuery, compiled on first use and
+// shared by every validation.
+func preparedMatchSchema() (rego.PreparedEvalQuery, error) {
+	matchSchemaOnce.Do(func() {
+		matchSchemaPrepared, matchSchemaErr = rego.New(rego.Query(matchSchemaQuery)).PrepareForEval(context.Background())
+	})
+	return matchSchemaPrepared, matchSchemaErr
+}
+
+func matchSchema(ctx context.Context, schema, input interface{}) error {
+	query, err := preparedMatchSchema()
+	if err != nil {
+		return fmt.Errorf("unable to validate input schema: %w", err)
+	}
+	rs, err := query.Eval(ctx, rego.EvalInput(map[string]interface{}{"document": input, "schema": schema}))
+	if err != nil {
+		return fmt.Errorf("unable to validate input schema: %w", err)
+	}
+	if len(rs) == 0 {
+		return errors.New("unable to validate input schema: no result")
+	}
+
+	result, ok := rs[0].Expressions[0].Value.([]interface{})
+	if !ok || len(result) != 2 {
+		return errors.New("unable to validate input schema: unexpected result")
+	}
+	if matched, _ := result[0].(bool); matched {
+		return nil
+	}
+
+	failures, _ := result[1].([]interface{})
+	messages := make([]string, 0, len(failures))
+	for _, failure := range failures {
+		detail, _ := failure.(map[string]interface{})
+		messages = append(messages, fmt.Sprintf("%v: %v", detail["field"], detail["desc"]))
+	}
+	return fmt.Errorf("%w: %s", ErrInputSchemaMismatch, strings.Join(messages, "; "))
+}
@@ -0,0 +1,27 @@
+package policyevaluator
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyEvaluator_Seed(t *testing.T) {
+	eval := NewPolicyEvaluator(&mutablePolicyLoader{module: `package random
+
+draws = [rand.intn("a", 1000000), rand.intn("b", 1000000), rand.intn("c", 1000000)]
+id = uuid.rfc4122("request")`})
+
+	evaluate := func(seed *int64) interface{} {
+		result, err := eval.EvaluatePolicyWithOptions(context.Background(), "random", json.RawMessage(`{}`), EvaluationOptions{Seed: seed})
+		assert.NoError(t, err)
+		return result.Value
+	}
+	seed := func(n int64) *int64 { return &n }
+
+	assert.Equal(t, evaluate(seed(42)), evaluate(seed(42)))
+	assert.NotEqual(t, evaluate(seed(42)), evaluate(seed(7)))
+	assert.NotEqual(t, evaluate(nil), evaluate(nil))
+}
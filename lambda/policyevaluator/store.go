@@ -0,0 +1,236 @@
+package policyevaluator
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+// maxSharedStores bounds the number of data versions whose stores are kept,
+// so that data changing on every call cannot grow the cache without limit.
+// The least recently used store is evicted first.
+const maxSharedStores = 16
+
+// requestStore is the store queries are compiled against. It holds no data
+// of its own: an evaluation reads data from the store its transaction was
+// opened on, so one compiled query serves calls with different data.
+type requestStore struct {
+	storage.Store
+}
+
+// newRequestStore creates the store a query is compiled against.
+func newRequestStore() requestStore {
+	return requestStore{Store: inmem.New()}
+}
+
+// emptyStore backs calls whose only data is layered over the shared data.
+var emptyStore = inmem.New()
+
+// callData is the data one call evaluates a policy against: a store shared
+// by the calls with the same Data, and the documents of the call's own
+// layered over it.
+type callData struct {
+	store   storage.Store
+	overlay *dataOverlay
+}
+
+// requestTxn is a transaction on the store holding the shared data of one
+// call, with the call's own data layered over it.
+type requestTxn struct {
+	storage.Transaction
+	store   storage.Store
+	overlay *dataOverlay
+}
+
+// Read reads from the call's data for its transactions, and from the empty
+// store otherwise.
+func (s requestStore) Read(ctx context.Context, txn storage.Transaction, path storage.Path) (interface{}, error) {
+	if request, ok := txn.(requestTxn); ok {
+		return request.read(ctx, path)
+	}
+	return s.Store.Read(ctx, txn, path)
+}
+
+// read reads the shared data at path as the overlay changes it. Only the
+// objects along the overlay's paths are copied.
+func (t requestTxn) read(ctx context.Context, path storage.Path) (interface{}, error) {
+	node := t.overlay
+	for i, key := range path {
+		if node.replaced {
+			return lookupData(node.apply(nil), path, path[i:])
+		}
+		child := node.children[key]
+		if child == nil {
+			return t.store.Read(ctx, t.Transaction, path)
+		}
+		node = child
+	}
+	if node.replaced {
+		return node.apply(nil), nil
+	}
+
+	shared, err := t.store.Read(ctx, t.Transaction, path)
+	if err != nil && !storage.IsNotFound(err) {
+		return nil, err
+	}
+	return node.apply(shared), nil
+}
+
+// lookupData returns the value at rest within value, which is found at the
+// end of the rest of path.
+func lookupData(value interface{}, path, rest storage.Path) (interface{}, error) {
+	for _, key := range rest {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			child, ok := node[key]
+			if !ok {
+				return nil, notFoundError(path)
+			}
+			value = child
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, notFoundError(path)
+			}
+			value = node[index]
+		default:
+			return nil, notFoundError(path)
+		}
+	}
+	return value, nil
+}
+
+// notFoundError is the error stores return for reads of missing documents.
+func notFoundError(path storage.Path) error {
+	return &storage.Error{Code: storage.NotFoundErr, Message: path.String() + ": document does not exist"}
+}
+
+// dataOverlay replaces subtrees of the shared data for one call, so that a
+// call's own documents, its mocks and the policy's bundle data do not require
+// copying the shared data into a store of the call's own.
+type dataOverlay struct {
+	value    interface{}
+	replaced bool // Whether value replaces the shared data at this path.
+	children map[string]*dataOverlay
+}
+
+// replace replaces the subtree at path with value, including the
+// replacements made within it so far.
+func (o *dataOverlay) replace(path []string, value interface{}) {
+	node := o
+	for _, key := range path {
+		child := node.children[key]
+		if child == nil {
+			if node.children == nil {
+				node.children = make(map[string]*dataOverlay)
+			}
+			child = &dataOverlay{}
+			node.children[key] = child
+		}
+		node = child
+	}
+	node.value, node.replaced, node.children = value, true, nil
+}
+
+// apply returns shared with the overlay's replacements. Objects along their
+// paths are copied rather than modified, and values that are not objects are
+// replaced by objects.
+func (o *dataOverlay) apply(shared interface{}) interface{} {
+	value := shared
+	if o.replaced {
+		value = o.value
+	}
+	if len(o.children) == 0 {
+		return value
+	}
+
+	object, _ := value.(map[string]interface{})
+	object = copyObject(object)
+	for key, child := range o.children {
+		object[key] = child.apply(object[key])
+	}
+	return object
+}
+
+// sharedStore is the store built from one version of Data.
+type sharedStore struct {
+	store      storage.Store
+	lastAccess time.Time
+}
+
+// callData returns the data a call evaluates the prepared policy against:
+// Data, from the store shared by the calls with the same DataVersion, with
+// the bundle's data, RequestData and MockData layered over it. It returns
+// nil when there is no data.
+func (pe *PolicyEvaluator) callData(p *preparedPolicy, opts EvaluationOptions) (*callData, error) {
+	if err := checkBundleData(p.data, opts.Data, opts.RequestData); err != nil {
+		return nil, err
+	}
+
+	overlay := &dataOverlay{}
+	for key, value := range p.data {
+		overlay.replace([]string{key}, value)
+	}
+	for key, value := range opts.RequestData {
+		overlay.replace([]string{key}, value)
+	}
+	if len(opts.MockData) > 0 {
+		reserved := append([]ast.Ref{}, p.packages...)
+		for _, path := range opts.ReservedData {
+			ref, err := mockDataRef(path)
+			if err != nil {
+				return nil, err
+			}
+			reserved = append(reserved, ref)
+		}
+		if err := overlay.mock(opts.MockData, reserved); err != nil {
+			return nil, err
+		}
+	}
+
+	store := pe.sharedStore(opts.Data, opts.DataVersion)
+	switch {
+	case store != nil:
+	case len(overlay.children) == 0:
+		return nil, nil
+	default:
+		store = emptyStore
+	}
+	return &callData{store: store, overlay: overlay}, nil
+}
+
+// sharedStore returns the store holding data, built once per version. Data
+// without a version gets a store of its own.
+func (pe *PolicyEvaluator) sharedStore(data map[string]interface{}, version string) storage.Store {
+	if len(data) == 0 {
+		return nil
+	}
+	if version == "" {
+		return inmem.NewFromObject(data)
+	}
+
+	pe.storesMu.Lock()
+	defer pe.storesMu.Unlock()
+
+	if shared := pe.stores[version]; shared != nil {
+		shared.lastAccess = time.Now()
+		return shared.store
+	}
+	shared := &sharedStore{store: inmem.NewFromObject(data), lastAccess: time.Now()}
+	pe.stores[version] = shared
+	for len(pe.stores) > maxSharedStores {
+		var oldestVersion string
+		var oldest time.Time
+		for other, cached := range pe.stores {
+			if oldestVersion == "" || cached.lastAccess.Before(oldest) {
+				oldestVersion, oldest = other, cached.lastAccess
+			}
+		}
+		delete(pe.stores, oldestVersion)
+	}
+	return shared.store
+}
@@ -0,0 +1,83 @@
+package policyevaluator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyEvaluator_SharedStore(t *testing.T) {
+	eval := NewPolicyEvaluator(&mockPolicyLoader{})
+	ctx := context.Background()
+	payload := json.RawMessage(`{"user": "alice"}`)
+	admins := map[string]interface{}{"users": map[string]interface{}{"alice": map[string]interface{}{"role": "admin"}}}
+	viewers := map[string]interface{}{"users": map[string]interface{}{"alice": map[string]interface{}{"role": "viewer"}}}
+
+	// Calls with the same version share the store built by the first.
+	for _, data := range []map[string]interface{}{admins, viewers} {
+		result, err := eval.EvaluatePolicyWithOptions(ctx, "directory", payload, EvaluationOptions{Query: "data.directory.allow", Data: data, DataVersion: "v1"})
+		assert.NoError(t, err)
+		assert.Equal(t, true, result.Value)
+	}
+	assert.Len(t, eval.stores, 1)
+	shared := eval.stores["v1"].store
+
+	result, err := eval.EvaluatePolicyWithOptions(ctx, "directory", payload, EvaluationOptions{Query: "data.directory.allow", Data: viewers, DataVersion: "v2"})
+	assert.NoError(t, err)
+	assert.Equal(t, false, result.Value)
+	assert.Same(t, shared, eval.stores["v1"].store)
+
+	// Without a version, each call builds its own.
+	result, err = eval.EvaluatePolicyWithOptions(ctx, "directory", payload, EvaluationOptions{Query: "data.directory.allow", Data: admins})
+	assert.NoError(t, err)
+	assert.Equal(t, true, result.Value)
+	assert.Len(t, eval.stores, 2)
+
+	for i := 0; i < 2*maxSharedStores; i++ {
+		_, err := eval.EvaluatePolicyWithOptions(ctx, "directory", payload, EvaluationOptions{Data: admins, DataVersion: fmt.Sprintf("v%d", i+3)})
+		assert.NoError(t, err)
+	}
+	assert.Len(t, eval.stores, maxSharedStores)
+}
+
+func TestPolicyEvaluator_SharedStoreOverlay(t *testing.T) {
+	eval := NewPolicyEvaluator(&mockPolicyLoader{})
+	ctx := context.Background()
+	payload := json.RawMessage(`{"user": "alice", "team": "core"}`)
+	teams := map[string]interface{}{"teams": map[string]interface{}{"core": []interface{}{"bob"}, "ops": []interface{}{"carol"}}}
+	opts := EvaluationOptions{
+		Data:        teams,
+		DataVersion: "v1",
+		RequestData: map[string]interface{}{"users": map[string]interface{}{"alice": map[string]interface{}{"role": "admin"}}},
+		MockData:    map[string]interface{}{"teams.core": []interface{}{"alice"}},
+	}
+
+	// Reads see the call's documents and mocks over the shared data, at any
+	// depth.
+	for query, expected := range map[string]interface{}{
+		"data.directory":        map[string]interface{}{"allow": true, "team": []interface{}{"alice"}},
+		"data.teams":            map[string]interface{}{"core": []interface{}{"alice"}, "ops": []interface{}{"carol"}},
+		"data.teams.core[0]":    "alice",
+		"data.teams.ops":        []interface{}{"carol"},
+		"data.users.alice.role": "admin",
+	} {
+		opts.Query = query
+		result, err := eval.EvaluatePolicyWithOptions(ctx, "directory", payload, opts)
+		assert.NoError(t, err, query)
+		assert.Equal(t, expected, result.Value, query)
+	}
+	opts.Query = "data"
+	result, err := eval.EvaluatePolicyWithOptions(ctx, "directory", payload, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"core": []interface{}{"alice"}, "ops": []interface{}{"carol"}}, result.Value.(map[string]interface{})["teams"])
+	assert.Contains(t, result.Value, "users")
+
+	// The shared data is left as it was.
+	result, err = eval.EvaluatePolicyWithOptions(ctx, "directory", payload, EvaluationOptions{Query: "data", Data: teams, DataVersion: "v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, teams["teams"], result.Value.(map[string]interface{})["teams"])
+	assert.NotContains(t, result.Value, "users")
+}
@@ -0,0 +1,24 @@
+package policyevaluator
+
+import "github.com/open-policy-agent/opa/metrics"
+
+// Timers the evaluator records in EvaluationOptions.Metrics, next to those
+// OPA records itself, such as metrics.RegoQueryEval for evaluating the query
+// and metrics.RegoInputParse for converting the input.
+const (
+	TimerLoad        = "policy_load"         // Loading the policy from its backend.
+	TimerPrepare     = "policy_prepare"      // Parsing and compiling it; zero when the compiled query was cached.
+	TimerInputDecode = "policy_input_decode" // Decoding the JSON input.
+	TimerResult      = "policy_result"       // Checking, truncating and annotating the result.
+)
+
+// startTimer starts the named timer of m and returns the function stopping
+// it. Without metrics it does nothing.
+func startTimer(m metrics.Metrics, name string) func() {
+	if m == nil {
+		return func() {}
+	}
+	timer := m.Timer(name)
+	timer.Start()
+	return func() { timer.Stop() }
+}
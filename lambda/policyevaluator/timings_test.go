@@ -0,0 +1,29 @@
+package policyevaluator
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyEvaluator_Metrics(t *testing.T) {
+	eval := NewPolicyEvaluator(&mutablePolicyLoader{module: "package timed\n\nallow = input.user == \"alice\""})
+
+	m := metrics.New()
+	_, err := eval.EvaluatePolicyWithOptions(context.Background(), "timed", json.RawMessage(`{"user": "alice"}`), EvaluationOptions{Metrics: m})
+	assert.NoError(t, err)
+	for _, name := range []string{TimerLoad, TimerPrepare, TimerInputDecode, TimerResult, metrics.RegoQueryEval} {
+		assert.Positive(t, m.Timer(name).Int64(), name)
+	}
+
+	// Metrics are not part of the query cache key, and a cached query is not
+	// compiled again.
+	m = metrics.New()
+	_, err = eval.EvaluatePolicyWithOptions(context.Background(), "timed", json.RawMessage(`{"user": "alice"}`), EvaluationOptions{Metrics: m})
+	assert.NoError(t, err)
+	assert.Zero(t, m.Timer(TimerPrepare).Int64())
+	assert.Positive(t, m.Timer(metrics.RegoQueryEval).Int64())
+}
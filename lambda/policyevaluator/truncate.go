@@ -0,0 +1,30 @@
+// policyevaluator/truncate.go
+package policyevaluator
+
+// truncateResult caps top-level arrays in value to max items. When value is an
+// array it is truncated directly; when it is an object, each array-valued
+// member is truncated. Nested arrays are left untouched. The boolean reports
+// whether anything was dropped.
+func truncateResult(value interface{}, max int) (interface{}, bool) {
+	if max <= 0 {
+		return value, false
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		if len(v) > max {
+			return v[:max], true
+		}
+	case map[string]interface{}:
+		truncated := false
+		for key, member := range v {
+			if items, ok := member.([]interface{}); ok && len(items) > max {
+				v[key] = items[:max]
+				truncated = true
+			}
+		}
+		return v, truncated
+	}
+
+	return value, false
+}
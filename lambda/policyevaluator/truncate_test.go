@@ -0,0 +1,64 @@
+package policyevaluator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateResult(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     interface{}
+		max       int
+		expected  interface{}
+		truncated bool
+	}{
+		{
+			name:     "disabled",
+			value:    []interface{}{1, 2, 3},
+			max:      0,
+			expected: []interface{}{1, 2, 3},
+		},
+		{
+			name:      "top-level array",
+			value:     []interface{}{1, 2, 3},
+			max:       2,
+			expected:  []interface{}{1, 2},
+			truncated: true,
+		},
+		{
+			name:     "array within limit",
+			value:    []interface{}{1, 2},
+			max:      2,
+			expected: []interface{}{1, 2},
+		},
+		{
+			name:      "object members",
+			value:     map[string]interface{}{"allow": false, "violations": []interface{}{"a", "b", "c"}},
+			max:       1,
+			expected:  map[string]interface{}{"allow": false, "violations": []interface{}{"a"}},
+			truncated: true,
+		},
+		{
+			name:     "nested arrays are untouched",
+			value:    map[string]interface{}{"nested": map[string]interface{}{"items": []interface{}{1, 2, 3}}},
+			max:      1,
+			expected: map[string]interface{}{"nested": map[string]interface{}{"items": []interface{}{1, 2, 3}}},
+		},
+		{
+			name:     "scalar",
+			value:    true,
+			max:      1,
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, truncated := truncateResult(test.value, test.max)
+			assert.Equal(t, test.expected, value)
+			assert.Equal(t, test.truncated, truncated)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package policyloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AzureBlobAPI is the part of the Azure Blob Storage client the loader uses,
+// satisfied by *azblob.Client.
+type AzureBlobAPI interface {
+	DownloadStream(ctx context.Context, containerName, blobName string, o *azblob.DownloadStreamOptions) (azblob.DownloadStreamResponse, error)
+}
+
+// AzureBlobPolicyLoader loads policies from an Azure Blob Storage container.
+type AzureBlobPolicyLoader struct {
+	accountName   string
+	containerName string
+	client        AzureBlobAPI
+	mu            sync.RWMutex
+	cache         map[string]string
+}
+
+// NewAzureBlobPolicyLoader creates a new AzureBlobPolicyLoader for a container
+// of a storage account, authenticated with the Azure SDK's default credential
+// chain, such as a service principal configured through AZURE_TENANT_ID,
+// AZURE_CLIENT_ID and AZURE_CLIENT_SECRET, or workload identity federation.
+func NewAzureBlobPolicyLoader(accountName, containerName string) (*AzureBlobPolicyLoader, error) {
+	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find Azure credentials: %w", err)
+	}
+
+	client, err := azblob.NewClient(fmt.Sprintf("https://%s.blob.core.windows.net/", accountName), credential, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewAzureBlobPolicyLoaderWithClient(client, accountName, containerName), nil
+}
+
+// NewAzureBlobPolicyLoaderWithClient creates a new AzureBlobPolicyLoader with a custom client.
+func NewAzureBlobPolicyLoaderWithClient(client AzureBlobAPI, accountName, containerName string) *AzureBlobPolicyLoader {
+	return &AzureBlobPolicyLoader{
+		accountName:   accountName,
+		containerName: containerName,
+		client:        client,
+		cache:         make(map[string]string),
+	}
+}
+
+// newAzureBlobPolicyLoaderFromEnv creates an AzureBlobPolicyLoader for
+// AZURE_POLICY_CONTAINER in AZURE_STORAGE_ACCOUNT. It returns nil when
+// neither variable is set.
+func newAzureBlobPolicyLoaderFromEnv() (PolicyLoader, error) {
+	accountName := strings.TrimSpace(os.Getenv("AZURE_STORAGE_ACCOUNT"))
+	containerName := strings.TrimSpace(os.Getenv("AZURE_POLICY_CONTAINER"))
+	switch {
+	case accountName == "" && containerName == "":
+		return nil, nil
+	case accountName == "":
+		return nil, errors.New("AZURE_STORAGE_ACCOUNT is required with AZURE_POLICY_CONTAINER")
+	case containerName == "":
+		return nil, errors.New("AZURE_POLICY_CONTAINER is required with AZURE_STORAGE_ACCOUNT")
+	}
+
+	loader, err := NewAzureBlobPolicyLoader(accountName, containerName)
+	if err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+// LoadPolicy loads a policy from Azure Blob Storage.
+func (loader *AzureBlobPolicyLoader) LoadPolicy(ctx context.Context, policyName string) (string, error) {
+	blobName, err := KeyToFilename(policyName)
+	if err != nil {
+		return "", err
+	}
+
+	if !revalidationRequested(ctx) {
+		loader.mu.RLock()
+		cached, ok := loader.cache[policyName]
+		loader.mu.RUnlock()
+		if ok {
+			observeCache(ctx, "azure", true)
+			return cached, nil
+		}
+	}
+	observeCache(ctx, "azure", false)
+
+	resp, err := loader.client.DownloadStream(ctx, loader.containerName, blobName, nil)
+	switch {
+	case bloberror.HasCode(err, bloberror.BlobNotFound):
+		return "", &FileNotFoundError{Key: policyName}
+	case bloberror.HasCode(err, bloberror.ContainerNotFound):
+		return "", fmt.Errorf("Azure container %s does not exist in storage account %s", loader.containerName, loader.accountName)
+	case err != nil:
+		log.Errorf("failed to get policy %s from Azure Blob Storage: %v", policyName, err)
+		return "", errors.New("failed to get policy from Azure Blob Storage")
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Errorf("failed to read policy content from %s: %v", policyName, err)
+		return "", errors.New("failed to read policy content from Azure Blob Storage")
+	}
+
+	policy := string(content)
+	loader.mu.Lock()
+	loader.cache[policyName] = policy
+	loader.mu.Unlock()
+
+	return policy, nil
+}
+
+// Stats reports the policies held in the in-memory cache, sorted by policy
+// name. Cached policies are never stale, as the container is not polled for
+// changes.
+func (loader *AzureBlobPolicyLoader) Stats() []PolicyStats {
+	loader.mu.RLock()
+	stats := make([]PolicyStats, 0, len(loader.cache))
+	for name := range loader.cache {
+		stats = append(stats, PolicyStats{Policy: name, Loaded: true})
+	}
+	loader.mu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Policy < stats[j].Policy })
+	return stats
+}
@@ -0,0 +1,90 @@
+package policyloader_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"opa_lambda/policyloader"
+)
+
+type mockAzureBlobClient struct {
+	mock.Mock
+}
+
+func (m *mockAzureBlobClient) DownloadStream(ctx context.Context, containerName, blobName string, o *azblob.DownloadStreamOptions) (azblob.DownloadStreamResponse, error) {
+	args := m.Called(ctx, containerName, blobName)
+	var resp azblob.DownloadStreamResponse
+	if content, ok := args.Get(0).(string); ok {
+		resp.DownloadResponse = blob.DownloadResponse{Body: io.NopCloser(strings.NewReader(content))}
+	}
+	return resp, args.Error(1)
+}
+
+func TestLoadItemAzureBlob_Cache(t *testing.T) {
+	client := new(mockAzureBlobClient)
+	loader := policyloader.NewAzureBlobPolicyLoaderWithClient(client, "account", "policies")
+
+	policyContent := "package auth.user\n\nallow = true"
+	client.On("DownloadStream", mock.Anything, "policies", "auth/user.rego").Return(policyContent, nil).Once()
+
+	// The second LoadPolicy is served from the cache.
+	for i := 0; i < 2; i++ {
+		content, err := loader.LoadPolicy(context.Background(), "auth.user")
+		assert.NoError(t, err)
+		assert.Equal(t, policyContent, content)
+	}
+	assert.Equal(t, []policyloader.PolicyStats{{Policy: "auth.user", Loaded: true}}, loader.Stats())
+
+	// Revalidation bypasses the cache.
+	client.On("DownloadStream", mock.Anything, "policies", "auth/user.rego").Return("package auth.user", nil).Once()
+	content, err := loader.LoadPolicy(policyloader.WithRevalidation(context.Background()), "auth.user")
+	assert.NoError(t, err)
+	assert.Equal(t, "package auth.user", content)
+
+	client.AssertExpectations(t)
+}
+
+func TestLoadItemAzureBlob_Errors(t *testing.T) {
+	client := new(mockAzureBlobClient)
+	loader := policyloader.NewAzureBlobPolicyLoaderWithClient(client, "account", "policies")
+
+	client.On("DownloadStream", mock.Anything, "policies", "missing.rego").Return(nil, &azcore.ResponseError{ErrorCode: string(bloberror.BlobNotFound)})
+	client.On("DownloadStream", mock.Anything, "policies", "orphan.rego").Return(nil, &azcore.ResponseError{ErrorCode: string(bloberror.ContainerNotFound)})
+	client.On("DownloadStream", mock.Anything, "policies", "broken.rego").Return(nil, errors.New("connection reset"))
+
+	_, err := loader.LoadPolicy(context.Background(), "missing")
+	var notFound *policyloader.FileNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+	assert.Equal(t, "missing", notFound.Key)
+
+	_, err = loader.LoadPolicy(context.Background(), "orphan")
+	assert.EqualError(t, err, "Azure container policies does not exist in storage account account")
+	assert.False(t, errors.As(err, &notFound))
+
+	_, err = loader.LoadPolicy(context.Background(), "broken")
+	assert.EqualError(t, err, "failed to get policy from Azure Blob Storage")
+	assert.Empty(t, loader.Stats())
+}
+
+func TestNewPolicyLoader_AzureBlob(t *testing.T) {
+	t.Setenv("AZURE_STORAGE_ACCOUNT", "account")
+	t.Setenv("AZURE_POLICY_CONTAINER", "policies")
+
+	loader, err := policyloader.NewPolicyLoader(context.TODO())
+	assert.NoError(t, err)
+	assert.IsType(t, &policyloader.AzureBlobPolicyLoader{}, loader)
+
+	t.Setenv("AZURE_POLICY_CONTAINER", "")
+	_, err = policyloader.NewPolicyLoader(context.TODO())
+	assert.EqualError(t, err, "AZURE_POLICY_CONTAINER is required with AZURE_STORAGE_ACCOUNT")
+}
@@ -0,0 +1,126 @@
+package policyloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/open-policy-agent/opa/bundle"
+)
+
+// bundleFilename names a policy's bundle, stored in a directory named after
+// the policy as in auth/user/bundle.tar.gz for auth.user. It is the name
+// "opa build" gives its output.
+const bundleFilename = "bundle.tar.gz"
+
+// gzipMagic starts every gzip stream, and so every bundle tarball. Rego
+// source never starts with it.
+const gzipMagic = "\x1f\x8b"
+
+// ErrBundleVerification is returned for bundles whose signature is missing or
+// does not verify against the configured key.
+var ErrBundleVerification = errors.New("bundle signature verification failed")
+
+// BundleVerificationConfig is the key bundles must be signed with, as by
+// "opa build --signing-key". A bundle is only used when its .signatures.json
+// verifies against it.
+type BundleVerificationConfig struct {
+	// PublicKey is the PEM-encoded public key, or the secret for HMAC
+	// algorithms, that verifies signatures.
+	PublicKey string
+
+	// KeyID is the ID bundles are signed under. Signatures are always
+	// verified with this key, whatever key ID they name.
+	KeyID string
+
+	// Algorithm is the signing algorithm, such as RS256 (the default) or
+	// ES256.
+	Algorithm string
+
+	// Scope, when set, must match the scope claim of signatures.
+	Scope string
+}
+
+// verificationConfig converts the configuration for the bundle reader.
+func (c *BundleVerificationConfig) verificationConfig() *bundle.VerificationConfig {
+	algorithm := c.Algorithm
+	if algorithm == "" {
+		algorithm = "RS256"
+	}
+	key := &bundle.KeyConfig{Key: c.PublicKey, Algorithm: algorithm, Scope: c.Scope}
+	return bundle.NewVerificationConfig(map[string]*bundle.KeyConfig{c.KeyID: key}, c.KeyID, c.Scope, nil)
+}
+
+// IsBundle reports whether a loaded policy is an OPA bundle tarball rather
+// than Rego source.
+func IsBundle(content string) bool {
+	return strings.HasPrefix(content, gzipMagic)
+}
+
+// KeyToBundleFilename converts a policy key name to the filename of its OPA
+// bundle, such as "auth/user/bundle.tar.gz".
+func KeyToBundleFilename(key string) (string, error) {
+	return KeyToDocumentFilename(key, "/"+bundleFilename)
+}
+
+// isBundleResponse reports whether a response carries a gzipped tarball,
+// which policy services send for bundles, rather than Rego source.
+func isBundleResponse(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/gzip" || mediaType == "application/x-gzip")
+}
+
+// ReadBundle reads a policy's bundle, checking that its modules and data
+// stay within its manifest's roots. With verification, the bundle must also
+// carry a signature that verifies, or ReadBundle fails with
+// ErrBundleVerification.
+func ReadBundle(policyName string, raw []byte, verification *BundleVerificationConfig) (bundle.Bundle, error) {
+	b, err := bundle.NewReader(bytes.NewReader(raw)).WithSkipBundleVerification(true).Read()
+	if err != nil {
+		return b, fmt.Errorf("invalid bundle for policy %s: %w", policyName, err)
+	}
+	if verification == nil {
+		return b, nil
+	}
+
+	// The reader rejects bundles without .signatures.json because a key ID
+	// is always configured.
+	reader := bundle.NewReader(bytes.NewReader(raw)).WithBundleVerificationConfig(verification.verificationConfig())
+	if b, err = reader.Read(); err != nil {
+		return b, fmt.Errorf("%w for policy %s: %v", ErrBundleVerification, policyName, err)
+	}
+	return b, nil
+}
+
+// checkBundle reads a bundle the way the evaluator will, so that a corrupt
+// bundle, one whose modules or data stray outside its manifest's roots, or
+// one that is not signed with the configured key, is rejected when it is
+// downloaded rather than replacing a good copy.
+func checkBundle(policyName string, raw []byte, verification *BundleVerificationConfig) error {
+	_, err := ReadBundle(policyName, raw, verification)
+	return err
+}
+
+// BundleVerificationFromEnv returns the key bundles must be signed with,
+// from POLICY_BUNDLE_PUBLIC_KEY and its companions, or nil when no key is
+// configured.
+func BundleVerificationFromEnv() (*BundleVerificationConfig, error) {
+	key := strings.TrimSpace(os.Getenv("POLICY_BUNDLE_PUBLIC_KEY"))
+	if key == "" {
+		return nil, nil
+	}
+	cfg := &BundleVerificationConfig{
+		PublicKey: key,
+		KeyID:     strings.TrimSpace(os.Getenv("POLICY_BUNDLE_KEY_ID")),
+		Algorithm: strings.TrimSpace(os.Getenv("POLICY_BUNDLE_SIGNING_ALG")),
+		Scope:     strings.TrimSpace(os.Getenv("POLICY_BUNDLE_SCOPE")),
+	}
+	if cfg.KeyID == "" {
+		return nil, errors.New("POLICY_BUNDLE_KEY_ID is required with POLICY_BUNDLE_PUBLIC_KEY")
+	}
+	return cfg, nil
+}
@@ -0,0 +1,300 @@
+package policyloader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/bundle"
+)
+
+// buildBundle returns a bundle tarball holding files, keyed by path.
+func buildBundle(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for path, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: path, Mode: 0o600, Size: int64(len(content))}); err != nil {
+			t.Fatalf("failed to write bundle: %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write bundle: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to write bundle: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to write bundle: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestPolicyServiceLoaderBundleMode(t *testing.T) {
+	t.Parallel()
+
+	valid := buildBundle(t, map[string]string{"/auth/user/policy.rego": "package auth.user\n\nallow = true"})
+	var served atomic.Value
+	served.Store(valid)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bundles/auth/user/bundle.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(served.Load().([]byte))
+	}))
+	t.Cleanup(server.Close)
+
+	cacheDir := t.TempDir()
+	loader, err := NewPolicyServiceLoader(PolicyServiceConfig{
+		ServiceURL:     server.URL,
+		ResourcePrefix: "bundles",
+		BundleMode:     true,
+		Persist:        true,
+		CacheDir:       cacheDir,
+		PollMin:        time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("failed to create loader: %v", err)
+	}
+
+	content, err := loader.LoadPolicy(context.Background(), "auth.user")
+	if err != nil {
+		t.Fatalf("expected bundle, got %v", err)
+	}
+	if content != string(valid) {
+		t.Fatalf("expected the raw bundle, got %q", content)
+	}
+	if persisted, err := os.ReadFile(filepath.Join(cacheDir, "auth", "user", "bundle.tar.gz")); err != nil || string(persisted) != content {
+		t.Fatalf("expected the bundle to be persisted, got %v", err)
+	}
+
+	// A corrupt bundle does not replace the one in use.
+	served.Store([]byte("not a bundle"))
+	content, err = loader.LoadPolicy(WithRevalidation(context.Background()), "auth.user")
+	if err == nil || !strings.Contains(err.Error(), "invalid bundle for policy auth.user") {
+		t.Fatalf("expected invalid bundle error, got %v", err)
+	}
+	if content, err = loader.LoadPolicy(context.Background(), "auth.user"); err != nil || content != string(valid) {
+		t.Fatalf("expected the previous bundle, got %v", err)
+	}
+}
+
+func TestPolicyServiceLoaderBundleContentType(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/gzip")
+		_, _ = w.Write([]byte("package example\n\nallow = true"))
+	}))
+	t.Cleanup(server.Close)
+
+	loader, err := NewPolicyServiceLoader(PolicyServiceConfig{ServiceURL: server.URL, PollMin: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to create loader: %v", err)
+	}
+
+	// Content-Type: application/gzip marks the body as a bundle, whatever
+	// the path it was served from.
+	if _, err := loader.LoadPolicy(context.Background(), "example"); err == nil || !strings.Contains(err.Error(), "invalid bundle") {
+		t.Fatalf("expected invalid bundle error, got %v", err)
+	}
+}
+
+// generateSigningKey returns a PEM-encoded RSA private key and its public key.
+func generateSigningKey(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	private := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return string(private), string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+}
+
+// signBundle signs a bundle tarball with privateKey under keyID, as
+// "opa build --signing-key" does.
+func signBundle(t *testing.T, raw []byte, privateKey, keyID string) []byte {
+	t.Helper()
+	b, err := bundle.NewReader(bytes.NewReader(raw)).Read()
+	if err != nil {
+		t.Fatalf("failed to read bundle: %v", err)
+	}
+	if err := b.GenerateSignature(bundle.NewSigningConfig(privateKey, "RS256", ""), keyID, false); err != nil {
+		t.Fatalf("failed to sign bundle: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := bundle.NewWriter(&buf).Write(b); err != nil {
+		t.Fatalf("failed to write bundle: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestPolicyServiceLoaderBundleVerification(t *testing.T) {
+	t.Parallel()
+
+	privateKey, publicKey := generateSigningKey(t)
+	otherKey, _ := generateSigningKey(t)
+	unsigned := buildBundle(t, map[string]string{"/auth/user/policy.rego": "package auth.user\n\nallow = true"})
+
+	tests := []struct {
+		name    string
+		served  []byte
+		wantErr bool
+	}{
+		{name: "correctly signed", served: signBundle(t, unsigned, privateKey, "policy-key")},
+		{name: "signed with another key", served: signBundle(t, unsigned, otherKey, "policy-key"), wantErr: true},
+		{name: "unsigned", served: unsigned, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(tt.served)
+			}))
+			t.Cleanup(server.Close)
+
+			loader, err := NewPolicyServiceLoader(PolicyServiceConfig{
+				ServiceURL: server.URL,
+				BundleMode: true,
+				PollMin:    time.Hour,
+				BundleVerification: &BundleVerificationConfig{
+					PublicKey: publicKey,
+					KeyID:     "policy-key",
+				},
+			})
+			if err != nil {
+				t.Fatalf("failed to create loader: %v", err)
+			}
+
+			content, err := loader.LoadPolicy(context.Background(), "auth.user")
+			if !tt.wantErr {
+				if err != nil || content != string(tt.served) {
+					t.Fatalf("expected the signed bundle, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrBundleVerification) {
+				t.Fatalf("expected verification error, got %v", err)
+			}
+			if content != "" {
+				t.Fatalf("expected no content for an unverified bundle, got %d bytes", len(content))
+			}
+		})
+	}
+}
+
+func TestPolicyServiceLoaderBundleVerificationPlainModule(t *testing.T) {
+	t.Parallel()
+
+	_, publicKey := generateSigningKey(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("package auth.user\n\nallow = true"))
+	}))
+	t.Cleanup(server.Close)
+
+	loader, err := NewPolicyServiceLoader(PolicyServiceConfig{
+		ServiceURL: server.URL,
+		PollMin:    time.Hour,
+		BundleVerification: &BundleVerificationConfig{
+			PublicKey: publicKey,
+			KeyID:     "policy-key",
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create loader: %v", err)
+	}
+
+	// Only a signature vouches for a policy, so plain modules are refused.
+	content, err := loader.LoadPolicy(context.Background(), "auth.user")
+	if !errors.Is(err, ErrBundleVerification) || content != "" {
+		t.Fatalf("expected verification error for a plain module, got %d bytes, %v", len(content), err)
+	}
+}
+
+func TestPolicyServiceLoaderBundleVerificationPersisted(t *testing.T) {
+	t.Parallel()
+
+	_, publicKey := generateSigningKey(t)
+	cacheDir := t.TempDir()
+	path := filepath.Join(cacheDir, "auth", "user", "bundle.tar.gz")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create cache dir: %v", err)
+	}
+	unsigned := buildBundle(t, map[string]string{"/auth/user/policy.rego": "package auth.user\n\nallow = true"})
+	if err := os.WriteFile(path, unsigned, 0o600); err != nil {
+		t.Fatalf("failed to persist bundle: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	loader, err := NewPolicyServiceLoader(PolicyServiceConfig{
+		ServiceURL: server.URL,
+		BundleMode: true,
+		Persist:    true,
+		CacheDir:   cacheDir,
+		PollMin:    time.Hour,
+		BundleVerification: &BundleVerificationConfig{
+			PublicKey: publicKey,
+			KeyID:     "policy-key",
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create loader: %v", err)
+	}
+
+	// An unsigned bundle left in the cache is not used as a fallback.
+	if content, err := loader.LoadPolicy(context.Background(), "auth.user"); err == nil || content != "" {
+		t.Fatalf("expected the unsigned persisted bundle to be rejected, got %d bytes", len(content))
+	}
+}
+
+func TestNewPolicyServiceLoaderBundleVerificationConfig(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewPolicyServiceLoader(PolicyServiceConfig{
+		ServiceURL:         "http://localhost",
+		BundleVerification: &BundleVerificationConfig{PublicKey: "key"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "key ID") {
+		t.Fatalf("expected missing key ID error, got %v", err)
+	}
+}
+
+func TestBundleVerificationFromEnv(t *testing.T) {
+	t.Setenv("POLICY_BUNDLE_PUBLIC_KEY", "key")
+	if _, err := BundleVerificationFromEnv(); err == nil || !strings.Contains(err.Error(), "POLICY_BUNDLE_KEY_ID") {
+		t.Fatalf("expected missing key ID error, got %v", err)
+	}
+
+	t.Setenv("POLICY_BUNDLE_KEY_ID", "policy-key")
+	cfg, err := BundleVerificationFromEnv()
+	if err != nil || cfg.PublicKey != "key" || cfg.KeyID != "policy-key" {
+		t.Fatalf("unexpected verification config %+v: %v", cfg, err)
+	}
+}
@@ -0,0 +1,273 @@
+package policyloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ChainLink is one loader of a ChainedPolicyLoader.
+type ChainLink struct {
+	Name   string
+	Loader PolicyLoader
+
+	// Budget is the share, between 0 and 1, of the time left before the
+	// request deadline that the loader may use before the chain moves on to
+	// the next loader. Zero lets the loader use all of it. Budgets only apply
+	// when the context has a deadline, as Lambda invocations do.
+	Budget float64
+}
+
+// ChainedPolicyLoader tries its loaders in order and returns the first policy
+// found, so a degraded primary backend falls back to the next one instead of
+// failing the request.
+type ChainedPolicyLoader struct {
+	links []ChainLink
+}
+
+// NewChainedPolicyLoader creates a loader that tries links in order.
+func NewChainedPolicyLoader(links ...ChainLink) (*ChainedPolicyLoader, error) {
+	if len(links) == 0 {
+		return nil, errors.New("policy loader chain is empty")
+	}
+	for _, link := range links {
+		if link.Budget < 0 || link.Budget > 1 {
+			return nil, fmt.Errorf("budget for policy loader %s must be between 0 and 1", link.Name)
+		}
+	}
+	return &ChainedPolicyLoader{links: links}, nil
+}
+
+// LoadPolicy returns the policy from the first loader that produces it within
+// its budget. When every loader fails, the errors of all of them are returned;
+// the policy is only reported missing when every loader reported it missing.
+func (c *ChainedPolicyLoader) LoadPolicy(ctx context.Context, key string) (string, error) {
+	var errs []error
+	for i, link := range c.links {
+		module, err := c.loadFrom(ctx, link, key, i == len(c.links)-1)
+		if err == nil {
+			return module, nil
+		}
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("policy loader %s: %w", link.Name, ctx.Err())
+		}
+		if i < len(c.links)-1 {
+			log.WithError(err).Warnf("policy loader %s failed for %s; trying %s", link.Name, key, c.links[i+1].Name)
+		}
+		errs = append(errs, fmt.Errorf("policy loader %s: %w", link.Name, err))
+	}
+	return "", &chainError{errs: errs}
+}
+
+// chainError holds the errors of every loader of a chain. It matches
+// ErrPolicyNotFound, and FileNotFoundError, only when all of them do: a
+// loader that failed might have had the policy.
+type chainError struct {
+	errs []error
+}
+
+func (e *chainError) Error() string {
+	return errors.Join(e.errs...).Error()
+}
+
+// Unwrap returns the errors of the loaders that failed or, when every loader
+// reported the policy missing, all of them.
+func (e *chainError) Unwrap() []error {
+	var failed []error
+	for _, err := range e.errs {
+		if !errors.Is(err, ErrPolicyNotFound) {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 {
+		return e.errs
+	}
+	return failed
+}
+
+// LoadDocument loads a document from the first loader that produces it,
+// skipping loaders that cannot load documents. Budgets apply as for policies.
+func (c *ChainedPolicyLoader) LoadDocument(ctx context.Context, key, suffix string) (string, error) {
+	var links []ChainLink
+	for _, link := range c.links {
+		if documents, ok := link.Loader.(DocumentLoader); ok {
+			links = append(links, ChainLink{Name: link.Name, Loader: documentLink{documents, suffix}, Budget: link.Budget})
+		}
+	}
+	if len(links) == 0 {
+		return "", &FileNotFoundError{Key: key + suffix}
+	}
+	return (&ChainedPolicyLoader{links: links}).LoadPolicy(ctx, key)
+}
+
+// documentLink adapts a DocumentLoader to a chain link loading documents
+// with a fixed suffix.
+type documentLink struct {
+	loader DocumentLoader
+	suffix string
+}
+
+func (d documentLink) LoadPolicy(ctx context.Context, key string) (string, error) {
+	return d.loader.LoadDocument(ctx, key, d.suffix)
+}
+
+// Stats merges the stats of every loader in the chain that reports them,
+// sorted by policy name. A policy held by several loaders is reported as the
+// earliest of them holds it.
+func (c *ChainedPolicyLoader) Stats() []PolicyStats {
+	seen := make(map[string]bool)
+	stats := []PolicyStats{}
+	for _, link := range c.links {
+		reporter, ok := link.Loader.(StatsReporter)
+		if !ok {
+			continue
+		}
+		for _, stat := range reporter.Stats() {
+			if !seen[stat.Policy] {
+				seen[stat.Policy] = true
+				stats = append(stats, stat)
+			}
+		}
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Policy < stats[j].Policy })
+	return stats
+}
+
+// loadFrom runs one loader under its budget. The last loader of the chain
+// gets whatever time is left. A loader that ignores its context is abandoned
+// once the budget is spent; its result is discarded.
+func (c *ChainedPolicyLoader) loadFrom(ctx context.Context, link ChainLink, key string, last bool) (string, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok || last || link.Budget == 0 {
+		return link.Loader.LoadPolicy(ctx, key)
+	}
+
+	budget := time.Duration(float64(time.Until(deadline)) * link.Budget)
+	linkCtx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	type result struct {
+		module string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		module, err := link.Loader.LoadPolicy(linkCtx, key)
+		done <- result{module, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.module, r.err
+	case <-linkCtx.Done():
+		return "", fmt.Errorf("no response within %s budget: %w", budget.Round(time.Millisecond), linkCtx.Err())
+	}
+}
+
+// newChainedPolicyLoaderFromEnv builds the chain described by POLICY_LOADERS,
+// a comma-separated list of "loader[=budget]" entries such as
+// "s3=0.4,service". Loaders are "s3" (S3_BUCKET), "gcs" (GCS_POLICY_BUCKET),
+// "azure" (AZURE_STORAGE_ACCOUNT and AZURE_POLICY_CONTAINER), "manifest"
+// (POLICY_MANIFEST_URL), "git" (POLICY_GIT_URL), "service"
+// (POLICY_SERVICE_URL and friends) and "filesystem" (POLICY_DIR). Every
+// listed loader must be configured, and no other backend is picked up from
+// the environment. It returns nil when POLICY_LOADERS is not set.
+func newChainedPolicyLoaderFromEnv() (PolicyLoader, error) {
+	raw := strings.TrimSpace(os.Getenv("POLICY_LOADERS"))
+	if raw == "" {
+		return nil, nil
+	}
+
+	var links []ChainLink
+	var described []string
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		loaderName, budgetRaw, hasBudget := strings.Cut(entry, "=")
+		link := ChainLink{Name: strings.TrimSpace(loaderName)}
+		description := link.Name
+		if hasBudget {
+			budget, err := strconv.ParseFloat(strings.TrimSpace(budgetRaw), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid POLICY_LOADERS entry %q: budget must be a number between 0 and 1", entry)
+			}
+			link.Budget = budget
+			description = fmt.Sprintf("%s (budget %g)", link.Name, budget)
+		}
+
+		loader, err := newNamedPolicyLoader(link.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POLICY_LOADERS entry %q: %w", entry, err)
+		}
+		link.Loader = loader
+		links = append(links, link)
+		described = append(described, description)
+	}
+
+	chain, err := NewChainedPolicyLoader(links...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid POLICY_LOADERS: %w", err)
+	}
+	log.Infof("Policy loaders: %s", strings.Join(described, " -> "))
+	return chain, nil
+}
+
+// newNamedPolicyLoader creates one of the backends a chain can refer to.
+func newNamedPolicyLoader(name string) (PolicyLoader, error) {
+	switch name {
+	case "s3":
+		loader, err := newS3PolicyLoaderFromEnv()
+		if err == nil && loader == nil {
+			err = errors.New("S3_BUCKET or S3_BUCKET_ROUTES is required")
+		}
+		return loader, err
+	case "gcs":
+		loader, err := newGCSPolicyLoaderFromEnv()
+		if err == nil && loader == nil {
+			err = errors.New("GCS_POLICY_BUCKET is required")
+		}
+		return loader, err
+	case "azure":
+		loader, err := newAzureBlobPolicyLoaderFromEnv()
+		if err == nil && loader == nil {
+			err = errors.New("AZURE_STORAGE_ACCOUNT and AZURE_POLICY_CONTAINER are required")
+		}
+		return loader, err
+	case "manifest":
+		loader, err := newManifestPolicyLoaderFromEnv()
+		if err == nil && loader == nil {
+			err = errors.New("POLICY_MANIFEST_URL is required")
+		}
+		return loader, err
+	case "git":
+		loader, err := newGitPolicyLoaderFromEnv()
+		if err == nil && loader == nil {
+			err = errors.New("POLICY_GIT_URL is required")
+		}
+		return loader, err
+	case "service":
+		cfg, err := newPolicyServiceConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		if cfg == nil {
+			return nil, errors.New("POLICY_SERVICE_URL is required")
+		}
+		return NewPolicyServiceLoader(*cfg)
+	case "filesystem":
+		return newFilesystemPolicyLoaderFromEnv(), nil
+	default:
+		return nil, fmt.Errorf("unknown policy loader %q", name)
+	}
+}
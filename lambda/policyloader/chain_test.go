@@ -0,0 +1,239 @@
+package policyloader
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stubLoader struct {
+	module string
+	err    error
+	delay  time.Duration
+	honour bool // Whether the stub stops waiting when its context is done.
+	calls  int
+}
+
+func (s *stubLoader) LoadPolicy(ctx context.Context, key string) (string, error) {
+	s.calls++
+	if s.delay > 0 {
+		if s.honour {
+			select {
+			case <-time.After(s.delay):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		} else {
+			time.Sleep(s.delay)
+		}
+	}
+	return s.module, s.err
+}
+
+func TestChainedPolicyLoaderFallsBack(t *testing.T) {
+	primary := &stubLoader{err: &FileNotFoundError{Key: "example"}}
+	secondary := &stubLoader{module: "package example"}
+
+	chain, err := NewChainedPolicyLoader(ChainLink{Name: "s3", Loader: primary}, ChainLink{Name: "service", Loader: secondary})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+
+	module, err := chain.LoadPolicy(context.Background(), "example")
+	if err != nil || module != "package example" {
+		t.Fatalf("expected secondary policy, got %q, %v", module, err)
+	}
+
+	secondary.err = &FileNotFoundError{Key: "example"}
+	_, err = chain.LoadPolicy(context.Background(), "example")
+	var notFound *FileNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected FileNotFoundError, got %v", err)
+	}
+}
+
+func TestChainedPolicyLoaderNotFound(t *testing.T) {
+	primary := &stubLoader{err: errors.New("connection refused")}
+	secondary := &stubLoader{err: &FileNotFoundError{Key: "example"}}
+
+	chain, err := NewChainedPolicyLoader(ChainLink{Name: "s3", Loader: primary}, ChainLink{Name: "service", Loader: secondary})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+
+	// A failed backend might have had the policy.
+	_, err = chain.LoadPolicy(context.Background(), "example")
+	if errors.Is(err, ErrPolicyNotFound) {
+		t.Fatalf("expected a failure, got not found: %v", err)
+	}
+	if !strings.Contains(err.Error(), "connection refused") || !strings.Contains(err.Error(), "unable to locate policy file: example") {
+		t.Fatalf("expected the errors of every loader, got %v", err)
+	}
+
+	primary.err = &FileNotFoundError{Key: "example"}
+	_, err = chain.LoadPolicy(context.Background(), "example")
+	if !errors.Is(err, ErrPolicyNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestChainedPolicyLoaderBudget(t *testing.T) {
+	for _, honour := range []bool{true, false} {
+		slow := &stubLoader{module: "package slow", delay: time.Second, honour: honour}
+		fallback := &stubLoader{module: "package fallback"}
+
+		chain, err := NewChainedPolicyLoader(ChainLink{Name: "s3", Loader: slow, Budget: 0.1}, ChainLink{Name: "service", Loader: fallback})
+		if err != nil {
+			t.Fatalf("failed to create chain: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		start := time.Now()
+		module, err := chain.LoadPolicy(ctx, "example")
+		elapsed := time.Since(start)
+		cancel()
+
+		if err != nil || module != "package fallback" {
+			t.Fatalf("expected fallback policy, got %q, %v", module, err)
+		}
+		if elapsed > 200*time.Millisecond {
+			t.Fatalf("expected the slow loader to be abandoned after its budget, took %s", elapsed)
+		}
+	}
+}
+
+func TestChainedPolicyLoaderWithoutDeadline(t *testing.T) {
+	slow := &stubLoader{module: "package slow", delay: 20 * time.Millisecond}
+	fallback := &stubLoader{module: "package fallback"}
+
+	chain, err := NewChainedPolicyLoader(ChainLink{Name: "s3", Loader: slow, Budget: 0.1}, ChainLink{Name: "service", Loader: fallback})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+
+	module, err := chain.LoadPolicy(context.Background(), "example")
+	if err != nil || module != "package slow" {
+		t.Fatalf("expected the primary to run without a budget, got %q, %v", module, err)
+	}
+	if fallback.calls != 0 {
+		t.Fatalf("expected no fallback, got %d calls", fallback.calls)
+	}
+}
+
+func TestNewChainedPolicyLoaderFromEnv(t *testing.T) {
+	t.Setenv("POLICY_SERVICE_URL", "")
+	t.Setenv("POLICY_LOADERS", "filesystem=0.5, filesystem")
+
+	loader, err := newChainedPolicyLoaderFromEnv()
+	if err != nil {
+		t.Fatalf("expected chain, got %v", err)
+	}
+	chain := loader.(*ChainedPolicyLoader)
+	if len(chain.links) != 2 || chain.links[0].Budget != 0.5 || chain.links[1].Budget != 0 {
+		t.Fatalf("unexpected links %+v", chain.links)
+	}
+
+	for _, raw := range []string{"filesystem=2", "filesystem=half", "ftp", "service"} {
+		t.Setenv("POLICY_LOADERS", raw)
+		if _, err := newChainedPolicyLoaderFromEnv(); err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
+	}
+
+	t.Setenv("POLICY_LOADERS", "")
+	if loader, err := newChainedPolicyLoaderFromEnv(); loader != nil || err != nil {
+		t.Fatalf("expected no chain, got %v, %v", loader, err)
+	}
+}
+
+func TestNewChainedPolicyLoaderFromEnvPolicyLoaders(t *testing.T) {
+	t.Setenv("POLICY_SERVICE_URL", "")
+	t.Setenv("POLICY_LOADERS", "filesystem=0.25")
+
+	loader, err := newChainedPolicyLoaderFromEnv()
+	if err != nil {
+		t.Fatalf("expected chain, got %v", err)
+	}
+	chain := loader.(*ChainedPolicyLoader)
+	if len(chain.links) != 1 || chain.links[0].Name != "filesystem" || chain.links[0].Budget != 0.25 {
+		t.Fatalf("unexpected links %+v", chain.links)
+	}
+
+	// A listed backend must be configured; none is picked up implicitly.
+	t.Setenv("POLICY_LOADERS", "filesystem,service")
+	if _, err := newChainedPolicyLoaderFromEnv(); err == nil || !strings.Contains(err.Error(), `invalid POLICY_LOADERS entry "service"`) {
+		t.Fatalf("expected unconfigured service to fail, got %v", err)
+	}
+
+	t.Setenv("POLICY_LOADERS", " , ")
+	if _, err := newChainedPolicyLoaderFromEnv(); err == nil {
+		t.Fatal("expected error for an empty list")
+	}
+}
+
+type statsLoader struct {
+	stubLoader
+	stats []PolicyStats
+}
+
+func (s *statsLoader) Stats() []PolicyStats {
+	return s.stats
+}
+
+func TestChainedPolicyLoaderStats(t *testing.T) {
+	primary := &statsLoader{stats: []PolicyStats{{Policy: "b", Loaded: true}}}
+	secondary := &statsLoader{stats: []PolicyStats{{Policy: "a", Loaded: true}, {Policy: "b", Stale: true}}}
+
+	chain, err := NewChainedPolicyLoader(
+		ChainLink{Name: "s3", Loader: primary},
+		ChainLink{Name: "filesystem", Loader: &stubLoader{}},
+		ChainLink{Name: "service", Loader: secondary},
+	)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+
+	stats := chain.Stats()
+	if len(stats) != 2 || stats[0].Policy != "a" || stats[1].Policy != "b" || !stats[1].Loaded || stats[1].Stale {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+type documentStubLoader struct {
+	stubLoader
+	documents map[string]string
+}
+
+func (d *documentStubLoader) LoadDocument(ctx context.Context, key, suffix string) (string, error) {
+	if document, ok := d.documents[key+suffix]; ok {
+		return document, nil
+	}
+	return "", &FileNotFoundError{Key: key + suffix}
+}
+
+func TestChainedPolicyLoaderLoadDocument(t *testing.T) {
+	primary := &documentStubLoader{documents: map[string]string{}}
+	secondary := &documentStubLoader{documents: map[string]string{"example.transform.json": "{}"}}
+
+	chain, err := NewChainedPolicyLoader(
+		ChainLink{Name: "s3", Loader: primary},
+		ChainLink{Name: "service", Loader: &stubLoader{module: "package example"}},
+		ChainLink{Name: "filesystem", Loader: secondary},
+	)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+
+	document, err := chain.LoadDocument(context.Background(), "example", ".transform.json")
+	if err != nil || document != "{}" {
+		t.Fatalf("expected document from filesystem, got %q, %v", document, err)
+	}
+
+	_, err = chain.LoadDocument(context.Background(), "other", ".transform.json")
+	var notFound *FileNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected FileNotFoundError, got %v", err)
+	}
+}
@@ -1,7 +1,14 @@
 // policyloader/error.go
 package policyloader
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPolicyNotFound matches, with errors.Is, every FileNotFoundError, for
+// callers that only need to know that a policy does not exist.
+var ErrPolicyNotFound = errors.New("policy not found")
 
 // FileNotFoundError is returned when a policy file cannot be found.
 type FileNotFoundError struct {
@@ -13,6 +20,11 @@ func (e *FileNotFoundError) Error() string {
 	return fmt.Sprintf("unable to locate policy file: %s", e.Key)
 }
 
+// Is reports whether target is ErrPolicyNotFound.
+func (e *FileNotFoundError) Is(target error) bool {
+	return target == ErrPolicyNotFound
+}
+
 // InvalidKeyNameError is returned when a policy key name contains a slash.
 type InvalidKeyNameError struct {
 	Key string
@@ -22,3 +34,16 @@ type InvalidKeyNameError struct {
 func (e *InvalidKeyNameError) Error() string {
 	return fmt.Sprintf("policy key name contains slash: %s", e.Key)
 }
+
+// URLTooLongError is returned when the policy service URL built for a policy
+// exceeds the configured maximum length.
+type URLTooLongError struct {
+	Key    string
+	Length int
+	Max    int
+}
+
+// Error returns the error message.
+func (e *URLTooLongError) Error() string {
+	return fmt.Sprintf("policy URL for %s is %d characters, exceeding the maximum of %d", e.Key, e.Length, e.Max)
+}
@@ -17,3 +17,8 @@ func TestErrorInvalidKeyNameError(t *testing.T) {
 	err := &policyloader.InvalidKeyNameError{Key: "t/e/s/t"}
 	assert.Equal(t, "policy key name contains slash: t/e/s/t", err.Error())
 }
+
+func TestErrorURLTooLongError(t *testing.T) {
+	err := &policyloader.URLTooLongError{Key: "test", Length: 3000, Max: 2048}
+	assert.Equal(t, "policy URL for test is 3000 characters, exceeding the maximum of 2048", err.Error())
+}
@@ -4,22 +4,114 @@ package policyloader
 import (
 	"context"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
-// FilesystemPolicyLoader loads policies from the filesystem.
-type FilesystemPolicyLoader struct{}
+// defaultPolicyDir is the directory policies are read from when no other is
+// configured, relative to the working directory.
+const defaultPolicyDir = "policies"
+
+// FilesystemPolicyLoader loads policies from the filesystem. A file is read
+// again only once its modification time or size changes, so edits take
+// effect on the next evaluation without a restart, and unchanged files are
+// not read on every load.
+type FilesystemPolicyLoader struct {
+	// Root is the directory holding the policies, laid out like an S3
+	// bucket. Empty reads from ./policies.
+	Root string
+
+	mu    sync.Mutex
+	files map[string]*fileEntry // Keyed by path.
+}
+
+// fileEntry is a file's content as of its modification time and size.
+type fileEntry struct {
+	content string
+	modTime time.Time
+	size    int64
+}
+
+// NewFilesystemPolicyLoader creates a FilesystemPolicyLoader reading policies
+// from rootDir.
+func NewFilesystemPolicyLoader(rootDir string) *FilesystemPolicyLoader {
+	return &FilesystemPolicyLoader{Root: rootDir}
+}
+
+// newFilesystemPolicyLoaderFromEnv creates a FilesystemPolicyLoader for
+// POLICY_DIR, or for ./policies when it is unset.
+func newFilesystemPolicyLoaderFromEnv() *FilesystemPolicyLoader {
+	return NewFilesystemPolicyLoader(strings.TrimSpace(os.Getenv("POLICY_DIR")))
+}
 
 // LoadPolicy loads a policy from the filesystem.
 func (p *FilesystemPolicyLoader) LoadPolicy(ctx context.Context, key string) (string, error) {
-	filename, err := KeyToFilename("policies." + key)
+	filename, err := KeyToFilename(key)
 	if err != nil {
 		return "", err
 	}
 
-	rawBytes, err := os.ReadFile(filename) // #nosec G304 Input is validated and sanitized before being used here.
+	content, err := p.read(p.path(filename))
 	if err != nil {
 		return "", &FileNotFoundError{Key: key}
 	}
+	return content, nil
+}
+
+// LoadDocument loads a document stored next to a policy on the filesystem.
+func (p *FilesystemPolicyLoader) LoadDocument(ctx context.Context, key, suffix string) (string, error) {
+	filename, err := KeyToDocumentFilename(key, suffix)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := p.read(p.path(filename))
+	if err != nil {
+		return "", &FileNotFoundError{Key: key + suffix}
+	}
+	return content, nil
+}
+
+// read returns the content of the file at path, reading it again only when
+// its modification time or size differs from when it was last read.
+func (p *FilesystemPolicyLoader) read(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		p.mu.Lock()
+		delete(p.files, path)
+		p.mu.Unlock()
+		return "", err
+	}
+
+	p.mu.Lock()
+	cached := p.files[path]
+	p.mu.Unlock()
+	if cached != nil && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.content, nil
+	}
 
-	return string(rawBytes), nil
+	rawBytes, err := os.ReadFile(path) // #nosec G304 Input is validated and sanitized before being used here.
+	if err != nil {
+		return "", err
+	}
+	entry := &fileEntry{content: string(rawBytes), modTime: info.ModTime(), size: info.Size()}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.files == nil {
+		p.files = make(map[string]*fileEntry)
+	}
+	p.files[path] = entry
+	return entry.content, nil
+}
+
+// path returns the path of a file under the loader's root.
+func (p *FilesystemPolicyLoader) path(filename string) string {
+	root := p.Root
+	if root == "" {
+		root = defaultPolicyDir
+	}
+	return filepath.Join(root, filename)
 }
@@ -4,7 +4,9 @@ package policyloader_test
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -48,3 +50,74 @@ func TestFilesystemLoadPolicyNotFound(t *testing.T) {
 	_, err := loader.LoadPolicy(ctx, "not-found")
 	assert.Error(t, err)
 }
+
+func TestFilesystemLoadPolicyRoot(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "auth"), 0o700))
+	policyFile := filepath.Join(root, "auth", "user.rego")
+	assert.NoError(t, os.WriteFile(policyFile, []byte("package auth.user\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "auth", "user.transform.json"), []byte("[]"), 0o600))
+
+	loader := policyloader.NewFilesystemPolicyLoader(root)
+	policy, err := loader.LoadPolicy(context.TODO(), "auth.user")
+	assert.NoError(t, err)
+	assert.Equal(t, "package auth.user\n", policy)
+
+	document, err := loader.LoadDocument(context.TODO(), "auth.user", ".transform.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", document)
+
+	// Edits are picked up on the next load.
+	assert.NoError(t, os.WriteFile(policyFile, []byte("package auth.user\n\nallow = true\n"), 0o600))
+	policy, err = loader.LoadPolicy(context.TODO(), "auth.user")
+	assert.NoError(t, err)
+	assert.Equal(t, "package auth.user\n\nallow = true\n", policy)
+}
+
+func TestFilesystemLoadPolicyModTime(t *testing.T) {
+	root := t.TempDir()
+	policyFile := filepath.Join(root, "cached.rego")
+	assert.NoError(t, os.WriteFile(policyFile, []byte("package cached\n\nallow = true\n"), 0o600))
+	info, err := os.Stat(policyFile)
+	assert.NoError(t, err)
+
+	loader := policyloader.NewFilesystemPolicyLoader(root)
+	policy, err := loader.LoadPolicy(context.TODO(), "cached")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\n\nallow = true\n", policy)
+
+	// A file with the same modification time and size is not read again.
+	assert.NoError(t, os.WriteFile(policyFile, []byte("package cached\n\nallow = 1234\n"), 0o600))
+	assert.NoError(t, os.Chtimes(policyFile, info.ModTime(), info.ModTime()))
+	policy, err = loader.LoadPolicy(context.TODO(), "cached")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\n\nallow = true\n", policy)
+
+	// A newer modification time is.
+	later := info.ModTime().Add(time.Second)
+	assert.NoError(t, os.Chtimes(policyFile, later, later))
+	policy, err = loader.LoadPolicy(context.TODO(), "cached")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\n\nallow = 1234\n", policy)
+
+	// So is a deleted file.
+	assert.NoError(t, os.Remove(policyFile))
+	_, err = loader.LoadPolicy(context.TODO(), "cached")
+	var notFound *policyloader.FileNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+}
+
+func TestNewPolicyLoader_PolicyDir(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "local.rego"), []byte("package local\n"), 0o600))
+	t.Setenv("POLICY_DIR", root)
+	t.Setenv("S3_BUCKET", "test")
+
+	loader, err := policyloader.NewPolicyLoader(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, policyloader.NewFilesystemPolicyLoader(root), loader)
+
+	policy, err := loader.LoadPolicy(context.TODO(), "local")
+	assert.NoError(t, err)
+	assert.Equal(t, "package local\n", policy)
+}
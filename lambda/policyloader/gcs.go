@@ -0,0 +1,166 @@
+package policyloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2/google"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// gcsEndpoint is the Google Cloud Storage JSON API.
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// gcsReadOnlyScope is the OAuth scope requested for reading policies.
+const gcsReadOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// ErrGCSObjectNotFound is returned by a GCSClient for objects that do not exist.
+var ErrGCSObjectNotFound = errors.New("GCS object not found")
+
+// GCSClient reads objects from Google Cloud Storage.
+type GCSClient interface {
+	// GetObject returns the content of an object, or ErrGCSObjectNotFound
+	// when the bucket has no such object.
+	GetObject(ctx context.Context, bucket, object string) ([]byte, error)
+}
+
+// GCSPolicyLoader loads policies from a Google Cloud Storage bucket.
+type GCSPolicyLoader struct {
+	bucketName string
+	client     GCSClient
+	mu         sync.RWMutex
+	cache      map[string]string
+}
+
+// NewGCSPolicyLoader creates a new GCSPolicyLoader authenticated with
+// Google's Application Default Credentials, such as a service account key
+// named by GOOGLE_APPLICATION_CREDENTIALS or a workload identity federation
+// configuration trusting the function's AWS role. Like Google's client
+// libraries, it talks to an unauthenticated emulator instead when
+// STORAGE_EMULATOR_HOST is set.
+func NewGCSPolicyLoader(bucketName string) (*GCSPolicyLoader, error) {
+	if host := strings.TrimSpace(os.Getenv("STORAGE_EMULATOR_HOST")); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		client := &gcsHTTPClient{httpClient: http.DefaultClient, endpoint: strings.TrimSuffix(host, "/")}
+		return NewGCSPolicyLoaderWithClient(client, bucketName), nil
+	}
+
+	httpClient, err := google.DefaultClient(context.Background(), gcsReadOnlyScope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find Google credentials for GCS: %w", err)
+	}
+	return NewGCSPolicyLoaderWithClient(&gcsHTTPClient{httpClient: httpClient, endpoint: gcsEndpoint}, bucketName), nil
+}
+
+// NewGCSPolicyLoaderWithClient creates a new GCSPolicyLoader with a custom GCS client.
+func NewGCSPolicyLoaderWithClient(client GCSClient, bucketName string) *GCSPolicyLoader {
+	return &GCSPolicyLoader{
+		bucketName: bucketName,
+		client:     client,
+		cache:      make(map[string]string),
+	}
+}
+
+// newGCSPolicyLoaderFromEnv creates a GCSPolicyLoader for GCS_POLICY_BUCKET.
+// It returns nil when the variable is unset.
+func newGCSPolicyLoaderFromEnv() (PolicyLoader, error) {
+	bucketName := strings.TrimSpace(os.Getenv("GCS_POLICY_BUCKET"))
+	if bucketName == "" {
+		return nil, nil
+	}
+
+	loader, err := NewGCSPolicyLoader(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+// LoadPolicy loads a policy from GCS.
+func (loader *GCSPolicyLoader) LoadPolicy(ctx context.Context, policyName string) (string, error) {
+	objectName, err := KeyToFilename(policyName)
+	if err != nil {
+		return "", err
+	}
+
+	if !revalidationRequested(ctx) {
+		loader.mu.RLock()
+		cached, ok := loader.cache[policyName]
+		loader.mu.RUnlock()
+		if ok {
+			observeCache(ctx, "gcs", true)
+			return cached, nil
+		}
+	}
+	observeCache(ctx, "gcs", false)
+
+	content, err := loader.client.GetObject(ctx, loader.bucketName, objectName)
+	if errors.Is(err, ErrGCSObjectNotFound) {
+		return "", &FileNotFoundError{Key: policyName}
+	}
+	if err != nil {
+		log.Errorf("failed to get policy %s from GCS: %v", policyName, err)
+		return "", errors.New("failed to get policy from GCS")
+	}
+
+	policy := string(content)
+	loader.mu.Lock()
+	loader.cache[policyName] = policy
+	loader.mu.Unlock()
+
+	return policy, nil
+}
+
+// Stats reports the policies held in the in-memory cache, sorted by policy
+// name. Cached policies are never stale, as GCS is not polled for changes.
+func (loader *GCSPolicyLoader) Stats() []PolicyStats {
+	loader.mu.RLock()
+	stats := make([]PolicyStats, 0, len(loader.cache))
+	for name := range loader.cache {
+		stats = append(stats, PolicyStats{Policy: name, Loaded: true})
+	}
+	loader.mu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Policy < stats[j].Policy })
+	return stats
+}
+
+// gcsHTTPClient reads objects through the GCS JSON API with an HTTP client
+// that authenticates its requests.
+type gcsHTTPClient struct {
+	httpClient *http.Client
+	endpoint   string
+}
+
+func (c *gcsHTTPClient) GetObject(ctx context.Context, bucket, object string) ([]byte, error) {
+	objectURL := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", c.endpoint, url.PathEscape(bucket), url.PathEscape(object))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, objectURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrGCSObjectNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status %s reading gs://%s/%s", resp.Status, bucket, object)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,95 @@
+package policyloader_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"opa_lambda/policyloader"
+)
+
+type mockGCSClient struct {
+	mock.Mock
+}
+
+func (m *mockGCSClient) GetObject(ctx context.Context, bucket, object string) ([]byte, error) {
+	args := m.Called(ctx, bucket, object)
+	content, _ := args.Get(0).([]byte)
+	return content, args.Error(1)
+}
+
+func TestLoadItemGCS_Cache(t *testing.T) {
+	client := new(mockGCSClient)
+	loader := policyloader.NewGCSPolicyLoaderWithClient(client, "test-bucket")
+
+	policyContent := "package auth.user\n\nallow = true"
+	client.On("GetObject", mock.Anything, "test-bucket", "auth/user.rego").Return([]byte(policyContent), nil).Once()
+
+	// The second LoadPolicy is served from the cache.
+	for i := 0; i < 2; i++ {
+		content, err := loader.LoadPolicy(context.Background(), "auth.user")
+		assert.NoError(t, err)
+		assert.Equal(t, policyContent, content)
+	}
+	assert.Equal(t, []policyloader.PolicyStats{{Policy: "auth.user", Loaded: true}}, loader.Stats())
+
+	// Revalidation bypasses the cache.
+	client.On("GetObject", mock.Anything, "test-bucket", "auth/user.rego").Return([]byte("package auth.user"), nil).Once()
+	content, err := loader.LoadPolicy(policyloader.WithRevalidation(context.Background()), "auth.user")
+	assert.NoError(t, err)
+	assert.Equal(t, "package auth.user", content)
+
+	client.AssertExpectations(t)
+}
+
+func TestLoadItemGCS_Errors(t *testing.T) {
+	client := new(mockGCSClient)
+	loader := policyloader.NewGCSPolicyLoaderWithClient(client, "test-bucket")
+
+	client.On("GetObject", mock.Anything, "test-bucket", "missing.rego").Return(nil, policyloader.ErrGCSObjectNotFound)
+	client.On("GetObject", mock.Anything, "test-bucket", "broken.rego").Return(nil, errors.New("connection reset"))
+
+	_, err := loader.LoadPolicy(context.Background(), "missing")
+	var notFound *policyloader.FileNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+	assert.Equal(t, "missing", notFound.Key)
+
+	_, err = loader.LoadPolicy(context.Background(), "broken")
+	assert.EqualError(t, err, "failed to get policy from GCS")
+	assert.Empty(t, loader.Stats())
+
+	_, err = loader.LoadPolicy(context.Background(), "bad/name")
+	var invalid *policyloader.InvalidKeyNameError
+	assert.ErrorAs(t, err, &invalid)
+}
+
+func TestNewPolicyLoader_GCSEmulator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/storage/v1/b/test-bucket/o/auth%2Fuser.rego" || r.URL.Query().Get("alt") != "media" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("package auth.user"))
+	}))
+	defer server.Close()
+
+	t.Setenv("GCS_POLICY_BUCKET", "test-bucket")
+	t.Setenv("STORAGE_EMULATOR_HOST", server.Listener.Addr().String())
+
+	loader, err := policyloader.NewPolicyLoader(context.TODO())
+	assert.NoError(t, err)
+	assert.IsType(t, &policyloader.GCSPolicyLoader{}, loader)
+
+	content, err := loader.LoadPolicy(context.Background(), "auth.user")
+	assert.NoError(t, err)
+	assert.Equal(t, "package auth.user", content)
+
+	_, err = loader.LoadPolicy(context.Background(), "auth.admin")
+	var notFound *policyloader.FileNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+}
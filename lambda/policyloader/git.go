@@ -0,0 +1,205 @@
+package policyloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultGitCloneTimeout bounds a clone when POLICY_GIT_TIMEOUT_SECONDS is
+// unset, so that an unreachable remote fails the loader instead of hanging.
+const defaultGitCloneTimeout = time.Minute
+
+// ErrGitNotFound is returned when the git binary GitPolicyLoader runs is not
+// on the PATH.
+var ErrGitNotFound = errors.New("the git policy loader requires a git binary on the PATH")
+
+// GitPolicyLoader loads policies from a shallow clone of a Git repository,
+// pinned to a branch, tag, or commit. The repository is cloned when the
+// loader is created and policies are read from the working tree, so the
+// policies in use only change when the function is deployed with another
+// ref. It runs the git binary, which must be on the PATH.
+type GitPolicyLoader struct {
+	// Timeout bounds each clone. Zero uses defaultGitCloneTimeout.
+	Timeout time.Duration
+
+	repoURL string
+	ref     string
+	subpath string
+
+	mu  sync.Mutex
+	dir string
+}
+
+// NewGitPolicyLoader clones ref of the repository at repoURL and creates a
+// GitPolicyLoader reading policies from subpath of its working tree. ref is a
+// branch, tag, or full commit SHA; empty clones the remote's default branch.
+// subpath is relative to the repository root; empty reads from the root.
+func NewGitPolicyLoader(ctx context.Context, repoURL, ref, subpath string) (*GitPolicyLoader, error) {
+	if repoURL == "" {
+		return nil, errors.New("git repository URL is required")
+	}
+	subpath = filepath.Clean(subpath)
+	if !filepath.IsLocal(subpath) {
+		return nil, fmt.Errorf("invalid git subpath %q: must be relative to the repository root", subpath)
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("%w: the provided.al2023 runtime does not include git; add a layer or use a container image providing it", ErrGitNotFound)
+	}
+
+	loader := &GitPolicyLoader{repoURL: repoURL, ref: ref, subpath: subpath}
+	if err := loader.clone(ctx); err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+// newGitPolicyLoaderFromEnv creates a GitPolicyLoader for POLICY_GIT_URL,
+// POLICY_GIT_REF and POLICY_GIT_SUBPATH. It returns nil when POLICY_GIT_URL
+// is unset.
+func newGitPolicyLoaderFromEnv() (PolicyLoader, error) {
+	repoURL := strings.TrimSpace(os.Getenv("POLICY_GIT_URL"))
+	if repoURL == "" {
+		return nil, nil
+	}
+
+	timeout, err := durationFromEnv("POLICY_GIT_TIMEOUT_SECONDS", defaultGitCloneTimeout)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	loader, err := NewGitPolicyLoader(ctx, repoURL, strings.TrimSpace(os.Getenv("POLICY_GIT_REF")), strings.TrimSpace(os.Getenv("POLICY_GIT_SUBPATH")))
+	if err != nil {
+		return nil, err
+	}
+	loader.Timeout = timeout
+	return loader, nil
+}
+
+// LoadPolicy loads a policy from the working tree.
+func (l *GitPolicyLoader) LoadPolicy(ctx context.Context, key string) (string, error) {
+	filename, err := KeyToFilename(key)
+	if err != nil {
+		return "", err
+	}
+	return l.read(ctx, key, filename)
+}
+
+// LoadDocument loads a document stored next to a policy in the working tree.
+func (l *GitPolicyLoader) LoadDocument(ctx context.Context, key, suffix string) (string, error) {
+	filename, err := KeyToDocumentFilename(key, suffix)
+	if err != nil {
+		return "", err
+	}
+	return l.read(ctx, key+suffix, filename)
+}
+
+// read reads a file from the working tree, cloning the repository again when
+// the working tree has been removed, as happens to /tmp between invocations
+// of a long-lived container.
+func (l *GitPolicyLoader) read(ctx context.Context, key, filename string) (string, error) {
+	dir, err := l.workingTree(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	rawBytes, err := os.ReadFile(filepath.Join(dir, l.subpath, filename)) // #nosec G304 Input is validated and sanitized before being used here.
+	if err != nil {
+		return "", &FileNotFoundError{Key: key}
+	}
+	return string(rawBytes), nil
+}
+
+// workingTree returns the directory of the clone, cloning again when it is
+// gone.
+func (l *GitPolicyLoader) workingTree(ctx context.Context) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(l.dir, ".git")); err == nil {
+		return l.dir, nil
+	}
+
+	log.Warnf("Git working tree %s is gone, cloning %s again", l.dir, redactURL(l.repoURL))
+	timeout := l.Timeout
+	if timeout <= 0 {
+		timeout = defaultGitCloneTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := l.clone(ctx); err != nil {
+		return "", err
+	}
+	return l.dir, nil
+}
+
+// clone fetches the pinned ref, and only that commit, into a new temporary
+// directory and checks it out. Fetching by ref rather than running
+// "git clone --branch" pins commit SHAs the same way as branches and tags.
+func (l *GitPolicyLoader) clone(ctx context.Context) error {
+	dir, err := os.MkdirTemp("", "policy-git-")
+	if err != nil {
+		return err
+	}
+
+	ref := l.ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	steps := [][]string{
+		{"init", "--quiet"},
+		{"remote", "add", "origin", l.repoURL},
+		{"fetch", "--quiet", "--depth", "1", "origin", ref},
+		{"checkout", "--quiet", "--detach", "FETCH_HEAD"},
+	}
+	for _, args := range steps {
+		if err := runGit(ctx, dir, args...); err != nil {
+			os.RemoveAll(dir)
+			return fmt.Errorf("unable to clone %s at %s: %w", redactURL(l.repoURL), ref, err)
+		}
+	}
+
+	log.Infof("Cloned %s at %s into %s", redactURL(l.repoURL), ref, dir)
+	l.dir = dir
+	return nil
+}
+
+// runGit runs git in dir, reporting its standard error on failure. Prompts
+// for credentials are disabled, so a missing credential fails instead of
+// hanging.
+func runGit(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("git %s: %w: %s", args[0], err, message)
+		}
+		return fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// redactURL hides the password of a repository URL, such as a token in
+// https://x-access-token:<token>@github.com/org/policies.git, for logging.
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.User == nil {
+		return raw
+	}
+	return parsed.Redacted()
+}
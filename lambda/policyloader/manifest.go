@@ -0,0 +1,252 @@
+package policyloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultManifestTTL is how long a manifest is used before it is checked for
+// changes when POLICY_MANIFEST_TTL_SECONDS is unset.
+const defaultManifestTTL = time.Minute
+
+// ManifestPolicyLoader serves policies from a manifest: a single JSON object
+// mapping policy names, such as "auth.user", to their Rego source, as
+// published by build pipelines producing one artifact for all policies. The
+// whole manifest is read at once and kept in memory.
+type ManifestPolicyLoader struct {
+	// TTL is how long the manifest is used before it is read again. Reads are
+	// conditional on its ETag, so an unchanged manifest is not downloaded
+	// again. A failed read keeps the manifest read last in use. Zero reads
+	// the manifest once for the lifetime of the container.
+	TTL time.Duration
+
+	source manifestSource
+
+	mu          sync.Mutex
+	policies    map[string]string
+	etag        string
+	fetched     time.Time
+	lastRefresh time.Time
+	stale       bool
+	failures    int
+}
+
+// manifestSource reads a manifest, returning errNotModified when it still has
+// the ETag etag.
+type manifestSource interface {
+	fetch(ctx context.Context, etag string) (body []byte, newETag string, err error)
+}
+
+// NewManifestPolicyLoader creates a ManifestPolicyLoader for the manifest at
+// location, an s3://bucket/key or http(s):// URL.
+func NewManifestPolicyLoader(location string) (*ManifestPolicyLoader, error) {
+	switch {
+	case strings.HasPrefix(location, "s3://"):
+		bucket, key, ok := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+		if !ok || bucket == "" || key == "" {
+			return nil, fmt.Errorf("invalid manifest location %q: expected s3://bucket/key", location)
+		}
+		sess, err := session.NewSession(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))})
+		if err != nil {
+			return nil, err
+		}
+		return NewS3ManifestPolicyLoader(s3.New(sess), bucket, key), nil
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return NewHTTPManifestPolicyLoader(&http.Client{Timeout: 15 * time.Second}, location), nil
+	default:
+		return nil, fmt.Errorf("invalid manifest location %q: expected an s3:// or https:// URL", location)
+	}
+}
+
+// NewS3ManifestPolicyLoader creates a ManifestPolicyLoader reading the
+// manifest stored under key in an S3 bucket.
+func NewS3ManifestPolicyLoader(client s3iface.S3API, bucket, key string) *ManifestPolicyLoader {
+	return &ManifestPolicyLoader{TTL: defaultManifestTTL, source: &s3ManifestSource{client: client, bucket: bucket, key: key}}
+}
+
+// NewHTTPManifestPolicyLoader creates a ManifestPolicyLoader downloading the
+// manifest from url.
+func NewHTTPManifestPolicyLoader(client *http.Client, url string) *ManifestPolicyLoader {
+	return &ManifestPolicyLoader{TTL: defaultManifestTTL, source: &httpManifestSource{client: client, url: url}}
+}
+
+// newManifestPolicyLoaderFromEnv creates a ManifestPolicyLoader for
+// POLICY_MANIFEST_URL, refreshed every POLICY_MANIFEST_TTL_SECONDS. It
+// returns nil when the variable is unset.
+func newManifestPolicyLoaderFromEnv() (PolicyLoader, error) {
+	location := strings.TrimSpace(os.Getenv("POLICY_MANIFEST_URL"))
+	if location == "" {
+		return nil, nil
+	}
+
+	ttl, err := durationFromEnv("POLICY_MANIFEST_TTL_SECONDS", defaultManifestTTL)
+	if err != nil {
+		return nil, err
+	}
+	loader, err := NewManifestPolicyLoader(location)
+	if err != nil {
+		return nil, err
+	}
+	loader.TTL = ttl
+	return loader, nil
+}
+
+// LoadPolicy returns a policy from the manifest, reading the manifest first
+// when it has not been read yet, is older than TTL, or ctx requests
+// revalidation. A policy missing from the manifest is a FileNotFoundError.
+func (l *ManifestPolicyLoader) LoadPolicy(ctx context.Context, policyName string) (string, error) {
+	if _, err := KeyToFilename(policyName); err != nil {
+		return "", err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.refresh(ctx); err != nil {
+		return "", err
+	}
+	module, ok := l.policies[policyName]
+	if !ok {
+		return "", &FileNotFoundError{Key: policyName}
+	}
+	return module, nil
+}
+
+// refresh reads the manifest when due. When a read fails, the manifest read
+// last stays in use unless ctx requests revalidation. The caller holds mu.
+func (l *ManifestPolicyLoader) refresh(ctx context.Context) error {
+	revalidate := revalidationRequested(ctx)
+	if l.policies != nil && !revalidate && (l.TTL <= 0 || time.Since(l.fetched) < l.TTL) {
+		return nil
+	}
+
+	body, etag, err := l.source.fetch(ctx, l.etag)
+	var policies map[string]string
+	if err == nil {
+		if err = json.Unmarshal(body, &policies); err == nil && policies == nil {
+			err = errors.New("manifest is not a JSON object")
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid policy manifest: %w", err)
+		}
+	}
+
+	switch {
+	case errors.Is(err, errNotModified):
+	case err == nil:
+		l.policies, l.etag = policies, etag
+	case l.policies == nil || revalidate:
+		l.failures++
+		return err
+	default:
+		log.WithError(err).Warn("serving cached policy manifest after refresh failure")
+		l.failures++
+		l.stale = true
+		return nil
+	}
+
+	l.fetched = time.Now()
+	l.lastRefresh = l.fetched
+	l.failures = 0
+	l.stale = false
+	return nil
+}
+
+// Stats reports every policy of the manifest read last, sorted by policy
+// name. They share the manifest's refresh health.
+func (l *ManifestPolicyLoader) Stats() []PolicyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := make([]PolicyStats, 0, len(l.policies))
+	for name := range l.policies {
+		stats = append(stats, PolicyStats{
+			Policy:              name,
+			Loaded:              true,
+			Stale:               l.stale,
+			ConsecutiveFailures: l.failures,
+			LastRefresh:         l.lastRefresh,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Policy < stats[j].Policy })
+	return stats
+}
+
+// s3ManifestSource reads a manifest from S3.
+type s3ManifestSource struct {
+	client s3iface.S3API
+	bucket string
+	key    string
+}
+
+func (s *s3ManifestSource) fetch(ctx context.Context, etag string) ([]byte, string, error) {
+	input := &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key)}
+	if etag != "" {
+		input.IfNoneMatch = aws.String(etag)
+	}
+	result, err := s.client.GetObjectWithContext(ctx, input)
+	if isNotModified(err) {
+		return nil, "", errNotModified
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read policy manifest s3://%s/%s: %w", s.bucket, s.key, err)
+	}
+	defer result.Body.Close()
+
+	body, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read policy manifest s3://%s/%s: %w", s.bucket, s.key, err)
+	}
+	return body, aws.StringValue(result.ETag), nil
+}
+
+// httpManifestSource downloads a manifest over HTTP.
+type httpManifestSource struct {
+	client *http.Client
+	url    string
+}
+
+func (s *httpManifestSource) fetch(ctx context.Context, etag string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to download policy manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, "", errNotModified
+	default:
+		return nil, "", fmt.Errorf("unable to download policy manifest: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to download policy manifest: %w", err)
+	}
+	return body, resp.Header.Get("Etag"), nil
+}
@@ -0,0 +1,133 @@
+package policyloader_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"opa_lambda/policyloader"
+)
+
+func TestManifestPolicyLoader_HTTP(t *testing.T) {
+	var requests, downloads int32
+	manifest := atomic.Value{}
+	manifest.Store(`{"auth.user": "package auth.user\n\nallow = true", "example": "package example"}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		body := manifest.Load().(string)
+		etag := fmt.Sprintf(`"%d"`, len(body))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&downloads, 1)
+		w.Header().Set("Etag", etag)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	loader := policyloader.NewHTTPManifestPolicyLoader(server.Client(), server.URL+"/policies.json")
+	loader.TTL = 0
+
+	content, err := loader.LoadPolicy(context.Background(), "auth.user")
+	assert.NoError(t, err)
+	assert.Equal(t, "package auth.user\n\nallow = true", content)
+
+	// Every policy is served from the manifest read once.
+	content, err = loader.LoadPolicy(context.Background(), "example")
+	assert.NoError(t, err)
+	assert.Equal(t, "package example", content)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	_, err = loader.LoadPolicy(context.Background(), "missing")
+	assert.ErrorIs(t, err, policyloader.ErrPolicyNotFound)
+	var notFound *policyloader.FileNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+	assert.Equal(t, "missing", notFound.Key)
+
+	// Revalidating an unchanged manifest does not download it again.
+	ctx := policyloader.WithRevalidation(context.Background())
+	_, err = loader.LoadPolicy(ctx, "example")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+
+	manifest.Store(`{"example": "package example\n\nallow = false"}`)
+	content, err = loader.LoadPolicy(ctx, "example")
+	assert.NoError(t, err)
+	assert.Equal(t, "package example\n\nallow = false", content)
+	_, err = loader.LoadPolicy(context.Background(), "auth.user")
+	assert.ErrorIs(t, err, policyloader.ErrPolicyNotFound)
+
+	stats := loader.Stats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, "example", stats[0].Policy)
+	assert.False(t, stats[0].Stale)
+}
+
+func TestManifestPolicyLoader_S3RefreshFailure(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3ManifestPolicyLoader(s3Client, "artifacts", "policies.json")
+	loader.TTL = time.Nanosecond
+
+	input := &s3.GetObjectInput{Bucket: aws.String("artifacts"), Key: aws.String("policies.json")}
+	conditional := &s3.GetObjectInput{Bucket: aws.String("artifacts"), Key: aws.String("policies.json"), IfNoneMatch: aws.String(`"v1"`)}
+	notModified := awserr.NewRequestFailure(awserr.New("NotModified", "Not Modified", nil), http.StatusNotModified, "request-id")
+
+	s3Client.On("GetObjectWithContext", mock.Anything, input).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader(`{"example": "package example"}`)),
+		ETag: aws.String(`"v1"`),
+	}, nil).Once()
+	s3Client.On("GetObjectWithContext", mock.Anything, conditional).Return(nil, notModified).Once()
+	s3Client.On("GetObjectWithContext", mock.Anything, conditional).Return(nil, awserr.New("AccessDenied", "Access Denied", nil)).Once()
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond)
+		content, err := loader.LoadPolicy(context.Background(), "example")
+		assert.NoError(t, err)
+		assert.Equal(t, "package example", content)
+	}
+
+	// The failed refresh leaves the manifest read last in use.
+	stats := loader.Stats()
+	assert.Len(t, stats, 1)
+	assert.True(t, stats[0].Stale)
+	assert.Equal(t, 1, stats[0].ConsecutiveFailures)
+
+	s3Client.AssertExpectations(t)
+}
+
+func TestManifestPolicyLoader_InvalidManifest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`["package example"]`))
+	}))
+	defer server.Close()
+
+	loader := policyloader.NewHTTPManifestPolicyLoader(server.Client(), server.URL)
+	_, err := loader.LoadPolicy(context.Background(), "example")
+	assert.ErrorContains(t, err, "invalid policy manifest")
+	assert.Empty(t, loader.Stats())
+}
+
+func TestNewPolicyLoader_Manifest(t *testing.T) {
+	t.Setenv("POLICY_MANIFEST_URL", "ftp://example.com/policies.json")
+	_, err := policyloader.NewPolicyLoader(context.TODO())
+	assert.ErrorContains(t, err, "invalid manifest location")
+
+	t.Setenv("POLICY_MANIFEST_URL", "https://example.com/policies.json")
+	loader, err := policyloader.NewPolicyLoader(context.TODO())
+	assert.NoError(t, err)
+	assert.IsType(t, &policyloader.ManifestPolicyLoader{}, loader)
+}
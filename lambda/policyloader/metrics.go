@@ -0,0 +1,69 @@
+package policyloader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultMetricsNamespace is the CloudWatch namespace used on Lambda when
+// POLICY_METRICS_NAMESPACE is not set.
+const defaultMetricsNamespace = "OPALambda"
+
+// metricsOutput receives the metric records. CloudWatch Logs extracts them
+// from the function's stdout.
+var metricsOutput io.Writer = os.Stdout
+
+// CacheObserver, when set, is called each time a loader looks a policy up in
+// its in-memory cache, with the context of the load, the backend's name and
+// whether the policy was served from the cache. Lookups skipped to
+// revalidate a policy count as misses. It must be set before policies are
+// loaded.
+var CacheObserver func(ctx context.Context, backend string, hit bool)
+
+// observeCache reports a cache lookup to CacheObserver.
+func observeCache(ctx context.Context, backend string, hit bool) {
+	if CacheObserver != nil {
+		CacheObserver(ctx, backend, hit)
+	}
+}
+
+// emitRefreshMetrics writes the per-policy refresh gauges as a CloudWatch
+// embedded metric format record, so alarms can fire on stale policies even
+// while requests keep succeeding from cache.
+func emitRefreshMetrics(namespace, policyName string, failures int, stale bool) {
+	staleValue := 0
+	if stale {
+		staleValue = 1
+	}
+
+	record := map[string]interface{}{
+		"_aws": map[string]interface{}{
+			"Timestamp": time.Now().UnixMilli(),
+			"CloudWatchMetrics": []map[string]interface{}{{
+				"Namespace":  namespace,
+				"Dimensions": [][]string{{"Policy"}},
+				"Metrics": []map[string]string{
+					{"Name": "StalePolicy", "Unit": "Count"},
+					{"Name": "ConsecutiveRefreshFailures", "Unit": "Count"},
+				},
+			}},
+		},
+		"Policy":                     policyName,
+		"StalePolicy":                staleValue,
+		"ConsecutiveRefreshFailures": failures,
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.WithError(err).Warn("failed to encode refresh metrics")
+		return
+	}
+	if _, err := metricsOutput.Write(append(line, '\n')); err != nil {
+		log.WithError(err).Warn("failed to write refresh metrics")
+	}
+}
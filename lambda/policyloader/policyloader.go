@@ -3,6 +3,7 @@ package policyloader
 import (
 	"context"
 	"os"
+	"strings"
 )
 
 // PolicyLoader loads policies.
@@ -10,10 +11,32 @@ type PolicyLoader interface {
 	LoadPolicy(ctx context.Context, key string) (string, error)
 }
 
+// DocumentLoader is implemented by loaders that can also load documents
+// stored next to a policy, named like the policy file with suffix in place of
+// .rego. A missing document is a FileNotFoundError.
+type DocumentLoader interface {
+	LoadDocument(ctx context.Context, key, suffix string) (string, error)
+}
+
+// StatsReporter is implemented by loaders that keep policies in memory and
+// can describe what they hold without loading anything.
+type StatsReporter interface {
+	Stats() []PolicyStats
+}
+
 // NewPolicyLoader creates a new PolicyLoader.
 func NewPolicyLoader(ctx context.Context) (PolicyLoader, error) {
-	var loader PolicyLoader
-	var err error
+	if chain, chainErr := newChainedPolicyLoaderFromEnv(); chainErr != nil {
+		return nil, chainErr
+	} else if chain != nil {
+		return chain, nil
+	}
+
+	// A policy directory is meant for local development, so it takes
+	// precedence over remote backends that may still be configured.
+	if strings.TrimSpace(os.Getenv("POLICY_DIR")) != "" {
+		return newFilesystemPolicyLoaderFromEnv(), nil
+	}
 
 	if cfg, cfgErr := newPolicyServiceConfigFromEnv(); cfgErr != nil {
 		return nil, cfgErr
@@ -21,11 +44,25 @@ func NewPolicyLoader(ctx context.Context) (PolicyLoader, error) {
 		return NewPolicyServiceLoader(*cfg)
 	}
 
-	if bucketName := os.Getenv("S3_BUCKET"); bucketName != "" {
-		loader, err = NewS3PolicyLoader(bucketName)
-	} else {
-		loader = &FilesystemPolicyLoader{}
+	if s3Loader, err := newS3PolicyLoaderFromEnv(); err != nil || s3Loader != nil {
+		return s3Loader, err
+	}
+
+	if gcsLoader, err := newGCSPolicyLoaderFromEnv(); err != nil || gcsLoader != nil {
+		return gcsLoader, err
+	}
+
+	if azureLoader, err := newAzureBlobPolicyLoaderFromEnv(); err != nil || azureLoader != nil {
+		return azureLoader, err
+	}
+
+	if manifestLoader, err := newManifestPolicyLoaderFromEnv(); err != nil || manifestLoader != nil {
+		return manifestLoader, err
+	}
+
+	if gitLoader, err := newGitPolicyLoaderFromEnv(); err != nil || gitLoader != nil {
+		return gitLoader, err
 	}
 
-	return loader, err
+	return newFilesystemPolicyLoaderFromEnv(), nil
 }
@@ -21,3 +21,25 @@ func TestNewPolicyLoader_S3(t *testing.T) {
 	assert.NoError(t, err)
 	assert.IsType(t, &policyloader.S3PolicyLoader{}, loader)
 }
+
+func TestNewPolicyLoader_S3Routes(t *testing.T) {
+	t.Setenv("S3_BUCKET", "")
+	t.Setenv("S3_BUCKET_ROUTES", "team_a=bucket-a, team_b/=bucket-b")
+
+	loader, err := policyloader.NewPolicyLoader(context.TODO())
+	assert.NoError(t, err)
+	assert.IsType(t, &policyloader.RoutedS3PolicyLoader{}, loader)
+
+	_, err = loader.LoadPolicy(context.TODO(), "team_c.auth")
+	var notFound *policyloader.FileNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+}
+
+func TestNewPolicyLoader_S3RoutesInvalid(t *testing.T) {
+	for _, raw := range []string{"team_a", "=bucket-a", "team_a=", "team_a=bucket-a,team_a/=bucket-b"} {
+		t.Setenv("S3_BUCKET_ROUTES", raw)
+
+		_, err := policyloader.NewPolicyLoader(context.TODO())
+		assert.Error(t, err, raw)
+	}
+}
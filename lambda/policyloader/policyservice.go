@@ -9,11 +9,15 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"opa_lambda/buildinfo"
+
 	log "github.com/sirupsen/logrus"
 )
 
@@ -27,12 +31,67 @@ type PolicyServiceConfig struct {
 	PollMin        time.Duration
 	PollMax        time.Duration
 	HTTPTimeout    time.Duration
+
+	// PollOverrides replaces the global PollMin/PollMax window for individual
+	// policies, keyed by policy name.
+	PollOverrides map[string]PollWindow
+
+	// BearerTokenSecretARN names a Secrets Manager secret holding the bearer
+	// token. When set it replaces BearerToken.
+	BearerTokenSecretARN string
+
+	// BearerTokenTTL is how long a token read from Secrets Manager is cached.
+	BearerTokenTTL time.Duration
+
+	// MaxURLLength caps the length of policy URLs. Longer URLs fail with
+	// URLTooLongError instead of being sent. Zero uses DefaultMaxURLLength.
+	MaxURLLength int
+
+	// UserAgent is sent on every policy request. Empty uses DefaultUserAgent.
+	UserAgent string
+
+	// MetricsNamespace is the CloudWatch namespace refresh metrics are
+	// published under. Empty disables the metrics.
+	MetricsNamespace string
+
+	// MaxCacheEntries caps the number of policies held in memory, evicting
+	// the least recently used one beyond it. Persisted copies are kept, so an
+	// evicted policy can still be served from disk if the service is down.
+	// Zero leaves the cache unbounded.
+	MaxCacheEntries int
+
+	// BundleMode resolves policy names to OPA bundles, such as
+	// auth/user/bundle.tar.gz for auth.user, instead of single .rego files.
+	// Without it, responses sent with Content-Type: application/gzip are
+	// still taken as bundles. Bundles are returned as their raw tarball, for
+	// the evaluator to unpack.
+	BundleMode bool
+
+	// BundleVerification, when set, requires every policy, whether
+	// downloaded or read from a cache, to be a bundle signed with its key.
+	// Plain modules, and unsigned or wrongly signed bundles, fail with
+	// ErrBundleVerification and are never returned.
+	BundleVerification *BundleVerificationConfig
+
+	// SharedCache, when set, is consulted before the service whenever a
+	// policy is due for a refresh, and holds every policy downloaded, so
+	// that concurrent containers share downloads. Revalidation requests
+	// bypass it.
+	SharedCache SharedCache
+}
+
+// PollWindow bounds the randomized interval between revalidation requests.
+type PollWindow struct {
+	Min time.Duration
+	Max time.Duration
 }
 
-// PolicyServiceLoader fetches .rego files from an HTTP policy service API.
+// PolicyServiceLoader fetches .rego files, or OPA bundles, from an HTTP
+// policy service API.
 type PolicyServiceLoader struct {
 	cfg            PolicyServiceConfig
 	client         *http.Client
+	token          bearerTokenSource
 	baseURL        string
 	resourcePrefix string
 	cacheDir       string
@@ -42,11 +101,39 @@ type PolicyServiceLoader struct {
 }
 
 type policyCacheEntry struct {
-	mu       sync.Mutex
-	module   string
-	etag     string
-	nextSync time.Time
-	loaded   bool
+	lastAccess atomic.Int64 // Unix nanoseconds; read under the loader's mu to evict.
+
+	mu          sync.Mutex
+	module      string
+	etag        string
+	nextSync    time.Time
+	loaded      bool
+	stale       bool
+	failures    int
+	lastRefresh time.Time
+}
+
+// PolicyStats describes the refresh health of a policy the loader has seen.
+type PolicyStats struct {
+	Policy              string    `json:"policy"`
+	Loaded              bool      `json:"loaded"`
+	Stale               bool      `json:"stale"`                // Serving a cached or persisted copy after a failed refresh
+	ConsecutiveFailures int       `json:"consecutive_failures"` // Refresh failures since the last successful refresh
+	LastRefresh         time.Time `json:"last_refresh"`         // Time of the last successful refresh
+}
+
+// DefaultMaxURLLength is the longest policy URL requested by default. It is
+// the limit commonly recommended for interoperability with proxies and CDNs.
+const DefaultMaxURLLength = 2048
+
+// DefaultUserAgent identifies this function and its build to the policy
+// service, e.g. "opa-lambda/1.2.3 (my-function)".
+func DefaultUserAgent() string {
+	agent := "opa-lambda/" + buildinfo.Version()
+	if function := os.Getenv("AWS_LAMBDA_FUNCTION_NAME"); function != "" {
+		agent += " (" + function + ")"
+	}
+	return agent
 }
 
 // NewPolicyServiceLoader creates a loader backed by an HTTP policy service.
@@ -66,6 +153,36 @@ func NewPolicyServiceLoader(cfg PolicyServiceConfig) (*PolicyServiceLoader, erro
 	if cfg.HTTPTimeout <= 0 {
 		cfg.HTTPTimeout = 15 * time.Second
 	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = DefaultUserAgent()
+	}
+	if cfg.MaxURLLength <= 0 {
+		cfg.MaxURLLength = DefaultMaxURLLength
+	}
+	if cfg.BearerTokenTTL <= 0 {
+		cfg.BearerTokenTTL = 15 * time.Minute
+	}
+	if v := cfg.BundleVerification; v != nil && (v.PublicKey == "" || v.KeyID == "") {
+		return nil, errors.New("bundle verification requires a public key and a key ID")
+	}
+	for name, window := range cfg.PollOverrides {
+		if window.Min <= 0 {
+			return nil, fmt.Errorf("poll override for %s must have a positive minimum", name)
+		}
+		if window.Max < window.Min {
+			window.Max = window.Min
+			cfg.PollOverrides[name] = window
+		}
+	}
+
+	var token bearerTokenSource = staticToken(cfg.BearerToken)
+	if cfg.BearerTokenSecretARN != "" {
+		client, err := newSecretsManagerClient()
+		if err != nil {
+			return nil, err
+		}
+		token = &secretTokenSource{client: client, secretID: cfg.BearerTokenSecretARN, ttl: cfg.BearerTokenTTL}
+	}
 
 	cacheDir := cfg.CacheDir
 	if cacheDir == "" {
@@ -75,6 +192,7 @@ func NewPolicyServiceLoader(cfg PolicyServiceConfig) (*PolicyServiceLoader, erro
 	loader := &PolicyServiceLoader{
 		cfg:            cfg,
 		client:         &http.Client{Timeout: cfg.HTTPTimeout},
+		token:          token,
 		baseURL:        cfg.ServiceURL,
 		resourcePrefix: strings.Trim(cfg.ResourcePrefix, "/"),
 		cacheDir:       cacheDir,
@@ -91,13 +209,24 @@ func (l *PolicyServiceLoader) LoadPolicy(ctx context.Context, policyName string)
 	entry.mu.Lock()
 	defer entry.mu.Unlock()
 
-	if entry.loaded && time.Now().Before(entry.nextSync) {
+	revalidate := revalidationRequested(ctx)
+	hit := entry.loaded && !revalidate && time.Now().Before(entry.nextSync)
+	observeCache(ctx, "service", hit)
+	if hit {
 		return entry.module, nil
 	}
 
 	if err := l.refreshPolicy(ctx, policyName, entry); err != nil {
+		entry.failures++
+		defer l.recordRefresh(policyName, entry)
+
+		if revalidate {
+			return "", err
+		}
+
 		if entry.loaded {
 			log.WithError(err).Warnf("serving cached copy of %s after refresh failure", policyName)
+			entry.stale = true
 			return entry.module, nil
 		}
 
@@ -105,8 +234,9 @@ func (l *PolicyServiceLoader) LoadPolicy(ctx context.Context, policyName string)
 			if cached, readErr := l.readPersistedPolicy(policyName); readErr == nil {
 				entry.module = cached
 				entry.loaded = true
+				entry.stale = true
 				entry.etag = ""
-				entry.nextSync = l.nextInterval()
+				entry.nextSync = l.nextInterval(policyName)
 				return entry.module, nil
 			}
 		}
@@ -114,14 +244,60 @@ func (l *PolicyServiceLoader) LoadPolicy(ctx context.Context, policyName string)
 		return "", err
 	}
 
+	entry.failures = 0
+	entry.stale = false
+	entry.lastRefresh = time.Now()
+	l.recordRefresh(policyName, entry)
+
 	return entry.module, nil
 }
 
+// Stats reports the refresh health of every policy held in memory, sorted by
+// policy name.
+func (l *PolicyServiceLoader) Stats() []PolicyStats {
+	l.mu.RLock()
+	names := make([]string, 0, len(l.cache))
+	entries := make(map[string]*policyCacheEntry, len(l.cache))
+	for name, entry := range l.cache {
+		names = append(names, name)
+		entries[name] = entry
+	}
+	l.mu.RUnlock()
+	sort.Strings(names)
+
+	stats := make([]PolicyStats, 0, len(names))
+	for _, name := range names {
+		entry := entries[name]
+		entry.mu.Lock()
+		stats = append(stats, PolicyStats{
+			Policy:              name,
+			Loaded:              entry.loaded,
+			Stale:               entry.stale,
+			ConsecutiveFailures: entry.failures,
+			LastRefresh:         entry.lastRefresh,
+		})
+		entry.mu.Unlock()
+	}
+	return stats
+}
+
+// recordRefresh publishes the outcome of a refresh attempt. The caller holds
+// entry.mu.
+func (l *PolicyServiceLoader) recordRefresh(policyName string, entry *policyCacheEntry) {
+	if l.cfg.MetricsNamespace == "" {
+		return
+	}
+	emitRefreshMetrics(l.cfg.MetricsNamespace, policyName, entry.failures, entry.stale)
+}
+
+// getEntry returns the cache entry of a policy, creating it if needed, and
+// marks it as used.
 func (l *PolicyServiceLoader) getEntry(policyName string) *policyCacheEntry {
 	l.mu.RLock()
 	entry := l.cache[policyName]
 	l.mu.RUnlock()
 	if entry != nil {
+		entry.lastAccess.Store(time.Now().UnixNano())
 		return entry
 	}
 
@@ -132,11 +308,32 @@ func (l *PolicyServiceLoader) getEntry(policyName string) *policyCacheEntry {
 		entry = &policyCacheEntry{}
 		l.cache[policyName] = entry
 	}
+	entry.lastAccess.Store(time.Now().UnixNano())
+	l.evictLocked()
 	return entry
 }
 
+// evictLocked drops the least recently used entries beyond
+// cfg.MaxCacheEntries. Loads already holding an evicted entry finish with
+// it. The caller holds mu for writing.
+func (l *PolicyServiceLoader) evictLocked() {
+	if l.cfg.MaxCacheEntries <= 0 {
+		return
+	}
+	for len(l.cache) > l.cfg.MaxCacheEntries {
+		var oldestName string
+		var oldest int64
+		for name, entry := range l.cache {
+			if access := entry.lastAccess.Load(); oldestName == "" || access < oldest {
+				oldestName, oldest = name, access
+			}
+		}
+		delete(l.cache, oldestName)
+	}
+}
+
 func (l *PolicyServiceLoader) refreshPolicy(ctx context.Context, policyName string, entry *policyCacheEntry) error {
-	filename, err := KeyToFilename(policyName)
+	filename, err := l.policyFilename(policyName)
 	if err != nil {
 		return err
 	}
@@ -146,35 +343,51 @@ func (l *PolicyServiceLoader) refreshPolicy(ctx context.Context, policyName stri
 		path = l.resourcePrefix + "/" + filename
 	}
 	url := fmt.Sprintf("%s/%s", l.baseURL, strings.TrimLeft(path, "/"))
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return err
+	if len(url) > l.cfg.MaxURLLength {
+		return &URLTooLongError{Key: policyName, Length: len(url), Max: l.cfg.MaxURLLength}
 	}
 
-	if entry.etag != "" {
-		req.Header.Set("If-None-Match", entry.etag)
+	// Policies are shared under their URL, which tells services and bundle
+	// mode apart.
+	if !revalidationRequested(ctx) && l.loadShared(ctx, policyName, filename, url, entry) {
+		return nil
 	}
-	if l.cfg.BearerToken != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", l.cfg.BearerToken))
+
+	token, err := l.token.Token(ctx)
+	if err != nil {
+		return err
 	}
 
-	resp, err := l.client.Do(req)
+	resp, err := l.getPolicy(ctx, url, entry.etag, token)
 	if err != nil {
 		return fmt.Errorf("failed to download policy %s: %w", policyName, err)
 	}
+
+	// The token may have been rotated while cached; fetch it again and retry once.
+	if resp.StatusCode == http.StatusUnauthorized {
+		if rotated, refreshErr := l.token.Refresh(ctx); refreshErr != nil {
+			log.WithError(refreshErr).Warn("failed to refresh bearer token after 401")
+		} else if rotated != token {
+			log.Infof("policy service rejected the bearer token for %s; retrying with a refreshed token", policyName)
+			resp.Body.Close()
+			if resp, err = l.getPolicy(ctx, url, entry.etag, rotated); err != nil {
+				return fmt.Errorf("failed to download policy %s: %w", policyName, err)
+			}
+		}
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotModified {
 		if !entry.loaded {
 			return errors.New("policy not downloaded yet; received 304 Not Modified")
 		}
-		entry.nextSync = l.nextInterval()
+		entry.nextSync = l.nextInterval(policyName)
+		sharedSet(ctx, l.cfg.SharedCache, url, &SharedPolicy{Content: entry.module, ETag: entry.etag})
 		return nil
 	}
 
 	if resp.StatusCode == http.StatusNotFound {
-		return fmt.Errorf("policy %s not found (404)", policyName)
+		return &FileNotFoundError{Key: policyName}
 	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
@@ -185,11 +398,15 @@ func (l *PolicyServiceLoader) refreshPolicy(ctx context.Context, policyName stri
 	if err != nil {
 		return fmt.Errorf("failed to read policy body: %w", err)
 	}
+	if err := l.checkPolicy(policyName, contentBytes, isBundleResponse(resp)); err != nil {
+		return err
+	}
 
 	entry.module = string(contentBytes)
 	entry.etag = resp.Header.Get("Etag")
 	entry.loaded = true
-	entry.nextSync = l.nextInterval()
+	entry.nextSync = l.nextInterval(policyName)
+	sharedSet(ctx, l.cfg.SharedCache, url, &SharedPolicy{Content: entry.module, ETag: entry.etag})
 
 	if l.cfg.Persist {
 		if err := l.persistPolicy(filename, entry.module); err != nil {
@@ -200,6 +417,49 @@ func (l *PolicyServiceLoader) refreshPolicy(ctx context.Context, policyName stri
 	return nil
 }
 
+// loadShared fills entry from the shared cache, reporting false when the
+// cache is not configured, misses, or fails. Bundles are checked as if they
+// had been downloaded.
+func (l *PolicyServiceLoader) loadShared(ctx context.Context, policyName, filename, url string, entry *policyCacheEntry) bool {
+	shared := sharedGet(ctx, l.cfg.SharedCache, url)
+	if shared == nil {
+		return false
+	}
+	if err := l.checkPolicy(policyName, []byte(shared.Content), false); err != nil {
+		log.WithError(err).Warnf("ignoring shared copy of %s", policyName)
+		return false
+	}
+
+	entry.module = shared.Content
+	entry.etag = shared.ETag
+	entry.loaded = true
+	entry.nextSync = l.nextInterval(policyName)
+
+	if l.cfg.Persist {
+		if err := l.persistPolicy(filename, entry.module); err != nil {
+			log.WithError(err).Warnf("failed to persist policy %s", policyName)
+		}
+	}
+	return true
+}
+
+func (l *PolicyServiceLoader) getPolicy(ctx context.Context, url, etag, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", l.cfg.UserAgent)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+
+	return l.client.Do(req)
+}
+
 func (l *PolicyServiceLoader) persistPolicy(filename, contents string) error {
 	fullPath := filepath.Join(l.cacheDir, filename)
 	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
@@ -213,7 +473,7 @@ func (l *PolicyServiceLoader) persistPolicy(filename, contents string) error {
 }
 
 func (l *PolicyServiceLoader) readPersistedPolicy(policyName string) (string, error) {
-	filename, err := KeyToFilename(policyName)
+	filename, err := l.policyFilename(policyName)
 	if err != nil {
 		return "", err
 	}
@@ -222,18 +482,56 @@ func (l *PolicyServiceLoader) readPersistedPolicy(policyName string) (string, er
 	if err != nil {
 		return "", err
 	}
+	// Persisted bundles are checked again, in case the key changed or the
+	// file was tampered with since it was written.
+	if err := l.checkPolicy(policyName, bytes, false); err != nil {
+		return "", err
+	}
 	return string(bytes), nil
 }
 
-func (l *PolicyServiceLoader) nextInterval() time.Time {
-	interval := l.cfg.PollMin
-	if l.cfg.PollMax > l.cfg.PollMin {
-		delta := l.cfg.PollMax - l.cfg.PollMin
+// checkPolicy checks a policy before it is used. Bundles, including any
+// content in bundle mode or sent as a gzip response, are checked with
+// checkBundle. With bundle verification, only a signature vouches for a
+// policy, so content that is not a bundle is rejected with
+// ErrBundleVerification.
+func (l *PolicyServiceLoader) checkPolicy(policyName string, content []byte, bundleResponse bool) error {
+	if l.cfg.BundleMode || bundleResponse || IsBundle(string(content)) {
+		return checkBundle(policyName, content, l.cfg.BundleVerification)
+	}
+	if l.cfg.BundleVerification != nil {
+		return fmt.Errorf("%w for policy %s: not a signed bundle", ErrBundleVerification, policyName)
+	}
+	return nil
+}
+
+// policyFilename returns the path of a policy relative to the service URL and
+// cache directory: its bundle in bundle mode, its .rego file otherwise.
+func (l *PolicyServiceLoader) policyFilename(policyName string) (string, error) {
+	if l.cfg.BundleMode {
+		return KeyToBundleFilename(policyName)
+	}
+	return KeyToFilename(policyName)
+}
+
+func (l *PolicyServiceLoader) nextInterval(policyName string) time.Time {
+	window := l.pollWindow(policyName)
+	interval := window.Min
+	if window.Max > window.Min {
+		delta := window.Max - window.Min
 		interval += time.Duration(rand.Int63n(int64(delta)))
 	}
 	return time.Now().Add(interval)
 }
 
+// pollWindow returns the policy's override window, falling back to the global one.
+func (l *PolicyServiceLoader) pollWindow(policyName string) PollWindow {
+	if window, ok := l.cfg.PollOverrides[policyName]; ok {
+		return window
+	}
+	return PollWindow{Min: l.cfg.PollMin, Max: l.cfg.PollMax}
+}
+
 func newPolicyServiceConfigFromEnv() (*PolicyServiceConfig, error) {
 	svc := strings.TrimSpace(os.Getenv("POLICY_SERVICE_URL"))
 	if svc == "" {
@@ -241,11 +539,13 @@ func newPolicyServiceConfigFromEnv() (*PolicyServiceConfig, error) {
 	}
 
 	cfg := &PolicyServiceConfig{
-		ServiceURL:     svc,
-		ResourcePrefix: strings.TrimSpace(os.Getenv("POLICY_RESOURCE_PREFIX")),
-		BearerToken:    strings.TrimSpace(os.Getenv("POLICY_BEARER_TOKEN")),
-		CacheDir:       strings.TrimSpace(os.Getenv("POLICY_CACHE_DIR")),
-		Persist:        true,
+		ServiceURL:           svc,
+		ResourcePrefix:       strings.TrimSpace(os.Getenv("POLICY_RESOURCE_PREFIX")),
+		BearerToken:          strings.TrimSpace(os.Getenv("POLICY_BEARER_TOKEN")),
+		BearerTokenSecretARN: strings.TrimSpace(os.Getenv("POLICY_BEARER_TOKEN_SECRET_ARN")),
+		CacheDir:             strings.TrimSpace(os.Getenv("POLICY_CACHE_DIR")),
+		UserAgent:            strings.TrimSpace(os.Getenv("POLICY_USER_AGENT")),
+		Persist:              true,
 	}
 
 	if raw := strings.TrimSpace(os.Getenv("POLICY_PERSIST")); raw != "" {
@@ -255,8 +555,18 @@ func newPolicyServiceConfigFromEnv() (*PolicyServiceConfig, error) {
 		}
 		cfg.Persist = val
 	}
+	if raw := strings.TrimSpace(os.Getenv("POLICY_BUNDLE_MODE")); raw != "" {
+		val, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POLICY_BUNDLE_MODE: %w", err)
+		}
+		cfg.BundleMode = val
+	}
 
 	var err error
+	if cfg.BundleVerification, err = BundleVerificationFromEnv(); err != nil {
+		return nil, err
+	}
 	if cfg.PollMin, err = durationFromEnv("POLICY_POLL_MIN_SECONDS", 10*time.Second); err != nil {
 		return nil, err
 	}
@@ -266,10 +576,80 @@ func newPolicyServiceConfigFromEnv() (*PolicyServiceConfig, error) {
 	if cfg.HTTPTimeout, err = durationFromEnv("POLICY_HTTP_TIMEOUT_SECONDS", 15*time.Second); err != nil {
 		return nil, err
 	}
+	if cfg.SharedCache, err = newRedisCacheFromEnv(); err != nil {
+		return nil, err
+	}
+	if cfg.BearerTokenTTL, err = durationFromEnv("POLICY_BEARER_TOKEN_TTL_SECONDS", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if raw := strings.TrimSpace(os.Getenv("POLICY_MAX_URL_LENGTH")); raw != "" {
+		if cfg.MaxURLLength, err = strconv.Atoi(raw); err != nil || cfg.MaxURLLength <= 0 {
+			return nil, fmt.Errorf("invalid POLICY_MAX_URL_LENGTH: must be a positive integer")
+		}
+	}
+	if cfg.PollOverrides, err = pollOverridesFromEnv("POLICY_POLL_OVERRIDES"); err != nil {
+		return nil, err
+	}
+	if raw := strings.TrimSpace(os.Getenv("POLICY_CACHE_MAX_ENTRIES")); raw != "" {
+		if cfg.MaxCacheEntries, err = strconv.Atoi(raw); err != nil || cfg.MaxCacheEntries < 0 {
+			return nil, fmt.Errorf("invalid POLICY_CACHE_MAX_ENTRIES: must be a non-negative integer")
+		}
+	}
+
+	// Metrics are published through the function's logs, so only default
+	// them on when running on Lambda. "none" turns them off.
+	cfg.MetricsNamespace = strings.TrimSpace(os.Getenv("POLICY_METRICS_NAMESPACE"))
+	if cfg.MetricsNamespace == "" && os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
+		cfg.MetricsNamespace = defaultMetricsNamespace
+	}
+	if strings.EqualFold(cfg.MetricsNamespace, "none") {
+		cfg.MetricsNamespace = ""
+	}
 
 	return cfg, nil
 }
 
+// pollOverridesFromEnv parses comma-separated "policy=min[:max]" pairs, in seconds,
+// such as "auth.user=5:10,static.config=3600".
+func pollOverridesFromEnv(name string) (map[string]PollWindow, error) {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return nil, nil
+	}
+
+	overrides := make(map[string]PollWindow)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		policyName, window, ok := strings.Cut(pair, "=")
+		policyName = strings.TrimSpace(policyName)
+		if !ok || policyName == "" {
+			return nil, fmt.Errorf("invalid %s entry %q: expected policy=min[:max]", name, pair)
+		}
+
+		minRaw, maxRaw, hasMax := strings.Cut(window, ":")
+		minSeconds, err := strconv.Atoi(strings.TrimSpace(minRaw))
+		if err != nil || minSeconds <= 0 {
+			return nil, fmt.Errorf("invalid %s entry %q: minimum must be a positive number of seconds", name, pair)
+		}
+		maxSeconds := minSeconds
+		if hasMax {
+			maxSeconds, err = strconv.Atoi(strings.TrimSpace(maxRaw))
+			if err != nil || maxSeconds < minSeconds {
+				return nil, fmt.Errorf("invalid %s entry %q: maximum must be at least the minimum", name, pair)
+			}
+		}
+
+		overrides[policyName] = PollWindow{
+			Min: time.Duration(minSeconds) * time.Second,
+			Max: time.Duration(maxSeconds) * time.Second,
+		}
+	}
+	return overrides, nil
+}
+
 func durationFromEnv(name string, def time.Duration) (time.Duration, error) {
 	raw := strings.TrimSpace(os.Getenv(name))
 	if raw == "" {
@@ -1,11 +1,15 @@
 package policyloader
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -67,6 +71,58 @@ func TestPolicyServiceLoaderCachesWithETag(t *testing.T) {
 	}
 }
 
+func TestPolicyServiceLoaderRevalidation(t *testing.T) {
+	t.Parallel()
+
+	var requests, failing int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("package example\nallow := true"))
+	}))
+	t.Cleanup(server.Close)
+
+	loader, err := NewPolicyServiceLoader(PolicyServiceConfig{ServiceURL: server.URL, PollMin: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to create loader: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := loader.LoadPolicy(ctx, "example"); err != nil {
+		t.Fatalf("expected policy, got %v", err)
+	}
+	if _, err := loader.LoadPolicy(ctx, "example"); err != nil {
+		t.Fatalf("expected cached policy, got %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Fatalf("expected the second load to be cached, got %d requests", got)
+	}
+
+	if _, err := loader.LoadPolicy(WithRevalidation(ctx), "example"); err != nil {
+		t.Fatalf("expected revalidated policy, got %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("expected revalidation to reach the service, got %d requests", got)
+	}
+
+	// A failed revalidation is reported rather than served from the cache.
+	atomic.StoreInt32(&failing, 1)
+	if _, err := loader.LoadPolicy(WithRevalidation(ctx), "example"); err == nil {
+		t.Fatal("expected revalidation failure")
+	}
+	if _, err := loader.LoadPolicy(ctx, "example"); err != nil {
+		t.Fatalf("expected cached policy, got %v", err)
+	}
+}
+
 func TestPolicyServiceLoaderUsesPersistedPolicy(t *testing.T) {
 	t.Parallel()
 
@@ -112,3 +168,246 @@ func TestPolicyServiceLoaderUsesPersistedPolicy(t *testing.T) {
 		t.Fatalf("expected persisted policy, got %v", err)
 	}
 }
+
+func TestPolicyServiceLoaderEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	var down atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		name := strings.TrimSuffix(filepath.Base(r.URL.Path), ".rego")
+		_, _ = w.Write([]byte("package " + name))
+	}))
+	t.Cleanup(server.Close)
+
+	loader, err := NewPolicyServiceLoader(PolicyServiceConfig{
+		ServiceURL:      server.URL,
+		PollMin:         time.Hour,
+		HTTPTimeout:     time.Second,
+		Persist:         true,
+		CacheDir:        t.TempDir(),
+		MaxCacheEntries: 2,
+	})
+	if err != nil {
+		t.Fatalf("failed to create loader: %v", err)
+	}
+
+	ctx := context.Background()
+	for _, name := range []string{"alpha", "beta", "alpha", "gamma"} {
+		if _, err := loader.LoadPolicy(ctx, name); err != nil {
+			t.Fatalf("expected policy %s, got %v", name, err)
+		}
+	}
+
+	var cached []string
+	for _, stat := range loader.Stats() {
+		cached = append(cached, stat.Policy)
+	}
+	if strings.Join(cached, ",") != "alpha,gamma" {
+		t.Fatalf("expected beta to be evicted, got %v", cached)
+	}
+
+	// The evicted policy's persisted copy outlives its cache entry.
+	down.Store(true)
+	module, err := loader.LoadPolicy(ctx, "beta")
+	if err != nil || module != "package beta" {
+		t.Fatalf("expected persisted beta, got %q, %v", module, err)
+	}
+}
+
+func TestPolicyServiceLoaderRefreshStats(t *testing.T) {
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("package example\nallow := true"))
+	}))
+	t.Cleanup(server.Close)
+
+	var metrics bytes.Buffer
+	metricsOutput = &metrics
+	t.Cleanup(func() { metricsOutput = os.Stdout })
+
+	loader, err := NewPolicyServiceLoader(PolicyServiceConfig{
+		ServiceURL:       server.URL,
+		PollMin:          time.Hour,
+		PollMax:          time.Hour,
+		HTTPTimeout:      time.Second,
+		MetricsNamespace: "Test",
+	})
+	if err != nil {
+		t.Fatalf("failed to create loader: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := loader.LoadPolicy(ctx, "example"); err != nil {
+		t.Fatalf("expected policy, got %v", err)
+	}
+
+	failing.Store(true)
+	for i := 0; i < 2; i++ {
+		entry := loader.getEntry("example")
+		entry.mu.Lock()
+		entry.nextSync = time.Now().Add(-time.Minute)
+		entry.mu.Unlock()
+
+		if _, err := loader.LoadPolicy(ctx, "example"); err != nil {
+			t.Fatalf("expected cached policy, got %v", err)
+		}
+	}
+
+	stats := loader.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("expected stats for one policy, got %+v", stats)
+	}
+	if got := stats[0]; got.Policy != "example" || !got.Stale || got.ConsecutiveFailures != 2 || got.LastRefresh.IsZero() {
+		t.Fatalf("unexpected stats %+v", got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(metrics.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a metric record per refresh, got %q", metrics.String())
+	}
+	var last map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[2]), &last); err != nil {
+		t.Fatalf("invalid metric record: %v", err)
+	}
+	if last["Policy"] != "example" || last["StalePolicy"] != float64(1) || last["ConsecutiveRefreshFailures"] != float64(2) {
+		t.Fatalf("unexpected metric record %v", last)
+	}
+
+	failing.Store(false)
+	entry := loader.getEntry("example")
+	entry.mu.Lock()
+	entry.nextSync = time.Now().Add(-time.Minute)
+	entry.mu.Unlock()
+	if _, err := loader.LoadPolicy(ctx, "example"); err != nil {
+		t.Fatalf("expected policy, got %v", err)
+	}
+	if got := loader.Stats()[0]; got.Stale || got.ConsecutiveFailures != 0 {
+		t.Fatalf("expected recovered stats, got %+v", got)
+	}
+}
+
+func TestPolicyServiceLoaderUserAgent(t *testing.T) {
+	t.Parallel()
+
+	agents := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.UserAgent()
+		_, _ = w.Write([]byte("package example\nallow := true"))
+	}))
+	t.Cleanup(server.Close)
+
+	for _, userAgent := range []string{"", "policy-client/7"} {
+		loader, err := NewPolicyServiceLoader(PolicyServiceConfig{ServiceURL: server.URL, UserAgent: userAgent})
+		if err != nil {
+			t.Fatalf("failed to create loader: %v", err)
+		}
+		if _, err := loader.LoadPolicy(context.Background(), "example"); err != nil {
+			t.Fatalf("expected policy, got %v", err)
+		}
+	}
+
+	if got := <-agents; !strings.HasPrefix(got, "opa-lambda/") {
+		t.Fatalf("expected default user agent, got %q", got)
+	}
+	if got := <-agents; got != "policy-client/7" {
+		t.Fatalf("expected configured user agent, got %q", got)
+	}
+}
+
+func TestPolicyServiceLoaderMaxURLLength(t *testing.T) {
+	t.Parallel()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte("package example\nallow := true"))
+	}))
+	t.Cleanup(server.Close)
+
+	loader, err := NewPolicyServiceLoader(PolicyServiceConfig{ServiceURL: server.URL, MaxURLLength: len(server.URL) + 20})
+	if err != nil {
+		t.Fatalf("failed to create loader: %v", err)
+	}
+
+	if _, err := loader.LoadPolicy(context.Background(), "example"); err != nil {
+		t.Fatalf("expected policy, got %v", err)
+	}
+
+	_, err = loader.LoadPolicy(context.Background(), "a.very.long.policy.name")
+	var tooLong *URLTooLongError
+	if !errors.As(err, &tooLong) {
+		t.Fatalf("expected URLTooLongError, got %v", err)
+	}
+	if tooLong.Max != len(server.URL)+20 {
+		t.Fatalf("unexpected maximum in %v", tooLong)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Fatalf("expected only the short URL to be requested, got %d requests", got)
+	}
+}
+
+func TestPolicyServiceLoaderPollOverrides(t *testing.T) {
+	t.Parallel()
+
+	cfg := PolicyServiceConfig{
+		ServiceURL: "http://127.0.0.1:0",
+		PollMin:    time.Hour,
+		PollMax:    time.Hour,
+		PollOverrides: map[string]PollWindow{
+			"hot": {Min: time.Second},
+		},
+	}
+
+	loader, err := NewPolicyServiceLoader(cfg)
+	if err != nil {
+		t.Fatalf("failed to create loader: %v", err)
+	}
+
+	if got := loader.pollWindow("hot"); got != (PollWindow{Min: time.Second, Max: time.Second}) {
+		t.Fatalf("expected override window, got %+v", got)
+	}
+	if got := loader.pollWindow("cold"); got != (PollWindow{Min: time.Hour, Max: time.Hour}) {
+		t.Fatalf("expected global window, got %+v", got)
+	}
+
+	if next := loader.nextInterval("hot"); time.Until(next) > time.Second {
+		t.Fatalf("expected hot policy to revalidate within a second, got %v", time.Until(next))
+	}
+}
+
+func TestPollOverridesFromEnv(t *testing.T) {
+	t.Setenv("POLICY_POLL_OVERRIDES", "auth.user=5:10, static.config=3600")
+
+	overrides, err := pollOverridesFromEnv("POLICY_POLL_OVERRIDES")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]PollWindow{
+		"auth.user":     {Min: 5 * time.Second, Max: 10 * time.Second},
+		"static.config": {Min: time.Hour, Max: time.Hour},
+	}
+	if len(overrides) != len(expected) {
+		t.Fatalf("expected %d overrides, got %d", len(expected), len(overrides))
+	}
+	for name, window := range expected {
+		if overrides[name] != window {
+			t.Fatalf("expected %s window %+v, got %+v", name, window, overrides[name])
+		}
+	}
+
+	for _, invalid := range []string{"auth.user", "auth.user=0", "auth.user=10:5", "=5"} {
+		t.Setenv("POLICY_POLL_OVERRIDES", invalid)
+		if _, err := pollOverridesFromEnv("POLICY_POLL_OVERRIDES"); err == nil {
+			t.Fatalf("expected error for %q", invalid)
+		}
+	}
+}
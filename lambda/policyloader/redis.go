@@ -0,0 +1,176 @@
+package policyloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultRedisTTL is how long a policy is shared through Redis when
+// POLICY_REDIS_TTL_SECONDS is unset.
+const defaultRedisTTL = time.Minute
+
+// redisTimeout bounds every Redis command, so that a slow Redis delays a
+// request by little more than the fetch from origin it was meant to save.
+const redisTimeout = 500 * time.Millisecond
+
+// defaultRedisKeyPrefix namespaces the keys this function writes, so that the
+// Redis instance can be shared with other applications.
+const defaultRedisKeyPrefix = "opa-lambda:policy:"
+
+// SharedPolicy is a policy, or a document stored next to one, as cached in a
+// SharedCache.
+type SharedPolicy struct {
+	Content string `json:"content"`
+	ETag    string `json:"etag,omitempty"` // The origin's ETag, for conditional refreshes.
+}
+
+// SharedCache is a cache that every container of the function consults
+// before fetching a policy from its origin, so that a fleet of concurrent
+// executions fetches each policy about once per TTL rather than once per
+// container. Loaders treat its errors as misses: the cache is never required
+// to load a policy.
+type SharedCache interface {
+	// Get returns the cached policy under key, or nil when there is none.
+	Get(ctx context.Context, key string) (*SharedPolicy, error)
+
+	// Set caches a policy under key for the cache's TTL.
+	Set(ctx context.Context, key string, policy *SharedPolicy) error
+}
+
+// RedisCache is a SharedCache backed by Redis.
+type RedisCache struct {
+	// TTL is how long a policy is served from Redis before some container
+	// fetches it from origin again.
+	TTL time.Duration
+
+	// Prefix is prepended to every key.
+	Prefix string
+
+	client redis.UniversalClient
+}
+
+// NewRedisCache creates a RedisCache for the Redis server at addr, such as
+// "my-cache.abc123.use1.cache.amazonaws.com:6379". A password, when set, is
+// sent with AUTH.
+func NewRedisCache(addr, password string, ttl time.Duration) *RedisCache {
+	return newRedisCache(&redis.Options{Addr: addr, Password: password}, ttl)
+}
+
+func newRedisCache(opts *redis.Options, ttl time.Duration) *RedisCache {
+	opts.DialTimeout = redisTimeout
+	opts.ReadTimeout = redisTimeout
+	opts.WriteTimeout = redisTimeout
+	opts.MaxRetries = -1
+	return NewRedisCacheWithClient(redis.NewClient(opts), ttl)
+}
+
+// NewRedisCacheWithClient creates a RedisCache with a custom Redis client,
+// such as a cluster client.
+func NewRedisCacheWithClient(client redis.UniversalClient, ttl time.Duration) *RedisCache {
+	return &RedisCache{TTL: ttl, Prefix: defaultRedisKeyPrefix, client: client}
+}
+
+// newRedisCacheFromEnv creates a RedisCache for POLICY_REDIS_ADDR, with
+// POLICY_REDIS_PASSWORD and POLICY_REDIS_TTL_SECONDS. It returns nil when
+// POLICY_REDIS_ADDR is unset.
+func newRedisCacheFromEnv() (SharedCache, error) {
+	addr := strings.TrimSpace(os.Getenv("POLICY_REDIS_ADDR"))
+	if addr == "" {
+		return nil, nil
+	}
+	opts, err := redisOptions(addr, os.Getenv("POLICY_REDIS_PASSWORD"))
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := durationFromEnv("POLICY_REDIS_TTL_SECONDS", defaultRedisTTL)
+	if err != nil {
+		return nil, err
+	}
+	return newRedisCache(opts, ttl), nil
+}
+
+// redisOptions returns the client options for addr, either host:port or a
+// redis:// or rediss:// URL such as "rediss://user@host:6379/0". rediss://
+// connects over TLS, as ElastiCache requires with in-transit encryption
+// enabled. A password, when set, replaces one in the URL.
+func redisOptions(addr, password string) (*redis.Options, error) {
+	opts := &redis.Options{Addr: addr}
+	if strings.Contains(addr, "://") {
+		var err error
+		if opts, err = redis.ParseURL(addr); err != nil {
+			return nil, fmt.Errorf("invalid POLICY_REDIS_ADDR: %w", err)
+		}
+	}
+	if password != "" {
+		opts.Password = password
+	}
+	return opts, nil
+}
+
+// Get returns the policy cached under key, or nil when there is none.
+func (c *RedisCache) Get(ctx context.Context, key string) (*SharedPolicy, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	raw, err := c.client.Get(ctx, c.Prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis get %s: %w", key, err)
+	}
+
+	var policy SharedPolicy
+	if err := json.Unmarshal(raw, &policy); err != nil {
+		return nil, fmt.Errorf("invalid redis entry %s: %w", key, err)
+	}
+	return &policy, nil
+}
+
+// Set caches a policy under key for c.TTL.
+func (c *RedisCache) Set(ctx context.Context, key string, policy *SharedPolicy) error {
+	raw, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	if err := c.client.Set(ctx, c.Prefix+key, raw, c.TTL).Err(); err != nil {
+		return fmt.Errorf("redis set %s: %w", key, err)
+	}
+	return nil
+}
+
+// sharedGet returns the policy cached under key in cache, or nil when cache
+// is nil, has no such policy, or fails, in which case the error is logged.
+func sharedGet(ctx context.Context, cache SharedCache, key string) *SharedPolicy {
+	if cache == nil {
+		return nil
+	}
+	policy, err := cache.Get(ctx, key)
+	if err != nil {
+		log.WithError(err).Warnf("shared policy cache unavailable, loading %s from origin", key)
+		return nil
+	}
+	return policy
+}
+
+// sharedSet caches a policy under key in cache, unless cache is nil. Errors
+// are logged and otherwise ignored.
+func sharedSet(ctx context.Context, cache SharedCache, key string, policy *SharedPolicy) {
+	if cache == nil {
+		return
+	}
+	if err := cache.Set(ctx, key, policy); err != nil {
+		log.WithError(err).Warnf("failed to share policy %s", key)
+	}
+}
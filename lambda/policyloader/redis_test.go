@@ -0,0 +1,149 @@
+package policyloader_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"opa_lambda/policyloader"
+)
+
+func TestRedisCache(t *testing.T) {
+	server := miniredis.RunT(t)
+	cache := policyloader.NewRedisCache(server.Addr(), "", time.Minute)
+
+	policy, err := cache.Get(context.Background(), "auth.user")
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+
+	err = cache.Set(context.Background(), "auth.user", &policyloader.SharedPolicy{Content: "package auth.user", ETag: `"v1"`})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"opa-lambda:policy:auth.user"}, server.Keys())
+
+	policy, err = cache.Get(context.Background(), "auth.user")
+	assert.NoError(t, err)
+	assert.Equal(t, &policyloader.SharedPolicy{Content: "package auth.user", ETag: `"v1"`}, policy)
+
+	// Entries expire after the TTL.
+	server.FastForward(time.Minute)
+	policy, err = cache.Get(context.Background(), "auth.user")
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+
+	server.Close()
+	_, err = cache.Get(context.Background(), "auth.user")
+	assert.Error(t, err)
+	assert.Error(t, cache.Set(context.Background(), "auth.user", &policyloader.SharedPolicy{Content: "package auth.user"}))
+}
+
+func TestLoadItemS3_SharedCache(t *testing.T) {
+	server := miniredis.RunT(t)
+	cache := policyloader.NewRedisCache(server.Addr(), "", time.Minute)
+
+	s3Client := new(mockS3Client)
+	inputObject := &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("shared-policy.rego"),
+	}
+	// Only the first container reads the object from S3.
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader("package shared\nallow = true")),
+		ETag: aws.String(`"v1"`),
+	}, nil).Once()
+
+	for i := 0; i < 3; i++ {
+		loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+		loader.SharedCache = cache
+
+		content, err := loader.LoadPolicy(context.Background(), "shared-policy")
+		assert.NoError(t, err)
+		assert.Equal(t, "package shared\nallow = true", content)
+	}
+	assert.Equal(t, []string{"opa-lambda:policy:s3://test-bucket/shared-policy.rego"}, server.Keys())
+
+	s3Client.AssertExpectations(t)
+}
+
+func TestLoadItemS3_SharedCacheUnavailable(t *testing.T) {
+	server := miniredis.RunT(t)
+	cache := policyloader.NewRedisCache(server.Addr(), "", time.Minute)
+	server.Close()
+
+	s3Client := new(mockS3Client)
+	inputObject := &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("shared-policy.rego"),
+	}
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader("package shared\nallow = true")),
+	}, nil).Once()
+
+	// A Redis outage is the same as having no shared cache.
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+	loader.SharedCache = cache
+	content, err := loader.LoadPolicy(context.Background(), "shared-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package shared\nallow = true", content)
+
+	s3Client.AssertExpectations(t)
+}
+
+func TestPolicyServiceLoader_SharedCache(t *testing.T) {
+	var requests atomic.Int32
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("package auth.user\n\nallow = true"))
+	}))
+	t.Cleanup(service.Close)
+
+	server := miniredis.RunT(t)
+	cache := policyloader.NewRedisCache(server.Addr(), "", time.Minute)
+	newLoader := func() *policyloader.PolicyServiceLoader {
+		loader, err := policyloader.NewPolicyServiceLoader(policyloader.PolicyServiceConfig{
+			ServiceURL:  service.URL,
+			CacheDir:    t.TempDir(),
+			PollMin:     time.Hour,
+			SharedCache: cache,
+		})
+		assert.NoError(t, err)
+		return loader
+	}
+
+	// Containers after the first are served from Redis.
+	for i := 0; i < 3; i++ {
+		content, err := newLoader().LoadPolicy(context.Background(), "auth.user")
+		assert.NoError(t, err)
+		assert.Equal(t, "package auth.user\n\nallow = true", content)
+	}
+	assert.Equal(t, int32(1), requests.Load())
+	assert.Equal(t, []string{"opa-lambda:policy:" + service.URL + "/auth/user.rego"}, server.Keys())
+
+	// Revalidation goes to the service.
+	_, err := newLoader().LoadPolicy(policyloader.WithRevalidation(context.Background()), "auth.user")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Once the shared copy expires, the next container downloads the policy again.
+	server.FastForward(time.Minute)
+	_, err = newLoader().LoadPolicy(context.Background(), "auth.user")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), requests.Load())
+
+	// A Redis outage falls through to the service.
+	server.Close()
+	_, err = newLoader().LoadPolicy(context.Background(), "auth.user")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), requests.Load())
+}
@@ -0,0 +1,19 @@
+package policyloader
+
+import "context"
+
+type revalidateKey struct{}
+
+// WithRevalidation returns a context under which loaders bypass their
+// in-memory caches and fetch the policy from its source again. The fresh copy
+// still replaces the cached one, and a failed fetch is returned instead of
+// being masked by a cached copy.
+func WithRevalidation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, revalidateKey{}, true)
+}
+
+// revalidationRequested reports whether ctx was created by WithRevalidation.
+func revalidationRequested(ctx context.Context) bool {
+	revalidate, _ := ctx.Value(revalidateKey{}).(bool)
+	return revalidate
+}
@@ -1,13 +1,20 @@
 package policyloader
 
 import (
+	"compress/gzip"
 	"context"
 	"errors"
 	"io"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
@@ -15,12 +22,52 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// s3Attempts bounds the attempts at reading one object, including the first.
+const s3Attempts = 3
+
+// s3RetryDelay is the pause before the second attempt; later attempts wait
+// proportionally longer.
+const s3RetryDelay = 100 * time.Millisecond
+
 // S3PolicyLoader loads policies from S3.
 type S3PolicyLoader struct {
+	// Timeout bounds each attempt at reading an object, so a slow read is
+	// retried instead of consuming the whole request deadline. Zero leaves
+	// attempts bounded by the request context only.
+	Timeout time.Duration
+
+	// TTL is how long a cached policy or document is served before it is
+	// fetched again. A failed refresh keeps serving the cached copy. Zero
+	// caches for the lifetime of the container.
+	TTL time.Duration
+
+	// SharedCache, when set, is consulted before S3 whenever a policy or
+	// document is not cached in memory or is older than TTL, and holds every
+	// object read, so that concurrent containers share reads. Missing
+	// objects are not shared. Revalidation requests bypass it.
+	SharedCache SharedCache
+
+	// DeletionGrace is how long a policy or document that was loaded before
+	// keeps being served from cache once a refresh finds it deleted, so that
+	// an accidental deletion does not break evaluations at once. Zero drops
+	// the cached copy on the first refresh that finds the object missing.
+	DeletionGrace time.Duration
+
 	bucketName string
 	s3Client   s3iface.S3API
 	mu         sync.RWMutex
-	cache      map[string]string
+	cache      map[string]*s3CacheEntry
+	documents  map[string]*s3CacheEntry // Keyed by object key.
+}
+
+// s3CacheEntry is a cached policy or document.
+type s3CacheEntry struct {
+	content  *string // Nil when the document does not exist.
+	etag     string  // The object's ETag, sent as If-None-Match on refreshes.
+	fetched  time.Time
+	stale    bool      // Whether the last refresh failed.
+	failures int       // Refresh failures since the last successful fetch.
+	deleted  time.Time // When a refresh first found the object missing, within DeletionGrace.
 }
 
 // NewS3PolicyLoader creates a new S3PolicyLoader.
@@ -34,12 +81,30 @@ func NewS3PolicyLoader(bucketName string) (*S3PolicyLoader, error) {
 		return nil, err
 	}
 
-	s3Client := s3.New(sess)
-	return &S3PolicyLoader{
-		bucketName: bucketName,
-		s3Client:   s3Client,
-		cache:      make(map[string]string),
-	}, nil
+	timeout, err := durationFromEnv("POLICY_S3_TIMEOUT_SECONDS", 0)
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := durationFromEnv("S3_POLICY_CACHE_TTL_SECONDS", 0)
+	if err != nil {
+		return nil, err
+	}
+	deletionGrace, err := durationFromEnv("POLICY_DELETION_GRACE_SECONDS", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedCache, err := newRedisCacheFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	loader := NewS3PolicyLoaderWithClient(s3.New(sess), bucketName)
+	loader.Timeout = timeout
+	loader.TTL = ttl
+	loader.DeletionGrace = deletionGrace
+	loader.SharedCache = sharedCache
+	return loader, nil
 }
 
 // NewS3PolicyLoaderWithClient creates a new S3PolicyLoader with a custom S3 client.
@@ -47,7 +112,8 @@ func NewS3PolicyLoaderWithClient(s3Client s3iface.S3API, bucketName string) *S3P
 	return &S3PolicyLoader{
 		bucketName: bucketName,
 		s3Client:   s3Client,
-		cache:      make(map[string]string),
+		cache:      make(map[string]*s3CacheEntry),
+		documents:  make(map[string]*s3CacheEntry),
 	}
 }
 
@@ -57,39 +123,260 @@ func (loader *S3PolicyLoader) LoadPolicy(ctx context.Context, policyName string)
 	if err != nil {
 		return "", err
 	}
+	return loader.load(ctx, loader.cache, policyName, policyName, objectKey, false)
+}
 
-	// Serve from in-memory cache when available to avoid repeated S3 calls on warm invocations.
-	loader.mu.RLock()
-	if cached, ok := loader.cache[policyName]; ok {
-		loader.mu.RUnlock()
-		return cached, nil
+// LoadDocument loads the document stored next to a policy, under the
+// policy's object key with suffix in place of .rego. Documents are cached
+// like policies, and so is their absence.
+func (loader *S3PolicyLoader) LoadDocument(ctx context.Context, policyName, suffix string) (string, error) {
+	objectKey, err := KeyToDocumentFilename(policyName, suffix)
+	if err != nil {
+		return "", err
 	}
+	return loader.load(ctx, loader.documents, objectKey, policyName+suffix, objectKey, true)
+}
+
+// load serves an object from cache, keyed by cacheKey, while it is younger
+// than loader.TTL, and fetches it otherwise. Refreshes are conditional on the
+// cached copy's ETag, so an unchanged object is not downloaded again. An
+// object found missing is cached as such when cacheAbsence is set, and dropped
+// from cache otherwise, unless a cached copy is within loader.DeletionGrace of
+// the first refresh that found it missing. When a refresh fails for any other reason, the cached copy
+// keeps being served. When ctx requests revalidation, such failures are
+// returned instead, without dropping the cached copy.
+func (loader *S3PolicyLoader) load(ctx context.Context, cache map[string]*s3CacheEntry, cacheKey, name, objectKey string, cacheAbsence bool) (string, error) {
+	loader.mu.RLock()
+	cached := cache[cacheKey]
 	loader.mu.RUnlock()
 
-	input := &s3.GetObjectInput{
-		Bucket: aws.String(loader.bucketName),
-		Key:    aws.String(objectKey),
+	revalidate := revalidationRequested(ctx)
+	hit := cached != nil && !revalidate && (loader.TTL <= 0 || time.Since(cached.fetched) < loader.TTL)
+	observeCache(ctx, "s3", hit)
+	if hit {
+		if cached.content == nil {
+			return "", &FileNotFoundError{Key: name}
+		}
+		return *cached.content, nil
+	}
+
+	// Objects are shared under their S3 URI, which tells buckets apart.
+	sharedKey := "s3://" + loader.bucketName + "/" + objectKey
+	if !revalidate {
+		if shared := sharedGet(ctx, loader.SharedCache, sharedKey); shared != nil {
+			loader.store(cache, cacheKey, &s3CacheEntry{content: &shared.Content, etag: shared.ETag, fetched: time.Now()})
+			return shared.Content, nil
+		}
+	}
+
+	var etag string
+	if cached != nil {
+		etag = cached.etag
+	}
+	object, err := loader.fetch(ctx, name, objectKey, etag)
+	var notFound *FileNotFoundError
+	switch {
+	case errors.Is(err, errNotModified):
+		loader.store(cache, cacheKey, &s3CacheEntry{content: cached.content, etag: etag, fetched: time.Now()})
+		sharedSet(ctx, loader.SharedCache, sharedKey, &SharedPolicy{Content: *cached.content, ETag: etag})
+		return *cached.content, nil
+	case err == nil:
+		loader.store(cache, cacheKey, &s3CacheEntry{content: &object.content, etag: object.etag, fetched: time.Now()})
+		sharedSet(ctx, loader.SharedCache, sharedKey, &SharedPolicy{Content: object.content, ETag: object.etag})
+	case errors.As(err, &notFound) && cached != nil && cached.content != nil && loader.DeletionGrace > 0:
+		deleted := cached.deleted
+		if deleted.IsZero() {
+			deleted = time.Now()
+		}
+		if time.Since(deleted) < loader.DeletionGrace {
+			if revalidate {
+				return "", err
+			}
+			log.Errorf("%s was deleted from S3; serving the cached copy until %s", name, deleted.Add(loader.DeletionGrace).Format(time.RFC3339))
+			loader.store(cache, cacheKey, &s3CacheEntry{content: cached.content, etag: etag, fetched: time.Now(), stale: true, failures: cached.failures + 1, deleted: deleted})
+			return *cached.content, nil
+		}
+		log.Errorf("%s was deleted from S3 and its grace period has ended; dropping the cached copy", name)
+		if cacheAbsence {
+			loader.store(cache, cacheKey, &s3CacheEntry{fetched: time.Now()})
+		} else {
+			loader.store(cache, cacheKey, nil)
+		}
+	case errors.As(err, &notFound) && cacheAbsence:
+		loader.store(cache, cacheKey, &s3CacheEntry{fetched: time.Now()})
+	case errors.As(err, &notFound):
+		loader.store(cache, cacheKey, nil)
+	case cached != nil && !revalidate:
+		log.WithError(err).Warnf("serving cached copy of %s after S3 refresh failure", name)
+		loader.store(cache, cacheKey, &s3CacheEntry{content: cached.content, etag: etag, fetched: cached.fetched, stale: true, failures: cached.failures + 1})
+		if cached.content == nil {
+			return "", &FileNotFoundError{Key: name}
+		}
+		return *cached.content, nil
+	}
+	return object.content, err
+}
+
+// store replaces, or with a nil entry removes, a cache entry.
+func (loader *S3PolicyLoader) store(cache map[string]*s3CacheEntry, cacheKey string, entry *s3CacheEntry) {
+	loader.mu.Lock()
+	defer loader.mu.Unlock()
+	if entry == nil {
+		delete(cache, cacheKey)
+		return
 	}
+	cache[cacheKey] = entry
+}
+
+// errNotModified is returned by conditional reads when the object still has
+// the ETag the caller already holds.
+var errNotModified = errors.New("object not modified")
+
+// s3Object is the content of an object read from S3 and its ETag.
+type s3Object struct {
+	content string
+	etag    string
+}
+
+// fetch reads objectKey, falling back to a gzipped copy stored under
+// <objectKey>.gz. policyName identifies the object in errors and logs. With
+// an etag, the read is conditional and returns errNotModified when the object
+// is unchanged. Transient failures are retried up to s3Attempts times in all,
+// each attempt bounded by loader.Timeout, as long as ctx allows.
+func (loader *S3PolicyLoader) fetch(ctx context.Context, policyName, objectKey, etag string) (s3Object, error) {
+	for attempt := 1; ; attempt++ {
+		object, retry, err := loader.fetchOnce(ctx, policyName, objectKey, etag)
+		if err == nil || !retry || attempt == s3Attempts || ctx.Err() != nil {
+			return object, err
+		}
+
+		log.Warnf("retrying transient failure reading policy %s from S3 (attempt %d of %d)", policyName, attempt, s3Attempts)
+		select {
+		case <-time.After(time.Duration(attempt) * s3RetryDelay):
+		case <-ctx.Done():
+			return s3Object{}, err
+		}
+	}
+}
 
-	result, err := loader.s3Client.GetObjectWithContext(ctx, input)
+// fetchOnce makes one attempt at reading objectKey and reports whether a
+// failure is worth retrying.
+func (loader *S3PolicyLoader) fetchOnce(ctx context.Context, policyName, objectKey, etag string) (s3Object, bool, error) {
+	attemptCtx := ctx
+	if loader.Timeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, loader.Timeout)
+		defer cancel()
+	}
+
+	// Fall back to a gzipped copy stored under <key>.gz.
+	// ETags differ between the two objects, so the condition holds for
+	// whichever of them the cached copy was read from.
+	result, err := loader.getObject(attemptCtx, objectKey, etag)
+	if isNoSuchKey(err) {
+		objectKey += ".gz"
+		result, err = loader.getObject(attemptCtx, objectKey, etag)
+	}
 	if err != nil {
+		if isNoSuchKey(err) {
+			return s3Object{}, false, &FileNotFoundError{Key: policyName}
+		}
+		if isNotModified(err) {
+			return s3Object{}, false, errNotModified
+		}
 		log.Errorf("failed to get policy %s from S3: %v", policyName, err)
-		return "", errors.New("failed to get policy from S3")
+		return s3Object{}, isTransient(ctx, err), errors.New("failed to get policy from S3")
 	}
 	defer result.Body.Close()
 
-	content, err := io.ReadAll(result.Body)
+	var body io.Reader = result.Body
+	if isGzipped(objectKey, result.ContentEncoding) {
+		gz, err := gzip.NewReader(result.Body)
+		if err != nil {
+			log.Errorf("failed to decompress policy %s: %v", policyName, err)
+			return s3Object{}, false, errors.New("failed to decompress policy content from S3")
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	content, err := io.ReadAll(body)
 	if err != nil {
 		log.Errorf("failed to read policy content from %s: %v", policyName, err)
-		return "", errors.New("failed to read policy content from S3")
+		// A connection dropped mid-body is worth another attempt.
+		return s3Object{}, ctx.Err() == nil, errors.New("failed to read policy content from S3")
 	}
 
-	policy := string(content)
+	return s3Object{content: string(content), etag: aws.StringValue(result.ETag)}, false, nil
+}
 
-	// Cache the freshly fetched policy for subsequent invocations.
-	loader.mu.Lock()
-	loader.cache[policyName] = policy
-	loader.mu.Unlock()
+// isTransient reports whether an S3 error may succeed on another attempt:
+// throttling, errors the SDK considers retryable, and attempts cut short by
+// the per-attempt timeout while ctx itself is still live.
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return request.IsErrorRetryable(err) || request.IsErrorThrottle(err) ||
+		errors.Is(err, context.DeadlineExceeded) || isCanceled(err)
+}
 
-	return policy, nil
+// isCanceled reports whether err is the SDK's wrapping of a canceled request
+// context, which is how a per-attempt timeout surfaces.
+func isCanceled(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == request.CanceledErrorCode
+}
+
+// Stats reports the policies held in the in-memory cache, sorted by policy
+// name. A policy is stale when its last refresh after TTL failed.
+func (loader *S3PolicyLoader) Stats() []PolicyStats {
+	loader.mu.RLock()
+	stats := make([]PolicyStats, 0, len(loader.cache))
+	for name, entry := range loader.cache {
+		stats = append(stats, PolicyStats{Policy: name, Loaded: true, Stale: entry.stale, ConsecutiveFailures: entry.failures})
+	}
+	loader.mu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Policy < stats[j].Policy })
+	return stats
+}
+
+// getObject reads objectKey, only if its ETag differs from etag unless etag
+// is empty.
+func (loader *S3PolicyLoader) getObject(ctx context.Context, objectKey, etag string) (*s3.GetObjectOutput, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(loader.bucketName),
+		Key:    aws.String(objectKey),
+	}
+	if etag != "" {
+		input.IfNoneMatch = aws.String(etag)
+	}
+	return loader.s3Client.GetObjectWithContext(ctx, input)
+}
+
+func isNoSuchKey(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == s3.ErrCodeNoSuchKey
+}
+
+// isNotModified reports whether a conditional read failed because the object
+// still matches: S3 answers 304 Not Modified, with no error code of its own.
+func isNotModified(err error) bool {
+	var reqErr awserr.RequestFailure
+	return errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotModified
+}
+
+// isGzipped reports whether an object holds gzip data, either because it was
+// uploaded with Content-Encoding: gzip or because its key ends in .gz.
+func isGzipped(objectKey string, contentEncoding *string) bool {
+	if strings.HasSuffix(objectKey, ".gz") {
+		return true
+	}
+	for _, encoding := range strings.Split(aws.StringValue(contentEncoding), ",") {
+		if strings.EqualFold(strings.TrimSpace(encoding), "gzip") {
+			return true
+		}
+	}
+	return false
 }
@@ -1,13 +1,18 @@
 package policyloader_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"io/ioutil"
+	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
@@ -92,6 +97,13 @@ func TestLoadItemS3_Cache(t *testing.T) {
 	// Expect a single S3 call; the second LoadPolicy should be served from cache.
 	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(outputObject, nil).Once()
 
+	var lookups []bool
+	policyloader.CacheObserver = func(ctx context.Context, backend string, hit bool) {
+		assert.Equal(t, "s3", backend)
+		lookups = append(lookups, hit)
+	}
+	t.Cleanup(func() { policyloader.CacheObserver = nil })
+
 	content, err := loader.LoadPolicy(context.Background(), policyName)
 	assert.NoError(t, err)
 	assert.Equal(t, policyContent, content)
@@ -100,6 +112,175 @@ func TestLoadItemS3_Cache(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, policyContent, content)
 
+	assert.Equal(t, []bool{false, true}, lookups)
+	s3Client.AssertExpectations(t)
+}
+
+func TestLoadItemS3_Stats(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+	assert.Empty(t, loader.Stats())
+
+	for _, name := range []string{"zeta", "alpha"} {
+		s3Client.On("GetObjectWithContext", mock.Anything, &s3.GetObjectInput{
+			Bucket: aws.String("test-bucket"),
+			Key:    aws.String(name + ".rego"),
+		}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader("package " + name))}, nil).Once()
+
+		_, err := loader.LoadPolicy(context.Background(), name)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, []policyloader.PolicyStats{
+		{Policy: "alpha", Loaded: true},
+		{Policy: "zeta", Loaded: true},
+	}, loader.Stats())
+}
+
+func TestLoadItemS3_Revalidation(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+
+	inputObject := &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("cached-policy.rego"),
+	}
+
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader("package cached\nallow = false")),
+	}, nil).Once()
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader("package cached\nallow = true")),
+	}, nil).Once()
+
+	content, err := loader.LoadPolicy(context.Background(), "cached-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\nallow = false", content)
+
+	// Revalidation skips the cache and stores the fresh copy for later loads.
+	content, err = loader.LoadPolicy(policyloader.WithRevalidation(context.Background()), "cached-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\nallow = true", content)
+
+	content, err = loader.LoadPolicy(context.Background(), "cached-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\nallow = true", content)
+
+	s3Client.AssertExpectations(t)
+}
+
+func TestLoadItemS3_TTL(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+	loader.TTL = time.Nanosecond
+
+	inputObject := &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("cached-policy.rego"),
+	}
+
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader("package cached\nallow = false")),
+	}, nil).Once()
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader("package cached\nallow = true")),
+	}, nil).Once()
+
+	content, err := loader.LoadPolicy(context.Background(), "cached-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\nallow = false", content)
+
+	// The cached copy has expired, so the update is picked up.
+	time.Sleep(time.Millisecond)
+	content, err = loader.LoadPolicy(context.Background(), "cached-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\nallow = true", content)
+
+	s3Client.AssertExpectations(t)
+}
+
+func TestLoadItemS3_TTLRefreshFailure(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+	loader.TTL = time.Nanosecond
+
+	inputObject := &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("cached-policy.rego"),
+	}
+
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader("package cached\nallow = true")),
+	}, nil).Once()
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(nil, awserr.New("AccessDenied", "Access Denied", nil)).Twice()
+
+	content, err := loader.LoadPolicy(context.Background(), "cached-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\nallow = true", content)
+
+	// A failed refresh keeps serving the cached copy, which is reported stale.
+	time.Sleep(time.Millisecond)
+	content, err = loader.LoadPolicy(context.Background(), "cached-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\nallow = true", content)
+	assert.Equal(t, []policyloader.PolicyStats{
+		{Policy: "cached-policy", Loaded: true, Stale: true, ConsecutiveFailures: 1},
+	}, loader.Stats())
+
+	// Revalidation still reports the failure.
+	_, err = loader.LoadPolicy(policyloader.WithRevalidation(context.Background()), "cached-policy")
+	assert.Error(t, err)
+
+	s3Client.AssertExpectations(t)
+}
+
+func TestLoadItemS3_ConditionalRevalidation(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+
+	inputObject := &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("cached-policy.rego"),
+	}
+	conditionalInput := func(etag string) *s3.GetObjectInput {
+		return &s3.GetObjectInput{
+			Bucket:      aws.String("test-bucket"),
+			Key:         aws.String("cached-policy.rego"),
+			IfNoneMatch: aws.String(etag),
+		}
+	}
+	notModified := awserr.NewRequestFailure(awserr.New("NotModified", "Not Modified", nil), http.StatusNotModified, "request-id")
+
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader("package cached\nallow = false")),
+		ETag: aws.String(`"v1"`),
+	}, nil).Once()
+	s3Client.On("GetObjectWithContext", mock.Anything, conditionalInput(`"v1"`)).Return(nil, notModified).Once()
+	s3Client.On("GetObjectWithContext", mock.Anything, conditionalInput(`"v1"`)).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader("package cached\nallow = true")),
+		ETag: aws.String(`"v2"`),
+	}, nil).Once()
+	s3Client.On("GetObjectWithContext", mock.Anything, conditionalInput(`"v2"`)).Return(nil, notModified).Once()
+
+	content, err := loader.LoadPolicy(context.Background(), "cached-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\nallow = false", content)
+
+	// An unchanged object is served from cache without downloading it again.
+	ctx := policyloader.WithRevalidation(context.Background())
+	content, err = loader.LoadPolicy(ctx, "cached-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\nallow = false", content)
+
+	// A changed object replaces the cached copy, and its ETag the cached one.
+	content, err = loader.LoadPolicy(ctx, "cached-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\nallow = true", content)
+
+	content, err = loader.LoadPolicy(ctx, "cached-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package cached\nallow = true", content)
+
 	s3Client.AssertExpectations(t)
 }
 
@@ -120,6 +301,186 @@ func TestLoadItemS3_Error(t *testing.T) {
 	s3Client.AssertExpectations(t)
 }
 
+func TestLoadItemS3_RetryAfterTimeout(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+	loader.Timeout = 20 * time.Millisecond
+
+	inputObject := &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("slow-policy.rego"),
+	}
+
+	// The first attempt hangs until its timeout; the second succeeds.
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}).Return(nil, awserr.New(request.CanceledErrorCode, "request context canceled", context.DeadlineExceeded)).Once()
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader("package slow")),
+	}, nil).Once()
+
+	content, err := loader.LoadPolicy(context.Background(), "slow-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package slow", content)
+
+	s3Client.AssertExpectations(t)
+}
+
+func TestLoadItemS3_NoRetryAfterDeadline(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+	loader.Timeout = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	s3Client.On("GetObjectWithContext", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}).Return(nil, awserr.New(request.CanceledErrorCode, "request context canceled", context.DeadlineExceeded)).Once()
+
+	start := time.Now()
+	_, err := loader.LoadPolicy(ctx, "slow-policy")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	s3Client.AssertExpectations(t)
+}
+
+func TestLoadItemS3_NotFound(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+
+	policyName := "missing-policy"
+
+	noSuchKey := awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	s3Client.On("GetObjectWithContext", mock.Anything, &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String(policyName + ".rego"),
+	}).Return(nil, noSuchKey)
+	s3Client.On("GetObjectWithContext", mock.Anything, &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String(policyName + ".rego.gz"),
+	}).Return(nil, noSuchKey)
+
+	_, err := loader.LoadPolicy(context.Background(), policyName)
+	var notFound *policyloader.FileNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+	assert.Equal(t, policyName, notFound.Key)
+
+	s3Client.AssertExpectations(t)
+}
+
+func TestLoadDocumentS3(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+
+	noSuchKey := awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	s3Client.On("GetObjectWithContext", mock.Anything, &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("auth/user.transform.json"),
+	}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(`{"operations": []}`))}, nil).Once()
+	s3Client.On("GetObjectWithContext", mock.Anything, &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("auth/admin.transform.json"),
+	}).Return(nil, noSuchKey).Once()
+	s3Client.On("GetObjectWithContext", mock.Anything, &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("auth/admin.transform.json.gz"),
+	}).Return(nil, noSuchKey).Once()
+
+	// Both the document and the missing one are served from the cache the second time.
+	for i := 0; i < 2; i++ {
+		content, err := loader.LoadDocument(context.Background(), "auth.user", ".transform.json")
+		assert.NoError(t, err)
+		assert.Equal(t, `{"operations": []}`, content)
+
+		_, err = loader.LoadDocument(context.Background(), "auth.admin", ".transform.json")
+		var notFound *policyloader.FileNotFoundError
+		assert.ErrorAs(t, err, &notFound)
+		assert.Equal(t, "auth.admin.transform.json", notFound.Key)
+	}
+	assert.Empty(t, loader.Stats())
+
+	s3Client.AssertExpectations(t)
+}
+
+func gzipString(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(s))
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	return buf.String()
+}
+
+func TestLoadItemS3_ContentEncodingGzip(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+
+	policyName := "compressed-policy"
+	policyContent := "package compressed\nallow = true"
+
+	s3Client.On("GetObjectWithContext", mock.Anything, &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String(policyName + ".rego"),
+	}).Return(&s3.GetObjectOutput{
+		Body:            ioutil.NopCloser(strings.NewReader(gzipString(t, policyContent))),
+		ContentEncoding: aws.String("gzip"),
+	}, nil)
+
+	content, err := loader.LoadPolicy(context.Background(), policyName)
+	assert.NoError(t, err)
+	assert.Equal(t, policyContent, content)
+
+	s3Client.AssertExpectations(t)
+}
+
+func TestLoadItemS3_GzipKey(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+
+	policyName := "compressed-policy"
+	policyContent := "package compressed\nallow = true"
+
+	s3Client.On("GetObjectWithContext", mock.Anything, &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String(policyName + ".rego"),
+	}).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil))
+	s3Client.On("GetObjectWithContext", mock.Anything, &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String(policyName + ".rego.gz"),
+	}).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader(gzipString(t, policyContent))),
+	}, nil)
+
+	content, err := loader.LoadPolicy(context.Background(), policyName)
+	assert.NoError(t, err)
+	assert.Equal(t, policyContent, content)
+
+	s3Client.AssertExpectations(t)
+}
+
+func TestLoadItemS3_CorruptGzip(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+
+	policyName := "compressed-policy"
+
+	s3Client.On("GetObjectWithContext", mock.Anything, &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String(policyName + ".rego"),
+	}).Return(&s3.GetObjectOutput{
+		Body:            ioutil.NopCloser(strings.NewReader("package plain")),
+		ContentEncoding: aws.String("gzip"),
+	}, nil)
+
+	_, err := loader.LoadPolicy(context.Background(), policyName)
+	assert.ErrorContains(t, err, "decompress")
+
+	s3Client.AssertExpectations(t)
+}
+
 func TestLoadItemS3_Empty(t *testing.T) {
 	s3Client := new(mockS3Client)
 	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
@@ -142,3 +503,126 @@ func TestLoadItemS3_Empty(t *testing.T) {
 
 	s3Client.AssertExpectations(t)
 }
+
+func TestRoutedS3PolicyLoader(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewRoutedS3PolicyLoader(
+		policyloader.NewS3PolicyLoaderWithClient(s3Client, "default-bucket"),
+		policyloader.S3BucketRoute{Prefix: "team_a", Loader: policyloader.NewS3PolicyLoaderWithClient(s3Client, "bucket-a")},
+		policyloader.S3BucketRoute{Prefix: "team_a.legacy", Loader: policyloader.NewS3PolicyLoaderWithClient(s3Client, "bucket-legacy")},
+	)
+
+	objects := map[string]string{
+		"bucket-a":       "team_a/auth.rego",
+		"bucket-legacy":  "team_a/legacy/auth.rego",
+		"default-bucket": "team_ab/auth.rego",
+	}
+	for bucket, key := range objects {
+		s3Client.On("GetObjectWithContext", mock.Anything, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(bucket))}, nil).Once()
+	}
+
+	for policyName, bucket := range map[string]string{
+		"team_a.auth":        "bucket-a",
+		"team_a.legacy.auth": "bucket-legacy",
+		"team_ab.auth":       "default-bucket",
+	} {
+		content, err := loader.LoadPolicy(context.Background(), policyName)
+		assert.NoError(t, err)
+		assert.Equal(t, bucket, content, policyName)
+	}
+
+	assert.Equal(t, []policyloader.PolicyStats{
+		{Policy: "team_a.auth", Loaded: true},
+		{Policy: "team_a.legacy.auth", Loaded: true},
+		{Policy: "team_ab.auth", Loaded: true},
+	}, loader.Stats())
+	s3Client.AssertExpectations(t)
+}
+
+func TestRoutedS3PolicyLoader_SeparateCaches(t *testing.T) {
+	clientA, clientB := new(mockS3Client), new(mockS3Client)
+	loaderA := policyloader.NewRoutedS3PolicyLoader(nil,
+		policyloader.S3BucketRoute{Prefix: "shared", Loader: policyloader.NewS3PolicyLoaderWithClient(clientA, "bucket-a")})
+	loaderB := policyloader.NewRoutedS3PolicyLoader(policyloader.NewS3PolicyLoaderWithClient(clientB, "bucket-b"))
+
+	for client, content := range map[*mockS3Client]string{clientA: "package shared.a", clientB: "package shared.b"} {
+		client.On("GetObjectWithContext", mock.Anything, mock.Anything).
+			Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(content))}, nil).Once()
+	}
+
+	content, err := loaderA.LoadPolicy(context.Background(), "shared.policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package shared.a", content)
+
+	content, err = loaderB.LoadPolicy(context.Background(), "shared.policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package shared.b", content)
+
+	clientA.AssertExpectations(t)
+	clientB.AssertExpectations(t)
+}
+
+func TestRoutedS3PolicyLoader_NoFallback(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewRoutedS3PolicyLoader(nil,
+		policyloader.S3BucketRoute{Prefix: "team_a", Loader: policyloader.NewS3PolicyLoaderWithClient(s3Client, "bucket-a")})
+
+	_, err := loader.LoadPolicy(context.Background(), "team_b.auth")
+	var notFound *policyloader.FileNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+
+	_, err = loader.LoadDocument(context.Background(), "team_b.auth", ".transform.json")
+	assert.ErrorAs(t, err, &notFound)
+	s3Client.AssertNotCalled(t, "GetObjectWithContext", mock.Anything, mock.Anything)
+}
+
+func TestLoadItemS3_DeletionGrace(t *testing.T) {
+	s3Client := new(mockS3Client)
+	loader := policyloader.NewS3PolicyLoaderWithClient(s3Client, "test-bucket")
+	loader.TTL = time.Nanosecond
+	loader.DeletionGrace = 50 * time.Millisecond
+
+	inputObject := &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("deleted-policy.rego"),
+	}
+	noSuchKey := awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(strings.NewReader("package deleted\nallow = true")),
+	}, nil).Once()
+	s3Client.On("GetObjectWithContext", mock.Anything, inputObject).Return(nil, noSuchKey)
+	s3Client.On("GetObjectWithContext", mock.Anything, &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("deleted-policy.rego.gz"),
+	}).Return(nil, noSuchKey)
+
+	content, err := loader.LoadPolicy(context.Background(), "deleted-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package deleted\nallow = true", content)
+
+	// Within the grace period the deleted policy is still served, as stale.
+	time.Sleep(time.Millisecond)
+	content, err = loader.LoadPolicy(context.Background(), "deleted-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package deleted\nallow = true", content)
+	assert.Equal(t, []policyloader.PolicyStats{
+		{Policy: "deleted-policy", Loaded: true, Stale: true, ConsecutiveFailures: 1},
+	}, loader.Stats())
+
+	// Revalidation reports the deletion without dropping the cached copy.
+	_, err = loader.LoadPolicy(policyloader.WithRevalidation(context.Background()), "deleted-policy")
+	assert.ErrorIs(t, err, policyloader.ErrPolicyNotFound)
+	content, err = loader.LoadPolicy(context.Background(), "deleted-policy")
+	assert.NoError(t, err)
+	assert.Equal(t, "package deleted\nallow = true", content)
+
+	// Once the grace period ends, the policy is reported missing.
+	time.Sleep(60 * time.Millisecond)
+	_, err = loader.LoadPolicy(context.Background(), "deleted-policy")
+	assert.ErrorIs(t, err, policyloader.ErrPolicyNotFound)
+	assert.Empty(t, loader.Stats())
+}
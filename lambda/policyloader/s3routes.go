@@ -0,0 +1,142 @@
+package policyloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// S3BucketRoute sends policies whose names start with Prefix to a bucket.
+// Prefix is a dotted package prefix such as "team_a" or "team_a.auth" and
+// matches whole segments only.
+type S3BucketRoute struct {
+	Prefix string
+	Loader *S3PolicyLoader
+}
+
+// RoutedS3PolicyLoader loads policies from the bucket routed by the longest
+// matching prefix, or from a default bucket. Every bucket has its own loader
+// and therefore its own cache, so the same policy name in two buckets never
+// collides. Policies keep their full object key in the routed bucket.
+type RoutedS3PolicyLoader struct {
+	routes   []S3BucketRoute // Longest prefix first.
+	fallback *S3PolicyLoader // Nil when there is no default bucket.
+}
+
+// NewRoutedS3PolicyLoader creates a loader routing policies by prefix, with
+// fallback serving every other policy. fallback may be nil.
+func NewRoutedS3PolicyLoader(fallback *S3PolicyLoader, routes ...S3BucketRoute) *RoutedS3PolicyLoader {
+	sorted := append([]S3BucketRoute(nil), routes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].Prefix) > len(sorted[j].Prefix) })
+	return &RoutedS3PolicyLoader{routes: sorted, fallback: fallback}
+}
+
+// route returns the loader for a policy, or nil when no bucket serves it.
+func (r *RoutedS3PolicyLoader) route(key string) *S3PolicyLoader {
+	for _, route := range r.routes {
+		if key == route.Prefix || strings.HasPrefix(key, route.Prefix+".") {
+			return route.Loader
+		}
+	}
+	return r.fallback
+}
+
+// LoadPolicy loads a policy from the bucket it is routed to.
+func (r *RoutedS3PolicyLoader) LoadPolicy(ctx context.Context, key string) (string, error) {
+	loader := r.route(key)
+	if loader == nil {
+		return "", &FileNotFoundError{Key: key}
+	}
+	return loader.LoadPolicy(ctx, key)
+}
+
+// LoadDocument loads a document from the bucket its policy is routed to.
+func (r *RoutedS3PolicyLoader) LoadDocument(ctx context.Context, key, suffix string) (string, error) {
+	loader := r.route(key)
+	if loader == nil {
+		return "", &FileNotFoundError{Key: key + suffix}
+	}
+	return loader.LoadDocument(ctx, key, suffix)
+}
+
+// Stats reports the cached policies of every bucket, sorted by policy name.
+func (r *RoutedS3PolicyLoader) Stats() []PolicyStats {
+	stats := []PolicyStats{}
+	loaders := make([]*S3PolicyLoader, 0, len(r.routes)+1)
+	for _, route := range r.routes {
+		loaders = append(loaders, route.Loader)
+	}
+	if r.fallback != nil {
+		loaders = append(loaders, r.fallback)
+	}
+	for _, loader := range loaders {
+		stats = append(stats, loader.Stats()...)
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Policy < stats[j].Policy })
+	return stats
+}
+
+// forBucket returns a loader for another bucket with the same client and
+// settings as loader, but a cache of its own.
+func (loader *S3PolicyLoader) forBucket(bucketName string) *S3PolicyLoader {
+	other := NewS3PolicyLoaderWithClient(loader.s3Client, bucketName)
+	other.Timeout = loader.Timeout
+	other.TTL = loader.TTL
+	other.SharedCache = loader.SharedCache
+	other.DeletionGrace = loader.DeletionGrace
+	return other
+}
+
+// newS3PolicyLoaderFromEnv creates the S3 loader for S3_BUCKET and, when
+// S3_BUCKET_ROUTES is set, routes such as "team_a=bucket-a,team_b=bucket-b"
+// to further buckets. All buckets share one S3 client and the settings read
+// for S3_BUCKET. It returns nil when
+// neither variable is set.
+func newS3PolicyLoaderFromEnv() (PolicyLoader, error) {
+	bucketName := os.Getenv("S3_BUCKET")
+	rawRoutes := strings.TrimSpace(os.Getenv("S3_BUCKET_ROUTES"))
+	if rawRoutes == "" {
+		if bucketName == "" {
+			return nil, nil
+		}
+		loader, err := NewS3PolicyLoader(bucketName)
+		if err != nil {
+			return nil, err
+		}
+		return loader, nil
+	}
+
+	base, err := NewS3PolicyLoader(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	var routes []S3BucketRoute
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(rawRoutes, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, bucket, ok := strings.Cut(entry, "=")
+		prefix = strings.Trim(strings.ReplaceAll(strings.TrimSpace(prefix), "/", "."), ".")
+		bucket = strings.TrimSpace(bucket)
+		if !ok || prefix == "" || bucket == "" {
+			return nil, fmt.Errorf("invalid S3_BUCKET_ROUTES entry %q: expected prefix=bucket", entry)
+		}
+		if seen[prefix] {
+			return nil, fmt.Errorf("invalid S3_BUCKET_ROUTES entry %q: duplicate prefix %s", entry, prefix)
+		}
+		seen[prefix] = true
+		routes = append(routes, S3BucketRoute{Prefix: prefix, Loader: base.forBucket(bucket)})
+	}
+
+	var fallback *S3PolicyLoader
+	if bucketName != "" {
+		fallback = base
+	}
+	return NewRoutedS3PolicyLoader(fallback, routes...), nil
+}
@@ -0,0 +1,44 @@
+package policyloader
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestS3PolicyLoaderFromEnvRouteSettings(t *testing.T) {
+	t.Setenv("S3_BUCKET", "default-bucket")
+	t.Setenv("S3_BUCKET_ROUTES", "team_a=bucket-a")
+	t.Setenv("POLICY_S3_TIMEOUT_SECONDS", "3")
+	t.Setenv("S3_POLICY_CACHE_TTL_SECONDS", "30")
+	t.Setenv("POLICY_DELETION_GRACE_SECONDS", "300")
+	t.Setenv("POLICY_REDIS_ADDR", "localhost:6379")
+
+	loader, err := newS3PolicyLoaderFromEnv()
+	if err != nil {
+		t.Fatalf("failed to create loader: %v", err)
+	}
+	routed, ok := loader.(*RoutedS3PolicyLoader)
+	if !ok {
+		t.Fatalf("expected a routed loader, got %T", loader)
+	}
+	base, route := routed.fallback, routed.route("team_a.auth")
+	if route == base || route.bucketName != "bucket-a" {
+		t.Fatalf("expected team_a to be routed to bucket-a, got %s", route.bucketName)
+	}
+
+	// Every setting reaches the routed bucket's loader, so a setting added to
+	// S3PolicyLoader without being copied by forBucket fails here.
+	baseValue, routeValue := reflect.ValueOf(base).Elem(), reflect.ValueOf(route).Elem()
+	for i := 0; i < baseValue.NumField(); i++ {
+		field := baseValue.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if baseValue.Field(i).IsZero() {
+			t.Fatalf("%s is not set from the environment by this test", field.Name)
+		}
+		if want, got := baseValue.Field(i).Interface(), routeValue.Field(i).Interface(); !reflect.DeepEqual(want, got) {
+			t.Fatalf("routed loader has %s %v, want %v", field.Name, got, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package policyloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// newSecretsManagerClient creates the client used to read the bearer token secret.
+var newSecretsManagerClient = func() (secretsmanageriface.SecretsManagerAPI, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))})
+	if err != nil {
+		return nil, err
+	}
+	return secretsmanager.New(sess), nil
+}
+
+// bearerTokenSource supplies the token sent to the policy service.
+type bearerTokenSource interface {
+	// Token returns the current token, possibly from a cache.
+	Token(ctx context.Context) (string, error)
+
+	// Refresh bypasses any cache, for example after the service rejected
+	// the cached token.
+	Refresh(ctx context.Context) (string, error)
+}
+
+// staticToken is a bearer token taken verbatim from the configuration.
+type staticToken string
+
+func (t staticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+func (t staticToken) Refresh(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// secretTokenSource reads the bearer token from AWS Secrets Manager and
+// caches it for ttl, so the token never appears in the function's
+// environment configuration.
+type secretTokenSource struct {
+	client   secretsmanageriface.SecretsManagerAPI
+	secretID string
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	token   string
+	fetched time.Time
+}
+
+// Token returns the cached token, fetching it again once the cache expires.
+// A failed refresh keeps serving the previous token.
+func (s *secretTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Since(s.fetched) < s.ttl {
+		return s.token, nil
+	}
+
+	token, err := s.fetch(ctx)
+	if err != nil {
+		if s.token != "" {
+			log.WithError(err).Warn("failed to refresh bearer token secret; using cached token")
+			return s.token, nil
+		}
+		return "", err
+	}
+	return token, nil
+}
+
+// Refresh reads the secret again regardless of the cache.
+func (s *secretTokenSource) Refresh(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.fetch(ctx)
+}
+
+// fetch reads the secret and caches it. The caller holds s.mu.
+func (s *secretTokenSource) fetch(ctx context.Context) (string, error) {
+	out, err := s.client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(s.secretID),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read bearer token secret: %w", err)
+	}
+
+	token := strings.TrimSpace(aws.StringValue(out.SecretString))
+	if token == "" {
+		return "", errors.New("bearer token secret has no string value")
+	}
+
+	s.token = token
+	s.fetched = time.Now()
+	return s.token, nil
+}
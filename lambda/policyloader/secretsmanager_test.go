@@ -0,0 +1,180 @@
+package policyloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+type fakeSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	mu     sync.Mutex
+	secret string
+	err    error
+	calls  int
+}
+
+func (f *fakeSecretsManager) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(f.secret)}, nil
+}
+
+func (f *fakeSecretsManager) set(secret string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.secret, f.err = secret, err
+}
+
+func useFakeSecretsManager(t *testing.T, fake *fakeSecretsManager) {
+	t.Helper()
+	previous := newSecretsManagerClient
+	newSecretsManagerClient = func() (secretsmanageriface.SecretsManagerAPI, error) { return fake, nil }
+	t.Cleanup(func() { newSecretsManagerClient = previous })
+}
+
+func TestSecretTokenSourceCachesToken(t *testing.T) {
+	fake := &fakeSecretsManager{secret: " token-1\n"}
+	source := &secretTokenSource{client: fake, secretID: "arn:secret", ttl: time.Hour}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		token, err := source.Token(ctx)
+		if err != nil || token != "token-1" {
+			t.Fatalf("expected token-1, got %q (%v)", token, err)
+		}
+	}
+	if fake.calls != 1 {
+		t.Fatalf("expected one Secrets Manager call, got %d", fake.calls)
+	}
+
+	// An expired token is fetched again; a failed refresh keeps the old one.
+	source.fetched = time.Now().Add(-2 * time.Hour)
+	fake.set("", errors.New("throttled"))
+	if token, err := source.Token(ctx); err != nil || token != "token-1" {
+		t.Fatalf("expected cached token after failed refresh, got %q (%v)", token, err)
+	}
+
+	fake.set("token-2", nil)
+	source.fetched = time.Now().Add(-2 * time.Hour)
+	if token, err := source.Token(ctx); err != nil || token != "token-2" {
+		t.Fatalf("expected refreshed token, got %q (%v)", token, err)
+	}
+}
+
+func TestSecretTokenSourceErrors(t *testing.T) {
+	ctx := context.Background()
+
+	source := &secretTokenSource{client: &fakeSecretsManager{err: errors.New("access denied")}, secretID: "arn:secret", ttl: time.Hour}
+	if _, err := source.Token(ctx); err == nil {
+		t.Fatal("expected error when the secret cannot be read")
+	}
+
+	source = &secretTokenSource{client: &fakeSecretsManager{secret: "  "}, secretID: "arn:secret", ttl: time.Hour}
+	if _, err := source.Token(ctx); err == nil {
+		t.Fatal("expected error for an empty secret")
+	}
+}
+
+func TestPolicyServiceLoaderBearerTokenSecret(t *testing.T) {
+	fake := &fakeSecretsManager{secret: "from-secret"}
+	useFakeSecretsManager(t, fake)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer from-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("package example\nallow := true"))
+	}))
+	t.Cleanup(server.Close)
+
+	loader, err := NewPolicyServiceLoader(PolicyServiceConfig{
+		ServiceURL:           server.URL,
+		BearerToken:          "from-env",
+		BearerTokenSecretARN: "arn:aws:secretsmanager:us-east-1:123456789012:secret:policy-token",
+	})
+	if err != nil {
+		t.Fatalf("failed to create loader: %v", err)
+	}
+
+	if _, err := loader.LoadPolicy(context.Background(), "example"); err != nil {
+		t.Fatalf("expected policy, got %v", err)
+	}
+}
+
+func TestPolicyServiceLoaderRetriesWithRotatedToken(t *testing.T) {
+	fake := &fakeSecretsManager{secret: "old-token"}
+	useFakeSecretsManager(t, fake)
+
+	var mu sync.Mutex
+	accepted := "old-token"
+	var attempts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer "+accepted {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("package example\nallow := true"))
+	}))
+	t.Cleanup(server.Close)
+
+	loader, err := NewPolicyServiceLoader(PolicyServiceConfig{
+		ServiceURL:           server.URL,
+		PollMin:              time.Hour,
+		BearerTokenSecretARN: "arn:aws:secretsmanager:us-east-1:123456789012:secret:policy-token",
+		BearerTokenTTL:       time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("failed to create loader: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := loader.LoadPolicy(ctx, "example"); err != nil {
+		t.Fatalf("expected policy, got %v", err)
+	}
+
+	// Rotate the secret while the old token is still cached.
+	fake.set("new-token", nil)
+	mu.Lock()
+	accepted = "new-token"
+	attempts = nil
+	mu.Unlock()
+
+	if _, err := loader.LoadPolicy(ctx, "other"); err != nil {
+		t.Fatalf("expected policy after token rotation, got %v", err)
+	}
+	if len(attempts) != 2 || attempts[0] != "Bearer old-token" || attempts[1] != "Bearer new-token" {
+		t.Fatalf("expected a retry with the rotated token, got %v", attempts)
+	}
+
+	// A token the service still rejects after refreshing is not retried again.
+	mu.Lock()
+	accepted = "unknown"
+	attempts = nil
+	mu.Unlock()
+
+	if _, err := loader.LoadPolicy(ctx, "third"); err == nil {
+		t.Fatal("expected unauthorized error")
+	}
+	if len(attempts) != 1 {
+		t.Fatalf("expected no retry when the token did not change, got %v", attempts)
+	}
+}
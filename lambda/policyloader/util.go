@@ -7,10 +7,16 @@ import (
 
 // KeyToFilename converts a policy key name to a filename.
 func KeyToFilename(key string) (string, error) {
+	return KeyToDocumentFilename(key, ".rego")
+}
+
+// KeyToDocumentFilename converts a policy key name to the filename of a
+// document stored next to the policy, such as "auth/user.transform.json".
+func KeyToDocumentFilename(key, suffix string) (string, error) {
 	if strings.Contains(key, "/") {
 		return "", &InvalidKeyNameError{Key: key}
 	}
 
 	filename := strings.ReplaceAll(key, ".", "/")
-	return filename + ".rego", nil
+	return filename + suffix, nil
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"opa_lambda/policyloader"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// prometheusRegistry holds the metrics served on METRICS_LISTEN_ADDR. It is
+// separate from the default registry, so that only the function's own
+// metrics and the Go runtime's are exposed.
+var prometheusRegistry = prometheus.NewRegistry()
+
+var (
+	evaluationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "opa_lambda_evaluations_total",
+		Help: "Policy decisions by policy and outcome.",
+	}, []string{"policy", "outcome"})
+
+	evaluationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "opa_lambda_evaluation_duration_seconds",
+		Help:    "Time taken to make a policy decision, including loading the policy.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"policy"})
+
+	policyCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "opa_lambda_policy_cache_lookups_total",
+		Help: "Policy loader cache lookups by backend and result, hit or miss.",
+	}, []string{"backend", "result"})
+)
+
+func init() {
+	prometheusRegistry.MustRegister(
+		evaluationsTotal,
+		evaluationDuration,
+		policyCacheLookups,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	policyloader.CacheObserver = observePolicyCache
+}
+
+// metricsEnabled is set once the metrics server starts. Until then decisions
+// are not recorded, so that invocations on Lambda, where nothing scrapes the
+// metrics, do not accumulate a series per policy name.
+var metricsEnabled atomic.Bool
+
+// serveMetrics serves the Prometheus metrics on addr at /metrics.
+func serveMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log.Infof("Serving metrics on %s", addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// metricsHandler starts recording metrics and returns the handler exposing
+// them.
+func metricsHandler() http.Handler {
+	metricsEnabled.Store(true)
+	return promhttp.HandlerFor(prometheusRegistry, promhttp.HandlerOpts{})
+}
+
+// observePolicyCache records a policy loader cache lookup on the span of the
+// load and, once the metrics server has started, in
+// opa_lambda_policy_cache_lookups_total.
+func observePolicyCache(ctx context.Context, backend string, hit bool) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("policy.backend", backend),
+		attribute.Bool("policy.cache_hit", hit),
+	)
+	if !metricsEnabled.Load() {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	policyCacheLookups.WithLabelValues(backend, result).Inc()
+}
+
+// Policy label values of decisions that do not name a single policy.
+const (
+	metricsPolicyUnknown  = "unknown"  // The policy was not resolved, or the request was rejected.
+	metricsPolicyMultiple = "multiple" // The decision evaluated several policies.
+)
+
+// recordDecisionMetrics counts a decision and observes its latency, once the
+// metrics server has started.
+func recordDecisionMetrics(req LambdaEvent, resp LambdaResponse, err error, elapsed time.Duration) {
+	if !metricsEnabled.Load() {
+		return
+	}
+	policy := metricsPolicy(req, err)
+	evaluationsTotal.WithLabelValues(policy, decisionOutcome(resp, err)).Inc()
+	evaluationDuration.WithLabelValues(policy).Observe(elapsed.Seconds())
+}
+
+// metricsPolicy returns the policy label of a decision's metrics. Requests
+// for policies that do not exist, are not allowed or were not evaluated
+// because the request was invalid share one label, so that clients cannot
+// add label values at will.
+func metricsPolicy(req LambdaEvent, err error) string {
+	if len(req.Policies) > 0 {
+		return metricsPolicyMultiple
+	}
+	if req.PolicyName == "" {
+		return metricsPolicyUnknown
+	}
+	if err != nil {
+		switch classifyError(err).code {
+		case errorCodePolicyRequired, errorCodePolicyNotFound, errorCodePolicyNotAllowed, errorCodeInvalidPayload, errorCodeInvalidRequest:
+			return metricsPolicyUnknown
+		}
+	}
+	return req.PolicyName
+}
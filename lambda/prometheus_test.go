@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"opa_lambda/policyloader"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	writeTestPolicy(t, "scraped", "package scraped\n\nallow = input.user == \"jane\"\n")
+
+	// Nothing is recorded until metrics are served.
+	_, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"scraped","payload":{"user":"jane"}}`))
+	require.NoError(t, err)
+
+	server := httptest.NewServer(metricsHandler())
+	t.Cleanup(func() {
+		server.Close()
+		metricsEnabled.Store(false)
+		evaluationsTotal.Reset()
+		evaluationDuration.Reset()
+		policyCacheLookups.Reset()
+	})
+	require.NotContains(t, scrape(t, server.URL), `policy="scraped"`)
+
+	for _, user := range []string{"jane", "jane", "joe"} {
+		_, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"scraped","payload":{"user":"`+user+`"}}`))
+		require.NoError(t, err)
+	}
+	policyloader.CacheObserver(context.Background(), "s3", true)
+	policyloader.CacheObserver(context.Background(), "s3", false)
+
+	// Merges count once, and requests for unknown policies add no label values.
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policies":["scraped","scraped"],"merge_outputs":true,"payload":{"user":"jane"}}`))
+	require.NoError(t, err)
+	for _, body := range []string{`{"policy":"missing1","payload":{}}`, `{"policy":"missing2","payload":{}}`, `{"payload":{}}`} {
+		_, err = handleLambda(context.Background(), json.RawMessage(body))
+		require.Error(t, err)
+	}
+
+	metrics := scrape(t, server.URL)
+	require.Contains(t, metrics, `opa_lambda_evaluations_total{outcome="decided",policy="multiple"} 1`)
+	require.Contains(t, metrics, `opa_lambda_evaluations_total{outcome="error",policy="unknown"} 3`)
+	require.NotContains(t, metrics, `missing1`)
+	require.Contains(t, metrics, `opa_lambda_evaluations_total{outcome="decided",policy="scraped"} 2`)
+	require.Contains(t, metrics, `opa_lambda_evaluations_total{outcome="denied",policy="scraped"} 1`)
+	require.Contains(t, metrics, `opa_lambda_evaluation_duration_seconds_count{policy="scraped"} 3`)
+	require.Contains(t, metrics, `opa_lambda_policy_cache_lookups_total{backend="s3",result="hit"} 1`)
+	require.Contains(t, metrics, `opa_lambda_policy_cache_lookups_total{backend="s3",result="miss"} 1`)
+	require.Contains(t, metrics, `go_goroutines`)
+}
+
+func scrape(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
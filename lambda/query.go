@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// errInvalidQuery is returned when a request's query cannot be evaluated
+// against the policy it names.
+var errInvalidQuery = errors.New("invalid query")
+
+// checkQuery validates a query replacing the default data.<policy> query. It
+// must be a single plain reference, such as data.authz.allow, to the policy's
+// package or a document within it, so that a query can neither reach into the
+// documents of other policies nor change what the policy sees, as a "with"
+// modifier would. An empty query is valid.
+func checkQuery(policyName, query string) error {
+	if query == "" {
+		return nil
+	}
+
+	body, err := ast.ParseBody(query)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidQuery, err)
+	}
+
+	pkg := ast.Ref{ast.DefaultRootDocument}
+	for _, segment := range strings.Split(policyName, ".") {
+		pkg = append(pkg, ast.StringTerm(segment))
+	}
+
+	invalid := fmt.Errorf("%w: query must be a reference to package %s of policy %s or a document within it", errInvalidQuery, pkg, policyName)
+	if len(body) != 1 || body[0].Negated || len(body[0].With) > 0 {
+		return invalid
+	}
+	term, ok := body[0].Terms.(*ast.Term)
+	if !ok {
+		return invalid
+	}
+	ref, ok := term.Value.(ast.Ref)
+	if !ok || !ref.HasPrefix(ast.DefaultRootRef) {
+		return invalid
+	}
+	if !ref.HasPrefix(pkg) {
+		return fmt.Errorf("%w: %s is outside package %s of policy %s", errInvalidQuery, ref, pkg, policyName)
+	}
+	for _, segment := range ref[1:] {
+		if _, ok := segment.Value.(ast.String); !ok {
+			return invalid
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr string
+	}{
+		{query: ""},
+		{query: "data.auth.user"},
+		{query: "data.auth.user.allow"},
+		{query: `data.auth.user["deny-reasons"]`},
+		{query: "data.auth", wantErr: "data.auth is outside package data.auth.user"},
+		{query: "data.example.allow", wantErr: "data.example.allow is outside package data.auth.user"},
+		{query: "data.auth.user.allow; data.example.allow", wantErr: "query must be a reference to package data.auth.user"},
+		{query: `data.auth.user.deny_reasons[_] == "expired"`, wantErr: "query must be a reference"},
+		{query: "data.auth.user.deny_reasons[x]", wantErr: "query must be a reference"},
+		{query: "data.auth.user.allow with data.auth.user.allow as true", wantErr: "query must be a reference"},
+		{query: `data.auth.user.allow with input.user as "admin"`, wantErr: "query must be a reference"},
+		{query: "not data.auth.user.allow", wantErr: "query must be a reference"},
+		{query: "input.user", wantErr: "query must be a reference to package data.auth.user"},
+		{query: "data.auth.user[", wantErr: "invalid query"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			err := checkQuery("auth.user", tt.query)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, errInvalidQuery)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestHandleLambdaDirectEventQuery(t *testing.T) {
+	writeTestPolicy(t, "authz", "package authz\n\nallow = count(deny_reasons) == 0\n\ndeny_reasons[\"not admin\"] {\n    not input.admin\n}\n")
+
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"authz","query":"data.authz.deny_reasons","payload":{"admin":false}}`))
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"not admin"}, resp.(LambdaResponse).Output)
+
+	resp, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"authz","query":"data.authz.allow","inputs":{"admin":{"admin":true},"guest":{"admin":false}}}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"admin": true, "guest": false}, resp.(LambdaResponse).Output)
+
+	// Without a query, the whole package is the decision.
+	resp, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"authz","payload":{"admin":true}}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"allow": true, "deny_reasons": []interface{}{}}, resp.(LambdaResponse).Output)
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"authz","query":"data.example.allow","payload":{}}`))
+	require.ErrorIs(t, err, errInvalidQuery)
+
+	// A query cannot change what the policy sees.
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"authz","query":"data.authz.allow with data.authz.allow as true","payload":{}}`))
+	require.ErrorIs(t, err, errInvalidQuery)
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policies":["authz","example"],"query":"data.authz.allow","payload":{}}`))
+	require.ErrorContains(t, err, "query is not supported when evaluating several policies")
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// dynamoDBAttempts bounds the read-modify-write attempts of one Allow call
+// when other containers update the same bucket concurrently.
+const dynamoDBAttempts = 5
+
+// DynamoDBLimiter keeps buckets in a DynamoDB table whose partition key is the
+// string attribute "key", so limits hold across containers. Each item also
+// carries an "expires_at" epoch-seconds attribute suitable for the table's
+// TTL setting.
+type DynamoDBLimiter struct {
+	tableName string
+	client    dynamodbiface.DynamoDBAPI
+	now       func() time.Time
+}
+
+// NewDynamoDBLimiter creates a DynamoDBLimiter for tableName.
+func NewDynamoDBLimiter(tableName string) (*DynamoDBLimiter, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))})
+	if err != nil {
+		return nil, err
+	}
+	return NewDynamoDBLimiterWithClient(dynamodb.New(sess), tableName), nil
+}
+
+// NewDynamoDBLimiterWithClient creates a DynamoDBLimiter with a custom client.
+func NewDynamoDBLimiterWithClient(client dynamodbiface.DynamoDBAPI, tableName string) *DynamoDBLimiter {
+	return &DynamoDBLimiter{tableName: tableName, client: client, now: time.Now}
+}
+
+// Allow takes a token from the bucket for key. Concurrent updates are
+// detected with a version attribute and retried.
+func (l *DynamoDBLimiter) Allow(ctx context.Context, key string, rate Rate) (bool, error) {
+	for attempt := 0; attempt < dynamoDBAttempts; attempt++ {
+		item, err := l.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(l.tableName),
+			Key:            map[string]*dynamodb.AttributeValue{"key": {S: aws.String(key)}},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return false, fmt.Errorf("unable to read rate limit bucket %s: %w", key, err)
+		}
+
+		current, version, err := decodeBucket(item.Item)
+		if err != nil {
+			return false, fmt.Errorf("unable to read rate limit bucket %s: %w", key, err)
+		}
+
+		now := l.now()
+		next, allowed := current.take(rate, now)
+		if !allowed {
+			return false, nil
+		}
+
+		put := &dynamodb.PutItemInput{
+			TableName: aws.String(l.tableName),
+			Item: map[string]*dynamodb.AttributeValue{
+				"key":        {S: aws.String(key)},
+				"tokens":     {N: aws.String(strconv.FormatFloat(next.tokens, 'f', -1, 64))},
+				"updated_at": {N: aws.String(strconv.FormatInt(next.updated.UnixNano(), 10))},
+				"expires_at": {N: aws.String(strconv.FormatInt(now.Add(rate.Period).Unix(), 10))},
+				"version":    {N: aws.String(strconv.FormatInt(version+1, 10))},
+			},
+		}
+		if item.Item == nil {
+			put.ConditionExpression = aws.String("attribute_not_exists(#key)")
+			put.ExpressionAttributeNames = map[string]*string{"#key": aws.String("key")}
+		} else {
+			put.ConditionExpression = aws.String("#version = :version")
+			put.ExpressionAttributeNames = map[string]*string{"#version": aws.String("version")}
+			put.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+				":version": {N: aws.String(strconv.FormatInt(version, 10))},
+			}
+		}
+
+		_, err = l.client.PutItemWithContext(ctx, put)
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("unable to update rate limit bucket %s: %w", key, err)
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("unable to update rate limit bucket %s: too many concurrent updates", key)
+}
+
+// decodeBucket reads a bucket and its version from a table item. A missing
+// item is a new bucket at version zero.
+func decodeBucket(item map[string]*dynamodb.AttributeValue) (bucket, int64, error) {
+	if item == nil {
+		return bucket{}, 0, nil
+	}
+
+	number := func(name string) (string, error) {
+		if attr, ok := item[name]; ok && attr.N != nil {
+			return *attr.N, nil
+		}
+		return "", fmt.Errorf("attribute %s is missing", name)
+	}
+
+	tokens, err := number("tokens")
+	if err != nil {
+		return bucket{}, 0, err
+	}
+	updated, err := number("updated_at")
+	if err != nil {
+		return bucket{}, 0, err
+	}
+	version, err := number("version")
+	if err != nil {
+		return bucket{}, 0, err
+	}
+
+	var b bucket
+	var nanos, v int64
+	if b.tokens, err = strconv.ParseFloat(tokens, 64); err != nil {
+		return bucket{}, 0, err
+	}
+	if nanos, err = strconv.ParseInt(updated, 10, 64); err != nil {
+		return bucket{}, 0, err
+	}
+	if v, err = strconv.ParseInt(version, 10, 64); err != nil {
+		return bucket{}, 0, err
+	}
+	b.updated = time.Unix(0, nanos)
+	return b, v, nil
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockDynamoDBClient struct {
+	mock.Mock
+	dynamodbiface.DynamoDBAPI
+}
+
+func (m *mockDynamoDBClient) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	args := m.Called(ctx, input)
+	return args.Get(0).(*dynamodb.GetItemOutput), args.Error(1)
+}
+
+func (m *mockDynamoDBClient) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	args := m.Called(ctx, input)
+	return args.Get(0).(*dynamodb.PutItemOutput), args.Error(1)
+}
+
+func bucketItem(tokens string, updated time.Time, version string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"key":        {S: aws.String("alice")},
+		"tokens":     {N: aws.String(tokens)},
+		"updated_at": {N: aws.String(strconv.FormatInt(updated.UnixNano(), 10))},
+		"version":    {N: aws.String(version)},
+	}
+}
+
+func TestDynamoDBLimiterNewBucket(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	client := new(mockDynamoDBClient)
+	limiter := NewDynamoDBLimiterWithClient(client, "buckets")
+	limiter.now = func() time.Time { return now }
+
+	client.On("GetItemWithContext", mock.Anything, mock.Anything).Return(&dynamodb.GetItemOutput{}, nil).Once()
+	client.On("PutItemWithContext", mock.Anything, mock.MatchedBy(func(put *dynamodb.PutItemInput) bool {
+		return aws.StringValue(put.ConditionExpression) == "attribute_not_exists(#key)" &&
+			aws.StringValue(put.Item["tokens"].N) == "1" &&
+			aws.StringValue(put.Item["version"].N) == "1" &&
+			aws.StringValue(put.Item["expires_at"].N) == "1700000060"
+	})).Return(&dynamodb.PutItemOutput{}, nil).Once()
+
+	allowed, err := limiter.Allow(context.Background(), "alice", Rate{Limit: 2, Period: time.Minute})
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	client.AssertExpectations(t)
+}
+
+func TestDynamoDBLimiterEmptyBucket(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	client := new(mockDynamoDBClient)
+	limiter := NewDynamoDBLimiterWithClient(client, "buckets")
+	limiter.now = func() time.Time { return now }
+
+	// A denied call leaves the item untouched.
+	client.On("GetItemWithContext", mock.Anything, mock.Anything).
+		Return(&dynamodb.GetItemOutput{Item: bucketItem("0.5", now, "7")}, nil).Once()
+
+	allowed, err := limiter.Allow(context.Background(), "alice", Rate{Limit: 2, Period: time.Minute})
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	client.AssertExpectations(t)
+}
+
+func TestDynamoDBLimiterConcurrentUpdate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	client := new(mockDynamoDBClient)
+	limiter := NewDynamoDBLimiterWithClient(client, "buckets")
+	limiter.now = func() time.Time { return now }
+
+	conflict := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conflict", nil)
+	client.On("GetItemWithContext", mock.Anything, mock.Anything).
+		Return(&dynamodb.GetItemOutput{Item: bucketItem("2", now, "3")}, nil).Once()
+	client.On("PutItemWithContext", mock.Anything, mock.Anything).
+		Return(&dynamodb.PutItemOutput{}, conflict).Once()
+	client.On("GetItemWithContext", mock.Anything, mock.Anything).
+		Return(&dynamodb.GetItemOutput{Item: bucketItem("1.5", now, "4")}, nil).Once()
+	client.On("PutItemWithContext", mock.Anything, mock.MatchedBy(func(put *dynamodb.PutItemInput) bool {
+		return aws.StringValue(put.ExpressionAttributeValues[":version"].N) == "4" &&
+			aws.StringValue(put.Item["version"].N) == "5" &&
+			aws.StringValue(put.Item["tokens"].N) == "0.5"
+	})).Return(&dynamodb.PutItemOutput{}, nil).Once()
+
+	allowed, err := limiter.Allow(context.Background(), "alice", Rate{Limit: 2, Period: time.Minute})
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	client.AssertExpectations(t)
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is the number of buckets a MemoryLimiter holds before it
+// starts pruning full ones.
+const maxIdleBuckets = 1024
+
+// MemoryLimiter keeps buckets in memory. Buckets are per container, so a
+// function scaled out to several containers admits up to the limit in each;
+// use DynamoDBLimiter when the limit must hold across containers.
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]memoryBucket
+	now     func() time.Time
+}
+
+// memoryBucket is a bucket along with the period of the rate it was last
+// taken at, which is how long it takes to refill.
+type memoryBucket struct {
+	bucket
+	period time.Duration
+}
+
+// NewMemoryLimiter creates an empty MemoryLimiter.
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]memoryBucket), now: time.Now}
+}
+
+// Allow takes a token from the bucket for key.
+func (l *MemoryLimiter) Allow(_ context.Context, key string, rate Rate) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, allowed := l.buckets[key].take(rate, now)
+	l.buckets[key] = memoryBucket{bucket: b, period: rate.Period}
+	l.prune(now)
+	return allowed, nil
+}
+
+// prune drops buckets that have been idle long enough to be full again, which
+// is the same as not having them, so that keys seen once do not accumulate in
+// a warm container. The caller holds l.mu.
+func (l *MemoryLimiter) prune(now time.Time) {
+	if len(l.buckets) < maxIdleBuckets {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= b.period {
+			delete(l.buckets, key)
+		}
+	}
+}
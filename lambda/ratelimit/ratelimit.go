@@ -0,0 +1,103 @@
+// Package ratelimit keeps the token buckets behind the ratelimit.allow
+// built-in, so policies can make rate-limit decisions without an external
+// service.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limiter takes tokens from named buckets.
+type Limiter interface {
+	// Allow takes a token from the bucket for key, refilled at rate, and
+	// reports whether one was available.
+	Allow(ctx context.Context, key string, rate Rate) (bool, error)
+}
+
+// DryRun allows every call without taking a token, for evaluations that
+// preview decisions rather than make them.
+var DryRun Limiter = dryRunLimiter{}
+
+type dryRunLimiter struct{}
+
+func (dryRunLimiter) Allow(ctx context.Context, key string, rate Rate) (bool, error) {
+	return true, nil
+}
+
+// Rate is a number of requests allowed per period. It is also the capacity of
+// the bucket, so a full bucket admits a burst of Limit requests.
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParseRate parses a rate such as "100/m". The period is one of s, m, h, or
+// d; a bare number is a rate per second.
+func ParseRate(value string) (Rate, error) {
+	limit, unit, found := strings.Cut(strings.TrimSpace(value), "/")
+	if !found {
+		unit = "s"
+	}
+
+	periods := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}
+	period, ok := periods[strings.TrimSpace(unit)]
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate %q: period must be s, m, h, or d", value)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(limit))
+	if err != nil || n <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q: limit must be a positive integer", value)
+	}
+	return Rate{Limit: n, Period: period}, nil
+}
+
+// bucket is the state of one token bucket.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills b for the time elapsed since it was last updated and takes a
+// token if one is available. A zero bucket starts full.
+func (b bucket) take(rate Rate, now time.Time) (bucket, bool) {
+	capacity := float64(rate.Limit)
+	if b.updated.IsZero() {
+		b.tokens = capacity
+	} else if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+capacity*float64(elapsed)/float64(rate.Period))
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return b, false
+	}
+	b.tokens--
+	return b, true
+}
+
+// NewLimiterFromEnv creates the limiter selected by RATE_LIMIT_BACKEND:
+// "memory" keeps buckets in the container, "dynamodb" in the table named by
+// RATE_LIMIT_TABLE. It returns nil when RATE_LIMIT_BACKEND is unset.
+func NewLimiterFromEnv() (Limiter, error) {
+	switch backend := strings.ToLower(os.Getenv("RATE_LIMIT_BACKEND")); backend {
+	case "":
+		return nil, nil
+	case "memory":
+		return NewMemoryLimiter(), nil
+	case "dynamodb":
+		table := os.Getenv("RATE_LIMIT_TABLE")
+		if table == "" {
+			return nil, fmt.Errorf("RATE_LIMIT_TABLE is required with RATE_LIMIT_BACKEND=dynamodb")
+		}
+		return NewDynamoDBLimiter(table)
+	default:
+		return nil, fmt.Errorf("unsupported RATE_LIMIT_BACKEND: %s", backend)
+	}
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Rate
+		ok    bool
+	}{
+		{value: "100/m", want: Rate{Limit: 100, Period: time.Minute}, ok: true},
+		{value: " 5 / h ", want: Rate{Limit: 5, Period: time.Hour}, ok: true},
+		{value: "10", want: Rate{Limit: 10, Period: time.Second}, ok: true},
+		{value: "1/d", want: Rate{Limit: 1, Period: 24 * time.Hour}, ok: true},
+		{value: "0/s"},
+		{value: "1.5/s"},
+		{value: "10/w"},
+		{value: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseRate(tt.value)
+			if !tt.ok {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewMemoryLimiter()
+	limiter.now = func() time.Time { return now }
+	rate := Rate{Limit: 2, Period: time.Minute}
+
+	allow := func(key string) bool {
+		allowed, err := limiter.Allow(context.Background(), key, rate)
+		assert.NoError(t, err)
+		return allowed
+	}
+
+	assert.True(t, allow("alice"))
+	assert.True(t, allow("alice"))
+	assert.False(t, allow("alice"))
+	assert.True(t, allow("bob"))
+
+	now = now.Add(30 * time.Second)
+	assert.True(t, allow("alice"))
+	assert.False(t, allow("alice"))
+
+	now = now.Add(10 * time.Minute)
+	assert.True(t, allow("alice"))
+	assert.True(t, allow("alice"))
+	assert.False(t, allow("alice"))
+}
+
+func TestNewLimiterFromEnv(t *testing.T) {
+	t.Setenv("RATE_LIMIT_BACKEND", "")
+	limiter, err := NewLimiterFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, limiter)
+
+	t.Setenv("RATE_LIMIT_BACKEND", "memory")
+	limiter, err = NewLimiterFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryLimiter{}, limiter)
+
+	t.Setenv("RATE_LIMIT_BACKEND", "dynamodb")
+	_, err = NewLimiterFromEnv()
+	assert.ErrorContains(t, err, "RATE_LIMIT_TABLE is required")
+
+	t.Setenv("RATE_LIMIT_BACKEND", "redis")
+	_, err = NewLimiterFromEnv()
+	assert.ErrorContains(t, err, "unsupported RATE_LIMIT_BACKEND")
+}
+
+func TestDryRun(t *testing.T) {
+	rate := Rate{Limit: 1, Period: time.Hour}
+	for i := 0; i < 3; i++ {
+		allowed, err := DryRun.Allow(context.Background(), "alice", rate)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+}
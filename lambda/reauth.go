@@ -0,0 +1,33 @@
+package main
+
+import "net/http"
+
+// reasonReauthRequired is the reason a policy gives to ask the client to
+// authenticate again, typically more strongly, before retrying.
+const reasonReauthRequired = "reauth_required"
+
+// defaultReauthChallenge is the WWW-Authenticate challenge sent when a policy
+// does not name one: the step-up error of RFC 9470.
+const defaultReauthChallenge = `Bearer error="insufficient_user_authentication"`
+
+// reauthResponse answers 401 Unauthorized when a policy follows the
+// re-authentication contract: an output object with allow set to false and
+// reason set to "reauth_required". The WWW-Authenticate header carries the
+// output's challenge string when it has one, such as a challenge naming the
+// acr_values to step up to, and defaultReauthChallenge otherwise. It reports
+// false for every other output, including other denials, which are left to
+// deniedResponse.
+func reauthResponse(resp LambdaResponse) (httpResponse, bool) {
+	result, ok := resp.Output.(map[string]interface{})
+	if !ok || result["allow"] != false || result["reason"] != reasonReauthRequired {
+		return httpResponse{}, false
+	}
+
+	challenge, ok := result["challenge"].(string)
+	if !ok || challenge == "" {
+		challenge = defaultReauthChallenge
+	}
+	httpResp := newHTTPResponse(http.StatusUnauthorized, resp)
+	httpResp.Headers["WWW-Authenticate"] = challenge
+	return httpResp, true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+const stepUpPolicy = `package stepup
+
+default allow = false
+
+allow { input.user == "jane"; input.mfa }
+
+reason = "reauth_required" { input.user == "jane"; not input.mfa }
+
+reason = "forbidden" { input.user != "jane" }
+
+challenge = "Bearer error=\"insufficient_user_authentication\", acr_values=\"mfa\"" { input.acr }
+`
+
+func TestReauthResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    interface{}
+		challenge string
+	}{
+		{name: "default challenge", output: map[string]interface{}{"allow": false, "reason": "reauth_required"}, challenge: defaultReauthChallenge},
+		{name: "policy challenge", output: map[string]interface{}{"allow": false, "reason": "reauth_required", "challenge": `Bearer acr_values="mfa"`}, challenge: `Bearer acr_values="mfa"`},
+		{name: "allowed", output: map[string]interface{}{"allow": true, "reason": "reauth_required"}},
+		{name: "other reason", output: map[string]interface{}{"allow": false, "reason": "forbidden"}},
+		{name: "no allow", output: map[string]interface{}{"reason": "reauth_required"}},
+		{name: "boolean", output: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, ok := reauthResponse(LambdaResponse{Output: tt.output})
+			require.Equal(t, tt.challenge != "", ok)
+			if ok {
+				require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+				require.Equal(t, tt.challenge, resp.Headers["WWW-Authenticate"])
+			}
+		})
+	}
+}
+
+func TestHandleLambdaAPIGatewayV2EventReauth(t *testing.T) {
+	writeTestPolicy(t, "stepup", stepUpPolicy)
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"stepup","payload":{"user":"jane"}}`)
+	require.Equal(t, http.StatusUnauthorized, gwResp.StatusCode)
+	require.Equal(t, defaultReauthChallenge, gwResp.Headers["WWW-Authenticate"])
+	require.Equal(t, "reauth_required", parseLambdaResponseBody(t, gwResp.Body).Output.(map[string]interface{})["reason"])
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"stepup","payload":{"user":"jane","acr":true}}`)
+	require.Equal(t, http.StatusUnauthorized, gwResp.StatusCode)
+	require.Equal(t, `Bearer error="insufficient_user_authentication", acr_values="mfa"`, gwResp.Headers["WWW-Authenticate"])
+
+	// Other denials are not turned into 401s.
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"stepup","payload":{"user":"joe"}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.NotContains(t, gwResp.Headers, "WWW-Authenticate")
+
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"stepup","payload":{"user":"jane","mfa":true}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+}
+
+func TestHandleLambdaReauthVersusDenied(t *testing.T) {
+	writeTestPolicy(t, "stepup", stepUpPolicy+"\nobligations = []\n")
+
+	// With obligations, a plain denial is a 403 and a step-up is still a 401.
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"stepup","payload":{"user":"joe"}}`)
+	require.Equal(t, http.StatusForbidden, gwResp.StatusCode)
+	require.NotContains(t, gwResp.Headers, "WWW-Authenticate")
+
+	event := events.ALBTargetGroupRequest{
+		RequestContext: events.ALBTargetGroupRequestContext{
+			ELB: events.ELBContext{TargetGroupArn: "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/opa/test"},
+		},
+		Body: `{"policy":"stepup","payload":{"user":"jane"}}`,
+	}
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+	albResp, ok := resp.(events.ALBTargetGroupResponse)
+	require.True(t, ok)
+	require.Equal(t, http.StatusUnauthorized, albResp.StatusCode)
+	require.Equal(t, defaultReauthChallenge, albResp.Headers["WWW-Authenticate"])
+
+	// Direct invocations return the output only.
+	direct, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"stepup","payload":{"user":"jane"}}`))
+	require.NoError(t, err)
+	require.Equal(t, "reauth_required", direct.(LambdaResponse).Output.(map[string]interface{})["reason"])
+}
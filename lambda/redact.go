@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"opa_lambda/policyloader"
+)
+
+// redactionSuffix names the redaction rules stored next to a policy, as in
+// policies/auth/user.redact.json for auth.user.
+const redactionSuffix = ".redact.json"
+
+// redactedValue replaces every redacted value in a returned output.
+const redactedValue = "[REDACTED]"
+
+// outputRedaction lists the output paths masked before an output is
+// returned. Paths are dotted field names from the root of the output,
+// optionally prefixed with "$."; a path crossing an array applies to each of
+// its elements.
+type outputRedaction struct {
+	Paths []string `json:"paths"`
+}
+
+// redactPaths splits redaction paths into their fields. The decision itself
+// cannot be redacted, since the HTTP integrations and batch aggregation act on
+// the returned allow.
+func redactPaths(paths []string) ([][]string, error) {
+	split := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		fields := strings.Split(strings.TrimPrefix(path, "$."), ".")
+		for _, field := range fields {
+			if field == "" {
+				return nil, fmt.Errorf("invalid redaction path %q", path)
+			}
+		}
+		if len(fields) == 1 && fields[0] == "allow" {
+			return nil, errors.New("allow cannot be redacted")
+		}
+		split = append(split, fields)
+	}
+	return split, nil
+}
+
+// requestedRedaction returns the paths the request's redact field lists.
+func requestedRedaction(req LambdaEvent) ([][]string, error) {
+	paths, err := redactPaths(req.Redact)
+	if err != nil {
+		return nil, invalidRequestError("%v", err)
+	}
+	return paths, nil
+}
+
+// redactResponse masks the requested paths and, when OUTPUT_REDACTION is
+// enabled, the paths the policies' redaction rules list in the output of a
+// decision. Outputs of several policies are masked with the rules of the
+// policy each comes from, and merged outputs with the rules of all of them.
+func redactResponse(ctx context.Context, req LambdaEvent, resp LambdaResponse, requested [][]string) (LambdaResponse, error) {
+	rules := func(policies ...string) ([][]string, error) {
+		paths := append([][]string{}, requested...)
+		for _, policy := range policies {
+			policyPaths, err := loadOutputRedaction(ctx, policy)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, policyPaths...)
+		}
+		return paths, nil
+	}
+
+	switch {
+	case len(req.Policies) > 0 && !req.MergeOutputs && !req.FirstMatch:
+		outputs, _ := resp.Output.(map[string]interface{})
+		redacted := make(map[string]interface{}, len(outputs))
+		for name, output := range outputs {
+			paths, err := rules(name)
+			if err != nil {
+				return LambdaResponse{}, err
+			}
+			redacted[name] = redactOutput(output, paths)
+		}
+		resp.Output = redacted
+	case len(req.Policies) > 0 && req.FirstMatch:
+		if resp.MatchedPolicy == "" {
+			return resp, nil
+		}
+		paths, err := rules(resp.MatchedPolicy)
+		if err != nil {
+			return LambdaResponse{}, err
+		}
+		resp.Output = redactOutput(resp.Output, paths)
+	default:
+		policies := req.Policies
+		if len(policies) == 0 {
+			policies = []string{req.PolicyName}
+		}
+		paths, err := rules(policies...)
+		if err != nil {
+			return LambdaResponse{}, err
+		}
+		resp.Output = redactDecisionOutput(req, resp.Output, paths)
+	}
+	return resp, nil
+}
+
+// redactDecisionOutput masks paths in the output of one policy, in each
+// output of an inputs request, or in both outputs and the differences of a
+// data comparison.
+func redactDecisionOutput(req LambdaEvent, output interface{}, paths [][]string) interface{} {
+	if len(paths) == 0 {
+		return output
+	}
+	switch {
+	case req.CandidateData != nil:
+		comparison, ok := output.(dataComparison)
+		if !ok {
+			return output
+		}
+		comparison.Current = redactOutput(comparison.Current, paths)
+		comparison.Candidate = redactOutput(comparison.Candidate, paths)
+		diff := make([]outputChange, len(comparison.Diff))
+		for i, change := range comparison.Diff {
+			diff[i] = redactChange(change, paths)
+		}
+		comparison.Diff = diff
+		return comparison
+	case req.Inputs != nil:
+		outputs, _ := output.(map[string]interface{})
+		redacted := make(map[string]interface{}, len(outputs))
+		for name, output := range outputs {
+			redacted[name] = redactOutput(output, paths)
+		}
+		return redacted
+	default:
+		return redactOutput(output, paths)
+	}
+}
+
+// redactChange masks the values of a difference that lie under, or contain,
+// a redacted path.
+func redactChange(change outputChange, paths [][]string) outputChange {
+	var at []string
+	if change.Path != "" {
+		at = strings.Split(change.Path, ".")
+	}
+	for _, path := range paths {
+		var within []string
+		switch {
+		case hasPathPrefix(at, path):
+			within = nil
+		case hasPathPrefix(path, at):
+			within = path[len(at):]
+		default:
+			continue
+		}
+		if change.Current != nil {
+			change.Current = redactValue(change.Current, within)
+		}
+		if change.Candidate != nil {
+			change.Candidate = redactValue(change.Candidate, within)
+		}
+	}
+	return change
+}
+
+// hasPathPrefix reports whether path starts with the fields of prefix.
+func hasPathPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, field := range prefix {
+		if path[i] != field {
+			return false
+		}
+	}
+	return true
+}
+
+// redactOutput masks every path in output.
+func redactOutput(output interface{}, paths [][]string) interface{} {
+	for _, path := range paths {
+		output = redactValue(output, path)
+	}
+	return output
+}
+
+// redactValue returns value with the value at path replaced by redactedValue.
+// Objects and arrays on the way are copied rather than modified, and a path
+// that does not exist leaves value unchanged.
+func redactValue(value interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return redactedValue
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		field, ok := v[path[0]]
+		if !ok {
+			return value
+		}
+		redacted := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			redacted[key] = element
+		}
+		redacted[path[0]] = redactValue(field, path[1:])
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, element := range v {
+			redacted[i] = redactValue(element, path)
+		}
+		return redacted
+	default:
+		return value
+	}
+}
+
+// loadOutputRedaction loads the redaction paths of a policy when
+// OUTPUT_REDACTION is enabled. A policy without redaction rules has none.
+func loadOutputRedaction(ctx context.Context, policyName string) ([][]string, error) {
+	enabled, err := boolFromEnv("OUTPUT_REDACTION", false)
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	pl, err := sharedPolicyLoader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	documents, ok := pl.(policyloader.DocumentLoader)
+	if !ok {
+		return nil, errors.New("OUTPUT_REDACTION is not supported by the policy backend")
+	}
+
+	raw, err := documents.LoadDocument(ctx, policyName, redactionSuffix)
+	var notFound *policyloader.FileNotFoundError
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var redaction outputRedaction
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&redaction); err != nil {
+		return nil, fmt.Errorf("invalid output redaction for %s: %w", policyName, err)
+	}
+	paths, err := redactPaths(redaction.Paths)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output redaction for %s: %w", policyName, err)
+	}
+	return paths, nil
+}
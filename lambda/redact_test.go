@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactValue(t *testing.T) {
+	output := map[string]interface{}{
+		"allow": true,
+		"user":  map[string]interface{}{"email": "jane@example.com", "name": "Jane"},
+		"grants": []interface{}{
+			map[string]interface{}{"token": "a", "scope": "read"},
+			map[string]interface{}{"scope": "write"},
+		},
+	}
+	paths, err := redactPaths([]string{"$.user.email", "grants.token", "missing.field"})
+	require.NoError(t, err)
+
+	redacted := redactOutput(output, paths)
+	require.Equal(t, map[string]interface{}{
+		"allow": true,
+		"user":  map[string]interface{}{"email": redactedValue, "name": "Jane"},
+		"grants": []interface{}{
+			map[string]interface{}{"token": redactedValue, "scope": "read"},
+			map[string]interface{}{"scope": "write"},
+		},
+	}, redacted)
+
+	// The evaluated output is left as it was.
+	require.Equal(t, "jane@example.com", output["user"].(map[string]interface{})["email"])
+	require.Equal(t, "a", output["grants"].([]interface{})[0].(map[string]interface{})["token"])
+
+	// A whole object can be redacted, and scalars have no fields to redact.
+	require.Equal(t, map[string]interface{}{"allow": true, "user": redactedValue, "grants": output["grants"]}, redactValue(output, []string{"user"}))
+	require.Equal(t, "plain", redactValue("plain", []string{"user"}))
+}
+
+func TestRedactPathsInvalid(t *testing.T) {
+	for _, path := range []string{"", "user..email", "$.", "user."} {
+		_, err := redactPaths([]string{path})
+		require.ErrorContains(t, err, "invalid redaction path", path)
+	}
+	_, err := redactPaths([]string{"allow"})
+	require.ErrorContains(t, err, "allow cannot be redacted")
+
+	_, err = redactPaths([]string{"allow.reason"})
+	require.NoError(t, err)
+}
+
+func TestRedactChange(t *testing.T) {
+	paths, err := redactPaths([]string{"user.email"})
+	require.NoError(t, err)
+
+	require.Equal(t, outputChange{Path: "user.email", Current: redactedValue, Candidate: redactedValue},
+		redactChange(outputChange{Path: "user.email", Current: "a@example.com", Candidate: "b@example.com"}, paths))
+	require.Equal(t, outputChange{Path: "user", Current: map[string]interface{}{"email": redactedValue}},
+		redactChange(outputChange{Path: "user", Current: map[string]interface{}{"email": "a@example.com"}}, paths))
+	require.Equal(t, outputChange{Path: "region", Current: "eu", Candidate: "us"},
+		redactChange(outputChange{Path: "region", Current: "eu", Candidate: "us"}, paths))
+}
+
+func writeTestRedaction(t *testing.T, name, redaction string) {
+	t.Helper()
+	path := filepath.Join(testPolicyDir(t), name+redactionSuffix)
+	require.NoError(t, os.WriteFile(path, []byte(redaction), 0o600))
+}
+
+func TestHandleLambdaDirectEventRedaction(t *testing.T) {
+	writeTestPolicy(t, "profile", `package profile
+
+allow = input.user == "jane"
+
+user = {"email": "jane@example.com", "address": {"city": "Paris", "street": "1 Rue de Rivoli"}}
+
+sessions = [{"id": "s1", "token": "t1"}, {"id": "s2", "token": "t2"}]
+`)
+	writeTestRedaction(t, "profile", `{"paths": ["sessions.token"]}`)
+	records := useDecisionLogger(t)
+
+	evaluate := func(event string) map[string]interface{} {
+		t.Helper()
+		resp, err := handleLambda(context.Background(), json.RawMessage(event))
+		require.NoError(t, err)
+		return resp.(LambdaResponse).Output.(map[string]interface{})
+	}
+
+	// Policy rules are opt-in; requested paths always apply.
+	output := evaluate(`{"policy": "profile", "payload": {"user": "jane"}, "redact": ["user.address.street"]}`)
+	require.Equal(t, map[string]interface{}{"city": "Paris", "street": redactedValue}, output["user"].(map[string]interface{})["address"])
+	require.Equal(t, "jane@example.com", output["user"].(map[string]interface{})["email"])
+	require.Equal(t, "t1", output["sessions"].([]interface{})[0].(map[string]interface{})["token"])
+
+	t.Setenv("OUTPUT_REDACTION", "true")
+	output = evaluate(`{"policy": "profile", "payload": {"user": "jane"}, "redact": ["user.email"]}`)
+	require.Equal(t, true, output["allow"])
+	require.Equal(t, redactedValue, output["user"].(map[string]interface{})["email"])
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"id": "s1", "token": redactedValue},
+		map[string]interface{}{"id": "s2", "token": redactedValue},
+	}, output["sessions"])
+
+	// Decision logs keep the full result.
+	logged := records()
+	require.Len(t, logged, 2)
+	require.Contains(t, logged[1].Result.(map[string]interface{})["user"], "email")
+	require.Equal(t, "jane@example.com", logged[1].Result.(map[string]interface{})["user"].(map[string]interface{})["email"])
+
+	// Outputs of several policies are redacted by the rules of their policy.
+	output = evaluate(`{"policies": ["profile", "example"], "payload": {"user": "jane"}}`)
+	require.Equal(t, redactedValue, output["profile"].(map[string]interface{})["sessions"].([]interface{})[1].(map[string]interface{})["token"])
+
+	_, err := handleLambda(context.Background(), json.RawMessage(`{"policy": "profile", "payload": {"user": "jane"}, "redact": ["allow"]}`))
+	require.ErrorContains(t, err, "allow cannot be redacted")
+
+	writeTestRedaction(t, "profile", `{"paths": ["sessions..token"]}`)
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy": "profile", "payload": {"user": "jane"}}`))
+	require.ErrorContains(t, err, "invalid output redaction for profile")
+}
+
+func TestHandleLambdaDirectEventRedactionComparison(t *testing.T) {
+	writeTestPolicy(t, "contact", "package contact\n\nemail = data.email\n")
+
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{
+		"policy": "contact", "payload": {"user": "jane"}, "redact": ["email"],
+		"data": {"email": "old@example.com"}, "candidate_data": {"email": "new@example.com"}
+	}`))
+	require.NoError(t, err)
+
+	comparison := resp.(LambdaResponse).Output.(dataComparison)
+	require.Equal(t, map[string]interface{}{"email": redactedValue}, comparison.Current)
+	require.Equal(t, map[string]interface{}{"email": redactedValue}, comparison.Candidate)
+	require.True(t, comparison.Changed)
+	require.Equal(t, []outputChange{{Path: "email", Current: redactedValue, Candidate: redactedValue}}, comparison.Diff)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// errRouteConflict is returned when a request body names a policy other than
+// the one its route is mapped to.
+var errRouteConflict = errors.New("policy does not match route")
+
+// policyRoute maps an HTTP path to the policy, and optionally the query, that
+// requests to it evaluate.
+type policyRoute struct {
+	Path   string
+	Policy string
+	Query  string // Empty for the default data.<policy> query.
+}
+
+// parsePolicyRoutes parses HTTP_POLICY_ROUTES, a comma-separated list of
+// path=policy or path=policy:query entries such as
+// "/authz/read=authz:data.authz.read". Routes are returned longest path first.
+func parsePolicyRoutes() ([]policyRoute, error) {
+	raw := strings.TrimSpace(os.Getenv("HTTP_POLICY_ROUTES"))
+	if raw == "" {
+		return nil, nil
+	}
+
+	var routes []policyRoute
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		path, target, ok := strings.Cut(entry, "=")
+		path = "/" + strings.Trim(strings.TrimSpace(path), "/")
+		policy, query, _ := strings.Cut(target, ":")
+		route := policyRoute{Path: path, Policy: strings.TrimSpace(policy), Query: strings.TrimSpace(query)}
+		if !ok || path == "/" || !policyNamePattern.MatchString(route.Policy) {
+			return nil, fmt.Errorf("invalid HTTP_POLICY_ROUTES entry %q: expected path=policy or path=policy:query", entry)
+		}
+		if route.Query != "" {
+			if _, err := ast.ParseBody(route.Query); err != nil {
+				return nil, fmt.Errorf("invalid HTTP_POLICY_ROUTES entry %q: %w", entry, err)
+			}
+		}
+		if seen[path] {
+			return nil, fmt.Errorf("invalid HTTP_POLICY_ROUTES entry %q: duplicate path %s", entry, path)
+		}
+		seen[path] = true
+		routes = append(routes, route)
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].Path) > len(routes[j].Path) })
+	return routes, nil
+}
+
+// matchPolicyRoute returns the route for an HTTP request path, if any. Like
+// data API paths, a route matches whole segments at the end of the path,
+// tolerating a stage or base path in front of it.
+func matchPolicyRoute(path string) (policyRoute, bool, error) {
+	routes, err := parsePolicyRoutes()
+	if err != nil {
+		return policyRoute{}, false, err
+	}
+
+	path = "/" + strings.Trim(path, "/")
+	for _, route := range routes {
+		if strings.HasSuffix(path, route.Path) {
+			return route, true, nil
+		}
+	}
+	return policyRoute{}, false, nil
+}
+
+// applyPolicyRoute points req at the policy and query of the route matching
+// path. Requests to paths without a route are left unchanged, and a route
+// without a query leaves the request's own query in place.
+func applyPolicyRoute(path string, req *LambdaEvent) error {
+	route, ok, err := matchPolicyRoute(path)
+	if err != nil || !ok {
+		return err
+	}
+
+	if req.PolicyName != "" && req.PolicyName != route.Policy {
+		return fmt.Errorf("%w: %s is served by %s, not %s", errRouteConflict, route.Path, route.Policy, req.PolicyName)
+	}
+	if req.Query != "" && route.Query != "" && req.Query != route.Query {
+		return fmt.Errorf("%w: %s queries %s, not %s", errRouteConflict, route.Path, route.Query, req.Query)
+	}
+	req.PolicyName = route.Policy
+	if route.Query != "" {
+		req.Query = route.Query
+	}
+	return nil
+}
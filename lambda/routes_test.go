@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchPolicyRoute(t *testing.T) {
+	t.Setenv("HTTP_POLICY_ROUTES", "/authz=authz, authz/read/=authz:data.authz.read")
+
+	tests := []struct {
+		path   string
+		want   policyRoute
+		wantOK bool
+	}{
+		{path: "/authz", want: policyRoute{Path: "/authz", Policy: "authz"}, wantOK: true},
+		{path: "/authz/read", want: policyRoute{Path: "/authz/read", Policy: "authz", Query: "data.authz.read"}, wantOK: true},
+		{path: "/prod/authz/read/", want: policyRoute{Path: "/authz/read", Policy: "authz", Query: "data.authz.read"}, wantOK: true},
+		{path: "/xauthz"},
+		{path: "/authz/write"},
+		{path: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok, err := matchPolicyRoute(tt.path)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParsePolicyRoutesInvalid(t *testing.T) {
+	for _, raw := range []string{"/authz", "=authz", "/=authz", "/authz=../authz", "/authz=authz:data.authz[", "/a=a,/a/=b"} {
+		t.Setenv("HTTP_POLICY_ROUTES", raw)
+
+		_, err := parsePolicyRoutes()
+		require.Error(t, err, raw)
+	}
+}
+
+func TestHandleLambdaAPIGatewayV2EventPolicyRoute(t *testing.T) {
+	writeTestPolicy(t, "authz", "package authz\n\nread = input.user == \"jane\"\n\nwrite = false\n")
+	t.Setenv("HTTP_POLICY_ROUTES", "/authz/read=authz:data.authz.read,/authz=authz")
+
+	invoke := func(path, body string) events.APIGatewayV2HTTPResponse {
+		raw, err := json.Marshal(events.APIGatewayV2HTTPRequest{Version: "2.0", RawPath: path, Body: body})
+		require.NoError(t, err)
+		resp, err := handleLambda(context.Background(), raw)
+		require.NoError(t, err)
+		return resp.(events.APIGatewayV2HTTPResponse)
+	}
+
+	gwResp := invoke("/authz/read", `{"payload":{"user":"jane"}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, true, parseLambdaResponseBody(t, gwResp.Body).Output)
+
+	gwResp = invoke("/authz", `{"payload":{"user":"jane"}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, map[string]interface{}{"read": true, "write": false}, parseLambdaResponseBody(t, gwResp.Body).Output)
+
+	gwResp = invoke("/opa", `{"policy":"example","payload":{"membership":{"user":{"login":"jane","mail":"jane@example.com"}}}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	assertExampleOutput(t, parseLambdaResponseBody(t, gwResp.Body).Output)
+
+	gwResp = invoke("/authz/read", `{"policy":"example","payload":{}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "policy does not match route")
+
+	gwResp = invoke("/authz", `{"query":"data.authz.write","payload":{}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, false, parseLambdaResponseBody(t, gwResp.Body).Output)
+
+	gwResp = invoke("/authz/read", `{"query":"data.authz.write","payload":{}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+
+	gwResp = invoke("/authz", `{"query":"data.example","payload":{}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "outside package data.authz")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	log "github.com/sirupsen/logrus"
+
+	"opa_lambda/policyevaluator"
+)
+
+// An S3BatchEvent asks the function to evaluate a policy against every record
+// of an NDJSON object in S3 and write the results to another object.
+type S3BatchEvent struct {
+	PolicyName string      `json:"policy"`        // The name of the OPA policy to check.
+	Input      S3ObjectRef `json:"input_object"`  // The NDJSON object holding one payload per line.
+	Output     S3ObjectRef `json:"output_object"` // The object receiving one NDJSON result per input line.
+}
+
+// S3ObjectRef identifies an S3 object.
+type S3ObjectRef struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+// S3BatchResponse summarizes a completed S3 batch evaluation.
+type S3BatchResponse struct {
+	Records   int         `json:"records"`         // Number of non-empty input lines.
+	Succeeded int         `json:"succeeded"`       // Records evaluated without error.
+	Failed    int         `json:"failed"`          // Records that could not be parsed or evaluated.
+	Output    S3ObjectRef `json:"output_object"`   // Where the results were written.
+	Error     string      `json:"error,omitempty"` // The error that aborted the batch, if any.
+}
+
+// s3BatchRecord is written to the output object for every input record.
+type s3BatchRecord struct {
+	Line int `json:"line"` // 1-based line number in the input object.
+	LambdaResponse
+}
+
+// newBatchS3Client creates the S3 client used for batch input and output.
+var newBatchS3Client = func() (s3iface.S3API, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))})
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
+func isS3BatchEvent(payload json.RawMessage) bool {
+	var probe struct {
+		Input struct {
+			Bucket string `json:"bucket"`
+		} `json:"input_object"`
+	}
+
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return false
+	}
+
+	return probe.Input.Bucket != ""
+}
+
+func handleS3BatchEvent(ctx context.Context, payload json.RawMessage) (S3BatchResponse, error) {
+	var req S3BatchEvent
+	if err := json.Unmarshal(payload, &req); err != nil {
+		err = fmt.Errorf("unable to parse S3 batch payload: %w", err)
+		log.Error(err)
+		return S3BatchResponse{Error: err.Error()}, err
+	}
+
+	client, err := newBatchS3Client()
+	if err != nil {
+		log.Error(err)
+		return S3BatchResponse{Error: err.Error()}, err
+	}
+
+	resp, err := evaluateS3Batch(ctx, client, req)
+	if err != nil {
+		log.Error(err)
+		resp.Error = err.Error()
+		return resp, err
+	}
+
+	log.Infof("Evaluated %d records from s3://%s/%s (%d succeeded, %d failed)", resp.Records, req.Input.Bucket, req.Input.Key, resp.Succeeded, resp.Failed)
+	return resp, nil
+}
+
+// evaluateS3Batch streams the input object line by line and uploads results
+// as they are produced, so neither side is held in memory in full.
+func evaluateS3Batch(ctx context.Context, client s3iface.S3API, req S3BatchEvent) (S3BatchResponse, error) {
+	resp := S3BatchResponse{Output: req.Output}
+
+	if req.PolicyName == "" {
+		return resp, errPolicyRequired
+	}
+	if err := checkPolicyAllowed(req.PolicyName); err != nil {
+		return resp, err
+	}
+	if req.Input.Bucket == "" || req.Input.Key == "" {
+		return resp, errors.New("input_object bucket and key are required")
+	}
+	if req.Output.Bucket == "" || req.Output.Key == "" {
+		return resp, errors.New("output_object bucket and key are required")
+	}
+
+	pe, opts, err := newEvaluator(ctx)
+	if err != nil {
+		return resp, err
+	}
+
+	object, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(req.Input.Bucket),
+		Key:    aws.String(req.Input.Key),
+	})
+	if err != nil {
+		return resp, fmt.Errorf("failed to get batch input from S3: %w", err)
+	}
+	defer object.Body.Close()
+
+	log.Infof("Evaluating policy %s against s3://%s/%s", req.PolicyName, req.Input.Bucket, req.Input.Key)
+
+	pr, pw := io.Pipe()
+	uploadErr := make(chan error, 1)
+	go func() {
+		uploader := s3manager.NewUploaderWithClient(client)
+		_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:      aws.String(req.Output.Bucket),
+			Key:         aws.String(req.Output.Key),
+			Body:        pr,
+			ContentType: aws.String("application/x-ndjson"),
+		})
+		pr.CloseWithError(err)
+		uploadErr <- err
+	}()
+
+	writer := bufio.NewWriter(pw)
+	encoder := json.NewEncoder(writer)
+	reader := bufio.NewReader(object.Body)
+
+	var streamErr error
+	for line := 1; ; line++ {
+		raw, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			streamErr = fmt.Errorf("failed to read batch input: %w", readErr)
+			break
+		}
+
+		if record := bytes.TrimSpace(raw); len(record) > 0 {
+			resp.Records++
+			out := s3BatchRecord{Line: line}
+			record, err := withInputContext(record)
+			var result *policyevaluator.EvaluationResult
+			if err == nil {
+				result, err = pe.EvaluatePolicyWithOptions(ctx, req.PolicyName, record, opts)
+			}
+			if err != nil {
+				resp.Failed++
+				out.Error = err.Error()
+			} else {
+				resp.Succeeded++
+				out.Output = result.Value
+				out.Truncated = result.Truncated
+			}
+			if err := encoder.Encode(out); err != nil {
+				streamErr = fmt.Errorf("failed to write batch output: %w", err)
+				break
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	if streamErr == nil {
+		streamErr = writer.Flush()
+	}
+	pw.CloseWithError(streamErr)
+
+	err = <-uploadErr
+	if streamErr != nil {
+		return resp, streamErr
+	}
+	if err != nil {
+		return resp, fmt.Errorf("failed to upload batch output to S3: %w", err)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 serves GETs from and records PUTs to an in-memory object map.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		_, _ = io.WriteString(w, body)
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = string(body)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newFakeS3Client(t *testing.T, objects map[string]string) (*s3.S3, *fakeS3) {
+	t.Helper()
+	fake := &fakeS3{objects: objects}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	require.NoError(t, err)
+	return s3.New(sess), fake
+}
+
+func TestEvaluateS3Batch(t *testing.T) {
+	input := strings.Join([]string{
+		`{"membership":{"user":{"login":"jane","mail":"jane@example.com"}}}`,
+		``,
+		`{"membership":{"user":{"login":"joe","mail":"joe@other.com"}}}`,
+		`{not json`,
+	}, "\n")
+	client, fake := newFakeS3Client(t, map[string]string{"/audit/in.ndjson": input})
+
+	resp, err := evaluateS3Batch(context.Background(), client, S3BatchEvent{
+		PolicyName: "example",
+		Input:      S3ObjectRef{Bucket: "audit", Key: "in.ndjson"},
+		Output:     S3ObjectRef{Bucket: "audit", Key: "out.ndjson"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, resp.Records)
+	require.Equal(t, 2, resp.Succeeded)
+	require.Equal(t, 1, resp.Failed)
+
+	fake.mu.Lock()
+	output := fake.objects["/audit/out.ndjson"]
+	fake.mu.Unlock()
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 3)
+
+	require.Equal(t, float64(1), records[0]["line"])
+	assertExampleOutput(t, records[0]["output"])
+	require.Equal(t, float64(3), records[1]["line"])
+	require.Equal(t, false, records[1]["output"].(map[string]interface{})["allow"])
+	require.Equal(t, float64(4), records[2]["line"])
+	require.NotEmpty(t, records[2]["error"])
+}
+
+func TestEvaluateS3BatchMissingInput(t *testing.T) {
+	client, _ := newFakeS3Client(t, map[string]string{})
+
+	_, err := evaluateS3Batch(context.Background(), client, S3BatchEvent{
+		PolicyName: "example",
+		Input:      S3ObjectRef{Bucket: "audit", Key: "missing.ndjson"},
+		Output:     S3ObjectRef{Bucket: "audit", Key: "out.ndjson"},
+	})
+	require.ErrorContains(t, err, "failed to get batch input from S3")
+}
+
+func TestIsS3BatchEvent(t *testing.T) {
+	require.True(t, isS3BatchEvent(json.RawMessage(`{"policy":"example","input_object":{"bucket":"b","key":"k"}}`)))
+	require.False(t, isS3BatchEvent(buildLambdaEventPayload(t)))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"time"
+)
+
+// Decision outcomes, as logged in the outcome field of decision logs.
+const (
+	outcomeError     = "error"
+	outcomeTimedOut  = "timed_out"
+	outcomeUndefined = "undefined"
+	outcomeDenied    = "denied"
+	outcomeDecided   = "decided"
+)
+
+// logDecision logs the outcome of a decision. Failed, timed out, undefined
+// and denied decisions are always logged; other decisions only when they
+// fall within LOG_SAMPLE_RATE, so high-volume deployments keep the signal at
+// a fraction of the log volume. With LOG_TIMINGS, entries break the elapsed
+// time down by phase.
+func logDecision(ctx context.Context, req LambdaEvent, resp LambdaResponse, err error, rate float64, elapsed time.Duration) {
+	entry := decisionLog(ctx).WithField("policy", decisionPolicy(req))
+	if rate < 1 {
+		entry = entry.WithField("sample_rate", rate)
+	}
+	if timings := decisionTimings(ctx); timings != nil {
+		entry = entry.WithField("timings", timingsField(timings, elapsed))
+	}
+
+	outcome := decisionOutcome(resp, err)
+	switch {
+	case outcome == outcomeError:
+		entry.WithField("outcome", outcome).WithError(err).Error("Policy decision failed")
+	case outcome != outcomeDecided || decisionSampled(decisionID(ctx), rate):
+		entry.WithField("outcome", outcome).Info("Policy decision")
+	}
+}
+
+// decisionPolicy names the policy a decision evaluated, or lists the
+// policies of a merge.
+func decisionPolicy(req LambdaEvent) string {
+	if req.PolicyName != "" {
+		return req.PolicyName
+	}
+	return strings.Join(req.Policies, ",")
+}
+
+// decisionOutcome classifies a decision for logging. A decision is denied
+// when its output is false or an object whose allow is false; outputs that
+// are not authorization decisions are simply decided.
+func decisionOutcome(resp LambdaResponse, err error) string {
+	switch {
+	case err != nil:
+		return outcomeError
+	case resp.TimedOut:
+		return outcomeTimedOut
+	case resp.Undefined:
+		return outcomeUndefined
+	}
+	if object, ok := resp.Output.(map[string]interface{}); ok && object["allow"] == false {
+		return outcomeDenied
+	}
+	if resp.Output == false {
+		return outcomeDenied
+	}
+	return outcomeDecided
+}
+
+// decisionSampled reports whether the decision with ID id falls within a
+// sample of rate. The choice is derived from the ID, so every log entry of a
+// decision, including those of the policies a merge evaluates, is kept or
+// dropped together.
+func decisionSampled(id string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(id))
+	return float64(hash.Sum64()) < rate*math.MaxUint64
+}
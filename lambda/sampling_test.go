@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecisionSampled(t *testing.T) {
+	require.True(t, decisionSampled("any", 1))
+	require.False(t, decisionSampled("any", 0))
+
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		id := fmt.Sprintf("decision-%d", i)
+		if decisionSampled(id, 0.1) {
+			sampled++
+			// The choice is the same every time for an ID.
+			require.True(t, decisionSampled(id, 0.1))
+		}
+	}
+	require.InDelta(t, 1000, sampled, 150)
+}
+
+func TestDecisionOutcome(t *testing.T) {
+	require.Equal(t, outcomeError, decisionOutcome(LambdaResponse{}, errors.New("boom")))
+	require.Equal(t, outcomeTimedOut, decisionOutcome(LambdaResponse{TimedOut: true, Output: true}, nil))
+	require.Equal(t, outcomeUndefined, decisionOutcome(LambdaResponse{Undefined: true}, nil))
+	require.Equal(t, outcomeDenied, decisionOutcome(LambdaResponse{Output: false}, nil))
+	require.Equal(t, outcomeDenied, decisionOutcome(LambdaResponse{Output: map[string]interface{}{"allow": false}}, nil))
+	require.Equal(t, outcomeDecided, decisionOutcome(LambdaResponse{Output: map[string]interface{}{"allow": true}}, nil))
+	require.Equal(t, outcomeDecided, decisionOutcome(LambdaResponse{Output: map[string]interface{}{"region": "eu"}}, nil))
+}
+
+func TestHandleLambdaDirectEventLogSampling(t *testing.T) {
+	hook := test.NewGlobal()
+	t.Cleanup(func() { log.StandardLogger().ReplaceHooks(make(log.LevelHooks)) })
+	writeTestPolicy(t, "sampled", "package sampled\n\nallow = input.user == \"jane\"\n")
+	t.Setenv("LOG_SAMPLE_RATE", "0")
+
+	decisions := func() []*log.Entry {
+		var entries []*log.Entry
+		for _, entry := range hook.AllEntries() {
+			if entry.Data["outcome"] != nil {
+				entries = append(entries, entry)
+			}
+		}
+		hook.Reset()
+		return entries
+	}
+
+	// Allowed decisions outside the sample are not logged.
+	_, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"sampled","payload":{"user":"jane"}}`))
+	require.NoError(t, err)
+	require.Empty(t, decisions())
+
+	// Denials and errors always are.
+	resp, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"sampled","payload":{"user":"joe"}}`))
+	require.NoError(t, err)
+	entries := decisions()
+	require.Len(t, entries, 1)
+	require.Equal(t, outcomeDenied, entries[0].Data["outcome"])
+	require.Equal(t, resp.(LambdaResponse).DecisionID, entries[0].Data["decision_id"])
+	require.Equal(t, 0.0, entries[0].Data["sample_rate"])
+
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"missing","payload":{}}`))
+	require.Error(t, err)
+	entries = decisions()
+	require.Len(t, entries, 1)
+	require.Equal(t, outcomeError, entries[0].Data["outcome"])
+	require.Equal(t, log.ErrorLevel, entries[0].Level)
+
+	t.Setenv("LOG_SAMPLE_RATE", "1")
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"sampled","payload":{"user":"jane"}}`))
+	require.NoError(t, err)
+	entries = decisions()
+	require.Len(t, entries, 1)
+	require.Equal(t, outcomeDecided, entries[0].Data["outcome"])
+	require.NotContains(t, entries[0].Data, "sample_rate")
+
+	t.Setenv("LOG_SAMPLE_RATE", "2")
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"sampled","payload":{"user":"jane"}}`))
+	require.ErrorContains(t, err, "LOG_SAMPLE_RATE must be between 0 and 1")
+}
@@ -0,0 +1,24 @@
+package main
+
+import "errors"
+
+// errSeedNotAllowed is returned for requests sending a seed to a deployment
+// that does not accept one.
+var errSeedNotAllowed = errors.New("seed requires ALLOW_EVALUATION_SEED=true")
+
+// requestSeed returns the seed a request evaluates with. Seeds make random
+// built-ins predictable, so they are only accepted by deployments that opt
+// in with ALLOW_EVALUATION_SEED, such as those running policy tests.
+func requestSeed(seed *int64) (*int64, error) {
+	if seed == nil {
+		return nil, nil
+	}
+	allowed, err := boolFromEnv("ALLOW_EVALUATION_SEED", false)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, errSeedNotAllowed
+	}
+	return seed, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleLambdaDirectEventSeed(t *testing.T) {
+	writeTestPolicy(t, "lottery", "package lottery\n\nticket = rand.intn(input.draw, 1000000)\n")
+	event := json.RawMessage(`{"policy":"lottery","payload":{"draw":"weekly"},"seed":42}`)
+
+	// Production deployments reject seeds.
+	_, err := handleLambda(context.Background(), event)
+	require.ErrorIs(t, err, errSeedNotAllowed)
+	require.Equal(t, http.StatusBadRequest, classifyError(err).status)
+
+	t.Setenv("ALLOW_EVALUATION_SEED", "true")
+	first, err := handleLambda(context.Background(), event)
+	require.NoError(t, err)
+	second, err := handleLambda(context.Background(), event)
+	require.NoError(t, err)
+	require.Equal(t, first.(LambdaResponse).Output, second.(LambdaResponse).Output)
+
+	inputs, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"lottery","inputs":{"a":{"draw":"weekly"}},"seed":42}`))
+	require.NoError(t, err)
+	require.Equal(t, first.(LambdaResponse).Output, inputs.(LambdaResponse).Output.(map[string]interface{})["a"])
+}
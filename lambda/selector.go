@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// errInvalidPolicySelection is returned when POLICY_SELECTOR_PATH does not
+// lead to a usable policy name in the payload.
+var errInvalidPolicySelection = errors.New("unable to select policy from payload")
+
+// policyNamePattern accepts dotted Rego package names such as "auth.user".
+var policyNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// selectPolicy extracts the policy name from the payload at
+// POLICY_SELECTOR_PATH, a dotted path such as "$.routing.policy". It returns
+// an empty name when no selector is configured.
+func selectPolicy(payload json.RawMessage) (string, error) {
+	selector := strings.TrimSpace(os.Getenv("POLICY_SELECTOR_PATH"))
+	if selector == "" {
+		return "", nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidPolicySelection, err)
+	}
+
+	for _, field := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(selector, "$"), "."), ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("%w: %s not found", errInvalidPolicySelection, selector)
+		}
+		if value, ok = object[field]; !ok {
+			return "", fmt.Errorf("%w: %s not found", errInvalidPolicySelection, selector)
+		}
+	}
+
+	name, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %s is not a string", errInvalidPolicySelection, selector)
+	}
+	name = strings.TrimSpace(name)
+	if !policyNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%w: invalid policy name %q", errInvalidPolicySelection, name)
+	}
+
+	return name, nil
+}
+
+// selectRequestPolicy points a request evaluating a single payload without
+// naming a policy at the policy its payload selects, if any.
+func selectRequestPolicy(req LambdaEvent) (LambdaEvent, error) {
+	if req.PolicyName != "" || len(req.Policies) > 0 || req.Inputs != nil || req.Payload == nil {
+		return req, nil
+	}
+
+	selected, err := selectPolicy(*req.Payload)
+	if err != nil {
+		return req, err
+	}
+	req.PolicyName = selected
+	return req, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		payload  string
+		want     string
+		wantErr  string
+	}{
+		{name: "unset", selector: "", payload: `{"routing":{"policy":"example"}}`, want: ""},
+		{name: "nested", selector: "$.routing.policy", payload: `{"routing":{"policy":"auth.user"}}`, want: "auth.user"},
+		{name: "no root marker", selector: "routing.policy", payload: `{"routing":{"policy":" example "}}`, want: "example"},
+		{name: "missing", selector: "$.routing.policy", payload: `{"routing":{}}`, wantErr: "not found"},
+		{name: "not an object", selector: "$.routing.policy", payload: `{"routing":"example"}`, wantErr: "not found"},
+		{name: "not a string", selector: "$.routing.policy", payload: `{"routing":{"policy":42}}`, wantErr: "not a string"},
+		{name: "path traversal", selector: "$.routing.policy", payload: `{"routing":{"policy":"../secrets"}}`, wantErr: "invalid policy name"},
+		{name: "empty name", selector: "$.routing.policy", payload: `{"routing":{"policy":""}}`, wantErr: "invalid policy name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("POLICY_SELECTOR_PATH", tt.selector)
+
+			got, err := selectPolicy(json.RawMessage(tt.payload))
+			if tt.wantErr != "" {
+				require.ErrorIs(t, err, errInvalidPolicySelection)
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHandleLambdaDirectEventPolicySelector(t *testing.T) {
+	t.Setenv("POLICY_SELECTOR_PATH", "$.routing.policy")
+
+	raw := json.RawMessage(`{"payload":{"routing":{"policy":"example"},"membership":{"user":{"login":"jane","mail":"jane@example.com"}}}}`)
+	resp, err := handleDirectLambdaEvent(context.Background(), raw)
+	require.NoError(t, err)
+	require.Equal(t, true, resp.Output.(map[string]interface{})["allow"])
+
+	raw = json.RawMessage(`{"policy":"example","payload":{"routing":{"policy":"missing"},"membership":{"user":{"login":"jane"}}}}`)
+	resp, err = handleDirectLambdaEvent(context.Background(), raw)
+	require.NoError(t, err)
+	require.Equal(t, "jane", resp.Output.(map[string]interface{})["user"])
+}
+
+func TestHandleLambdaAPIGatewayV2EventPolicySelectorError(t *testing.T) {
+	t.Setenv("POLICY_SELECTOR_PATH", "$.routing.policy")
+
+	gwResp := invokeAPIGatewayV2(t, nil, `{"payload":{"routing":{}}}`)
+	require.Equal(t, 400, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "unable to select policy")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultMaxSQSBatchItems matches the largest batch an SQS event source
+// mapping delivers; MAX_SQS_BATCH_ITEMS can lower it.
+const defaultMaxSQSBatchItems = 10000
+
+func isSQSEvent(payload json.RawMessage) bool {
+	var probe struct {
+		Records []struct {
+			EventSource string `json:"eventSource"`
+		} `json:"Records"`
+	}
+
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return false
+	}
+
+	return len(probe.Records) > 0 && probe.Records[0].EventSource == "aws:sqs"
+}
+
+// handleSQSEvent evaluates the LambdaEvent carried in each message body and
+// reports failed messages through the partial batch response, so only they
+// are retried. Messages from FIFO queues are processed in order within their
+// message group; once a message in a group fails, the remaining messages of
+// that group are reported as failures without being evaluated, so they are
+// never processed ahead of the failed one. Other groups are unaffected.
+// Messages beyond MAX_SQS_BATCH_ITEMS are reported as failures unevaluated,
+// which returns them to the queue for a later invocation. So are messages
+// whose decision cannot be delivered to DECISION_WEBHOOK_URL.
+func handleSQSEvent(ctx context.Context, payload json.RawMessage) (events.SQSEventResponse, error) {
+	var event events.SQSEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		err = fmt.Errorf("unable to parse SQS payload: %w", err)
+		log.Error(err)
+		return events.SQSEventResponse{}, err
+	}
+
+	maxItems, err := intFromEnv("MAX_SQS_BATCH_ITEMS", defaultMaxSQSBatchItems)
+	if err != nil {
+		log.Error(err)
+		return events.SQSEventResponse{}, err
+	}
+
+	var resp events.SQSEventResponse
+	failedGroups := make(map[string]bool)
+
+	for i, msg := range event.Records {
+		if maxItems > 0 && i >= maxItems {
+			log.Warnf("deferring SQS message %s: batch exceeds MAX_SQS_BATCH_ITEMS=%d", msg.MessageId, maxItems)
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+			continue
+		}
+
+		group := msg.Attributes["MessageGroupId"]
+		if group != "" && failedGroups[group] {
+			log.Warnf("skipping SQS message %s: an earlier message in group %s failed", msg.MessageId, group)
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+			continue
+		}
+
+		if err := evaluateSQSMessage(ctx, msg); err != nil {
+			log.WithError(err).Errorf("failed to evaluate SQS message %s", msg.MessageId)
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+			if group != "" {
+				failedGroups[group] = true
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+func evaluateSQSMessage(ctx context.Context, msg events.SQSMessage) error {
+	var req LambdaEvent
+	if err := decodeEnvelope([]byte(msg.Body), &req); err != nil {
+		return fmt.Errorf("unable to parse SQS message body: %w", err)
+	}
+
+	resp, err := evaluatePolicy(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	output, err := json.Marshal(resp.Output)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("SQS message %s evaluated: %s", msg.MessageId, output)
+	return sendDecisionWebhook(ctx, req, resp, msg.MessageId)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleLambdaSQSFIFOEvent(t *testing.T) {
+	good := string(buildLambdaEventPayloadBytes(t))
+	bad := `{"policy":"example"}`
+
+	message := func(id, group, body string) events.SQSMessage {
+		return events.SQSMessage{
+			MessageId:   id,
+			EventSource: "aws:sqs",
+			Body:        body,
+			Attributes:  map[string]string{"MessageGroupId": group},
+		}
+	}
+
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		message("a1", "A", good),
+		message("b1", "B", good),
+		message("a2", "A", bad),
+		message("b2", "B", good),
+		message("a3", "A", good),
+		message("c1", "C", bad),
+		message("b3", "B", good),
+	}}
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+
+	sqsResp, ok := resp.(events.SQSEventResponse)
+	require.True(t, ok)
+
+	var failed []string
+	for _, failure := range sqsResp.BatchItemFailures {
+		failed = append(failed, failure.ItemIdentifier)
+	}
+	require.Equal(t, []string{"a2", "a3", "c1"}, failed)
+}
+
+func TestHandleLambdaSQSStandardEvent(t *testing.T) {
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "1", EventSource: "aws:sqs", Body: `{"policy":"example"}`},
+		{MessageId: "2", EventSource: "aws:sqs", Body: string(buildLambdaEventPayloadBytes(t))},
+	}}
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+
+	sqsResp, ok := resp.(events.SQSEventResponse)
+	require.True(t, ok)
+	require.Equal(t, []events.SQSBatchItemFailure{{ItemIdentifier: "1"}}, sqsResp.BatchItemFailures)
+}
+
+func TestHandleLambdaSQSEventBatchLimit(t *testing.T) {
+	t.Setenv("MAX_SQS_BATCH_ITEMS", "2")
+
+	body := string(buildLambdaEventPayloadBytes(t))
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "1", EventSource: "aws:sqs", Body: body},
+		{MessageId: "2", EventSource: "aws:sqs", Body: body},
+		{MessageId: "3", EventSource: "aws:sqs", Body: body},
+	}}
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+
+	sqsResp, ok := resp.(events.SQSEventResponse)
+	require.True(t, ok)
+	require.Equal(t, []events.SQSBatchItemFailure{{ItemIdentifier: "3"}}, sqsResp.BatchItemFailures)
+}
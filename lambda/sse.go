@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// sseHeartbeatInterval is how often a comment is sent while a batch streamed
+// as Server-Sent Events has no result ready, so that proxies and browsers
+// keep the connection open through slow evaluations.
+var sseHeartbeatInterval = 15 * time.Second
+
+// sseDone is the data of the event that ends a batch streamed as Server-Sent
+// Events.
+type sseDone struct {
+	Count int `json:"count"` // The number of results sent.
+}
+
+// streamBatchEvents evaluates a batch request in the background like
+// streamBatch, writing one Server-Sent Event per payload as soon as it has
+// been evaluated and a final "done" event. Each result is a default
+// ("message") event whose id is the payload's index and whose data is the
+// same JSON object as an NDJSON line.
+func streamBatchEvents(ctx context.Context, req LambdaEvent) io.Reader {
+	pr, pw := io.Pipe()
+	results := make(chan batchItemResult)
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(results)
+		for i, payload := range req.Payloads {
+			select {
+			case results <- batchItemResult{Index: i, LambdaResponse: evaluateBatchItem(ctx, req, payload)}:
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(stopped)
+		heartbeat := time.NewTicker(sseHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		count := 0
+		for {
+			var event []byte
+			var err error
+			select {
+			case result, ok := <-results:
+				if !ok {
+					event, err = sseEvent("", "done", sseDone{Count: count})
+					if err == nil {
+						_, err = pw.Write(event)
+					}
+					pw.CloseWithError(err)
+					return
+				}
+				event, err = sseEvent(fmt.Sprint(result.Index), "", result)
+				count++
+			case <-heartbeat.C:
+				event = []byte(": heartbeat\n\n")
+			}
+			if err == nil {
+				_, err = pw.Write(event)
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	return pr
+}
+
+// sseEvent encodes one Server-Sent Event. An empty id is left out, and an
+// empty name sends a default ("message") event.
+func sseEvent(id, name string, data interface{}) ([]byte, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var event bytes.Buffer
+	if id != "" {
+		fmt.Fprintf(&event, "id: %s\n", id)
+	}
+	if name != "" {
+		fmt.Fprintf(&event, "event: %s\n", name)
+	}
+	fmt.Fprintf(&event, "data: %s\n\n", payload)
+	return event.Bytes(), nil
+}
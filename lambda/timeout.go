@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TIMEOUT_DECISION values.
+const (
+	timeoutDecisionError = "error"
+	timeoutDecisionDeny  = "deny"
+	timeoutDecisionAllow = "allow"
+)
+
+// timeoutDecisionFromEnv reads TIMEOUT_DECISION, defaulting to "error".
+func timeoutDecisionFromEnv() (string, error) {
+	decision := strings.ToLower(strings.TrimSpace(os.Getenv("TIMEOUT_DECISION")))
+	switch decision {
+	case "":
+		return timeoutDecisionError, nil
+	case timeoutDecisionError, timeoutDecisionDeny, timeoutDecisionAllow:
+		return decision, nil
+	default:
+		return "", fmt.Errorf("invalid TIMEOUT_DECISION %q: expected deny, allow, or error", decision)
+	}
+}
+
+// timeoutResponse applies TIMEOUT_DECISION to an evaluation that exceeded
+// EVAL_TIMEOUT_SECONDS. "deny" and "allow" answer with {"allow": false} or
+// {"allow": true} and mark the response as timed out; "error" returns the
+// timeout error. The timeout is logged as an error either way.
+func timeoutResponse(policyName string, evalErr error) (LambdaResponse, error) {
+	decision, err := timeoutDecisionFromEnv()
+	if err != nil {
+		return LambdaResponse{}, err
+	}
+
+	log.WithError(evalErr).WithFields(log.Fields{
+		"policy":           policyName,
+		"timeout_decision": decision,
+	}).Error("POLICY EVALUATION TIMED OUT")
+
+	switch decision {
+	case timeoutDecisionDeny:
+		return LambdaResponse{Output: map[string]interface{}{"allow": false}, TimedOut: true}, nil
+	case timeoutDecisionAllow:
+		return LambdaResponse{Output: map[string]interface{}{"allow": true}, TimedOut: true}, nil
+	default:
+		return LambdaResponse{}, evalErr
+	}
+}
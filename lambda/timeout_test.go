@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"opa_lambda/policyevaluator"
+
+	"github.com/stretchr/testify/require"
+)
+
+const slowTestPolicy = `package slowtest
+
+never {
+    r := numbers.range(1, 10000)
+    some i, j
+    r[i] * r[j] == -1
+}`
+
+func TestHandleLambdaDirectEventTimeoutDecision(t *testing.T) {
+	writeTestPolicy(t, "slowtest", slowTestPolicy)
+	t.Setenv("EVAL_TIMEOUT_SECONDS", "0.02")
+
+	raw := json.RawMessage(`{"policy":"slowtest","payload":{}}`)
+
+	tests := []struct {
+		decision string
+		allow    interface{}
+	}{
+		{decision: "deny", allow: false},
+		{decision: "allow", allow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.decision, func(t *testing.T) {
+			t.Setenv("TIMEOUT_DECISION", tt.decision)
+
+			resp, err := handleDirectLambdaEvent(context.Background(), raw)
+			require.NoError(t, err)
+			require.True(t, resp.TimedOut)
+			require.Equal(t, map[string]interface{}{"allow": tt.allow}, resp.Output)
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		t.Setenv("TIMEOUT_DECISION", "")
+
+		_, err := handleDirectLambdaEvent(context.Background(), raw)
+		require.ErrorIs(t, err, policyevaluator.ErrEvaluationTimeout)
+
+		gwResp := invokeAPIGatewayV2(t, nil, string(raw))
+		require.Equal(t, http.StatusGatewayTimeout, gwResp.StatusCode)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv("TIMEOUT_DECISION", "maybe")
+
+		_, err := handleDirectLambdaEvent(context.Background(), raw)
+		require.ErrorContains(t, err, "invalid TIMEOUT_DECISION")
+	})
+}
+
+func TestStatusForErrorTimeouts(t *testing.T) {
+	require.Equal(t, http.StatusGatewayTimeout, classifyError(fmt.Errorf("%w after 1s", policyevaluator.ErrEvaluationTimeout)).status)
+	require.Equal(t, http.StatusServiceUnavailable, classifyError(fmt.Errorf("%w after 1s", policyevaluator.ErrLoadTimeout)).status)
+}
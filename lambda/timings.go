@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"opa_lambda/policyevaluator"
+
+	"github.com/open-policy-agent/opa/metrics"
+)
+
+type decisionTimingsKey struct{}
+
+// withDecisionTimings returns a context carrying the metrics the evaluator
+// records the phases of a decision in, unless ctx already carries them.
+// Decisions evaluating several policies, such as merges, therefore sum the
+// time each phase takes across every policy.
+func withDecisionTimings(ctx context.Context) context.Context {
+	if decisionTimings(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, decisionTimingsKey{}, metrics.New())
+}
+
+// decisionTimings returns the metrics of the decision being evaluated under
+// ctx, or nil when LOG_TIMINGS is off.
+func decisionTimings(ctx context.Context) metrics.Metrics {
+	m, _ := ctx.Value(decisionTimingsKey{}).(metrics.Metrics)
+	return m
+}
+
+// timingsField breaks the time a decision took down by phase, in
+// milliseconds: loading its policies, compiling them, evaluating the query,
+// and converting the input and result, out of the total.
+func timingsField(m metrics.Metrics, total time.Duration) map[string]float64 {
+	timer := func(names ...string) time.Duration {
+		var d time.Duration
+		for _, name := range names {
+			d += time.Duration(m.Timer(name).Int64())
+		}
+		return d
+	}
+	return map[string]float64{
+		"load_ms":    milliseconds(timer(policyevaluator.TimerLoad)),
+		"compile_ms": milliseconds(timer(policyevaluator.TimerPrepare)),
+		"eval_ms":    milliseconds(timer(metrics.RegoQueryEval)),
+		"marshal_ms": milliseconds(timer(policyevaluator.TimerInputDecode, metrics.RegoInputParse, policyevaluator.TimerResult)),
+		"total_ms":   milliseconds(total),
+	}
+}
+
+// milliseconds converts d to milliseconds, truncated to the microsecond.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleLambdaDirectEventLogTimings(t *testing.T) {
+	hook := test.NewGlobal()
+	t.Cleanup(func() { log.StandardLogger().ReplaceHooks(make(log.LevelHooks)) })
+	writeTestPolicy(t, "timed", "package timed\n\nallow = input.user == \"jane\"\n")
+
+	decision := func() *log.Entry {
+		defer hook.Reset()
+		for _, entry := range hook.AllEntries() {
+			if entry.Data["outcome"] != nil {
+				return entry
+			}
+		}
+		return nil
+	}
+
+	// Timings are off by default.
+	_, err := handleLambda(context.Background(), json.RawMessage(`{"policy":"timed","payload":{"user":"jane"}}`))
+	require.NoError(t, err)
+	require.NotContains(t, decision().Data, "timings")
+
+	t.Setenv("LOG_TIMINGS", "true")
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"timed","payload":{"user":"jane"}}`))
+	require.NoError(t, err)
+	timings, ok := decision().Data["timings"].(map[string]float64)
+	require.True(t, ok)
+	require.ElementsMatch(t, []string{"load_ms", "compile_ms", "eval_ms", "marshal_ms", "total_ms"}, keys(timings))
+	require.Positive(t, timings["total_ms"])
+	require.GreaterOrEqual(t, timings["total_ms"], timings["load_ms"]+timings["compile_ms"]+timings["eval_ms"])
+
+	// Failed decisions carry them too.
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"missing","payload":{}}`))
+	require.Error(t, err)
+	require.Contains(t, decision().Data, "timings")
+
+	t.Setenv("LOG_TIMINGS", "sometimes")
+	_, err = handleLambda(context.Background(), json.RawMessage(`{"policy":"timed","payload":{"user":"jane"}}`))
+	require.ErrorContains(t, err, "LOG_TIMINGS")
+}
+
+func keys(m map[string]float64) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"opa_lambda/policyloader"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// traceHeader returns the X-Ray trace header of the invocation, as passed by
+// the Lambda runtime in the context and in _X_AMZN_TRACE_ID, or an empty
+// string when tracing is off or the function runs outside Lambda.
+func traceHeader(ctx context.Context) string {
+	if value, _ := ctx.Value(xray.LambdaTraceHeaderKey).(string); value != "" {
+		return value
+	}
+	return os.Getenv("_X_AMZN_TRACE_ID")
+}
+
+// traceID returns the ID of the invocation's X-Ray trace, such as
+// 1-5759e988-bd862e3fe1be46a994272793, or an empty string without tracing.
+func traceID(ctx context.Context) string {
+	raw := traceHeader(ctx)
+	if raw == "" {
+		return ""
+	}
+	return header.FromString(raw).TraceID
+}
+
+// traceSubsegment runs fn in an OpenTelemetry span and an X-Ray subsegment
+// named name, under the invocation's span and segment, with attrs on the span.
+// Without a trace header fn runs outside X-Ray, so that tracing costs nothing
+// when it is off and outside Lambda.
+func traceSubsegment(ctx context.Context, name string, fn func(context.Context) error, attrs ...attribute.KeyValue) error {
+	return traceSpan(ctx, name, func(ctx context.Context) error {
+		raw := traceHeader(ctx)
+		if raw == "" {
+			return fn(ctx)
+		}
+		if ctx.Value(xray.LambdaTraceHeaderKey) == nil {
+			//lint:ignore SA1029 the X-Ray SDK looks the header up under this string key.
+			ctx = context.WithValue(ctx, xray.LambdaTraceHeaderKey, raw)
+		}
+		return xray.Capture(ctx, name, fn)
+	}, attrs...)
+}
+
+// tracedPolicyLoader records every policy load in its own span and X-Ray
+// subsegment.
+type tracedPolicyLoader struct {
+	policyloader.PolicyLoader
+}
+
+func (l tracedPolicyLoader) LoadPolicy(ctx context.Context, key string) (string, error) {
+	var module string
+	err := traceSubsegment(ctx, "load_policy", func(ctx context.Context) error {
+		var err error
+		module, err = l.PolicyLoader.LoadPolicy(ctx, key)
+		return err
+	}, attribute.String("policy.name", key))
+	return module, err
+}
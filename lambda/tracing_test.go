@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/stretchr/testify/require"
+)
+
+const testTraceHeader = "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0"
+
+func TestTraceID(t *testing.T) {
+	t.Setenv("_X_AMZN_TRACE_ID", "")
+	require.Empty(t, traceID(context.Background()))
+
+	//lint:ignore SA1029 the Lambda runtime passes the header under this string key.
+	ctx := context.WithValue(context.Background(), xray.LambdaTraceHeaderKey, testTraceHeader)
+	require.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", traceID(ctx))
+
+	t.Setenv("_X_AMZN_TRACE_ID", testTraceHeader)
+	require.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", traceID(context.Background()))
+}
+
+func TestHandleLambdaTraceID(t *testing.T) {
+	t.Setenv("_X_AMZN_TRACE_ID", testTraceHeader)
+
+	// Evaluations run in subsegments of the invocation's trace, and only
+	// return its ID when asked to.
+	resp, err := handleLambda(context.Background(), buildLambdaEventPayload(t))
+	require.NoError(t, err)
+	assertExampleOutput(t, resp.(LambdaResponse).Output)
+	require.Empty(t, resp.(LambdaResponse).TraceID)
+
+	t.Setenv("INCLUDE_TRACE_ID", "true")
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy":"example","payload":{}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", parseLambdaResponseBody(t, gwResp.Body).TraceID)
+	require.Equal(t, "Root=1-5759e988-bd862e3fe1be46a994272793", gwResp.Headers["X-Amzn-Trace-Id"])
+
+	// Without tracing there is nothing to return.
+	t.Setenv("_X_AMZN_TRACE_ID", "")
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy":"example","payload":{}}`)
+	require.Equal(t, http.StatusOK, gwResp.StatusCode)
+	require.Empty(t, parseLambdaResponseBody(t, gwResp.Body).TraceID)
+	require.NotContains(t, gwResp.Headers, "X-Amzn-Trace-Id")
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"opa_lambda/policyloader"
+)
+
+// transformSuffix names the input transform stored next to a policy, as in
+// policies/auth/user.transform.json for auth.user.
+const transformSuffix = ".transform.json"
+
+// errInputTransform is returned when a payload cannot be transformed, such as
+// a field that cannot be coerced to the requested type.
+var errInputTransform = errors.New("unable to transform input")
+
+// jsonNumberPattern matches the JSON number grammar.
+var jsonNumberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// inputTransform reshapes a payload before it becomes the policy's input.
+type inputTransform struct {
+	Operations []transformOperation `json:"operations"`
+}
+
+// transformOperation is one step of an inputTransform. Paths are dotted field
+// names from the root of the payload, optionally prefixed with "$.".
+type transformOperation struct {
+	Op        string          `json:"op"`        // move, copy, set, remove, coerce, or derive.
+	From      string          `json:"from"`      // The source path of move, copy, and derive.
+	Path      string          `json:"path"`      // The target path.
+	Value     json.RawMessage `json:"value"`     // The value written by set.
+	Type      string          `json:"type"`      // The type coerce converts to: string, number, boolean, or array.
+	Function  string          `json:"function"`  // The function derive computes fields with; see deriveFunctions.
+	Separator string          `json:"separator"` // The separator of the split function.
+}
+
+// transformPayload applies the policy's input transform to payload when
+// INPUT_TRANSFORMS is enabled and the policy has one. Otherwise payload is
+// returned unchanged.
+func transformPayload(ctx context.Context, policyName string, payload json.RawMessage) (json.RawMessage, error) {
+	enabled, err := boolFromEnv("INPUT_TRANSFORMS", false)
+	if err != nil || !enabled {
+		return payload, err
+	}
+
+	transform, err := loadInputTransform(ctx, policyName)
+	if err != nil || transform == nil {
+		return payload, err
+	}
+	return transform.apply(payload)
+}
+
+// loadInputTransform loads and parses the input transform of a policy. It
+// returns nil when the policy has none.
+func loadInputTransform(ctx context.Context, policyName string) (*inputTransform, error) {
+	pl, err := sharedPolicyLoader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	documents, ok := pl.(policyloader.DocumentLoader)
+	if !ok {
+		return nil, errors.New("INPUT_TRANSFORMS is not supported by the policy backend")
+	}
+
+	raw, err := documents.LoadDocument(ctx, policyName, transformSuffix)
+	var notFound *policyloader.FileNotFoundError
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var transform inputTransform
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&transform); err != nil {
+		return nil, fmt.Errorf("invalid input transform for %s: %w", policyName, err)
+	}
+	for i, op := range transform.Operations {
+		if err := op.validate(); err != nil {
+			return nil, fmt.Errorf("invalid input transform for %s: operation %d: %w", policyName, i, err)
+		}
+	}
+	return &transform, nil
+}
+
+// validate checks that an operation has the fields its op requires.
+func (op transformOperation) validate() error {
+	if transformPath(op.Path) == nil {
+		return errors.New("path is required")
+	}
+	switch op.Op {
+	case "move", "copy":
+		if transformPath(op.From) == nil {
+			return fmt.Errorf("from is required for %s", op.Op)
+		}
+	case "derive":
+		if transformPath(op.From) == nil {
+			return errors.New("from is required for derive")
+		}
+		if _, ok := deriveFunctions[op.Function]; !ok {
+			return fmt.Errorf("unsupported derive function: %q", op.Function)
+		}
+		if op.Function == "split" && op.Separator == "" {
+			return errors.New("separator is required for split")
+		}
+	case "set":
+		if op.Value == nil {
+			return errors.New("value is required for set")
+		}
+	case "remove":
+	case "coerce":
+		switch op.Type {
+		case "string", "number", "boolean", "array":
+		default:
+			return fmt.Errorf("unsupported coerce type: %q", op.Type)
+		}
+	default:
+		return fmt.Errorf("unsupported op: %q", op.Op)
+	}
+	return nil
+}
+
+// apply runs the operations in order. Operations reading a path that is
+// absent from the payload are skipped, so one transform can serve clients
+// that send different subsets of fields.
+func (t *inputTransform) apply(payload json.RawMessage) (json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var root interface{}
+	if err := decoder.Decode(&root); err != nil {
+		return nil, err
+	}
+
+	for _, op := range t.Operations {
+		path := transformPath(op.Path)
+		var err error
+		switch op.Op {
+		case "move", "copy":
+			value, ok := lookupPath(root, transformPath(op.From))
+			if !ok {
+				continue
+			}
+			if op.Op == "move" {
+				removePath(root, transformPath(op.From))
+			}
+			err = setPath(root, path, value)
+		case "set":
+			var value interface{}
+			if value, err = decodeTransformValue(op.Value); err == nil {
+				err = setPath(root, path, value)
+			}
+		case "remove":
+			removePath(root, path)
+		case "coerce":
+			value, ok := lookupPath(root, path)
+			if !ok {
+				continue
+			}
+			if value, err = coerceValue(value, op.Type); err == nil {
+				err = setPath(root, path, value)
+			}
+		case "derive":
+			value, ok := lookupPath(root, transformPath(op.From))
+			if !ok {
+				continue
+			}
+			if value, err = deriveValue(value, op.Function, op.Separator); err == nil {
+				err = setPath(root, path, value)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s %s: %v", errInputTransform, op.Op, op.Path, err)
+		}
+	}
+
+	return json.Marshal(root)
+}
+
+// transformPath splits a dotted path into field names, or returns nil for an
+// empty path.
+func transformPath(path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(path), "$"), ".")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+func decodeTransformValue(raw json.RawMessage) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+// lookupPath returns the value at path, if every step of it is an object
+// field that exists.
+func lookupPath(root interface{}, path []string) (interface{}, bool) {
+	value := root
+	for _, field := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[field]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setPath writes value at path, creating missing intermediate objects.
+func setPath(root interface{}, path []string, value interface{}) error {
+	object, ok := root.(map[string]interface{})
+	if !ok {
+		return errors.New("input is not an object")
+	}
+	for i, field := range path[:len(path)-1] {
+		next, exists := object[field]
+		if !exists {
+			next = map[string]interface{}{}
+			object[field] = next
+		}
+		if object, ok = next.(map[string]interface{}); !ok {
+			return fmt.Errorf("%s is not an object", strings.Join(path[:i+1], "."))
+		}
+	}
+	object[path[len(path)-1]] = value
+	return nil
+}
+
+// removePath deletes the field at path, if present.
+func removePath(root interface{}, path []string) {
+	parent, ok := lookupPath(root, path[:len(path)-1])
+	if !ok {
+		return
+	}
+	if object, ok := parent.(map[string]interface{}); ok {
+		delete(object, path[len(path)-1])
+	}
+}
+
+// coerceValue converts a JSON value to the named type.
+func coerceValue(value interface{}, typ string) (interface{}, error) {
+	switch typ {
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return v.String(), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case "number":
+		switch v := value.(type) {
+		case json.Number:
+			return v, nil
+		case string:
+			if number := strings.TrimSpace(v); jsonNumberPattern.MatchString(number) {
+				return json.Number(number), nil
+			}
+			return nil, fmt.Errorf("%q is not a number", v)
+		}
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a boolean", v)
+			}
+			return b, nil
+		}
+	case "array":
+		if array, ok := value.([]interface{}); ok {
+			return array, nil
+		}
+		return []interface{}{value}, nil
+	}
+	return nil, fmt.Errorf("cannot coerce %T to %s", value, typ)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInputTransformApply(t *testing.T) {
+	transform := inputTransform{Operations: []transformOperation{
+		{Op: "move", From: "login", Path: "$.user.name"},
+		{Op: "copy", From: "user.name", Path: "audit.actor"},
+		{Op: "set", Path: "source", Value: json.RawMessage(`{"kind": "gateway"}`)},
+		{Op: "remove", Path: "debug"},
+		{Op: "coerce", Path: "age", Type: "number"},
+		{Op: "coerce", Path: "admin", Type: "boolean"},
+		{Op: "coerce", Path: "id", Type: "string"},
+		{Op: "coerce", Path: "roles", Type: "array"},
+		{Op: "move", From: "missing", Path: "elsewhere"},
+		{Op: "coerce", Path: "absent", Type: "number"},
+	}}
+
+	out, err := transform.apply(json.RawMessage(`{"login": "jane", "debug": true, "age": "42", "admin": "true", "id": 12345678901234567890, "roles": "reader"}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"user": {"name": "jane"},
+		"audit": {"actor": "jane"},
+		"source": {"kind": "gateway"},
+		"age": 42,
+		"admin": true,
+		"id": "12345678901234567890",
+		"roles": ["reader"]
+	}`, string(out))
+}
+
+func TestInputTransformApplyErrors(t *testing.T) {
+	coerce := inputTransform{Operations: []transformOperation{{Op: "coerce", Path: "age", Type: "number"}}}
+	_, err := coerce.apply(json.RawMessage(`{"age": "forty"}`))
+	require.ErrorIs(t, err, errInputTransform)
+
+	_, err = coerce.apply(json.RawMessage(`{"age": "NaN"}`))
+	require.ErrorIs(t, err, errInputTransform)
+
+	set := inputTransform{Operations: []transformOperation{{Op: "set", Path: "user.name", Value: json.RawMessage(`"jane"`)}}}
+	_, err = set.apply(json.RawMessage(`{"user": "jane"}`))
+	require.ErrorContains(t, err, "user is not an object")
+
+	_, err = set.apply(json.RawMessage(`[]`))
+	require.ErrorContains(t, err, "input is not an object")
+}
+
+func TestTransformOperationValidate(t *testing.T) {
+	require.NoError(t, transformOperation{Op: "remove", Path: "a"}.validate())
+	require.ErrorContains(t, transformOperation{Op: "remove"}.validate(), "path is required")
+	require.ErrorContains(t, transformOperation{Op: "move", Path: "a"}.validate(), "from is required")
+	require.ErrorContains(t, transformOperation{Op: "set", Path: "a"}.validate(), "value is required")
+	require.ErrorContains(t, transformOperation{Op: "coerce", Path: "a", Type: "date"}.validate(), "unsupported coerce type")
+	require.ErrorContains(t, transformOperation{Op: "rename", Path: "a"}.validate(), "unsupported op")
+}
+
+func writeTestTransform(t *testing.T, name, transform string) {
+	t.Helper()
+	path := filepath.Join(testPolicyDir(t), name+transformSuffix)
+	require.NoError(t, os.WriteFile(path, []byte(transform), 0o600))
+}
+
+func TestEvaluatePolicyWithInputTransform(t *testing.T) {
+	writeTestTransform(t, "example", `{"operations": [
+		{"op": "move", "from": "login", "path": "membership.user.login"},
+		{"op": "move", "from": "email", "path": "membership.user.mail"}
+	]}`)
+	payload := `{"policy": "example", "payload": {"login": "jane", "email": "jane@example.com"}}`
+
+	// Transforms are opt-in.
+	resp, err := handleLambda(context.Background(), json.RawMessage(payload))
+	require.NoError(t, err)
+	require.Equal(t, false, resp.(LambdaResponse).Output.(map[string]interface{})["allow"])
+
+	t.Setenv("INPUT_TRANSFORMS", "true")
+	resp, err = handleLambda(context.Background(), json.RawMessage(payload))
+	require.NoError(t, err)
+	require.Equal(t, true, resp.(LambdaResponse).Output.(map[string]interface{})["allow"])
+
+	// Policies without a transform see the payload unchanged.
+	resp, err = handleLambda(context.Background(), json.RawMessage(`{"policy": "world", "payload": {}}`))
+	require.NoError(t, err)
+	require.NotNil(t, resp.(LambdaResponse).Output)
+}
+
+func TestEvaluatePolicyWithInvalidInputTransform(t *testing.T) {
+	t.Setenv("INPUT_TRANSFORMS", "true")
+
+	writeTestTransform(t, "example", `{"operations": [{"op": "coerce", "path": "membership", "type": "number"}]}`)
+	gwResp := invokeAPIGatewayV2(t, nil, `{"policy": "example", "payload": {"membership": "x"}}`)
+	require.Equal(t, http.StatusBadRequest, gwResp.StatusCode)
+
+	writeTestTransform(t, "example", `{"operations": [{"op": "rename", "path": "a"}]}`)
+	gwResp = invokeAPIGatewayV2(t, nil, `{"policy": "example", "payload": {}}`)
+	require.Equal(t, http.StatusInternalServerError, gwResp.StatusCode)
+	require.Contains(t, parseLambdaResponseBody(t, gwResp.Body).Error, "invalid input transform for example")
+}
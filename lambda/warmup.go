@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"opa_lambda/buildinfo"
+	"opa_lambda/policyloader"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Shapes of the warmup response, selected with WARMUP_RESPONSE.
+const (
+	warmupResponseStatus = "status" // Only {"status":"ok"} (default).
+	warmupResponseStats  = "stats"  // The status plus the loader's cache contents.
+)
+
+// warmupResponse is returned for warmup invocations. Status is "ok" once the
+// policy loader is ready and "error" otherwise.
+type warmupResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	*warmupStats
+}
+
+// warmupStats describes the policy loader's cache, for WARMUP_RESPONSE=stats.
+type warmupStats struct {
+	Version        string                     `json:"version"`
+	PoliciesLoaded int                        `json:"policies_loaded"`
+	PoliciesStale  int                        `json:"policies_stale"`
+	Policies       []policyloader.PolicyStats `json:"policies"`
+}
+
+// isWarmupEvent reports whether payload is a warmup ping: an EventBridge
+// scheduled event, {"warmup": true}, or {"action": "warmup"}.
+func isWarmupEvent(payload json.RawMessage) bool {
+	var probe struct {
+		Source     string `json:"source"`
+		DetailType string `json:"detail-type"`
+		Warmup     bool   `json:"warmup"`
+		Action     string `json:"action"`
+	}
+
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return false
+	}
+
+	if probe.Source == "aws.events" && probe.DetailType == "Scheduled Event" {
+		return true
+	}
+	return probe.Warmup || probe.Action == actionWarmup
+}
+
+// handleWarmup readies the shared policy loader and reports on it. No policy
+// is loaded or evaluated, so warmups neither touch the policy backends nor
+// count against a policy's metrics. Failures are reported in the response
+// rather than as an invocation error so that monitors can parse them.
+func handleWarmup(ctx context.Context) warmupResponse {
+	shape := strings.ToLower(os.Getenv("WARMUP_RESPONSE"))
+	switch shape {
+	case "":
+		shape = warmupResponseStatus
+	case warmupResponseStatus, warmupResponseStats:
+	default:
+		err := fmt.Errorf("unsupported WARMUP_RESPONSE: %s", os.Getenv("WARMUP_RESPONSE"))
+		log.Error(err)
+		return warmupResponse{Status: "error", Error: err.Error()}
+	}
+
+	pl, err := sharedPolicyLoader(ctx)
+	if err != nil {
+		log.Error(err)
+		return warmupResponse{Status: "error", Error: err.Error()}
+	}
+
+	resp := warmupResponse{Status: "ok"}
+	if shape != warmupResponseStats {
+		return resp
+	}
+
+	stats := &warmupStats{
+		Version:  buildinfo.Version(),
+		Policies: []policyloader.PolicyStats{},
+	}
+	if reporter, ok := pl.(policyloader.StatsReporter); ok {
+		stats.Policies = reporter.Stats()
+	}
+	for _, stat := range stats.Policies {
+		if stat.Loaded {
+			stats.PoliciesLoaded++
+		}
+		if stat.Stale {
+			stats.PoliciesStale++
+		}
+	}
+
+	resp.warmupStats = stats
+	return resp
+}
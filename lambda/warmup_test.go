@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsWarmupEvent(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    bool
+	}{
+		{payload: `{"version":"0","source":"aws.events","detail-type":"Scheduled Event","detail":{}}`, want: true},
+		{payload: `{"warmup":true}`, want: true},
+		{payload: `{"action":"warmup"}`, want: true},
+		{payload: `{"warmup":false,"policy":"example"}`, want: false},
+		{payload: `{"source":"aws.s3","detail-type":"Object Created"}`, want: false},
+		{payload: `{"action":"parse","policy":"example"}`, want: false},
+		{payload: `[]`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			require.Equal(t, tt.want, isWarmupEvent(json.RawMessage(tt.payload)))
+		})
+	}
+}
+
+func invokeWarmup(t *testing.T, payload string) string {
+	t.Helper()
+	resp, err := handleLambda(context.Background(), json.RawMessage(payload))
+	require.NoError(t, err)
+
+	body, err := json.Marshal(resp)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestHandleLambdaWarmup(t *testing.T) {
+	// The policy would fail to evaluate, so an ok status shows it was not.
+	require.JSONEq(t, `{"status":"ok"}`, invokeWarmup(t, `{"warmup":true,"policy":"nosuchpolicy"}`))
+	require.JSONEq(t, `{"status":"ok"}`, invokeWarmup(t, `{"source":"aws.events","detail-type":"Scheduled Event"}`))
+
+	t.Setenv("WARMUP_RESPONSE", "stats")
+	var stats map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(invokeWarmup(t, `{"action":"warmup"}`)), &stats))
+	require.Equal(t, "ok", stats["status"])
+	require.Contains(t, stats, "version")
+	require.Equal(t, float64(0), stats["policies_loaded"])
+	require.Equal(t, float64(0), stats["policies_stale"])
+	require.Equal(t, []interface{}{}, stats["policies"])
+
+	t.Setenv("WARMUP_RESPONSE", "verbose")
+	require.JSONEq(t, `{"status":"error","error":"unsupported WARMUP_RESPONSE: verbose"}`, invokeWarmup(t, `{"warmup":true}`))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Values of DECISION_WEBHOOK_SEND.
+const (
+	webhookSendAll  = "all"  // Every decision.
+	webhookSendDeny = "deny" // Denied decisions only.
+)
+
+// webhookAttempts bounds the attempts at delivering one decision, including
+// the first.
+const webhookAttempts = 3
+
+// webhookRetryDelay is the pause before the second attempt; later attempts
+// wait proportionally longer.
+const webhookRetryDelay = 100 * time.Millisecond
+
+// webhookClient delivers decisions to DECISION_WEBHOOK_URL. Its timeout
+// bounds each attempt.
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// webhookDecision is the body posted to DECISION_WEBHOOK_URL.
+type webhookDecision struct {
+	DecisionID string      `json:"decision_id"`
+	Policy     string      `json:"policy"`
+	MessageID  string      `json:"message_id,omitempty"` // The SQS message the decision was made for.
+	Outcome    string      `json:"outcome"`              // As in decision logs; see decisionOutcome.
+	Output     interface{} `json:"output,omitempty"`
+}
+
+// sendDecisionWebhook posts a decision made for an asynchronous trigger to
+// DECISION_WEBHOOK_URL, authenticated with DECISION_WEBHOOK_TOKEN as a bearer
+// token when it is set, so that the decision reaches a downstream system
+// rather than only the logs. DECISION_WEBHOOK_SEND selects the decisions
+// sent: all of them (the default), or only denied ones. It does nothing when
+// DECISION_WEBHOOK_URL is unset.
+func sendDecisionWebhook(ctx context.Context, req LambdaEvent, resp LambdaResponse, messageID string) error {
+	url := strings.TrimSpace(os.Getenv("DECISION_WEBHOOK_URL"))
+	if url == "" {
+		return nil
+	}
+
+	outcome := decisionOutcome(resp, nil)
+	switch send := strings.ToLower(strings.TrimSpace(os.Getenv("DECISION_WEBHOOK_SEND"))); send {
+	case "", webhookSendAll:
+	case webhookSendDeny:
+		if outcome != outcomeDenied {
+			return nil
+		}
+	default:
+		return fmt.Errorf("invalid DECISION_WEBHOOK_SEND %q: must be %s or %s", send, webhookSendAll, webhookSendDeny)
+	}
+
+	body, err := json.Marshal(webhookDecision{
+		DecisionID: resp.DecisionID,
+		Policy:     decisionPolicy(req),
+		MessageID:  messageID,
+		Outcome:    outcome,
+		Output:     resp.Output,
+	})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := postDecisionWebhook(ctx, url, body)
+		if err == nil || !retry || attempt == webhookAttempts || ctx.Err() != nil {
+			if err != nil {
+				return fmt.Errorf("unable to send decision to webhook: %w", err)
+			}
+			return nil
+		}
+
+		log.WithError(err).Warnf("retrying decision webhook (attempt %d of %d)", attempt, webhookAttempts)
+		select {
+		case <-time.After(time.Duration(attempt) * webhookRetryDelay):
+		case <-ctx.Done():
+			return fmt.Errorf("unable to send decision to webhook: %w", err)
+		}
+	}
+}
+
+// postDecisionWebhook makes one attempt at posting body and reports whether
+// a failure is worth retrying: network errors, throttling and server errors.
+func postDecisionWebhook(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("DECISION_WEBHOOK_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return !errors.Is(err, context.Canceled), err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected status %s", resp.Status)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookSink records the decisions posted to it, answering with the
+// statuses queued in failures before succeeding.
+type webhookSink struct {
+	mu        sync.Mutex
+	decisions []webhookDecision
+	headers   []http.Header
+	failures  []int
+}
+
+func (s *webhookSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.failures) > 0 {
+		w.WriteHeader(s.failures[0])
+		s.failures = s.failures[1:]
+		return
+	}
+	var decision webhookDecision
+	if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.decisions = append(s.decisions, decision)
+	s.headers = append(s.headers, r.Header)
+}
+
+func (s *webhookSink) take() []webhookDecision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	decisions := s.decisions
+	s.decisions = nil
+	return decisions
+}
+
+func handleSQSMessages(t *testing.T, bodies ...string) []string {
+	var event events.SQSEvent
+	for i, body := range bodies {
+		event.Records = append(event.Records, events.SQSMessage{MessageId: string(rune('a' + i)), EventSource: "aws:sqs", Body: body})
+	}
+	raw, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	resp, err := handleLambda(context.Background(), raw)
+	require.NoError(t, err)
+	var failed []string
+	for _, failure := range resp.(events.SQSEventResponse).BatchItemFailures {
+		failed = append(failed, failure.ItemIdentifier)
+	}
+	return failed
+}
+
+func TestHandleLambdaSQSEventDecisionWebhook(t *testing.T) {
+	sink := &webhookSink{}
+	server := httptest.NewServer(sink)
+	t.Cleanup(server.Close)
+	writeTestPolicy(t, "notified", "package notified\n\nallow = input.user == \"jane\"\n")
+	allowed := `{"policy":"notified","payload":{"user":"jane"}}`
+	denied := `{"policy":"notified","payload":{"user":"joe"}}`
+
+	// Without a webhook, decisions are only logged.
+	require.Empty(t, handleSQSMessages(t, allowed))
+
+	t.Setenv("DECISION_WEBHOOK_URL", server.URL)
+	t.Setenv("DECISION_WEBHOOK_TOKEN", "secret")
+	require.Empty(t, handleSQSMessages(t, allowed, denied))
+	decisions := sink.take()
+	require.Len(t, decisions, 2)
+	require.Equal(t, "a", decisions[0].MessageID)
+	require.Equal(t, "notified", decisions[0].Policy)
+	require.Equal(t, outcomeDecided, decisions[0].Outcome)
+	require.Equal(t, map[string]interface{}{"allow": true}, decisions[0].Output)
+	require.NotEmpty(t, decisions[0].DecisionID)
+	require.Equal(t, outcomeDenied, decisions[1].Outcome)
+	require.Equal(t, "Bearer secret", sink.headers[0].Get("Authorization"))
+
+	t.Setenv("DECISION_WEBHOOK_SEND", "deny")
+	require.Empty(t, handleSQSMessages(t, allowed, denied))
+	decisions = sink.take()
+	require.Len(t, decisions, 1)
+	require.Equal(t, "b", decisions[0].MessageID)
+
+	// Transient failures are retried.
+	t.Setenv("DECISION_WEBHOOK_SEND", "all")
+	sink.failures = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	require.Empty(t, handleSQSMessages(t, allowed))
+	require.Len(t, sink.take(), 1)
+
+	// A decision that cannot be delivered fails its message, returning it to
+	// the queue.
+	sink.failures = []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}
+	require.Equal(t, []string{"a"}, handleSQSMessages(t, allowed))
+	sink.failures = []int{http.StatusUnauthorized}
+	require.Equal(t, []string{"a", "b"}, handleSQSMessages(t, allowed, `{"policy":"missing","payload":{}}`))
+	require.Empty(t, sink.take())
+
+	t.Setenv("DECISION_WEBHOOK_SEND", "violations")
+	require.Equal(t, []string{"a"}, handleSQSMessages(t, allowed))
+}